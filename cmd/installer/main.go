@@ -0,0 +1,136 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Command installer installs the docker compose package it is shipped in without network
+// access. Build it for the target hosts and pass it to the docker compose export, e.g.
+//
+//	CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -o installer ./cmd/installer
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/goodrain/rainbond-oam/pkg/installer"
+	"github.com/sirupsen/logrus"
+)
+
+const usage = `Usage: installer <command> [options]
+
+Commands:
+  preflight  Check the host can run the app
+  install    Load the images, render the parameters and start the app
+  upgrade    Upgrade the installed app to the version of this package
+  start      Start the installed app
+  stop       Stop the installed app
+  uninstall  Remove the app, --purge removes its volumes too
+
+Options:
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	packageDir := flags.String("package", "", "the extracted package, the dir of the installer by default")
+	dir := flags.String("dir", "", "install dir of the app, "+installer.DefaultInstallRoot+"/<app> by default")
+	values := flags.String("values", "", "file of KEY=VALUE lines with the values of the parameters")
+	yes := flags.Bool("yes", false, "use the default values of the parameters not in the values file instead of asking")
+	timeout := flags.Duration("timeout", 5*time.Minute, "time a service has to become running or healthy")
+	skipPreflight := flags.Bool("skip-preflight", false, "do not check the host before installing")
+	purge := flags.Bool("purge", false, "remove the volumes of the app on uninstall")
+	flags.Parse(os.Args[2:])
+
+	if *packageDir == "" {
+		*packageDir = executableDir()
+	}
+	i, err := installer.New(*packageDir, *dir)
+	if err != nil {
+		logrus.Fatalf("read package %s failure %s", *packageDir, err.Error())
+	}
+	i.ValuesFile = *values
+	i.Timeout = *timeout
+	i.SkipPreflight = *skipPreflight
+	if !*yes && isTerminal(os.Stdin) {
+		i.In = os.Stdin
+	}
+
+	switch command {
+	case "preflight":
+		problems := i.Preflight()
+		for _, problem := range problems {
+			fmt.Println("✗", problem)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("✓ the host can run", i.Bundle().AppName)
+	case "install":
+		err = i.Install()
+	case "upgrade":
+		err = i.Upgrade()
+	case "start":
+		// the package is installed on the first start, like the run script of the package does
+		if i.Installed() {
+			err = i.Start()
+		} else {
+			err = i.Install()
+		}
+	case "stop":
+		err = i.Stop()
+	case "uninstall":
+		err = i.Uninstall(*purge)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func executableDir() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return "."
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	return filepath.Dir(exe)
+}
+
+func isTerminal(f io.Reader) bool {
+	file, ok := f.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,119 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+const (
+	// composeEnvFileName the env file docker compose reads the variables of the compose file from
+	composeEnvFileName = ".env"
+	// secretEnvValue the value of the envs generated when the app is installed
+	secretEnvValue = "**None**"
+	// secretEnvComment the comment of the secret variables, the run script fills them in on the first start
+	secretEnvComment = ", generated secret"
+)
+
+// composeVariable an env set through the env file instead of the compose file
+type composeVariable struct {
+	Name        string
+	Service     string
+	Env         string
+	Default     string
+	Description string
+	// Secret the value is generated on the first start, every site gets its own
+	Secret bool
+}
+
+// composeVariables the variables of the compose file, the secrets and the envs users may change
+type composeVariables struct {
+	list  []*composeVariable
+	index map[string]*composeVariable
+}
+
+func newComposeVariables() *composeVariables {
+	return &composeVariables{index: make(map[string]*composeVariable)}
+}
+
+// reference returns the value of the env of the service in the compose file, a ${VAR} reference
+// for secrets and changeable envs, the value itself for the others
+func (v *composeVariables) reference(service string, env v1alpha1.ComponentEnv) string {
+	secret := env.AttrValue == secretEnvValue
+	if !secret && !env.IsChange {
+		return env.AttrValue
+	}
+	name := parameterName(service, env.AttrName)
+	if _, ok := v.index[name]; !ok {
+		variable := &composeVariable{Name: name, Service: service, Env: env.AttrName, Description: env.Name, Secret: secret}
+		if !secret {
+			variable.Default = env.AttrValue
+		}
+		v.index[name] = variable
+		v.list = append(v.list, variable)
+	}
+	return "${" + name + "}"
+}
+
+// isReference returns true if the value is the reference of a variable
+func (v *composeVariables) isReference(value string) bool {
+	if !strings.HasPrefix(value, "${") || !strings.HasSuffix(value, "}") {
+		return false
+	}
+	_, ok := v.index[value[2:len(value)-1]]
+	return ok
+}
+
+// writeEnvFile write the env file template, the changeable envs are documented and the
+// secrets are left empty to be generated on the first start
+func (v *composeVariables) writeEnvFile(exportPath string, ram v1alpha1.RainbondApplicationConfig) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Environment of %s %s, docker compose reads it from the dir of docker-compose.yaml.\n", ram.AppName, ram.AppVersion)
+	b.WriteString("# Change the values before the first start, empty secrets are generated on the first start.\n")
+	for _, variable := range v.list {
+		b.WriteString("\n# " + variable.Service + " " + variable.Env)
+		if variable.Description != "" && variable.Description != variable.Env {
+			b.WriteString(" (" + variable.Description + ")")
+		}
+		if variable.Secret {
+			b.WriteString(secretEnvComment + "\n")
+		} else {
+			b.WriteString(", can be changed\n")
+		}
+		b.WriteString(variable.Name + "=" + envFileValue(variable.Default) + "\n")
+	}
+	return ioutil.WriteFile(path.Join(exportPath, composeEnvFileName), []byte(b.String()), 0644)
+}
+
+// envFileValue quote the value if docker compose would not read it as is, single quoted
+// values are not interpolated
+func envFileValue(value string) string {
+	if !strings.ContainsAny(value, " \t#'\"$\\") {
+		return value
+	}
+	if !strings.Contains(value, "'") {
+		return "'" + value + "'"
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$").Replace(value) + `"`
+}
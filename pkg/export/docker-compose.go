@@ -20,68 +20,161 @@ package export
 
 import (
 	"fmt"
+	"github.com/goodrain/rainbond-oam/pkg/installer"
 	"github.com/goodrain/rainbond-oam/pkg/util/image"
 	"io/ioutil"
 	"os"
 	"path"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/goodrain/rainbond-oam/pkg/oam"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
 	"github.com/goodrain/rainbond-oam/pkg/util"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
 
-type dockerComposeExporter struct {
-	logger      *logrus.Logger
-	ram         v1alpha1.RainbondApplicationConfig
-	imageClient image.Client
-	homePath    string
-	exportPath  string
+const (
+	// ComposeBridgeNetworkMode all services join a private bridge network
+	ComposeBridgeNetworkMode = "bridge"
+	// ComposeHostNetworkMode all services use the host network
+	ComposeHostNetworkMode = "host"
+
+	composeNetworkName = "rainbond"
+	// composeFileName and composeRunScript are written at the package root
+	composeFileName  = "docker-compose.yaml"
+	composeRunScript = "run.sh"
+)
+
+func init() {
+	// compose pulls the images when the app is started, the credentials are not kept
+	Register(DC, Capabilities{NeedsImages: true, SupportsOnline: true, ImageLayout: true, PackageKind: "dockercompose"}, newDockerComposeExporter)
 }
 
-func (d *dockerComposeExporter) Export() (*Result, error) {
+type dockerComposeExporter struct {
+	logger       *logrus.Logger
+	ram          v1alpha1.RainbondApplicationConfig
+	imageClient  image.Client
+	pull         image.PullOptions
+	mode         string
+	networkMode  string
+	gatewayImage string
+	// installer the installer binary shipped in the package, the package is a plain compose package if it is empty
+	installer  string
+	exportPath string
+	report     *ExportReport
+	// dryRun build the spec without writing it, for the plan of the export
+	dryRun bool
+	// spec the compose spec built by buildDockerComposeYaml
+	spec *DockerComposeYaml
+	// variables the envs of the spec set through the env file
+	variables *composeVariables
+}
 
-	d.logger.Infof("start export app %s to docker compose app spec", d.ram.AppName)
-	// Delete the old application group directory and then regenerate the application package
-	if err := PrepareExportDir(d.exportPath); err != nil {
-		d.logger.Errorf("prepare export dir failure %s", err.Error())
-		return nil, err
-	}
+func newDockerComposeExporter(ctx *Context) (Renderer, error) {
+	return &dockerComposeExporter{
+		logger:       ctx.Logger,
+		ram:          *ctx.RAM,
+		imageClient:  ctx.ImageClient,
+		pull:         ctx.Pull,
+		mode:         ctx.Mode,
+		networkMode:  ctx.Options.ComposeNetworkMode,
+		gatewayImage: ctx.Options.GatewayImage,
+		installer:    ctx.Options.Installer,
+		exportPath:   ctx.ExportPath,
+		report:       ctx.Report,
+	}, nil
+}
 
-	d.logger.Infof("success prepare export dir")
+func (d *dockerComposeExporter) SaveImages(ram v1alpha1.RainbondApplicationConfig) error {
 	// Save components attachments
-	if err := d.saveComponents(); err != nil {
-		return nil, err
+	if err := d.saveComponents(ram); err != nil {
+		return err
 	}
 	d.logger.Infof("success save components")
+	return nil
+}
+
+func (d *dockerComposeExporter) Render() error {
 	// build docker-compose.yaml
 	if err := d.buildDockerComposeYaml(); err != nil {
-		return nil, err
+		return err
 	}
 	d.logger.Infof("success build docker compose yaml spec")
 	// build run.sh shell
 	if err := d.buildStartScript(); err != nil {
-		return nil, err
+		return err
 	}
 	d.logger.Infof("success build start script")
-	// packaging
-	packageName := fmt.Sprintf("%s-%s-dockercompose.tar.gz", d.ram.AppName, d.ram.AppVersion)
-	name, err := Packaging(packageName, d.homePath, d.exportPath)
-	if err != nil {
-		err = fmt.Errorf("Failed to package app %s: %s ", packageName, err.Error())
-		d.logger.Error(err)
-		return nil, err
+	if d.installer != "" {
+		if err := d.writeInstallerBundle(); err != nil {
+			return err
+		}
+		d.logger.Infof("success write installer bundle")
+	}
+	if hasIngressRoutes(d.ram) {
+		d.report.addExtraImage("gateway", "gateway", d.gatewayImage)
+	}
+	return nil
+}
+
+func (d *dockerComposeExporter) InstallSteps() []InstallStep {
+	if d.installer != "" {
+		return []InstallStep{
+			{Description: "Check that the host can run the app.", Command: "./run.sh preflight"},
+			{Description: "Install the app, the variables that can be changed are asked for and the secrets generated. No network access is required.", Command: "./run.sh install"},
+			{Description: "Uninstall the app.", Command: "./run.sh uninstall"},
+		}
+	}
+	start := "Start the app, empty secrets in " + composeEnvFileName + " are generated on the first start."
+	if d.mode == OfflineMode {
+		start = "Start the app, the images of the package are loaded and empty secrets in " + composeEnvFileName + " are generated on the first start."
+	}
+	return []InstallStep{
+		{Description: "Change the variables in " + composeEnvFileName + " if needed."},
+		{Description: start, Command: "./run.sh start"},
+		{Description: "Stop the app.", Command: "./run.sh stop"},
+	}
+}
+
+func (d *dockerComposeExporter) Plan(report *ExportReport) error {
+	if d.mode == OfflineMode {
+		// the config files are written when the images are saved
+		dockerCompose := newDockerCompose(d.ram)
+		for _, component := range d.ram.Components {
+			for _, v := range component.ServiceVolumeMapList {
+				if v.VolumeType == v1alpha1.ConfigFileVolumeType {
+					report.Files = append(report.Files, configFileName(dockerCompose.GetServiceName(component.ServiceShareID), v))
+				}
+			}
+		}
+	}
+	d.dryRun = true
+	if err := d.buildDockerComposeYaml(); err != nil {
+		return err
+	}
+	report.Files = append(report.Files, composeFileName, composeEnvFileName, composeRunScript)
+	if d.installer != "" {
+		if _, err := os.Stat(d.installer); err != nil {
+			report.warn(d.logger, "installer %s can not be shipped: %s", d.installer, err.Error())
+		}
+		report.Files = append(report.Files, installer.BinaryName, installer.BundleFileName)
+	}
+	if hasIngressRoutes(d.ram) {
+		report.Files = append(report.Files, gatewayConfFile)
+		report.addExtraImage("gateway", "gateway", d.gatewayImage)
 	}
-	d.logger.Infof("success export app " + d.ram.AppName)
-	return &Result{PackagePath: path.Join(d.homePath, name), PackageName: name}, nil
+	return nil
 }
 
 // saveComponents Bulk export of mirrored mode, lower disk footprint for the entire package
-func (d *dockerComposeExporter) saveComponents() error {
-	dockerCompose := newDockerCompose(d.ram)
+func (d *dockerComposeExporter) saveComponents(ram v1alpha1.RainbondApplicationConfig) error {
+	dockerCompose := newDockerCompose(ram)
+	var requests []image.PullRequest
 	var componentImageNames []string
-	for _, component := range d.ram.Components {
+	for _, component := range ram.Components {
 		componentName := component.ServiceCname
 		componentEnName := dockerCompose.GetServiceName(component.ServiceShareID)
 		serviceDir := fmt.Sprintf("%s/%s", d.exportPath, componentEnName)
@@ -100,16 +193,39 @@ func (d *dockerComposeExporter) saveComponents() error {
 		}
 		if component.ShareImage != "" {
 			// app is image type
-			_, err := d.imageClient.ImagePull(component.ShareImage, component.AppImage.HubUser, component.AppImage.HubPassword, 30)
-			if err != nil {
-				return err
-			}
-			d.logger.Infof("pull component %s image success", componentName)
+			requests = append(requests, image.PullRequest{
+				Image:    component.ShareImage,
+				Username: component.AppImage.HubUser,
+				Password: component.AppImage.HubPassword,
+				Owners:   []string{"component " + componentName},
+			})
 			componentImageNames = append(componentImageNames, component.ShareImage)
 		}
 	}
+	// plugins run as sidecar services, their images are saved together with components
+	for _, component := range ram.Components {
+		for _, plugin := range composeSidecarPlugins(ram, component) {
+			if plugin.ShareImage == "" {
+				continue
+			}
+			requests = append(requests, image.PullRequest{
+				Image:    plugin.ShareImage,
+				Username: plugin.PluginImage.HubUser,
+				Password: plugin.PluginImage.HubPassword,
+				Owners:   []string{"plugin " + plugin.PluginName},
+			})
+			componentImageNames = append(componentImageNames, plugin.ShareImage)
+		}
+	}
+	if hasIngressRoutes(ram) {
+		requests = append(requests, image.PullRequest{Image: d.gatewayImage, Owners: []string{"gateway"}})
+		componentImageNames = append(componentImageNames, d.gatewayImage)
+	}
+	if err := image.PullImages(d.imageClient, requests, d.pull, d.logger); err != nil {
+		return err
+	}
 	start := time.Now()
-	err := d.imageClient.ImageSave(fmt.Sprintf("%s/component-images.tar", d.exportPath), componentImageNames)
+	err := image.SaveImages(d.imageClient, fmt.Sprintf("%s/component-images.tar", d.exportPath), uniqueImages(componentImageNames), d.pull)
 	if err != nil {
 		logrus.Errorf("Failed to save image(%v) : %s", componentImageNames, err)
 		return err
@@ -120,11 +236,21 @@ func (d *dockerComposeExporter) saveComponents() error {
 
 func (d *dockerComposeExporter) buildDockerComposeYaml() error {
 	y := &DockerComposeYaml{
-		Version:  "2.1",
 		Volumes:  make(map[string]GlobalVolume, 5),
 		Services: make(map[string]*Service, 5),
 	}
+	hostNetwork := d.networkMode == ComposeHostNetworkMode
+	if !hostNetwork {
+		y.Networks = map[string]GlobalNetwork{
+			composeNetworkName: {Driver: "bridge"},
+		}
+	}
 	dockerCompose := newDockerCompose(d.ram)
+	for _, warning := range dockerCompose.warnings {
+		d.report.warn(d.logger, "%s", warning)
+	}
+	publishedPorts := make(map[string]struct{})
+	variables := newComposeVariables()
 
 	for _, app := range d.ram.Components {
 		shareImage := app.ShareImage
@@ -140,30 +266,37 @@ func (d *dockerComposeExporter) buildDockerComposeYaml() error {
 			envs["PORT"] = fmt.Sprintf("%d", port.ContainerPort)
 		}
 		envs["MEMORY_SIZE"] = GetMemoryType(app.ExtendMethodRule.InitMemory)
-		for _, item := range append(app.Envs, app.ServiceConnectInfoMapList...) {
-			envs[item.AttrName] = item.AttrValue
-			if item.AttrValue == "**None**" {
-				envs[item.AttrName] = util.NewUUID()[:8]
-			}
+		// secrets and changeable envs reference the variables of the env file, the secrets
+		// are generated on the first start so every site gets its own
+		// a new slice, appending to app.Envs may write into the template of the caller
+		appEnvs := append(append([]v1alpha1.ComponentEnv(nil), app.Envs...), composeConnectInfo(app, appName, hostNetwork)...)
+		for _, item := range appEnvs {
+			envs[item.AttrName] = variables.reference(appName, item)
 		}
-		var depServices []string
+		dependsOn := make(map[string]ServiceDependency)
 		for _, item := range app.DepServiceMapList {
 			serviceKey := item.DepServiceKey
-			depEnvs := getPublicEnvByKey(serviceKey, d.ram.Components)
-			for k, v := range depEnvs {
-				if v == "**None**" {
-					v = util.NewUUID()[:8]
-				}
-				envs[k] = v
-			}
-			for _, app := range d.ram.Components {
-				if serviceKey == app.ComponentKey || serviceKey == app.ServiceShareID {
-					depServices = append(depServices, dockerCompose.GetServiceName(app.ServiceShareID))
+			for _, dep := range d.ram.Components {
+				if serviceKey == dep.ComponentKey || serviceKey == dep.ServiceShareID {
+					depName := dockerCompose.GetServiceName(dep.ServiceShareID)
+					// the connect info of the dependency shares the variables of the dependency
+					for _, env := range composeConnectInfo(dep, depName, hostNetwork) {
+						envs[env.AttrName] = variables.reference(depName, env)
+					}
+					condition := "service_started"
+					if composeHealthcheck(dep) != nil {
+						condition = "service_healthy"
+					}
+					dependsOn[depName] = ServiceDependency{Condition: condition}
+					break
 				}
 			}
 		}
 
 		for key, value := range envs {
+			if variables.isReference(value) {
+				continue
+			}
 			// env rendering
 			envs[key] = util.ParseVariable(value, envs)
 		}
@@ -172,61 +305,341 @@ func (d *dockerComposeExporter) buildDockerComposeYaml() error {
 			Image:         shareImage,
 			ContainerName: appName,
 			Restart:       "always",
-			NetworkMode:   "host",
 			Volumes:       volumes,
 			Command:       app.Cmd,
 			Environment:   envs,
+			Deploy:        composeDeploy(app),
+			Healthcheck:   composeHealthcheck(app),
+		}
+		if hostNetwork {
+			service.NetworkMode = ComposeHostNetworkMode
+		} else {
+			service.Networks = map[string]*ServiceNetwork{
+				composeNetworkName: {Aliases: composeAliases(app, appName)},
+			}
+			service.Ports = d.composePorts(app, publishedPorts)
 		}
 		service.Loggin.Driver = "json-file"
 		service.Loggin.Options.MaxSize = "5m"
 		service.Loggin.Options.MaxFile = "2"
-		if len(depServices) > 0 {
-			service.DependsOn = depServices
+		if len(dependsOn) > 0 {
+			service.DependsOn = dependsOn
 		}
 
 		y.Services[appName] = service
+		for _, sidecar := range d.buildSidecarServices(app, appName, hostNetwork) {
+			y.Services[sidecar.ContainerName] = sidecar
+		}
+	}
+
+	if hasIngressRoutes(d.ram) {
+		gateway, err := d.buildGatewayService(dockerCompose, publishedPorts)
+		if err != nil {
+			return err
+		}
+		y.Services[gatewayServiceName] = gateway
 	}
 
 	y.Volumes = dockerCompose.GetGlobalVolumes()
+	d.spec = y
+	d.variables = variables
 	content, err := yaml.Marshal(y)
 	if err != nil {
 		d.logger.Error("Failed to build yaml file: ", err)
 		return err
 	}
+	if d.dryRun {
+		return nil
+	}
 
-	err = ioutil.WriteFile(fmt.Sprintf("%s/docker-compose.yaml", d.exportPath), content, 0644)
+	err = ioutil.WriteFile(path.Join(d.exportPath, composeFileName), content, 0644)
 	if err != nil {
 		d.logger.Error("Failed to create yaml file: ", err)
 		return err
 	}
+	if err := variables.writeEnvFile(d.exportPath, d.ram); err != nil {
+		d.logger.Errorf("write env file failure %s", err.Error())
+		return err
+	}
 	return nil
 }
 
+// buildSidecarServices build the plugins of the component as sidecar services,
+// they share the network namespace of the component service.
+func (d *dockerComposeExporter) buildSidecarServices(app *v1alpha1.Component, appName string, hostNetwork bool) []*Service {
+	var services []*Service
+	for _, config := range app.ServicePluginConfigs {
+		if !config.PluginStatus {
+			continue
+		}
+		plugin := findPlugin(d.ram.Plugins, config)
+		if plugin == nil || plugin.ShareImage == "" {
+			d.report.warn(d.logger, "plugin %s of component %s not found, skip it", config.PluginKey, app.ServiceCname)
+			continue
+		}
+		alias := plugin.PluginAlias
+		if alias == "" {
+			alias = plugin.PluginKey
+		}
+		name := composeName(fmt.Sprintf("%s-%s", appName, alias))
+		service := &Service{
+			Image:         plugin.ShareImage,
+			ContainerName: name,
+			Restart:       "always",
+			NetworkMode:   "service:" + appName,
+			Environment:   oam.PluginConfigEnvs(plugin, config),
+			DependsOn: map[string]ServiceDependency{
+				appName: {Condition: "service_started"},
+			},
+		}
+		if hostNetwork {
+			service.NetworkMode = ComposeHostNetworkMode
+		}
+		service.Loggin.Driver = "json-file"
+		service.Loggin.Options.MaxSize = "5m"
+		service.Loggin.Options.MaxFile = "2"
+		services = append(services, service)
+	}
+	return services
+}
+
+// composeSidecarPlugins returns the enabled plugins of the component
+func composeSidecarPlugins(ram v1alpha1.RainbondApplicationConfig, app *v1alpha1.Component) []*v1alpha1.Plugin {
+	var plugins []*v1alpha1.Plugin
+	for _, config := range app.ServicePluginConfigs {
+		if !config.PluginStatus {
+			continue
+		}
+		if plugin := findPlugin(ram.Plugins, config); plugin != nil {
+			plugins = append(plugins, plugin)
+		}
+	}
+	return plugins
+}
+
+func findPlugin(plugins []*v1alpha1.Plugin, config v1alpha1.ComponentPluginConfig) *v1alpha1.Plugin {
+	for _, plugin := range plugins {
+		if plugin.PluginKey == config.PluginKey || (config.PluginID != "" && plugin.PluginID == config.PluginID) {
+			return plugin
+		}
+	}
+	return nil
+}
+
+// buildGatewayService write the nginx config implements the ingress routes and
+// build the reverse proxy service
+func (d *dockerComposeExporter) buildGatewayService(dockerCompose *dockerCompose, published map[string]struct{}) (*Service, error) {
+	hostNetwork := d.networkMode == ComposeHostNetworkMode
+	gateway := newNginxGateway(d.ram, func(com *v1alpha1.Component, port int) string {
+		if hostNetwork {
+			return fmt.Sprintf("127.0.0.1:%d", port)
+		}
+		return fmt.Sprintf("%s:%d", dockerCompose.GetServiceName(com.ServiceShareID), port)
+	}, hostNetwork)
+	conf := gateway.Render()
+	for _, warning := range gateway.Warnings {
+		d.report.warn(d.logger, "[gateway] %s", warning)
+	}
+	if !d.dryRun {
+		if err := os.MkdirAll(path.Join(d.exportPath, gatewayDir), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path.Join(d.exportPath, gatewayConfFile), []byte(conf), 0644); err != nil {
+			d.logger.Errorf("write gateway config failure %s", err.Error())
+			return nil, err
+		}
+	}
+	service := &Service{
+		Image:         d.gatewayImage,
+		ContainerName: gatewayServiceName,
+		Restart:       "always",
+		Volumes:       []string{"./" + gatewayConfFile + ":/etc/nginx/nginx.conf:ro"},
+	}
+	if hostNetwork {
+		service.NetworkMode = ComposeHostNetworkMode
+	} else {
+		service.Networks = map[string]*ServiceNetwork{composeNetworkName: {}}
+		for _, listener := range gateway.Listeners() {
+			hostPort := allocateHostPort(published, listener.Port, listener.Protocol)
+			if hostPort != listener.Port {
+				d.report.warn(d.logger, "host port %d/%s of gateway is already published, use %d instead", listener.Port, listener.Protocol, hostPort)
+			}
+			service.Ports = append(service.Ports, fmt.Sprintf("%d:%d/%s", hostPort, listener.Port, listener.Protocol))
+		}
+	}
+	if len(gateway.Targets) > 0 {
+		service.DependsOn = make(map[string]ServiceDependency)
+		for _, com := range gateway.Targets {
+			service.DependsOn[dockerCompose.GetServiceName(com.ServiceShareID)] = ServiceDependency{Condition: "service_started"}
+		}
+	}
+	service.Loggin.Driver = "json-file"
+	service.Loggin.Options.MaxSize = "5m"
+	service.Loggin.Options.MaxFile = "2"
+	return service, nil
+}
+
+// allocateHostPort returns the port itself or the next port not published yet
+func allocateHostPort(published map[string]struct{}, port int, protocol string) int {
+	hostPort := port
+	for {
+		if _, exists := published[fmt.Sprintf("%d/%s", hostPort, protocol)]; !exists {
+			break
+		}
+		hostPort++
+	}
+	published[fmt.Sprintf("%d/%s", hostPort, protocol)] = struct{}{}
+	return hostPort
+}
+
+// composePorts publish the outer ports of the component, a port already published
+// by another service is moved to the next free host port.
+func (d *dockerComposeExporter) composePorts(app *v1alpha1.Component, published map[string]struct{}) []string {
+	var ports []string
+	for _, port := range app.Ports {
+		if !port.IsOuter {
+			continue
+		}
+		protocol := "tcp"
+		if strings.ToLower(port.Protocol) == "udp" {
+			protocol = "udp"
+		}
+		hostPort := allocateHostPort(published, port.ContainerPort, protocol)
+		if hostPort != port.ContainerPort {
+			d.report.warn(d.logger, "host port %d/%s of %s is already published, use %d instead", port.ContainerPort, protocol, app.ServiceCname, hostPort)
+		}
+		ports = append(ports, fmt.Sprintf("%d:%d/%s", hostPort, port.ContainerPort, protocol))
+	}
+	return ports
+}
+
+// loopbackHost matches the loopback hosts in connect info, e.g. 127.0.0.1:3306 or jdbc:mysql://localhost/db
+var loopbackHost = regexp.MustCompile(`(^|[^\w.-])(127\.0\.0\.1|localhost)([^\w.-]|$)`)
+
+// composeConnectInfo returns the connect info of the component. On the host network the loopback
+// hosts reach the component, on the bridge network they are replaced by its service name.
+func composeConnectInfo(app *v1alpha1.Component, serviceName string, hostNetwork bool) []v1alpha1.ComponentEnv {
+	if hostNetwork {
+		return app.ServiceConnectInfoMapList
+	}
+	envs := make([]v1alpha1.ComponentEnv, 0, len(app.ServiceConnectInfoMapList))
+	for _, env := range app.ServiceConnectInfoMapList {
+		env.AttrValue = loopbackHost.ReplaceAllString(env.AttrValue, "${1}"+serviceName+"${3}")
+		envs = append(envs, env)
+	}
+	return envs
+}
+
+func composeAliases(app *v1alpha1.Component, serviceName string) []string {
+	var aliases []string
+	set := map[string]struct{}{serviceName: {}}
+	names := []string{app.K8SComponentName, app.ServiceAlias}
+	for _, port := range app.Ports {
+		names = append(names, port.K8sServiceName)
+	}
+	for _, name := range names {
+		if _, exists := set[name]; exists || name == "" {
+			continue
+		}
+		set[name] = struct{}{}
+		aliases = append(aliases, name)
+	}
+	return aliases
+}
+
+func composeDeploy(app *v1alpha1.Component) *ServiceDeploy {
+	if app.CPU <= 0 && app.Memory <= 0 {
+		return nil
+	}
+	deploy := &ServiceDeploy{}
+	if app.CPU > 0 {
+		// cpu unit is millicore
+		deploy.Resources.Limits.CPUs = fmt.Sprintf("%.3f", float64(app.CPU)/1000)
+	}
+	if app.Memory > 0 {
+		deploy.Resources.Limits.Memory = fmt.Sprintf("%dM", app.Memory)
+	}
+	return deploy
+}
+
+// composeHealthcheck build healthcheck from the readiness probe, fall back to the liveness probe
+func composeHealthcheck(app *v1alpha1.Component) *ServiceHealthcheck {
+	var probe *v1alpha1.ComponentProbe
+	for i := range app.Probes {
+		if !app.Probes[i].IsUsed {
+			continue
+		}
+		if probe == nil || app.Probes[i].Mode == "readiness" {
+			probe = &app.Probes[i]
+		}
+	}
+	if probe == nil {
+		return nil
+	}
+	var test string
+	switch {
+	case probe.Cmd != "":
+		test = probe.Cmd
+	case probe.Scheme == "http":
+		url := fmt.Sprintf("http://127.0.0.1:%d/%s", probe.Port, strings.TrimPrefix(probe.Path, "/"))
+		test = fmt.Sprintf("curl -fs %s >/dev/null || wget -q -O /dev/null %s", url, url)
+	case probe.Port > 0:
+		test = fmt.Sprintf("nc -z 127.0.0.1 %d || bash -c 'echo > /dev/tcp/127.0.0.1/%d'", probe.Port, probe.Port)
+	default:
+		return nil
+	}
+	hc := &ServiceHealthcheck{
+		Test: []string{"CMD-SHELL", test},
+	}
+	if probe.PeriodSecond > 0 {
+		hc.Interval = fmt.Sprintf("%ds", probe.PeriodSecond)
+	}
+	if probe.TimeoutSecond > 0 {
+		hc.Timeout = fmt.Sprintf("%ds", probe.TimeoutSecond)
+	}
+	if probe.FailureThreshold > 0 {
+		hc.Retries = probe.FailureThreshold
+	}
+	if probe.InitialDelaySecond > 0 {
+		hc.StartPeriod = fmt.Sprintf("%ds", probe.InitialDelaySecond)
+	}
+	return hc
+}
+
 func (d *dockerComposeExporter) buildStartScript() error {
-	if err := ioutil.WriteFile(path.Join(d.exportPath, "run.sh"), []byte(runScritShell), 0755); err != nil {
+	script := runScritShell
+	if d.installer != "" {
+		script = installerRunScript
+	}
+	if err := ioutil.WriteFile(path.Join(d.exportPath, composeRunScript), []byte(script), 0755); err != nil {
 		d.logger.Errorf("write run shell script failure %s", err.Error())
 		return err
 	}
 	return nil
 }
 
-//DockerComposeYaml -
+//DockerComposeYaml compose specification file, the top level version field is obsolete
 type DockerComposeYaml struct {
-	Version  string                  `yaml:"version"`
-	Volumes  map[string]GlobalVolume `yaml:"volumes,omitempty"`
-	Services map[string]*Service     `yaml:"services,omitempty"`
+	Version  string                   `yaml:"version,omitempty"`
+	Networks map[string]GlobalNetwork `yaml:"networks,omitempty"`
+	Volumes  map[string]GlobalVolume  `yaml:"volumes,omitempty"`
+	Services map[string]*Service      `yaml:"services,omitempty"`
 }
 
 //Service service
 type Service struct {
-	Image         string            `yaml:"image"`
-	ContainerName string            `yaml:"container_name,omitempty"`
-	Restart       string            `yaml:"restart,omitempty"`
-	NetworkMode   string            `yaml:"network_mode,omitempty"`
-	Volumes       []string          `yaml:"volumes,omitempty"`
-	Command       string            `yaml:"command,omitempty"`
-	Environment   map[string]string `yaml:"environment,omitempty"`
-	DependsOn     []string          `yaml:"depends_on,omitempty"`
+	Image         string                       `yaml:"image"`
+	ContainerName string                       `yaml:"container_name,omitempty"`
+	Restart       string                       `yaml:"restart,omitempty"`
+	NetworkMode   string                       `yaml:"network_mode,omitempty"`
+	Networks      map[string]*ServiceNetwork   `yaml:"networks,omitempty"`
+	Ports         []string                     `yaml:"ports,omitempty"`
+	Volumes       []string                     `yaml:"volumes,omitempty"`
+	Command       string                       `yaml:"command,omitempty"`
+	Environment   map[string]string            `yaml:"environment,omitempty"`
+	DependsOn     map[string]ServiceDependency `yaml:"depends_on,omitempty"`
+	Deploy        *ServiceDeploy               `yaml:"deploy,omitempty"`
+	Healthcheck   *ServiceHealthcheck          `yaml:"healthcheck,omitempty"`
 	Loggin        struct {
 		Driver  string `yaml:"driver,omitempty"`
 		Options struct {
@@ -236,16 +649,51 @@ type Service struct {
 	} `yaml:"logging,omitempty"`
 }
 
-//GlobalVolume -
+// ServiceNetwork service network attachment
+type ServiceNetwork struct {
+	Aliases []string `yaml:"aliases,omitempty"`
+}
+
+// ServiceDependency long syntax of depends_on
+type ServiceDependency struct {
+	Condition string `yaml:"condition"`
+}
+
+// ServiceDeploy service deploy config, only resource limits is used
+type ServiceDeploy struct {
+	Resources struct {
+		Limits struct {
+			CPUs   string `yaml:"cpus,omitempty"`
+			Memory string `yaml:"memory,omitempty"`
+		} `yaml:"limits"`
+	} `yaml:"resources"`
+}
+
+// ServiceHealthcheck service healthcheck
+type ServiceHealthcheck struct {
+	Test        []string `yaml:"test"`
+	Interval    string   `yaml:"interval,omitempty"`
+	Timeout     string   `yaml:"timeout,omitempty"`
+	Retries     int      `yaml:"retries,omitempty"`
+	StartPeriod string   `yaml:"start_period,omitempty"`
+}
+
 type GlobalVolume struct {
 	External bool `yaml:"external"`
 }
 
+// GlobalNetwork top level network
+type GlobalNetwork struct {
+	Driver string `yaml:"driver,omitempty"`
+}
+
 type dockerCompose struct {
 	ram            v1alpha1.RainbondApplicationConfig
 	globalVolumes  []string
 	serviceVolumes map[string][]string
 	serviceNames   map[string]string
+	// warnings the volumes dropped from the services
+	warnings []string
 }
 
 func newDockerCompose(ram v1alpha1.RainbondApplicationConfig) *dockerCompose {
@@ -306,7 +754,7 @@ func (d *dockerCompose) buildVolumes() (map[string][]string, []string) {
 		for _, dvol := range cpt.MntReleationList {
 			vol := volumeMaps[dvol.ShareServiceUUID+dvol.VolumeName]
 			if vol == "" {
-				logrus.Warningf("[dockerCompose] [buildVolumes] dependent volume(%s/%s) not found", dvol.ShareServiceUUID, dvol.VolumeName)
+				d.warnings = append(d.warnings, fmt.Sprintf("dependent volume %s/%s of component %s not found, drop it", dvol.ShareServiceUUID, dvol.VolumeName, cpt.ServiceCname))
 				continue
 			}
 			componentVolumes[cpt.ServiceShareID] = append(componentVolumes[cpt.ServiceShareID], fmt.Sprintf("%s:%s", vol, dvol.VolumeMountDir))
@@ -398,7 +846,7 @@ check::dependency() {
     iprint 'successful install docker!'
   }
 
-  which docker-compose &>/dev/null || {
+  compose version &>/dev/null || {
     eprint 'Not found docker-compose command!'
 
     install::docker-compose || {
@@ -421,22 +869,51 @@ install::docker() {
 }
 
 install::docker-compose() {
-  curl -L "https://github.com/docker/compose/releases/download/1.24.0/docker-compose-$(uname -s)-$(uname -m)" -o /usr/local/bin/docker-compose
+  curl -L "https://github.com/docker/compose/releases/download/v2.20.3/docker-compose-$(uname -s | tr A-Z a-z)-$(uname -m)" -o /usr/local/bin/docker-compose
   chmod +x /usr/local/bin/docker-compose
   which docker-compose &>/dev/null
 }
 
+# compose specification requires docker compose v2 or docker-compose 1.27+
+compose() {
+  if docker compose version &>/dev/null; then
+    docker compose "$@"
+  else
+    docker-compose "$@"
+  fi
+}
+
 import::image() {
-  docker load -i component-images.tar
+  if [ -d images ]; then
+    tar -C images -cf - . | docker load
+  elif [ -f component-images.tar ]; then
+    docker load -i component-images.tar
+  fi
+}
+
+# fill in the empty secrets of .env on the first start, every site gets its own
+generate::secrets() {
+  [ -f .env ] || return 0
+  local line comment="" tmp=.env.tmp
+  : >$tmp
+  while IFS= read -r line || [ -n "$line" ]; do
+    if [[ $line =~ ^[A-Za-z_][A-Za-z0-9_]*=$ ]] && [[ $comment == *", generated secret" ]]; then
+      line="${line}$(head -c 16 /dev/urandom | od -An -tx1 | tr -d ' \n')"
+    fi
+    echo "$line" >>$tmp
+    comment="$line"
+  done <.env
+  chmod 600 $tmp && mv $tmp .env
 }
 
 start() {
   import::image
-  docker-compose -f docker-compose.yaml up -d
+  generate::secrets
+  compose -f docker-compose.yaml up -d
 }
 
 stop() {
-  docker-compose -f docker-compose.yaml down
+  compose -f docker-compose.yaml down
 }
 
 main() {
@@ -0,0 +1,306 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/installer"
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+func newComposeTestTemplate() v1alpha1.RainbondApplicationConfig {
+	return v1alpha1.RainbondApplicationConfig{
+		AppName:    "demo",
+		AppVersion: "1.0",
+		Components: []*v1alpha1.Component{
+			{
+				ServiceCname:      "web",
+				ServiceShareID:    "web-share",
+				ComponentKey:      "web-key",
+				K8SComponentName:  "web-k8s",
+				ShareImage:        "registry.example.com/demo/web:v1",
+				CPU:               500,
+				Memory:            512,
+				Ports:             []v1alpha1.ComponentPort{{ContainerPort: 8080, Protocol: "http", IsOuter: true}},
+				DepServiceMapList: []v1alpha1.ComponentDep{{DepServiceKey: "db-key"}},
+			},
+			{
+				ServiceCname:     "db",
+				ServiceShareID:   "db-share",
+				ComponentKey:     "db-key",
+				K8SComponentName: "db-k8s",
+				ShareImage:       "registry.example.com/demo/db:v1",
+				Ports:            []v1alpha1.ComponentPort{{ContainerPort: 8080, Protocol: "tcp", IsOuter: true}},
+				Probes:           []v1alpha1.ComponentProbe{{Mode: "readiness", Scheme: "tcp", Port: 8080, IsUsed: true, PeriodSecond: 3}},
+			},
+		},
+	}
+}
+
+func buildTestComposeYaml(t *testing.T, networkMode string) *DockerComposeYaml {
+	t.Helper()
+	exportPath := t.TempDir()
+	d := &dockerComposeExporter{
+		logger:      logrus.StandardLogger(),
+		ram:         newComposeTestTemplate(),
+		networkMode: networkMode,
+		exportPath:  exportPath,
+	}
+	if err := d.buildDockerComposeYaml(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path.Join(exportPath, "docker-compose.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var y DockerComposeYaml
+	if err := yaml.Unmarshal(content, &y); err != nil {
+		t.Fatal(err)
+	}
+	return &y
+}
+
+func TestBuildDockerComposeYamlUsesBridgeNetwork(t *testing.T) {
+	y := buildTestComposeYaml(t, ComposeBridgeNetworkMode)
+	if _, ok := y.Networks[composeNetworkName]; !ok {
+		t.Fatalf("expected bridge network %s, got %v", composeNetworkName, y.Networks)
+	}
+	web := y.Services["web"]
+	if web.NetworkMode != "" {
+		t.Fatalf("expected no network mode in bridge mode, got %s", web.NetworkMode)
+	}
+	if aliases := web.Networks[composeNetworkName].Aliases; len(aliases) != 1 || aliases[0] != "web-k8s" {
+		t.Fatalf("expected k8s component name alias, got %v", aliases)
+	}
+	if web.Deploy == nil || web.Deploy.Resources.Limits.CPUs != "0.500" || web.Deploy.Resources.Limits.Memory != "512M" {
+		t.Fatalf("unexpected resource limits %+v", web.Deploy)
+	}
+	if dep := web.DependsOn["db"]; dep.Condition != "service_healthy" {
+		t.Fatalf("expected web to wait for healthy db, got %+v", web.DependsOn)
+	}
+	if y.Services["db"].Healthcheck == nil {
+		t.Fatalf("expected db healthcheck")
+	}
+	// both services publish 8080, one of them must be moved
+	published := map[string]struct{}{}
+	for _, svc := range y.Services {
+		for _, port := range svc.Ports {
+			if _, exists := published[port[:4]]; exists {
+				t.Fatalf("host port %s published twice", port)
+			}
+			published[port[:4]] = struct{}{}
+		}
+	}
+}
+
+func TestBuildDockerComposeYamlKeepsHostNetworkMode(t *testing.T) {
+	y := buildTestComposeYaml(t, ComposeHostNetworkMode)
+	if len(y.Networks) != 0 {
+		t.Fatalf("expected no networks in host mode, got %v", y.Networks)
+	}
+	for name, svc := range y.Services {
+		if svc.NetworkMode != ComposeHostNetworkMode {
+			t.Fatalf("expected service %s to use host network", name)
+		}
+		if len(svc.Ports) != 0 {
+			t.Fatalf("expected no published ports in host mode, got %v", svc.Ports)
+		}
+	}
+}
+
+func TestBuildDockerComposeYamlRendersPluginSidecars(t *testing.T) {
+	ram := newComposeTestTemplate()
+	ram.Plugins = []*v1alpha1.Plugin{
+		{
+			PluginKey:   "mesh",
+			PluginAlias: "mesh",
+			ShareImage:  "registry.example.com/demo/mesh:v1",
+			ConfigGroups: []v1alpha1.PluginConfigGroup{
+				{Options: []v1alpha1.PluginConfigGroupOption{{AttrName: "LOG_LEVEL", AttrDefaultValue: "info"}}},
+			},
+		},
+	}
+	ram.Components[0].ServicePluginConfigs = []v1alpha1.ComponentPluginConfig{
+		{PluginKey: "mesh", PluginStatus: true},
+	}
+	exportPath := t.TempDir()
+	d := &dockerComposeExporter{
+		logger:      logrus.StandardLogger(),
+		ram:         ram,
+		networkMode: ComposeBridgeNetworkMode,
+		exportPath:  exportPath,
+	}
+	if err := d.buildDockerComposeYaml(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path.Join(exportPath, "docker-compose.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var y DockerComposeYaml
+	if err := yaml.Unmarshal(content, &y); err != nil {
+		t.Fatal(err)
+	}
+	sidecar, ok := y.Services["web-mesh"]
+	if !ok {
+		t.Fatalf("expected sidecar service web-mesh, got %v", y.Services)
+	}
+	if sidecar.NetworkMode != "service:web" {
+		t.Fatalf("expected sidecar to share web network namespace, got %s", sidecar.NetworkMode)
+	}
+	if sidecar.Environment["LOG_LEVEL"] != "info" {
+		t.Fatalf("expected plugin config env to be injected, got %v", sidecar.Environment)
+	}
+}
+
+func TestComposeInstallerBundle(t *testing.T) {
+	fakeResolveImageDigest(t)
+	home := t.TempDir()
+	binary := path.Join(t.TempDir(), "installer")
+	if err := ioutil.WriteFile(binary, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ram := newComposeTestTemplate()
+	ram.AppName = "Demo App"
+	ram.Components[0].Envs = []v1alpha1.ComponentEnv{
+		{AttrName: "TITLE", Name: "site title", AttrValue: "demo", IsChange: true},
+		{AttrName: "MODE", AttrValue: "prod"},
+	}
+	exporter, err := newExporter(DC, home, ram, nil, logrus.StandardLogger(), newOptions(WithOnlineMode(false), WithInstaller(binary)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exporter.Export(); err != nil {
+		t.Fatal(err)
+	}
+	exportPath := path.Join(home, "Demo App-1.0-dockercompose")
+	bundle, err := installer.ReadBundle(exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Project != "demo_app" || len(bundle.Images) != 0 {
+		t.Fatalf("unexpected bundle %+v", bundle)
+	}
+	if len(bundle.Services) != 2 || bundle.Services[0].Name != "db" || !bundle.Services[0].Healthcheck {
+		t.Fatalf("expected db to be started first, got %+v", bundle.Services)
+	}
+	if len(bundle.Parameters) != 1 || bundle.Parameters[0].Name != "WEB_TITLE" || bundle.Parameters[0].Default != "demo" || bundle.Parameters[0].Secret {
+		t.Fatalf("unexpected parameters %+v", bundle.Parameters)
+	}
+	if len(bundle.Ports) != 2 || bundle.Ports[0].Port != 8080 || bundle.Ports[1].Port != 8081 {
+		t.Fatalf("unexpected ports %+v", bundle.Ports)
+	}
+	script, err := ioutil.ReadFile(path.Join(exportPath, "run.sh"))
+	if err != nil || !strings.Contains(string(script), "exec ./installer") || strings.Contains(string(script), "curl") {
+		t.Fatalf("expected the run script to use the installer, got %s %v", script, err)
+	}
+	if info, err := os.Stat(path.Join(exportPath, installer.BinaryName)); err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Fatalf("expected the installer to be shipped executable, got %v %v", info, err)
+	}
+}
+
+func TestBuildDockerComposeYamlWritesEnvFile(t *testing.T) {
+	exportPath := t.TempDir()
+	ram := newComposeTestTemplate()
+	ram.Components[0].Envs = []v1alpha1.ComponentEnv{
+		{Name: "title of the site", AttrName: "TITLE", AttrValue: "my demo", IsChange: true},
+		{AttrName: "DB_URL", AttrValue: "mysql://root:${MYSQL_PASSWORD}@db"},
+	}
+	ram.Components[1].ServiceConnectInfoMapList = []v1alpha1.ComponentEnv{
+		{AttrName: "MYSQL_PASSWORD", AttrValue: "**None**"},
+		{AttrName: "MYSQL_USER", AttrValue: "root"},
+	}
+	d := &dockerComposeExporter{
+		logger:      logrus.StandardLogger(),
+		ram:         ram,
+		networkMode: ComposeBridgeNetworkMode,
+		exportPath:  exportPath,
+	}
+	if err := d.buildDockerComposeYaml(); err != nil {
+		t.Fatal(err)
+	}
+	web, db := d.spec.Services["web"].Environment, d.spec.Services["db"].Environment
+	// the consumer shares the secret of the dependency instead of a value generated at export time
+	if db["MYSQL_PASSWORD"] != "${DB_MYSQL_PASSWORD}" || web["MYSQL_PASSWORD"] != "${DB_MYSQL_PASSWORD}" {
+		t.Fatalf("expected the secret to be a shared variable, got web %v db %v", web, db)
+	}
+	if web["DB_URL"] != "mysql://root:${DB_MYSQL_PASSWORD}@db" || web["MYSQL_USER"] != "root" || web["TITLE"] != "${WEB_TITLE}" {
+		t.Fatalf("unexpected web envs %v", web)
+	}
+	env, err := ioutil.ReadFile(path.Join(exportPath, composeEnvFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# web TITLE (title of the site), can be changed\nWEB_TITLE='my demo'\n",
+		"# db MYSQL_PASSWORD, generated secret\nDB_MYSQL_PASSWORD=\n",
+	} {
+		if !strings.Contains(string(env), line) {
+			t.Fatalf("expected %q in env file:\n%s", line, env)
+		}
+	}
+	if strings.Count(string(env), "DB_MYSQL_PASSWORD=") != 1 {
+		t.Fatalf("expected the shared secret to be written once:\n%s", env)
+	}
+}
+
+func TestBuildDockerComposeYamlRewritesLoopbackConnectInfo(t *testing.T) {
+	// the loopback hosts reach the dependency on the host network only
+	for mode, expected := range map[string][2]string{
+		ComposeBridgeNetworkMode: {"db", "jdbc:mysql://db:3306/demo"},
+		ComposeHostNetworkMode:   {"127.0.0.1", "jdbc:mysql://localhost:3306/demo"},
+	} {
+		ram := newComposeTestTemplate()
+		ram.Components[1].ServiceConnectInfoMapList = []v1alpha1.ComponentEnv{
+			{AttrName: "MYSQL_HOST", AttrValue: "127.0.0.1"},
+			{AttrName: "MYSQL_URL", AttrValue: "jdbc:mysql://localhost:3306/demo"},
+			{AttrName: "MYSQL_BACKUP_HOST", AttrValue: "127.0.0.10"},
+		}
+		d := &dockerComposeExporter{logger: logrus.StandardLogger(), ram: ram, networkMode: mode, exportPath: t.TempDir()}
+		if err := d.buildDockerComposeYaml(); err != nil {
+			t.Fatal(err)
+		}
+		web := d.spec.Services["web"].Environment
+		if web["MYSQL_HOST"] != expected[0] || web["MYSQL_URL"] != expected[1] || web["MYSQL_BACKUP_HOST"] != "127.0.0.10" {
+			t.Fatalf("unexpected connect info in %s mode %v", mode, web)
+		}
+	}
+}
+
+func TestBuildDockerComposeYamlKeepsTheEnvsOfTheTemplate(t *testing.T) {
+	ram := newComposeTestTemplate()
+	envs := make([]v1alpha1.ComponentEnv, 1, 4)
+	envs[0] = v1alpha1.ComponentEnv{AttrName: "MODE", AttrValue: "prod"}
+	ram.Components[1].Envs = envs
+	ram.Components[1].ServiceConnectInfoMapList = []v1alpha1.ComponentEnv{{AttrName: "DB_HOST", AttrValue: "127.0.0.1"}}
+	d := &dockerComposeExporter{logger: logrus.StandardLogger(), ram: ram, exportPath: t.TempDir()}
+	if err := d.buildDockerComposeYaml(); err != nil {
+		t.Fatal(err)
+	}
+	if spare := envs[:2][1]; spare.AttrName != "" {
+		t.Fatalf("expected the envs of the template not to be written, got %+v", spare)
+	}
+}
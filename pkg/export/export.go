@@ -19,18 +19,22 @@
 package export
 
 import (
-	"fmt"
+	"crypto"
 	"github.com/containerd/containerd"
 	dockercli "github.com/docker/docker/client"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
 	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/sbom"
 	"github.com/sirupsen/logrus"
-	"path"
 )
 
 //AppLocalExport export local package
 type AppLocalExport interface {
 	Export() (*Result, error)
+	// Plan describes the export without pulling or writing anything, the report lists
+	// the images, the files to render, the package path and the problems of the app
+	Plan() (*ExportReport, error)
 }
 
 //Result export result
@@ -38,6 +42,7 @@ type Result struct {
 	PackagePath   string
 	PackageName   string
 	PackageFormat string
+	Report        *ExportReport
 }
 
 //AppFormat app spec format
@@ -52,52 +57,248 @@ var (
 	SLG AppFormat = "slug"
 	//HELM
 	HELM AppFormat = "helm-chart"
+	//VELA kubevela core.oam.dev/v1beta1 application
+	VELA AppFormat = "kubevela"
 )
 
-//New new exporter
-func New(format AppFormat, homePath string, ram v1alpha1.RainbondApplicationConfig, containerdCli *containerd.Client, dockerCli *dockercli.Client, logger *logrus.Logger) (AppLocalExport, error) {
+const (
+	//OfflineMode images are saved into the package
+	OfflineMode = "offline"
+	//OnlineMode images stay in their registries, the package references them pinned to digests
+	OnlineMode = "online"
+)
+
+//Options export options
+type Options struct {
+	// Mode offline(default) or online, slug packages are always offline
+	Mode string
+	// KeepCredentials keep the registry credentials of the images in online packages
+	KeepCredentials bool
+	// ComposeNetworkMode docker compose network mode, bridge(default) or host
+	ComposeNetworkMode string
+	// GatewayImage reverse proxy image that implements the ingress routes in docker compose export
+	GatewayImage string
+	// SlugSystemd generate systemd units to run the slug package
+	SlugSystemd bool
+	// PackageFormat archive format of the package, tar.gz(default), tar.zst or zip
+	PackageFormat archive.Format
+	// Signer signs the package manifest, the package is not signed if it is nil
+	Signer crypto.Signer
+	// BasePackage previous package of the app, only the files and image layers
+	// not in it are exported if it is set
+	BasePackage string
+	// OCIImageLayout store the images in an OCI image layout under images/ instead of
+	// docker save tarballs, layers shared by components and plugins are stored once,
+	// slug packages load the images in run scripts and keep the tarballs
+	OCIImageLayout bool
+	// PullConcurrency images pulled at the same time, 4 by default
+	PullConcurrency int
+	// PullTimeout timeout of one image pull in minutes, 30 by default
+	PullTimeout int
+	// SplitSize split the package into numbered parts of at most SplitSize bytes with a
+	// part index, the package is not split if it is 0
+	SplitSize int64
+	// SBOMFormat write the SBOM document of every component and plugin image into the
+	// package, no SBOM is written if it is empty. The images are required, so it only
+	// works in offline mode.
+	SBOMFormat sbom.Format
+	// Installer the installer binary built from cmd/installer for the target hosts, docker compose
+	// packages ship it and their run script installs the app through it without network access
+	Installer string
+	// Platforms the platforms the images are pulled and saved for, e.g. linux/amd64 and linux/arm64,
+	// the platform of the host if it is empty. Other platforms require containerd.
+	Platforms []string
+	// ComponentArch pull and save the image of each component for the arch of the component,
+	// the images of the components without arch are saved for Platforms
+	ComponentArch bool
+	// ImageCacheDir the saved images are kept in the cache dir and taken from it by later exports
+	// if their digests did not change, no cache is used if it is empty
+	ImageCacheDir string
+	// ImageCacheSize size limit of the image cache in bytes, the least recently used images are evicted
+	ImageCacheSize int64
+	// VMDisks write the disks of the VM components as qcow2 or raw files under vm-disks instead of
+	// saving their images, the files are recorded in the disk layouts of the app template
+	VMDisks bool
+	// ReadmeHTML write the README of the package as HTML as well
+	ReadmeHTML bool
+	// Topology draw the topology of the app into the package in the formats, the Mermaid
+	// flowchart is embedded in the README as well
+	Topology []TopologyFormat
+}
+
+//Option set export option
+type Option func(*Options)
+
+//WithComposeNetworkMode set docker compose network mode, support bridge and host
+func WithComposeNetworkMode(mode string) Option {
+	return func(o *Options) {
+		o.ComposeNetworkMode = mode
+	}
+}
+
+//WithGatewayImage set the nginx image used to implement the ingress routes
+func WithGatewayImage(image string) Option {
+	return func(o *Options) {
+		o.GatewayImage = image
+	}
+}
+
+//WithSlugSystemd run slug package with systemd units instead of nohup scripts
+func WithSlugSystemd() Option {
+	return func(o *Options) {
+		o.SlugSystemd = true
+	}
+}
+
+//WithPackageFormat set the archive format of the package
+func WithPackageFormat(format archive.Format) Option {
+	return func(o *Options) {
+		o.PackageFormat = format
+	}
+}
+
+//WithSigner sign the package with the key, ed25519, ecdsa and rsa keys are supported
+func WithSigner(signer crypto.Signer) Option {
+	return func(o *Options) {
+		o.Signer = signer
+	}
+}
+
+//WithBasePackage export the changes against the previous package, basePackage is
+//the package archive, the extracted package dir or its manifest.json
+func WithBasePackage(basePackage string) Option {
+	return func(o *Options) {
+		o.BasePackage = basePackage
+	}
+}
+
+//WithOCIImageLayout store the images of the package in an OCI image layout
+func WithOCIImageLayout() Option {
+	return func(o *Options) {
+		o.OCIImageLayout = true
+	}
+}
+
+//WithOnlineMode export the app without images, the images are pinned to their digests and
+//pulled from their registries, the registry credentials are stripped unless keepCredentials is set
+func WithOnlineMode(keepCredentials bool) Option {
+	return func(o *Options) {
+		o.Mode = OnlineMode
+		o.KeepCredentials = keepCredentials
+	}
+}
+
+//WithPullConcurrency set the number of images pulled at the same time
+func WithPullConcurrency(concurrency int) Option {
+	return func(o *Options) {
+		o.PullConcurrency = concurrency
+	}
+}
+
+//WithPullTimeout set the timeout of one image pull in minutes
+func WithPullTimeout(minutes int) Option {
+	return func(o *Options) {
+		o.PullTimeout = minutes
+	}
+}
+
+//WithSplitSize split the package into parts of at most size bytes, e.g. for size-limited media
+func WithSplitSize(size int64) Option {
+	return func(o *Options) {
+		o.SplitSize = size
+	}
+}
+
+//WithSBOM write the SBOM documents of the images in the format, spdx or cyclonedx
+func WithSBOM(format sbom.Format) Option {
+	return func(o *Options) {
+		o.SBOMFormat = format
+	}
+}
+
+//WithInstaller ship the installer binary in docker compose packages, the app is installed,
+//upgraded and uninstalled through it without network access
+func WithInstaller(binary string) Option {
+	return func(o *Options) {
+		o.Installer = binary
+	}
+}
+
+//WithPlatforms pull and save the images for the platforms, e.g. linux/amd64 and linux/arm64
+func WithPlatforms(platforms ...string) Option {
+	return func(o *Options) {
+		o.Platforms = platforms
+	}
+}
+
+//WithComponentArch pull and save the image of each component for the arch of the component
+func WithComponentArch() Option {
+	return func(o *Options) {
+		o.ComponentArch = true
+	}
+}
+
+//WithImageCache keep the saved images in the cache dir and take unchanged images from it, the
+//least recently used images are evicted when the cache exceeds maxSize bytes
+func WithImageCache(dir string, maxSize int64) Option {
+	return func(o *Options) {
+		o.ImageCacheDir = dir
+		o.ImageCacheSize = maxSize
+	}
+}
+
+//WithVMDisks write the disks of the VM components as standalone files under vm-disks
+func WithVMDisks() Option {
+	return func(o *Options) {
+		o.VMDisks = true
+	}
+}
+
+//WithReadmeHTML write README.html next to the README.md of the package
+func WithReadmeHTML() Option {
+	return func(o *Options) {
+		o.ReadmeHTML = true
+	}
+}
+
+//WithTopology draw the topology of the app into the package, e.g. topology.dot for TopologyDOT
+func WithTopology(formats ...TopologyFormat) Option {
+	return func(o *Options) {
+		o.Topology = formats
+	}
+}
+
+func newOptions(opts ...Option) Options {
+	options := Options{
+		Mode:               OfflineMode,
+		ComposeNetworkMode: ComposeBridgeNetworkMode,
+		GatewayImage:       DefaultGatewayImage,
+		PackageFormat:      archive.TarGz,
+		PullConcurrency:    image.DefaultPullConcurrency,
+		PullTimeout:        image.DefaultPullTimeout,
+		ImageCacheSize:     image.DefaultCacheSize,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+//New new exporter of the registered format, an error is returned if the format is not registered
+func New(format AppFormat, homePath string, ram v1alpha1.RainbondApplicationConfig, containerdCli *containerd.Client, dockerCli *dockercli.Client, logger *logrus.Logger, opts ...Option) (AppLocalExport, error) {
 	imageClient, err := image.NewClient(containerdCli, dockerCli)
 	if err != nil {
 		logger.Errorf("create image client error: %v", err)
 		return nil, err
 	}
-	switch format {
-	case RAM:
-		return &ramExporter{
-			logger:      logger,
-			ram:         ram,
-			imageClient: imageClient,
-			mode:        "offline",
-			homePath:    homePath,
-			exportPath:  path.Join(homePath, fmt.Sprintf("%s-%s-ram", ram.AppName, ram.AppVersion)),
-		}, nil
-	case DC:
-		return &dockerComposeExporter{
-			logger:      logger,
-			ram:         ram,
-			imageClient: imageClient,
-			homePath:    homePath,
-			exportPath:  path.Join(homePath, fmt.Sprintf("%s-%s-dockercompose", ram.AppName, ram.AppVersion)),
-		}, nil
-	case SLG:
-		return &slugExporter{
-			logger:      logger,
-			ram:         ram,
-			imageClient: imageClient,
-			mode:        "offline",
-			homePath:    homePath,
-			exportPath:  path.Join(homePath, fmt.Sprintf("%s-%s-slug", ram.AppName, ram.AppVersion)),
-		}, nil
-	case HELM:
-		return &helmChartExporter{
-			logger:      logger,
-			ram:         ram,
-			imageClient: imageClient,
-			mode:        "offline",
-			homePath:    homePath,
-			exportPath:  path.Join(homePath, fmt.Sprintf("%s-%s-helm", ram.AppName, ram.AppVersion)),
-		}, nil
-	default:
-		panic("not support app format")
+	return newExporter(format, homePath, ram, imageClient, logger, newOptions(opts...))
+}
+
+func newExporter(format AppFormat, homePath string, ram v1alpha1.RainbondApplicationConfig, imageClient image.Client, logger *logrus.Logger, options Options) (AppLocalExport, error) {
+	p, err := newPipeline(format, homePath, ram, imageClient, logger, options)
+	if err != nil {
+		logger.Errorf("create exporter error: %v", err)
+		return nil, err
 	}
+	return p, nil
 }
@@ -0,0 +1,237 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util"
+)
+
+const (
+	// DefaultGatewayImage the reverse proxy image used by docker compose export
+	DefaultGatewayImage = "nginx:1.25-alpine"
+
+	gatewayDir = "gateway"
+	// gatewayConfFile the nginx config of the gateway, relative to the package root
+	gatewayConfFile    = gatewayDir + "/nginx.conf"
+	gatewayServiceName = "rainbond-gateway"
+	gatewayHTTPPort    = 80
+)
+
+// gatewayListener a port the reverse proxy listens on
+type gatewayListener struct {
+	Port     int
+	Protocol string
+}
+
+// gatewayUpstream resolve the address of the component port
+type gatewayUpstream func(com *v1alpha1.Component, port int) string
+
+// nginxGateway render the ingress routes of the template to nginx config
+type nginxGateway struct {
+	ram      v1alpha1.RainbondApplicationConfig
+	upstream gatewayUpstream
+	// hostNetwork the gateway shares the network with components, stream routes
+	// would listen on the same port as the component, so they are not rendered
+	hostNetwork bool
+	// Warnings routes that can not be expressed
+	Warnings []string
+	// Targets components referenced by routes
+	Targets []*v1alpha1.Component
+}
+
+func newNginxGateway(ram v1alpha1.RainbondApplicationConfig, upstream gatewayUpstream, hostNetwork bool) *nginxGateway {
+	return &nginxGateway{ram: ram, upstream: upstream, hostNetwork: hostNetwork}
+}
+
+func hasIngressRoutes(ram v1alpha1.RainbondApplicationConfig) bool {
+	return len(ram.IngressHTTPRoutes) > 0 || len(ram.IngressSreamRoutes) > 0
+}
+
+func (n *nginxGateway) findComponent(key string) *v1alpha1.Component {
+	for _, com := range n.ram.Components {
+		if com.ComponentKey == key || com.ServiceShareID == key {
+			return com
+		}
+	}
+	return nil
+}
+
+func (n *nginxGateway) addTarget(com *v1alpha1.Component) {
+	for _, target := range n.Targets {
+		if target == com {
+			return
+		}
+	}
+	n.Targets = append(n.Targets, com)
+}
+
+// Listeners returns all ports the gateway listens on
+func (n *nginxGateway) Listeners() []gatewayListener {
+	var listeners []gatewayListener
+	if len(n.ram.IngressHTTPRoutes) > 0 {
+		listeners = append(listeners, gatewayListener{Port: gatewayHTTPPort, Protocol: "tcp"})
+	}
+	if n.hostNetwork {
+		return listeners
+	}
+	for _, route := range n.ram.IngressSreamRoutes {
+		listeners = append(listeners, gatewayListener{Port: int(route.Port), Protocol: streamProtocol(route.Protocol)})
+	}
+	return listeners
+}
+
+func streamProtocol(protocol string) string {
+	if strings.ToLower(protocol) == "udp" {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// Render returns the nginx.conf content
+func (n *nginxGateway) Render() string {
+	var b strings.Builder
+	b.WriteString("# generated by rainbond, implements the ingress routes of the app\n")
+	b.WriteString("worker_processes auto;\n\nevents {\n    worker_connections 1024;\n}\n")
+	if len(n.ram.IngressHTTPRoutes) > 0 {
+		n.renderHTTP(&b)
+	}
+	if len(n.ram.IngressSreamRoutes) > 0 {
+		if n.hostNetwork {
+			n.Warnings = append(n.Warnings, "stream routes are not proxied in host network, the component ports are reachable directly")
+		} else {
+			n.renderStream(&b)
+		}
+	}
+	return b.String()
+}
+
+func (n *nginxGateway) renderHTTP(b *strings.Builder) {
+	b.WriteString("\nhttp {\n")
+	b.WriteString("    map $http_upgrade $connection_upgrade {\n        default upgrade;\n        ''      close;\n    }\n")
+	var locations strings.Builder
+	seen := make(map[string]struct{})
+	for i, route := range n.ram.IngressHTTPRoutes {
+		com := n.findComponent(route.ComponentKey)
+		if com == nil {
+			n.Warnings = append(n.Warnings, fmt.Sprintf("http route %s: component %s not found", route.Location, route.ComponentKey))
+			continue
+		}
+		location := route.Location
+		if location == "" {
+			location = "/"
+		}
+		if _, exists := seen[location]; exists {
+			n.Warnings = append(n.Warnings, fmt.Sprintf("http route %s: duplicate location is not supported, skip component %s", location, com.ServiceCname))
+			continue
+		}
+		seen[location] = struct{}{}
+		if route.SSL {
+			n.Warnings = append(n.Warnings, fmt.Sprintf("http route %s: certificate is not exported, serve it over http", location))
+		}
+		n.addTarget(com)
+		upstream := fmt.Sprintf("http_upstream_%d", i)
+		fmt.Fprintf(b, "    upstream %s {\n", upstream)
+		if route.LoadBalancing == "cookie-session-affinity" {
+			b.WriteString("        ip_hash;\n")
+		}
+		fmt.Fprintf(b, "        server %s;\n    }\n", n.upstream(com, int(route.Port)))
+		n.renderLocation(&locations, location, upstream, route)
+	}
+	fmt.Fprintf(b, "    server {\n        listen %d;\n        server_name _;\n", gatewayHTTPPort)
+	b.WriteString(locations.String())
+	b.WriteString("    }\n}\n")
+}
+
+func (n *nginxGateway) renderLocation(b *strings.Builder, location, upstream string, route *v1alpha1.IngressHTTPRoute) {
+	fmt.Fprintf(b, "        location %s {\n", location)
+	for _, name := range util.SortedKeys(route.Headers) {
+		fmt.Fprintf(b, "            if ($http_%s != %q) {\n                return 404;\n            }\n", nginxVariableName(name), route.Headers[name])
+	}
+	for _, name := range util.SortedKeys(route.Cookies) {
+		fmt.Fprintf(b, "            if ($cookie_%s != %q) {\n                return 404;\n            }\n", nginxVariableName(name), route.Cookies[name])
+	}
+	fmt.Fprintf(b, "            proxy_pass http://%s;\n", upstream)
+	b.WriteString("            proxy_set_header Host $host;\n")
+	b.WriteString("            proxy_set_header X-Real-IP $remote_addr;\n")
+	b.WriteString("            proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;\n")
+	for _, name := range util.SortedKeys(route.ProxyHeader) {
+		fmt.Fprintf(b, "            proxy_set_header %s %q;\n", name, route.ProxyHeader[name])
+	}
+	if route.ConnectionTimeout > 0 {
+		fmt.Fprintf(b, "            proxy_connect_timeout %ds;\n", route.ConnectionTimeout)
+	}
+	if route.RequestTimeout > 0 {
+		fmt.Fprintf(b, "            proxy_send_timeout %ds;\n", route.RequestTimeout)
+	}
+	if route.ResponseTimeout > 0 {
+		fmt.Fprintf(b, "            proxy_read_timeout %ds;\n", route.ResponseTimeout)
+	}
+	// 0 means unlimited in both rainbond and nginx
+	fmt.Fprintf(b, "            client_max_body_size %dm;\n", route.RequestBodySizeLimit)
+	if route.Websocket {
+		b.WriteString("            proxy_http_version 1.1;\n")
+		b.WriteString("            proxy_set_header Upgrade $http_upgrade;\n")
+		b.WriteString("            proxy_set_header Connection $connection_upgrade;\n")
+	}
+	if route.ProxyBuffer {
+		b.WriteString("            proxy_buffering on;\n")
+		if route.ProxyBufferSize > 0 {
+			fmt.Fprintf(b, "            proxy_buffer_size %dk;\n", route.ProxyBufferSize)
+			if route.ProxyBufferNumbers > 0 {
+				fmt.Fprintf(b, "            proxy_buffers %d %dk;\n", route.ProxyBufferNumbers, route.ProxyBufferSize)
+			}
+		}
+	} else {
+		b.WriteString("            proxy_buffering off;\n")
+	}
+	b.WriteString("        }\n")
+}
+
+func (n *nginxGateway) renderStream(b *strings.Builder) {
+	b.WriteString("\nstream {\n")
+	for i, route := range n.ram.IngressSreamRoutes {
+		com := n.findComponent(route.ComponentKey)
+		if com == nil {
+			n.Warnings = append(n.Warnings, fmt.Sprintf("stream route %d: component %s not found", route.Port, route.ComponentKey))
+			continue
+		}
+		n.addTarget(com)
+		upstream := fmt.Sprintf("stream_upstream_%d", i)
+		fmt.Fprintf(b, "    upstream %s {\n        server %s;\n    }\n", upstream, n.upstream(com, int(route.Port)))
+		listen := fmt.Sprintf("%d", route.Port)
+		if streamProtocol(route.Protocol) == "udp" {
+			listen += " udp"
+		}
+		fmt.Fprintf(b, "    server {\n        listen %s;\n        proxy_pass %s;\n", listen, upstream)
+		if route.ConnectionTimeout > 0 {
+			fmt.Fprintf(b, "        proxy_connect_timeout %ds;\n", route.ConnectionTimeout)
+		}
+		b.WriteString("    }\n")
+	}
+	b.WriteString("}\n")
+}
+
+// nginxVariableName convert header or cookie name to nginx variable suffix
+func nginxVariableName(name string) string {
+	return strings.ToLower(strings.Replace(name, "-", "_", -1))
+}
@@ -0,0 +1,89 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+func newGatewayTestTemplate() v1alpha1.RainbondApplicationConfig {
+	ram := newComposeTestTemplate()
+	ram.IngressHTTPRoutes = []*v1alpha1.IngressHTTPRoute{
+		{
+			Location:             "/api",
+			Headers:              map[string]string{"X-Env": "prod"},
+			ResponseTimeout:      60,
+			RequestBodySizeLimit: 10,
+			Websocket:            true,
+			TargetComponent:      v1alpha1.TargetComponent{ComponentKey: "web-key", Port: 8080},
+		},
+		{
+			Location:        "/api",
+			TargetComponent: v1alpha1.TargetComponent{ComponentKey: "db-key", Port: 8080},
+		},
+	}
+	ram.IngressSreamRoutes = []*v1alpha1.IngressSreamRoute{
+		{Protocol: "udp", TargetComponent: v1alpha1.TargetComponent{ComponentKey: "db-key", Port: 5353}},
+	}
+	return ram
+}
+
+func TestNginxGatewayRendersRoutes(t *testing.T) {
+	gateway := newNginxGateway(newGatewayTestTemplate(), func(com *v1alpha1.Component, port int) string {
+		return fmt.Sprintf("%s:%d", com.ServiceCname, port)
+	}, false)
+	conf := gateway.Render()
+	for _, expected := range []string{
+		"server web:8080;",
+		"location /api {",
+		`if ($http_x_env != "prod")`,
+		"proxy_read_timeout 60s;",
+		"client_max_body_size 10m;",
+		"proxy_set_header Upgrade $http_upgrade;",
+		"listen 5353 udp;",
+		"server db:5353;",
+	} {
+		if !strings.Contains(conf, expected) {
+			t.Fatalf("expected nginx config to contain %q, got\n%s", expected, conf)
+		}
+	}
+	if len(gateway.Warnings) != 1 {
+		t.Fatalf("expected duplicate location warning, got %v", gateway.Warnings)
+	}
+	if listeners := gateway.Listeners(); len(listeners) != 2 {
+		t.Fatalf("expected http and udp listeners, got %v", listeners)
+	}
+}
+
+func TestNginxGatewaySkipsStreamRoutesInHostNetwork(t *testing.T) {
+	gateway := newNginxGateway(newGatewayTestTemplate(), func(com *v1alpha1.Component, port int) string {
+		return fmt.Sprintf("127.0.0.1:%d", port)
+	}, true)
+	conf := gateway.Render()
+	if strings.Contains(conf, "stream {") {
+		t.Fatalf("expected no stream block in host network, got\n%s", conf)
+	}
+	if listeners := gateway.Listeners(); len(listeners) != 1 || listeners[0].Port != gatewayHTTPPort {
+		t.Fatalf("expected only the http listener, got %v", listeners)
+	}
+}
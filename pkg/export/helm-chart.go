@@ -19,40 +19,91 @@ var (
 	ImageSave string = "image_save"
 )
 
+func init() {
+	Register(HELM, Capabilities{NeedsImages: true, SupportsOnline: true, KeepsCredentials: true, ImageLayout: true, PackageKind: "helm"}, newHelmChartExporter)
+}
+
 type helmChartExporter struct {
 	logger      *logrus.Logger
 	ram         v1alpha1.RainbondApplicationConfig
 	imageClient image.Client
+	pull        image.PullOptions
 	mode        string
-	homePath    string
 	exportPath  string
+	// imageLayout the images are stored in the OCI image layout of the package
+	imageLayout bool
+	// rendered the chart is written before the images are saved, the images it depends on are saved too
+	rendered bool
+}
+
+func newHelmChartExporter(ctx *Context) (Renderer, error) {
+	return &helmChartExporter{
+		logger:      ctx.Logger,
+		ram:         *ctx.RAM,
+		imageClient: ctx.ImageClient,
+		pull:        ctx.Pull,
+		mode:        ctx.Mode,
+		exportPath:  ctx.ExportPath,
+		imageLayout: ctx.Options.OCIImageLayout,
+	}, nil
 }
 
-func (h *helmChartExporter) Export() (*Result, error) {
-	h.logger.Infof("start export app %s to helm chart spec", h.ram.AppName)
+func (h *helmChartExporter) SaveImages(ram v1alpha1.RainbondApplicationConfig) error {
 	dependentImages, err := h.initHelmChart()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if err := SaveComponents(h.ram, h.imageClient, h.exportPath, h.logger, dependentImages); err != nil {
+	h.rendered = true
+	if err := SaveComponents(ram, h.imageClient, h.exportPath, h.logger, dependentImages, h.pull); err != nil {
 		h.logger.Errorf("helm chart export save component failure %v", err)
-		return nil, err
+		return err
 	}
 	h.logger.Infof("success save components")
 	// Save plugin attachments
-	if err := SavePlugins(h.ram, h.imageClient, h.exportPath, h.logger); err != nil {
-		return nil, err
+	if err := SavePlugins(ram, h.imageClient, h.exportPath, h.logger, h.pull); err != nil {
+		return err
 	}
 	h.logger.Infof("success save plugins")
-	packageName := fmt.Sprintf("%s-%s-helm.tar.gz", h.ram.AppName, h.ram.AppVersion)
-	name, err := Packaging(packageName, h.homePath, h.exportPath)
-	if err != nil {
-		err = fmt.Errorf("Failed to package app %s: %s ", packageName, err.Error())
-		h.logger.Error(err)
-		return nil, err
+	return nil
+}
+
+func (h *helmChartExporter) Render() error {
+	if h.rendered {
+		return nil
+	}
+	_, err := h.initHelmChart()
+	return err
+}
+
+func (h *helmChartExporter) InstallSteps() []InstallStep {
+	var steps []InstallStep
+	if h.mode == OfflineMode {
+		steps = append(steps, loadImagesStep(h.imageLayout))
 	}
-	h.logger.Infof("success export app " + h.ram.AppName)
-	return &Result{PackagePath: path.Join(h.homePath, name), PackageName: name}, nil
+	return append(steps, InstallStep{Description: "Install the chart, change the values of the chart with --set if needed.", Command: fmt.Sprintf("helm install %s %q", strings.ToLower(composeName(h.ram.AppName)), "./"+h.ram.AppName)})
+}
+
+func (h *helmChartExporter) Plan(report *ExportReport) error {
+	helmChartPath := h.ram.AppName
+	report.Files = append(report.Files, path.Join(helmChartPath, "Chart.yaml"))
+	kinds := make(map[string]struct{})
+	for _, k8sResource := range h.ram.K8sResources {
+		var unstructuredObject unstructured.Unstructured
+		if err := yaml.Unmarshal([]byte(k8sResource.Content), &unstructuredObject); err != nil {
+			report.warn(h.logger, "k8s resource %s can not be parsed: %v", k8sResource.Name, err)
+			continue
+		}
+		kind := unstructuredObject.GetKind()
+		if _, ok := kinds[kind]; ok {
+			continue
+		}
+		kinds[kind] = struct{}{}
+		report.Files = append(report.Files, path.Join(helmChartPath, "templates", fmt.Sprintf("%v.yaml", kind)))
+	}
+	if h.mode == OfflineMode {
+		report.warn(h.logger, "images the chart depends on are listed in dependent_image.txt when the chart is written, they are not planned")
+	}
+	return nil
 }
 
 func (h *helmChartExporter) initHelmChart() ([]string, error) {
@@ -0,0 +1,497 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
+	"github.com/goodrain/rainbond-oam/pkg/util/ocilayout"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// BaseReference the package an incremental package is exported against
+type BaseReference struct {
+	AppName             string `json:"app_name"`
+	AppVersion          string `json:"app_version"`
+	TemplateFingerprint string `json:"template_fingerprint"`
+	// ManifestSHA256 sha256 of the manifest.json of the base package
+	ManifestSHA256 string `json:"manifest_sha256"`
+}
+
+// dockerArchiveManifest the manifest.json written by docker save and containerd export
+type dockerArchiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// LoadBaseManifest read the manifest of the base package, basePackage is the package
+// archive, the extracted package dir or the manifest.json file itself
+func LoadBaseManifest(basePackage string) (*PackageManifest, []byte, error) {
+	info, err := os.Stat(basePackage)
+	if err != nil {
+		return nil, nil, err
+	}
+	var body []byte
+	switch {
+	case info.IsDir():
+		body, err = ioutil.ReadFile(filepath.Join(basePackage, ManifestFileName))
+	case strings.HasSuffix(basePackage, ".json"):
+		body, err = ioutil.ReadFile(basePackage)
+	default:
+		// the manifest is at the root dir of the package, e.g. app-1.0-ram/manifest.json
+		body, err = archive.ReadEntry(basePackage, func(name string) bool {
+			parts := strings.Split(strings.TrimPrefix(name, "./"), "/")
+			return len(parts) == 2 && parts[1] == ManifestFileName
+		})
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("read manifest of base package %s failure %s", basePackage, err.Error())
+	}
+	var manifest PackageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("parse manifest of base package %s failure %s", basePackage, err.Error())
+	}
+	return &manifest, body, nil
+}
+
+// applyDelta drop the files and image layers that are already in the base package,
+// the manifest keeps listing them with the base flag
+func applyDelta(exportPath string, manifest, base *PackageManifest, baseBody []byte) error {
+	sum := sha256.Sum256(baseBody)
+	manifest.Base = &BaseReference{
+		AppName:             base.AppName,
+		AppVersion:          base.AppVersion,
+		TemplateFingerprint: base.TemplateFingerprint,
+		ManifestSHA256:      hex.EncodeToString(sum[:]),
+	}
+	images := make(map[string]int)
+	for i, image := range manifest.Images {
+		images[image.Path] = i
+	}
+	baseFiles := make(map[string]string)
+	for _, f := range base.Files {
+		baseFiles[f.Path] = f.SHA256
+	}
+	baseLayers := make(map[string][]string)
+	for _, image := range base.Images {
+		for _, layer := range image.Layers {
+			baseLayers[layer.SHA256] = append(baseLayers[layer.SHA256], image.RepoTags...)
+		}
+	}
+	files := make(map[string]int)
+	for i := range manifest.Files {
+		f := &manifest.Files[i]
+		files[f.Path] = i
+		idx, isImage := images[f.Path]
+		if !isImage {
+			// the metadata is always shipped, the package can not be read without it, the blobs
+			// of the image layout are dropped per layer below, symlinks have no content to share
+			if f.Path != "metadata.json" && f.Link == "" && !strings.HasPrefix(f.Path, ImageLayoutDir+"/") && baseFiles[f.Path] == f.SHA256 {
+				if err := os.Remove(filepath.Join(exportPath, filepath.FromSlash(f.Path))); err != nil {
+					return err
+				}
+				f.Base = true
+			}
+			continue
+		}
+		// image tarballs are always shipped with the config and the new layers, so they can be loaded
+		// after the base layers are put back
+		image := &manifest.Images[idx]
+		omit := make(map[string]struct{})
+		tags := make(map[string]struct{})
+		for j := range image.Layers {
+			layer := &image.Layers[j]
+			if repoTags, ok := baseLayers[layer.SHA256]; ok {
+				layer.Base = true
+				omit[layer.Path] = struct{}{}
+				for _, tag := range repoTags {
+					tags[tag] = struct{}{}
+				}
+			}
+		}
+		if len(omit) == 0 {
+			continue
+		}
+		for tag := range tags {
+			image.BaseRepoTags = append(image.BaseRepoTags, tag)
+		}
+		sort.Strings(image.BaseRepoTags)
+		tarball := filepath.Join(exportPath, filepath.FromSlash(f.Path))
+		if err := rewriteTarball(tarball, func(hdr *tar.Header) bool {
+			_, skip := omit[hdr.Name]
+			return !skip
+		}, nil); err != nil {
+			return fmt.Errorf("drop base layers from %s failure %s", f.Path, err.Error())
+		}
+		sum, size, err := fileChecksum(tarball)
+		if err != nil {
+			return err
+		}
+		f.SHA256, f.Size = sum, size
+	}
+	// the layers of the image layout are blob files, the manifests and configs are always shipped
+	for i := range manifest.Images {
+		image := &manifest.Images[i]
+		if !ocilayout.IsLayout(filepath.Join(exportPath, image.Path)) {
+			continue
+		}
+		tags := make(map[string]struct{})
+		for j := range image.Layers {
+			layer := &image.Layers[j]
+			repoTags, ok := baseLayers[layer.SHA256]
+			if !ok {
+				continue
+			}
+			blob := image.Path + "/" + layer.Path
+			if err := os.Remove(filepath.Join(exportPath, filepath.FromSlash(blob))); err != nil {
+				return err
+			}
+			layer.Base = true
+			if idx, ok := files[blob]; ok {
+				manifest.Files[idx].Base = true
+			}
+			for _, tag := range repoTags {
+				tags[tag] = struct{}{}
+			}
+		}
+		for tag := range tags {
+			image.BaseRepoTags = append(image.BaseRepoTags, tag)
+		}
+		sort.Strings(image.BaseRepoTags)
+	}
+	return nil
+}
+
+// RestoreImageTarball put the base layers back into the image tarball of the incremental package,
+// sources are image tarballs saved from the base images. The sha256 of the missing layers are
+// returned if they are not found in sources, the tarball is left untouched then.
+func RestoreImageTarball(tarball string, image ManifestImage, sources []string) ([]string, error) {
+	layerDir, found, missing, err := findBaseLayers(filepath.Dir(tarball), image, sources)
+	if layerDir != "" {
+		defer os.RemoveAll(layerDir)
+	}
+	if err != nil || len(missing) > 0 || len(found) == 0 {
+		return missing, err
+	}
+	return nil, rewriteTarball(tarball, nil, func(tw *tar.Writer) error {
+		for _, layer := range image.Layers {
+			if !layer.Base {
+				continue
+			}
+			if err := appendTarFile(tw, layer.Path, found[layer.SHA256]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RestoreImageLayout put the base layers back into the image layout of the incremental package
+// as blob files, sources and the returned value are the same as RestoreImageTarball
+func RestoreImageLayout(layoutDir string, image ManifestImage, sources []string) ([]string, error) {
+	layerDir, found, missing, err := findBaseLayers(filepath.Dir(layoutDir), image, sources)
+	if layerDir != "" {
+		defer os.RemoveAll(layerDir)
+	}
+	if err != nil || len(missing) > 0 {
+		return missing, err
+	}
+	for _, layer := range image.Layers {
+		if !layer.Base {
+			continue
+		}
+		if err := os.Rename(found[layer.SHA256], filepath.Join(layoutDir, filepath.FromSlash(layer.Path))); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// findBaseLayers extract the base layers of the image from sources into a temp dir under parent
+func findBaseLayers(parent string, image ManifestImage, sources []string) (string, map[string]string, []string, error) {
+	wanted := make(map[string]int64)
+	for _, layer := range image.Layers {
+		if layer.Base {
+			wanted[layer.SHA256] = layer.Size
+		}
+	}
+	if len(wanted) == 0 {
+		return "", nil, nil, nil
+	}
+	layerDir, err := ioutil.TempDir(parent, ".base-layers")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	found := make(map[string]string)
+	for _, source := range sources {
+		if len(found) == len(wanted) {
+			break
+		}
+		if err := extractLayers(source, wanted, found, layerDir); err != nil {
+			return layerDir, nil, nil, fmt.Errorf("read base image tarball %s failure %s", filepath.Base(source), err.Error())
+		}
+	}
+	var missing []string
+	for sum := range wanted {
+		if _, ok := found[sum]; !ok {
+			missing = append(missing, sum)
+		}
+	}
+	sort.Strings(missing)
+	return layerDir, found, missing, nil
+}
+
+// extractLayers save the entries of the source tarball whose sha256 is wanted into dir
+func extractLayers(source string, wanted map[string]int64, found map[string]string, dir string) error {
+	sizes := make(map[int64]struct{})
+	for _, size := range wanted {
+		sizes[size] = struct{}{}
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := sizes[hdr.Size]; !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		tmp, err := ioutil.TempFile(dir, "layer")
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		_, err = io.Copy(io.MultiWriter(tmp, h), tr)
+		tmp.Close()
+		if err != nil {
+			return err
+		}
+		sum := hex.EncodeToString(h.Sum(nil))
+		if _, ok := wanted[sum]; !ok || found[sum] != "" {
+			os.Remove(tmp.Name())
+			continue
+		}
+		found[sum] = tmp.Name()
+	}
+}
+
+// rewriteTarball copy the entries kept by filter into a new tarball that replaces the old one,
+// appends adds entries at the end
+func rewriteTarball(tarball string, filter func(hdr *tar.Header) bool, appends func(tw *tar.Writer) error) error {
+	in, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(tarball + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	tr := tar.NewReader(in)
+	tw := tar.NewWriter(out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			out.Close()
+			return err
+		}
+		if filter != nil && !filter(hdr) {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			out.Close()
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if appends != nil {
+		if err := appends(tw); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), tarball)
+}
+
+func appendTarFile(tw *tar.Writer, name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: info.Size(), Typeflag: tar.TypeReg, ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// scanImageTarball checksum the tarball and the entries in it, the image info is
+// returned if it is a docker archive
+func scanImageTarball(file string) (*ManifestFile, *ManifestImage, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	tee := io.TeeReader(f, h)
+	entries := make(map[string]ManifestFile)
+	var archiveManifest []byte
+	tr := tar.NewReader(tee)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// not a tarball, checksum it as a plain file
+			sum, size, err := fileChecksum(file)
+			if err != nil {
+				return nil, nil, err
+			}
+			return &ManifestFile{Size: size, SHA256: sum}, nil, nil
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Name == "manifest.json" {
+			if archiveManifest, err = ioutil.ReadAll(tr); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		eh := sha256.New()
+		size, err := io.Copy(eh, tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries[hdr.Name] = ManifestFile{Path: hdr.Name, Size: size, SHA256: hex.EncodeToString(eh.Sum(nil))}
+	}
+	// checksum the padding after the end of archive
+	if _, err := io.Copy(ioutil.Discard, tee); err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	entry := &ManifestFile{Size: info.Size(), SHA256: hex.EncodeToString(h.Sum(nil))}
+	if archiveManifest == nil {
+		return entry, nil, nil
+	}
+	var items []dockerArchiveManifest
+	if err := json.Unmarshal(archiveManifest, &items); err != nil {
+		return entry, nil, nil
+	}
+	image := &ManifestImage{TagLayers: make(map[string][]string)}
+	seen := make(map[string]struct{})
+	for _, item := range items {
+		image.RepoTags = append(image.RepoTags, item.RepoTags...)
+		for _, tag := range item.RepoTags {
+			image.TagLayers[tag] = item.Layers
+		}
+		for _, layer := range item.Layers {
+			if _, ok := seen[layer]; ok {
+				continue
+			}
+			seen[layer] = struct{}{}
+			if layerEntry, ok := entries[layer]; ok {
+				image.Layers = append(image.Layers, layerEntry)
+			}
+		}
+	}
+	return entry, image, nil
+}
+
+// scanImageLayout list the images of the layout and their layers, a layer shared by
+// several images is listed once
+func scanImageLayout(dir string) (*ManifestImage, error) {
+	layout, err := ocilayout.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	images, err := layout.Images()
+	if err != nil {
+		return nil, err
+	}
+	image := &ManifestImage{TagLayers: make(map[string][]string)}
+	seen := make(map[string]struct{})
+	for _, img := range images {
+		manifest, err := layout.Manifest(img.Name)
+		if err != nil {
+			return nil, fmt.Errorf("read manifest of image %s failure %s", img.Name, err.Error())
+		}
+		image.RepoTags = append(image.RepoTags, img.Name)
+		for _, layer := range manifest.Layers {
+			if layer.Digest.Algorithm() != digest.SHA256 {
+				continue
+			}
+			image.TagLayers[img.Name] = append(image.TagLayers[img.Name], "blobs/sha256/"+layer.Digest.Encoded())
+			if _, ok := seen[layer.Digest.Encoded()]; ok {
+				continue
+			}
+			seen[layer.Digest.Encoded()] = struct{}{}
+			image.Layers = append(image.Layers, ManifestFile{
+				Path:   "blobs/sha256/" + layer.Digest.Encoded(),
+				Size:   layer.Size,
+				SHA256: layer.Digest.Encoded(),
+			})
+		}
+	}
+	return image, nil
+}
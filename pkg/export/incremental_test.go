@@ -0,0 +1,214 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/ocilayout"
+	"github.com/sirupsen/logrus"
+)
+
+// writeTestImageTarball write a docker archive with one image and the given layers
+func writeTestImageTarball(t *testing.T, file, tag string, layers map[string]string) {
+	t.Helper()
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	item := dockerArchiveManifest{Config: "config.json", RepoTags: []string{tag}}
+	entries := map[string]string{"config.json": `{"tag":"` + tag + `"}`}
+	for name, content := range layers {
+		item.Layers = append(item.Layers, name)
+		entries[name] = content
+	}
+	body, _ := json.Marshal([]dockerArchiveManifest{item})
+	entries["manifest.json"] = string(body)
+	for name, content := range entries {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestTarball(t *testing.T, file string) map[string]string {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries := make(map[string]string)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		content, _ := ioutil.ReadAll(tr)
+		entries[hdr.Name] = string(content)
+	}
+	return entries
+}
+
+func newIncrementalTestPackages(t *testing.T) (basePackage, exportPath string) {
+	t.Helper()
+	home := t.TempDir()
+	baseDir := path.Join(home, "demo-1.0-ram")
+	exportPath = path.Join(home, "demo-2.0-ram")
+	for dir, version := range map[string]string{baseDir: "1.0", exportPath: "2.0"} {
+		os.MkdirAll(dir, 0755)
+		ioutil.WriteFile(path.Join(dir, "metadata.json"), []byte(`{"app_version":"`+version+`"}`), 0644)
+		ioutil.WriteFile(path.Join(dir, "plugin.conf"), []byte("unchanged"), 0644)
+	}
+	writeTestImageTarball(t, path.Join(baseDir, "component-images.tar"), "demo/web:1.0", map[string]string{"base/layer.tar": "base layer", "v1/layer.tar": "v1 layer"})
+	writeTestImageTarball(t, path.Join(exportPath, "component-images.tar"), "demo/web:2.0", map[string]string{"base/layer.tar": "base layer", "v2/layer.tar": "v2 layer!"})
+	ram := newComposeTestTemplate()
+	ram.AppVersion = "1.0"
+	if _, err := WriteManifest(baseDir, RAM, ram); err != nil {
+		t.Fatal(err)
+	}
+	basePackage = path.Join(home, "demo-1.0-ram.tar.gz")
+	if err := archive.Pack(baseDir, basePackage, archive.TarGz); err != nil {
+		t.Fatal(err)
+	}
+	ram.AppVersion = "2.0"
+	if err := sealPackage(exportPath, RAM, OfflineMode, nil, nil, ram, nil, basePackage); err != nil {
+		t.Fatal(err)
+	}
+	return path.Join(baseDir, "component-images.tar"), exportPath
+}
+
+func TestIncrementalExportDropsBaseFilesAndLayers(t *testing.T) {
+	_, exportPath := newIncrementalTestPackages(t)
+	manifest, err := ReadManifest(exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Base == nil || manifest.Base.AppVersion != "1.0" || manifest.Base.ManifestSHA256 == "" {
+		t.Fatalf("expected base reference to 1.0, got %+v", manifest.Base)
+	}
+	if _, err := os.Stat(path.Join(exportPath, "plugin.conf")); !os.IsNotExist(err) {
+		t.Fatal("expected unchanged file to be dropped")
+	}
+	if _, err := os.Stat(path.Join(exportPath, "metadata.json")); err != nil {
+		t.Fatal("expected metadata to be shipped")
+	}
+	entries := readTestTarball(t, path.Join(exportPath, "component-images.tar"))
+	if _, ok := entries["base/layer.tar"]; ok {
+		t.Fatal("expected base layer to be dropped from the image tarball")
+	}
+	if entries["v2/layer.tar"] != "v2 layer!" || entries["manifest.json"] == "" {
+		t.Fatalf("expected new layer and manifest to be shipped, got %v", entries)
+	}
+	if len(manifest.Images) != 1 || len(manifest.Images[0].BaseRepoTags) != 1 || manifest.Images[0].BaseRepoTags[0] != "demo/web:1.0" {
+		t.Fatalf("expected base image reference, got %+v", manifest.Images)
+	}
+	if report := manifest.Verify(exportPath); !report.OK() {
+		t.Fatalf("expected incremental package to verify, got %s", report.Error())
+	}
+}
+
+func TestRestoreImageTarball(t *testing.T) {
+	baseTarball, exportPath := newIncrementalTestPackages(t)
+	manifest, err := ReadManifest(exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarball := path.Join(exportPath, "component-images.tar")
+	missing, err := RestoreImageTarball(tarball, manifest.Images[0], nil)
+	if err != nil || len(missing) != 1 {
+		t.Fatalf("expected base layer to be missing without sources, got %v %v", missing, err)
+	}
+	missing, err = RestoreImageTarball(tarball, manifest.Images[0], []string{baseTarball})
+	if err != nil || len(missing) != 0 {
+		t.Fatalf("expected base layer to be restored, got %v %v", missing, err)
+	}
+	if entries := readTestTarball(t, tarball); entries["base/layer.tar"] != "base layer" || entries["v2/layer.tar"] != "v2 layer!" {
+		t.Fatalf("unexpected restored tarball %v", entries)
+	}
+}
+
+func TestIncrementalExportWithImageLayout(t *testing.T) {
+	home := t.TempDir()
+	baseDir := path.Join(home, "demo-1.0-ram")
+	exportPath := path.Join(home, "demo-2.0-ram")
+	os.MkdirAll(baseDir, 0755)
+	os.MkdirAll(exportPath, 0755)
+	baseTarball := path.Join(baseDir, "component-images.tar")
+	writeTestImageTarball(t, baseTarball, "demo/web:1.0", map[string]string{"base/layer.tar": "base layer", "v1/layer.tar": "v1 layer"})
+	writeTestImageTarball(t, path.Join(exportPath, "component-images.tar"), "demo/web:2.0", map[string]string{"base/layer.tar": "base layer", "v2/layer.tar": "v2 layer!"})
+	writeTestImageTarball(t, path.Join(exportPath, "plugin-images.tar"), "demo/plugin:2.0", map[string]string{"base/layer.tar": "base layer"})
+	ioutil.WriteFile(path.Join(exportPath, "metadata.json"), []byte(`{"app_version":"2.0"}`), 0644)
+	ram := newComposeTestTemplate()
+	if _, err := WriteManifest(baseDir, RAM, ram); err != nil {
+		t.Fatal(err)
+	}
+	if err := storeImagesInLayout(exportPath, primaryPlatform(image.PullOptions{}), logrus.StandardLogger()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(exportPath, "plugin-images.tar")); !os.IsNotExist(err) {
+		t.Fatal("expected image tarballs to be removed")
+	}
+	layoutDir := path.Join(exportPath, ImageLayoutDir)
+	if err := sealPackage(exportPath, RAM, OfflineMode, nil, nil, ram, nil, baseDir); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := ReadManifest(exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Images) != 1 || manifest.Images[0].Path != ImageLayoutDir || len(manifest.Images[0].RepoTags) != 2 {
+		t.Fatalf("expected the layout to be listed as one image store, got %+v", manifest.Images)
+	}
+	// the base layer shared by the component and the plugin is stored once and dropped
+	image := manifest.Images[0]
+	if len(image.Layers) != 2 || len(image.BaseRepoTags) != 1 || image.BaseRepoTags[0] != "demo/web:1.0" {
+		t.Fatalf("unexpected layout layers %+v", image)
+	}
+	if report := manifest.Verify(exportPath); !report.OK() {
+		t.Fatalf("expected incremental package to verify, got %s", report.Error())
+	}
+	missing, err := RestoreImageLayout(layoutDir, image, []string{baseTarball})
+	if err != nil || len(missing) != 0 {
+		t.Fatalf("expected base layer to be restored, got %v %v", missing, err)
+	}
+	layout, err := ocilayout.Open(layoutDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mf, err := layout.Manifest("demo/plugin:2.0")
+	if err != nil || len(mf.Layers) != 1 {
+		t.Fatalf("unexpected plugin manifest %v %v", mf, err)
+	}
+	if content, err := ioutil.ReadFile(layout.BlobPath(mf.Layers[0].Digest)); err != nil || string(content) != "base layer" {
+		t.Fatalf("expected restored base layer blob, got %q %v", content, err)
+	}
+}
@@ -0,0 +1,155 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/goodrain/rainbond-oam/pkg/installer"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+)
+
+// writeInstallerBundle ship the installer binary and the bundle spec in the package, the
+// run script hands over to the installer so the app is installed without network access
+func (d *dockerComposeExporter) writeInstallerBundle() error {
+	if err := copyExecutable(d.installer, path.Join(d.exportPath, installer.BinaryName)); err != nil {
+		return fmt.Errorf("copy installer %s failure %s", d.installer, err.Error())
+	}
+	if err := d.installerBundle().Write(d.exportPath); err != nil {
+		return fmt.Errorf("write installer bundle failure %s", err.Error())
+	}
+	return nil
+}
+
+// installerBundle describes the services, ports and parameters of the built compose spec
+func (d *dockerComposeExporter) installerBundle() *installer.Bundle {
+	bundle := &installer.Bundle{
+		AppName:     d.ram.AppName,
+		AppVersion:  d.ram.AppVersion,
+		Project:     composeProjectName(d.ram.AppName),
+		ComposeFile: composeFileName,
+	}
+	if d.mode == OfflineMode {
+		// the images are in the layout dir if the images are stored in an image layout
+		bundle.Images = []string{"component-images.tar", "images"}
+	}
+	arches := make(map[string]struct{})
+	if d.mode == OfflineMode {
+		// the saved images run on the architectures of their platforms
+		platforms, _ := image.ParsePlatforms(packagePlatforms(d.pull)...)
+		for _, platform := range platforms {
+			arches[platform.Architecture] = struct{}{}
+		}
+	} else {
+		for _, component := range d.ram.Components {
+			if component.Arch != "" {
+				arches[component.Arch] = struct{}{}
+			}
+		}
+	}
+	for arch := range arches {
+		bundle.Arch = append(bundle.Arch, arch)
+	}
+	sort.Strings(bundle.Arch)
+	var services []installer.Service
+	for name, service := range d.spec.Services {
+		var dependsOn []string
+		for dep := range service.DependsOn {
+			dependsOn = append(dependsOn, dep)
+		}
+		sort.Strings(dependsOn)
+		services = append(services, installer.Service{Name: name, DependsOn: dependsOn, Healthcheck: service.Healthcheck != nil})
+		for _, port := range service.Ports {
+			// host:container/protocol
+			hostPort, protocol := strings.SplitN(port, ":", 2)[0], "tcp"
+			if strings.HasSuffix(port, "/udp") {
+				protocol = "udp"
+			}
+			if p, err := strconv.Atoi(hostPort); err == nil {
+				bundle.Ports = append(bundle.Ports, installer.Port{Service: name, Port: p, Protocol: protocol})
+			}
+		}
+	}
+	bundle.Services = installer.SortServices(services)
+	sort.Slice(bundle.Ports, func(i, j int) bool { return bundle.Ports[i].Port < bundle.Ports[j].Port })
+	// the envs users may change are set and the secrets generated at install time
+	for _, variable := range d.variables.list {
+		bundle.Parameters = append(bundle.Parameters, installer.Parameter{
+			Name:        variable.Name,
+			Service:     variable.Service,
+			Env:         variable.Env,
+			Default:     variable.Default,
+			Description: variable.Description,
+			Secret:      variable.Secret,
+		})
+	}
+	return bundle
+}
+
+// composeProjectName returns a valid compose project name of the app
+func composeProjectName(appName string) string {
+	name := strings.Trim(strings.ToLower(strings.Replace(composeName(appName), ".", "-", -1)), "_-")
+	if name == "" {
+		return "app"
+	}
+	return name
+}
+
+// parameterName returns the key of the env of the service in the values file, e.g. WEB_DB_PASSWORD
+func parameterName(serviceName, env string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, serviceName+"_"+env)
+}
+
+func copyExecutable(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+var installerRunScript = `#!/bin/bash
+# the app is installed by the installer shipped in the package, no network is required
+cd $(dirname $0)
+cmd="$1"
+[[ x$cmd == x ]] && cmd=start
+shift
+exec ./installer "$cmd" "$@"
+`
@@ -0,0 +1,86 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"github.com/goodrain/rainbond-oam/pkg/oam"
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+func init() {
+	Register(VELA, Capabilities{NeedsImages: true, SupportsOnline: true, KeepsCredentials: true, Validate: true, ImageLayout: true, PackageKind: "kubevela"}, newKubeVelaExporter)
+}
+
+type kubeVelaExporter struct {
+	logger     *logrus.Logger
+	ram        v1alpha1.RainbondApplicationConfig
+	exportPath string
+	mode       string
+	// imageLayout the images are stored in the OCI image layout of the package
+	imageLayout bool
+}
+
+func newKubeVelaExporter(ctx *Context) (Renderer, error) {
+	return &kubeVelaExporter{logger: ctx.Logger, ram: *ctx.RAM, exportPath: ctx.ExportPath, mode: ctx.Mode, imageLayout: ctx.Options.OCIImageLayout}, nil
+}
+
+func (k *kubeVelaExporter) Render() error {
+	if err := k.writeApplicationYaml(); err != nil {
+		return err
+	}
+	k.logger.Infof("success write kubevela application spec file")
+	return nil
+}
+
+func (k *kubeVelaExporter) Plan(report *ExportReport) error {
+	report.Files = append(report.Files, "application.yaml")
+	if _, err := oam.NewVelaBuilder(k.ram).Build(); err != nil {
+		report.warn(k.logger, "build kubevela application failure %s", err.Error())
+	}
+	return nil
+}
+
+func (k *kubeVelaExporter) InstallSteps() []InstallStep {
+	var steps []InstallStep
+	if k.mode == OfflineMode {
+		steps = append(steps, loadImagesStep(k.imageLayout))
+	}
+	return append(steps, InstallStep{Description: "Deploy the application with KubeVela.", Command: "vela up -f application.yaml"})
+}
+
+func (k *kubeVelaExporter) writeApplicationYaml() error {
+	app, err := oam.NewVelaBuilder(k.ram).Build()
+	if err != nil {
+		return fmt.Errorf("build kubevela application failure %s", err.Error())
+	}
+	content, err := yaml.Marshal(app)
+	if err != nil {
+		return fmt.Errorf("marshal kubevela application failure %s", err.Error())
+	}
+	if err := ioutil.WriteFile(path.Join(k.exportPath, "application.yaml"), content, 0644); err != nil {
+		return fmt.Errorf("write kubevela application file failure %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/ocilayout"
+)
+
+const (
+	// ManifestFileName integrity manifest at the root of every package
+	ManifestFileName = "manifest.json"
+	// ManifestVersion schema version of the manifest
+	ManifestVersion = "v1"
+	// ExporterVersion version of the exporter that writes the package
+	ExporterVersion = "1.0.0"
+)
+
+// PackageManifest lists every file of the package with its checksum
+type PackageManifest struct {
+	Version         string `json:"version"`
+	ExporterVersion string `json:"exporter_version"`
+	// Format the app format of the package, e.g. ram, docker-compose
+	Format     AppFormat `json:"format"`
+	AppName    string    `json:"app_name"`
+	AppVersion string    `json:"app_version"`
+	// TemplateFingerprint sha256 of the app template the package is exported from
+	TemplateFingerprint string    `json:"template_fingerprint"`
+	CreatedAt           time.Time `json:"created_at"`
+	// Mode online packages carry no images, they are pulled from their registries
+	Mode string `json:"mode,omitempty"`
+	// Platforms the platforms of the images in the package, e.g. linux/amd64
+	Platforms []string `json:"platforms,omitempty"`
+	// ImagePlatforms the platforms each component and plugin image of the package is saved for
+	ImagePlatforms map[string][]string `json:"image_platforms,omitempty"`
+	// Base the package the incremental package is exported against
+	Base   *BaseReference  `json:"base,omitempty"`
+	Files  []ManifestFile  `json:"files"`
+	Images []ManifestImage `json:"images,omitempty"`
+}
+
+// ManifestFile a file of the package, path is relative to the package root and slash separated
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Base the file is not shipped, it is the same as the one in the base package
+	Base bool `json:"base,omitempty"`
+	// Link the target of the symbolic link, a symlink is verified by its target instead of its content
+	Link string `json:"link,omitempty"`
+}
+
+// ManifestImage an image tarball or the image layout of the package and the layers in it
+type ManifestImage struct {
+	Path     string   `json:"path"`
+	RepoTags []string `json:"repo_tags"`
+	// Layers path is the entry in the tarball or the blob file in the layout
+	Layers []ManifestFile `json:"layers"`
+	// TagLayers the paths in Layers each repo tag is made of, a layer shared by several images is listed for each of them
+	TagLayers map[string][]string `json:"tag_layers,omitempty"`
+	// BaseRepoTags images of the base package that provide the layers not shipped
+	BaseRepoTags []string `json:"base_repo_tags,omitempty"`
+}
+
+// ManifestReport the result of verifying a package against its manifest
+type ManifestReport struct {
+	Missing   []string
+	Corrupted []string
+	// Unlisted files of the package the manifest does not cover, so neither the
+	// checksums nor the signature vouch for them
+	Unlisted []string
+}
+
+// OK returns true if all files of the manifest are intact
+func (m *ManifestReport) OK() bool {
+	return len(m.Missing) == 0 && len(m.Corrupted) == 0
+}
+
+// Complete returns true if all files of the manifest are intact and the package has no other files
+func (m *ManifestReport) Complete() bool {
+	return m.OK() && len(m.Unlisted) == 0
+}
+
+func (m *ManifestReport) Error() string {
+	var parts []string
+	if len(m.Missing) > 0 {
+		parts = append(parts, fmt.Sprintf("missing files: %s", strings.Join(m.Missing, ", ")))
+	}
+	if len(m.Corrupted) > 0 {
+		parts = append(parts, fmt.Sprintf("corrupted files: %s", strings.Join(m.Corrupted, ", ")))
+	}
+	if len(m.Unlisted) > 0 {
+		parts = append(parts, fmt.Sprintf("files not in the manifest: %s", strings.Join(m.Unlisted, ", ")))
+	}
+	return "package verification failure, " + strings.Join(parts, "; ")
+}
+
+// TemplateFingerprint returns the sha256 of the app template
+func TemplateFingerprint(ram v1alpha1.RainbondApplicationConfig) (string, error) {
+	body, err := json.Marshal(ram)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// WriteManifest checksum all files under exportPath and write the manifest into it,
+// it must be called after all files of the package are written
+func WriteManifest(exportPath string, format AppFormat, ram v1alpha1.RainbondApplicationConfig) (*PackageManifest, error) {
+	manifest, err := BuildManifest(exportPath, format, ram)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, manifest.Write(exportPath)
+}
+
+// BuildManifest checksum all files under exportPath, the layers of image tarballs are listed as well
+func BuildManifest(exportPath string, format AppFormat, ram v1alpha1.RainbondApplicationConfig) (*PackageManifest, error) {
+	fingerprint, err := TemplateFingerprint(ram)
+	if err != nil {
+		return nil, fmt.Errorf("fingerprint app template failure %s", err.Error())
+	}
+	manifest := &PackageManifest{
+		Version:             ManifestVersion,
+		ExporterVersion:     ExporterVersion,
+		Format:              format,
+		AppName:             ram.AppName,
+		AppVersion:          ram.AppVersion,
+		TemplateFingerprint: fingerprint,
+		CreatedAt:           time.Now().UTC(),
+	}
+	err = filepath.Walk(exportPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(exportPath, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ManifestFileName || rel == SignatureFileName {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			manifest.Files = append(manifest.Files, ManifestFile{Path: rel, Link: target})
+			return nil
+		}
+		if strings.HasSuffix(rel, ".tar") {
+			entry, image, err := scanImageTarball(file)
+			if err != nil {
+				return err
+			}
+			entry.Path = rel
+			manifest.Files = append(manifest.Files, *entry)
+			if image != nil {
+				image.Path = rel
+				manifest.Images = append(manifest.Images, *image)
+			}
+			return nil
+		}
+		sum, size, err := fileChecksum(file)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, ManifestFile{Path: rel, Size: size, SHA256: sum})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("checksum package files failure %s", err.Error())
+	}
+	if ocilayout.IsLayout(filepath.Join(exportPath, ImageLayoutDir)) {
+		image, err := scanImageLayout(filepath.Join(exportPath, ImageLayoutDir))
+		if err != nil {
+			return nil, err
+		}
+		image.Path = ImageLayoutDir
+		manifest.Images = append(manifest.Images, *image)
+	}
+	return manifest, nil
+}
+
+// Write write the manifest into the package dir
+func (m *PackageManifest) Write(exportPath string) error {
+	body, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(exportPath, ManifestFileName), body, 0644)
+}
+
+// sealPackage write the manifest of the package and sign it if a signer is supplied,
+// only the changes against basePackage are kept if it is set
+func sealPackage(exportPath string, format AppFormat, mode string, platforms []string, imagePlatforms map[string][]string, ram v1alpha1.RainbondApplicationConfig, signer crypto.Signer, basePackage string) error {
+	manifest, err := BuildManifest(exportPath, format, ram)
+	if err != nil {
+		return err
+	}
+	manifest.Mode = mode
+	manifest.Platforms = platforms
+	manifest.ImagePlatforms = imagePlatforms
+	if basePackage != "" {
+		base, body, err := LoadBaseManifest(basePackage)
+		if err != nil {
+			return err
+		}
+		if err := applyDelta(exportPath, manifest, base, body); err != nil {
+			return fmt.Errorf("export changes against base package %s failure %s", base.AppVersion, err.Error())
+		}
+	}
+	if err := manifest.Write(exportPath); err != nil {
+		return fmt.Errorf("write package manifest failure %s", err.Error())
+	}
+	if signer == nil {
+		return nil
+	}
+	return SignManifest(exportPath, signer)
+}
+
+// ReadManifest read the manifest of the extracted package, os.IsNotExist is true
+// for the error if the package is exported by an older version
+func ReadManifest(packagePath string) (*PackageManifest, error) {
+	body, err := ioutil.ReadFile(filepath.Join(packagePath, ManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	var manifest PackageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("parse package manifest failure %s", err.Error())
+	}
+	return &manifest, nil
+}
+
+// Verify check the files under packagePath against the manifest, files of the base package
+// are skipped and the files not listed are reported as unlisted
+func (m *PackageManifest) Verify(packagePath string) *ManifestReport {
+	report := &ManifestReport{}
+	listed := make(map[string]bool, len(m.Files))
+	for _, f := range m.Files {
+		listed[f.Path] = true
+		if f.Base {
+			continue
+		}
+		file := filepath.Join(packagePath, filepath.FromSlash(f.Path))
+		if f.Link != "" {
+			if _, err := os.Lstat(file); err != nil {
+				report.Missing = append(report.Missing, f.Path)
+			} else if target, err := os.Readlink(file); err != nil || target != f.Link {
+				report.Corrupted = append(report.Corrupted, fmt.Sprintf("%s (link target mismatch)", f.Path))
+			}
+			continue
+		}
+		info, err := os.Lstat(file)
+		if err != nil {
+			report.Missing = append(report.Missing, f.Path)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			report.Corrupted = append(report.Corrupted, fmt.Sprintf("%s (not a regular file)", f.Path))
+			continue
+		}
+		if info.Size() != f.Size {
+			report.Corrupted = append(report.Corrupted, fmt.Sprintf("%s (size %d, expected %d)", f.Path, info.Size(), f.Size))
+			continue
+		}
+		sum, _, err := fileChecksum(file)
+		if err != nil {
+			report.Corrupted = append(report.Corrupted, fmt.Sprintf("%s (%s)", f.Path, err.Error()))
+			continue
+		}
+		if sum != f.SHA256 {
+			report.Corrupted = append(report.Corrupted, fmt.Sprintf("%s (sha256 mismatch)", f.Path))
+		}
+	}
+	filepath.Walk(packagePath, func(file string, info os.FileInfo, err error) error {
+		// other files than regular files and symlinks are not written into the manifest
+		if err != nil || !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(packagePath, file)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != ManifestFileName && rel != SignatureFileName && !listed[rel] {
+			report.Unlisted = append(report.Unlisted, rel)
+		}
+		return nil
+	})
+	sort.Strings(report.Missing)
+	sort.Strings(report.Corrupted)
+	sort.Strings(report.Unlisted)
+	return report
+}
+
+// Lists returns true if the file or the image layout at the slash separated path is part of the package
+func (m *PackageManifest) Lists(rel string) bool {
+	for _, f := range m.Files {
+		if f.Path == rel {
+			return true
+		}
+	}
+	for _, image := range m.Images {
+		if image.Path == rel {
+			return true
+		}
+	}
+	return false
+}
+
+func fileChecksum(file string) (string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
@@ -0,0 +1,119 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestWriteManifestAndVerify(t *testing.T) {
+	exportPath := t.TempDir()
+	ram := newComposeTestTemplate()
+	if err := os.MkdirAll(path.Join(exportPath, "web"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"metadata.json":             `{"app_name":"demo"}`,
+		"component-images.tar":      "images",
+		"web/web-slug.tgz":          "slug",
+		"web/config/etc/nginx.conf": "conf",
+	}
+	for name, content := range files {
+		os.MkdirAll(path.Dir(path.Join(exportPath, name)), 0755)
+		if err := ioutil.WriteFile(path.Join(exportPath, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest, err := WriteManifest(exportPath, RAM, ram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != len(files) || manifest.Format != RAM || manifest.TemplateFingerprint == "" {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	read, err := ReadManifest(exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := read.Verify(exportPath); !report.OK() {
+		t.Fatalf("expected intact package, got %s", report.Error())
+	}
+	// truncate the image tarball and remove the slug
+	if err := ioutil.WriteFile(path.Join(exportPath, "component-images.tar"), []byte("ima"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(exportPath, "metadata.json"), []byte(`{"app_name":"demx"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path.Join(exportPath, "web/web-slug.tgz"))
+	if err := ioutil.WriteFile(path.Join(exportPath, "web/extra-images.tar"), []byte("extra"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report := read.Verify(exportPath)
+	if report.OK() {
+		t.Fatal("expected verification failure")
+	}
+	if len(report.Missing) != 1 || report.Missing[0] != "web/web-slug.tgz" {
+		t.Fatalf("unexpected missing files %v", report.Missing)
+	}
+	if len(report.Corrupted) != 2 || !strings.HasPrefix(report.Corrupted[0], "component-images.tar (size 3") || !strings.Contains(report.Corrupted[1], "sha256 mismatch") {
+		t.Fatalf("unexpected corrupted files %v", report.Corrupted)
+	}
+	if len(report.Unlisted) != 1 || report.Unlisted[0] != "web/extra-images.tar" || report.Complete() {
+		t.Fatalf("unexpected unlisted files %v", report.Unlisted)
+	}
+}
+
+func TestVerifyPackageWithSymlink(t *testing.T) {
+	exportPath := t.TempDir()
+	if err := os.MkdirAll(path.Join(exportPath, "web", "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(exportPath, "web", "bin", "app-1.0"), []byte("app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("app-1.0", path.Join(exportPath, "web", "bin", "app")); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := WriteManifest(exportPath, SLG, newComposeTestTemplate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := manifest.Verify(exportPath); !report.Complete() {
+		t.Fatalf("expected the symlink to be listed, got %s", report.Error())
+	}
+	// the symlink is verified by its target
+	os.Remove(path.Join(exportPath, "web", "bin", "app"))
+	if err := os.Symlink("/etc/passwd", path.Join(exportPath, "web", "bin", "app")); err != nil {
+		t.Fatal(err)
+	}
+	if report := manifest.Verify(exportPath); len(report.Corrupted) != 1 || report.Corrupted[0] != "web/bin/app (link target mismatch)" {
+		t.Fatalf("expected the changed symlink to be reported, got %v", report.Corrupted)
+	}
+}
+
+func TestReadManifestOfOldPackage(t *testing.T) {
+	if _, err := ReadManifest(t.TempDir()); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+}
@@ -0,0 +1,390 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/sirupsen/logrus"
+)
+
+// Capabilities what the shared export pipeline does for a format
+type Capabilities struct {
+	// NeedsImages the component and plugin images are saved into the package in offline mode
+	NeedsImages bool
+	// SupportsOnline the package can reference the images in their registries, formats
+	// that do not support it are always exported in offline mode
+	SupportsOnline bool
+	// KeepsCredentials the registry credentials are kept in online mode if asked for
+	KeepsCredentials bool
+	// Validate the app template is validated before the export
+	Validate bool
+	// ImageLayout the saved images can be moved into an OCI image layout, formats that
+	// load the saved tarballs in their scripts do not support it
+	ImageLayout bool
+	// PackageKind suffix of the export dir and the package, e.g. ram for app-1.0-ram.tar.gz
+	PackageKind string
+}
+
+// Context the export shared by the pipeline and the format
+type Context struct {
+	Format       AppFormat
+	Capabilities Capabilities
+	Logger       *logrus.Logger
+	// RAM the app template, the images are pinned in it in online mode before the format is created
+	RAM         *v1alpha1.RainbondApplicationConfig
+	ImageClient image.Client
+	Options     Options
+	// Mode the mode the package is exported in, it is offline if the format does not support online mode
+	Mode       string
+	Pull       image.PullOptions
+	HomePath   string
+	ExportPath string
+	Report     *ExportReport
+}
+
+// Warn log the warning and keep it in the export report
+func (c *Context) Warn(format string, args ...interface{}) {
+	c.Report.warn(c.Logger, format, args...)
+}
+
+// AddImage list an image other than the component and plugin images in the export report
+func (c *Context) AddImage(kind, name, image string) {
+	c.Report.addExtraImage(kind, name, image)
+}
+
+// Renderer writes the format specific files into the export dir, it is called by the
+// pipeline after the images are saved and before the package is sealed
+type Renderer interface {
+	Render() error
+}
+
+// ImageSaver is implemented by the formats that save other images than the component and
+// plugin images, the images must be saved as component-images.tar and plugin-images.tar.
+// The images of ram are saved, the images not shipped in the tarballs, e.g. the images of
+// the VM disks written as files, are removed from it.
+type ImageSaver interface {
+	SaveImages(ram v1alpha1.RainbondApplicationConfig) error
+}
+
+// Factory creates the renderer of the format for the export
+type Factory func(ctx *Context) (Renderer, error)
+
+type registration struct {
+	capabilities Capabilities
+	factory      Factory
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[AppFormat]registration)
+)
+
+// Register make the format available to New, it panics if the format is registered twice
+// or the factory is nil. Third-party formats register themselves in init.
+func Register(format AppFormat, capabilities Capabilities, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("export: register nil factory of format " + string(format))
+	}
+	if _, ok := registry[format]; ok {
+		panic("export: register format twice " + string(format))
+	}
+	if capabilities.PackageKind == "" {
+		capabilities.PackageKind = string(format)
+	}
+	registry[format] = registration{capabilities: capabilities, factory: factory}
+}
+
+// Formats returns the registered formats
+func Formats() []AppFormat {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var formats []AppFormat
+	for format := range registry {
+		formats = append(formats, format)
+	}
+	sort.Slice(formats, func(i, j int) bool { return formats[i] < formats[j] })
+	return formats
+}
+
+// FormatCapabilities returns the capabilities of the registered format
+func FormatCapabilities(format AppFormat) (Capabilities, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	r, ok := registry[format]
+	return r.capabilities, ok
+}
+
+// pipeline exports the app in the steps shared by all formats: prepare the export dir,
+// resolve the images, save them, render the format, seal and package
+type pipeline struct {
+	ctx     *Context
+	factory Factory
+}
+
+func newPipeline(format AppFormat, homePath string, ram v1alpha1.RainbondApplicationConfig, imageClient image.Client, logger *logrus.Logger, options Options) (*pipeline, error) {
+	registryMu.RLock()
+	r, ok := registry[format]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("not support app format %s", format)
+	}
+	mode := options.Mode
+	if mode == OnlineMode && !r.capabilities.SupportsOnline {
+		logger.Warningf("%s package is built from the saved images, export it in offline mode", format)
+		mode = OfflineMode
+	}
+	if options.SBOMFormat != "" && mode == OnlineMode {
+		logger.Warningf("sbom is generated from the saved images, it is not written in online mode")
+	}
+	if !validPackageFormat(options.PackageFormat) {
+		return nil, fmt.Errorf("not support package format %s, support %v", options.PackageFormat, archive.Formats)
+	}
+	switch options.ComposeNetworkMode {
+	case "", ComposeBridgeNetworkMode, ComposeHostNetworkMode:
+	default:
+		return nil, fmt.Errorf("not support docker compose network mode %s", options.ComposeNetworkMode)
+	}
+	for _, f := range options.Topology {
+		if !f.valid() {
+			return nil, fmt.Errorf("not support topology format %s", f)
+		}
+	}
+	pull := image.PullOptions{Concurrency: options.PullConcurrency, Timeout: options.PullTimeout, MaxAttempts: image.DefaultPullMaxAttempts}
+	platforms, err := image.ParsePlatforms(options.Platforms...)
+	if err != nil {
+		return nil, err
+	}
+	pull.Platforms = platforms
+	if options.ComponentArch {
+		if pull.ImagePlatforms, err = archPlatforms(ram); err != nil {
+			return nil, err
+		}
+	}
+	if options.ImageCacheDir != "" && mode == OfflineMode && r.capabilities.NeedsImages {
+		if pull.Cache, err = image.OpenCache(options.ImageCacheDir, options.ImageCacheSize); err != nil {
+			return nil, err
+		}
+	}
+	ram = copyTemplate(ram)
+	return &pipeline{
+		ctx: &Context{
+			Format:       format,
+			Capabilities: r.capabilities,
+			Logger:       logger,
+			RAM:          &ram,
+			ImageClient:  imageClient,
+			Options:      options,
+			Mode:         mode,
+			Pull:         pull,
+			HomePath:     homePath,
+			ExportPath:   path.Join(homePath, fmt.Sprintf("%s-%s-%s", ram.AppName, ram.AppVersion, r.capabilities.PackageKind)),
+		},
+		factory: r.factory,
+	}, nil
+}
+
+// copyTemplate copy the components and plugins the export changes, e.g. the images pinned and
+// the credentials stripped in online mode or the VM disk files recorded, the template of the
+// caller is kept as it is
+func copyTemplate(ram v1alpha1.RainbondApplicationConfig) v1alpha1.RainbondApplicationConfig {
+	components := make([]*v1alpha1.Component, 0, len(ram.Components))
+	for _, component := range ram.Components {
+		copied := *component
+		if component.VM != nil {
+			vm := *component.VM
+			vm.DiskLayout = append([]v1alpha1.VMDiskLayoutItem(nil), component.VM.DiskLayout...)
+			copied.VM = &vm
+		}
+		components = append(components, &copied)
+	}
+	plugins := make([]*v1alpha1.Plugin, 0, len(ram.Plugins))
+	for _, plugin := range ram.Plugins {
+		copied := *plugin
+		plugins = append(plugins, &copied)
+	}
+	if ram.Components != nil {
+		ram.Components = components
+	}
+	if ram.Plugins != nil {
+		ram.Plugins = plugins
+	}
+	return ram
+}
+
+// validPackageFormat returns true if the package can be packed in the format, empty is tar.gz
+func validPackageFormat(format archive.Format) bool {
+	if format == "" {
+		return true
+	}
+	for _, f := range archive.Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *pipeline) Export() (*Result, error) {
+	ctx := p.ctx
+	ctx.Logger.Infof("start export app %s to %s app spec", ctx.RAM.AppName, ctx.Format)
+	ctx.Report = newReport(ctx.Format, *ctx.RAM, ctx.Mode)
+	if ctx.Capabilities.Validate {
+		ctx.RAM.HandleNullValue()
+		if err := ctx.RAM.Validation(); err != nil {
+			return nil, err
+		}
+	}
+	saveImages := ctx.Mode == OfflineMode && ctx.Capabilities.NeedsImages
+	if saveImages {
+		ctx.Report.Platforms = packagePlatforms(ctx.Pull)
+		ctx.checkComponentArch()
+	}
+	// Delete the old application group directory and then regenerate the application package
+	if err := PrepareExportDir(ctx.ExportPath); err != nil {
+		ctx.Logger.Errorf("prepare export dir failure %s", err.Error())
+		return nil, err
+	}
+	ctx.Logger.Infof("success prepare export dir")
+	if ctx.Mode == OnlineMode {
+		done := ctx.Report.phase("pin images")
+		keepCredentials := ctx.Options.KeepCredentials && ctx.Capabilities.KeepsCredentials
+		if err := pinImages(*ctx.RAM, keepCredentials, ctx.Pull.Timeout*60, ctx.Logger); err != nil {
+			ctx.Logger.Errorf("pin images failure %s", err.Error())
+			return nil, err
+		}
+		done()
+	}
+	renderer, err := p.factory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if saveImages {
+		if err := p.saveImages(renderer); err != nil {
+			return nil, err
+		}
+	}
+	done := ctx.Report.phase("render")
+	if err := renderer.Render(); err != nil {
+		return nil, err
+	}
+	done()
+	flowchart, err := writeTopologies(ctx.ExportPath, *ctx.RAM, ctx.Options.Topology)
+	if err != nil {
+		ctx.Logger.Errorf("write topology failure %s", err.Error())
+		return nil, err
+	}
+	if err := writeReadme(ctx.ExportPath, *ctx.RAM, ctx.Format, renderer, flowchart, ctx.Options.ReadmeHTML); err != nil {
+		ctx.Logger.Errorf("write readme failure %s", err.Error())
+		return nil, err
+	}
+	if saveImages && ctx.Options.OCIImageLayout && ctx.Capabilities.ImageLayout {
+		done := ctx.Report.phase("store image layout")
+		if err := storeImagesInLayout(ctx.ExportPath, primaryPlatform(ctx.Pull), ctx.Logger); err != nil {
+			ctx.Logger.Errorf("store images in image layout failure %s", err.Error())
+			return nil, err
+		}
+		done()
+	}
+	// packaging
+	if err := ctx.Report.write(ctx.ExportPath, *ctx.RAM); err != nil {
+		ctx.Logger.Errorf("write export report failure %s", err.Error())
+		return nil, err
+	}
+	done = ctx.Report.phase("seal package")
+	var platforms map[string][]string
+	if saveImages {
+		platforms = imagePlatforms(*ctx.RAM, ctx.Pull)
+	}
+	if err := sealPackage(ctx.ExportPath, ctx.Format, ctx.Mode, ctx.Report.Platforms, platforms, *ctx.RAM, ctx.Options.Signer, ctx.Options.BasePackage); err != nil {
+		ctx.Logger.Errorf("seal package failure %s", err.Error())
+		return nil, err
+	}
+	done()
+	packageName := packageFileName(*ctx.RAM, ctx.Capabilities.PackageKind, ctx.Options.PackageFormat)
+	done = ctx.Report.phase("archive package")
+	name, err := Packaging(packageName, ctx.HomePath, ctx.ExportPath, ctx.Options.SplitSize)
+	if err != nil {
+		err = fmt.Errorf("Failed to package app %s: %s ", packageName, err.Error())
+		ctx.Logger.Error(err)
+		return nil, err
+	}
+	done()
+	ctx.Logger.Infof("success export app " + ctx.RAM.AppName)
+	return ctx.Report.result(ctx.HomePath, name, ctx.Options.PackageFormat)
+}
+
+// saveImages save the images with the saver of the format or the component and plugin
+// images, and write their SBOMs
+func (p *pipeline) saveImages(renderer Renderer) error {
+	ctx := p.ctx
+	// the images of the VM disks written as files are not saved
+	ram := *ctx.RAM
+	if ctx.Options.VMDisks {
+		done := ctx.Report.phase("extract vm disks")
+		extracted, err := extractVMDisks(ctx)
+		if err != nil {
+			ctx.Logger.Errorf("extract vm disks failure %s", err.Error())
+			return err
+		}
+		ram = withoutImages(ram, extracted)
+		done()
+	}
+	done := ctx.Report.phase("save images")
+	if saver, ok := renderer.(ImageSaver); ok {
+		if err := saver.SaveImages(ram); err != nil {
+			return err
+		}
+	} else {
+		if len(ram.Components) > 0 {
+			if err := SaveComponents(ram, ctx.ImageClient, ctx.ExportPath, ctx.Logger, []string{}, ctx.Pull); err != nil {
+				return err
+			}
+			ctx.Logger.Infof("success save components")
+		}
+		if len(ctx.RAM.Plugins) > 0 {
+			if err := SavePlugins(*ctx.RAM, ctx.ImageClient, ctx.ExportPath, ctx.Logger, ctx.Pull); err != nil {
+				return err
+			}
+			ctx.Logger.Infof("success save plugins")
+		}
+	}
+	done()
+	if ctx.Pull.Cache != nil {
+		ctx.Report.cacheHits(ctx.Pull.Cache.Hits())
+	}
+	if ctx.Options.SBOMFormat == "" {
+		return nil
+	}
+	done = ctx.Report.phase("generate sbom")
+	if err := writeSBOMs(ctx.ExportPath, ram, ctx.Options.SBOMFormat, ctx.Report, ctx.Logger); err != nil {
+		ctx.Logger.Errorf("write sbom failure %s", err.Error())
+		return err
+	}
+	done()
+	return nil
+}
@@ -0,0 +1,138 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type listRenderer struct {
+	ctx *Context
+}
+
+func (l *listRenderer) Render() error {
+	var content string
+	for _, component := range l.ctx.RAM.Components {
+		content += component.ServiceCname + "=" + component.ShareImage + "\n"
+	}
+	l.ctx.Warn("%d components listed", len(l.ctx.RAM.Components))
+	return ioutil.WriteFile(path.Join(l.ctx.ExportPath, "components.txt"), []byte(content), 0644)
+}
+
+func TestRegisterFormat(t *testing.T) {
+	const format AppFormat = "test-list"
+	Register(format, Capabilities{}, func(ctx *Context) (Renderer, error) {
+		return &listRenderer{ctx: ctx}, nil
+	})
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected registering a format twice to panic")
+		}
+	}()
+	defer func() {
+		registryMu.Lock()
+		delete(registry, format)
+		registryMu.Unlock()
+	}()
+	home := t.TempDir()
+	// the format does not support online mode, the package is exported offline without images
+	exporter, err := newExporter(format, home, newComposeTestTemplate(), nil, logrus.StandardLogger(), newOptions(WithOnlineMode(false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := exporter.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.PackageName != "demo-1.0-test-list.tar.gz" || result.Report.Mode != OfflineMode || len(result.Report.Warnings) != 1 {
+		t.Fatalf("unexpected result %+v %+v", result, result.Report)
+	}
+	content, err := ioutil.ReadFile(path.Join(home, "demo-1.0-test-list", "components.txt"))
+	if err != nil || len(content) == 0 {
+		t.Fatalf("expected the rendered file, got %q %v", content, err)
+	}
+	Register(format, Capabilities{}, func(ctx *Context) (Renderer, error) { return nil, nil })
+}
+
+func TestNewUnknownFormat(t *testing.T) {
+	if _, err := newExporter("unknown", t.TempDir(), newComposeTestTemplate(), nil, logrus.StandardLogger(), newOptions()); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+	for _, format := range []AppFormat{RAM, DC, SLG, HELM, VELA} {
+		if _, ok := FormatCapabilities(format); !ok {
+			t.Fatalf("expected built-in format %s to be registered", format)
+		}
+	}
+}
+
+func TestPipelinePlatforms(t *testing.T) {
+	ram := newComposeTestTemplate()
+	ram.Components[0].Arch = "arm64"
+	p, err := newPipeline(RAM, t.TempDir(), ram, nil, logrus.StandardLogger(), newOptions(WithPlatforms("linux/amd64"), WithComponentArch()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.ctx.Pull.PlatformsOf(ram.Components[0].ShareImage).String(); got != "linux/arm64" {
+		t.Fatalf("expected the image of web to be saved for its arch, got %s", got)
+	}
+	if got := p.ctx.Pull.PlatformsOf(ram.Components[1].ShareImage).String(); got != "linux/amd64" {
+		t.Fatalf("expected the image of db to be saved for the export platforms, got %s", got)
+	}
+	if got := packagePlatforms(p.ctx.Pull); len(got) != 2 || got[0] != "linux/amd64" || got[1] != "linux/arm64" {
+		t.Fatalf("unexpected package platforms %v", got)
+	}
+	images := imagePlatforms(ram, p.ctx.Pull)
+	if got := images[ram.Components[0].ShareImage]; len(got) != 1 || got[0] != "linux/arm64" {
+		t.Fatalf("expected the platforms of every image to be recorded, got %v", images)
+	}
+
+	// the arch of the component is only reported if the image is not exported for it
+	p, err = newPipeline(RAM, t.TempDir(), ram, nil, logrus.StandardLogger(), newOptions(WithPlatforms("linux/amd64")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ctx.Report = newReport(RAM, ram, OfflineMode)
+	p.ctx.checkComponentArch()
+	if len(p.ctx.Report.Warnings) != 1 || p.ctx.Report.Warnings[0] != "component web runs on linux/arm64 but its image is exported for linux/amd64" {
+		t.Fatalf("unexpected warnings %v", p.ctx.Report.Warnings)
+	}
+
+	if _, err := newPipeline(RAM, t.TempDir(), ram, nil, logrus.StandardLogger(), newOptions(WithPlatforms("linux/arm64/v8/x"))); err == nil {
+		t.Fatal("expected an invalid platform to fail")
+	}
+}
+
+func TestNewPipelineRejectsUnknownComposeNetworkMode(t *testing.T) {
+	_, err := newPipeline(DC, t.TempDir(), newComposeTestTemplate(), nil, logrus.StandardLogger(), newOptions(WithComposeNetworkMode("overlay")))
+	if err == nil || !strings.Contains(err.Error(), "not support docker compose network mode overlay") {
+		t.Fatalf("expected the network mode to be rejected, got %v", err)
+	}
+}
+
+func TestNewPipelineRejectsUnknownPackageFormat(t *testing.T) {
+	_, err := newPipeline(RAM, t.TempDir(), newComposeTestTemplate(), nil, logrus.StandardLogger(), newOptions(WithPackageFormat("rar")))
+	if err == nil || !strings.Contains(err.Error(), "not support package format rar") {
+		t.Fatalf("expected the package format to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"path"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+// Planner is implemented by the formats that describe their export without writing it,
+// they list the files they render in the report and add the problems of the app as warnings
+type Planner interface {
+	Plan(report *ExportReport) error
+}
+
+// Plan describes the export without pulling images or writing anything: the images to pull
+// and save or to reference with the sizes known from the base package, the files to render,
+// the package path and the problems found in the app template. The problems are reported as
+// warnings, the plan only fails if the format can not be planned at all.
+func (p *pipeline) Plan() (*ExportReport, error) {
+	ctx := p.ctx
+	ctx.Report = newReport(ctx.Format, *ctx.RAM, ctx.Mode)
+	report := ctx.Report
+	report.DryRun = true
+	report.PackagePath = path.Join(ctx.HomePath, packageFileName(*ctx.RAM, ctx.Capabilities.PackageKind, ctx.Options.PackageFormat))
+	if ctx.Capabilities.Validate {
+		ctx.RAM.HandleNullValue()
+		if err := ctx.RAM.Validation(); err != nil {
+			report.warn(ctx.Logger, "app template is invalid: %s", err.Error())
+		}
+	}
+	saved := make(map[string]savedImage)
+	if ctx.Options.BasePackage != "" {
+		base, _, err := LoadBaseManifest(ctx.Options.BasePackage)
+		if err != nil {
+			report.warn(ctx.Logger, "%s", err.Error())
+		} else {
+			saved = baseImageSizes(base)
+		}
+	}
+	if ctx.Mode == OfflineMode && ctx.Capabilities.NeedsImages {
+		report.Platforms = packagePlatforms(ctx.Pull)
+		ctx.checkComponentArch()
+	}
+	p.planImageCredentials()
+	p.planDependencies()
+	renderer, err := p.factory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if planner, ok := renderer.(Planner); ok {
+		if err := planner.Plan(report); err != nil {
+			return nil, err
+		}
+	}
+	if ctx.Mode == OfflineMode && ctx.Capabilities.NeedsImages && ctx.Options.SBOMFormat != "" {
+		report.Files = append(report.Files, SBOMDir+"/")
+	}
+	if ctx.Mode == OfflineMode && ctx.Capabilities.NeedsImages && ctx.Options.VMDisks && hasVMDisks(*ctx.RAM) {
+		report.Files = append(report.Files, VMDiskDir+"/")
+	}
+	for _, format := range ctx.Options.Topology {
+		report.Files = append(report.Files, format.FileName())
+	}
+	report.Files = append(report.Files, ReadmeFileName)
+	if ctx.Options.ReadmeHTML {
+		report.Files = append(report.Files, ReadmeHTMLFileName)
+	}
+	report.Files = append(report.Files, ReportFileName, ManifestFileName)
+	if ctx.Options.Signer != nil {
+		report.Files = append(report.Files, SignatureFileName)
+	}
+	fingerprint, err := TemplateFingerprint(*ctx.RAM)
+	if err != nil {
+		return nil, err
+	}
+	report.TemplateFingerprint = fingerprint
+	report.addImages(*ctx.RAM, saved)
+	return report, nil
+}
+
+// planImageCredentials report the images that can not be pulled with the credentials of the
+// template in offline mode, and the private images whose credentials are not kept in online mode
+func (p *pipeline) planImageCredentials() {
+	ctx := p.ctx
+	keepCredentials := ctx.Options.KeepCredentials && ctx.Capabilities.KeepsCredentials
+	check := func(kind, name, image string, info v1alpha1.ImageInfo) {
+		if image == "" || info.HubUser == "" {
+			return
+		}
+		switch {
+		case ctx.Mode == OfflineMode && ctx.Capabilities.NeedsImages && info.HubPassword == "":
+			ctx.Warn("image %s of %s %s has a registry user but no password", image, kind, name)
+		case ctx.Mode == OnlineMode && !keepCredentials:
+			ctx.Warn("credentials of image %s of %s %s are not kept, it must be pullable without them where the package is installed", image, kind, name)
+		}
+	}
+	for _, component := range ctx.RAM.Components {
+		check("component", component.ServiceCname, component.ShareImage, component.AppImage)
+	}
+	for _, plugin := range ctx.RAM.Plugins {
+		check("plugin", plugin.PluginName, plugin.ShareImage, plugin.PluginImage)
+	}
+}
+
+// planDependencies report the dependency keys that match no component of the app
+func (p *pipeline) planDependencies() {
+	ctx := p.ctx
+	keys := make(map[string]struct{})
+	for _, component := range ctx.RAM.Components {
+		keys[component.ComponentKey] = struct{}{}
+		if component.ServiceShareID != "" {
+			keys[component.ServiceShareID] = struct{}{}
+		}
+	}
+	for _, component := range ctx.RAM.Components {
+		for _, dep := range component.DepServiceMapList {
+			if _, ok := keys[dep.DepServiceKey]; !ok {
+				ctx.Warn("dependency %s of component %s not found in the app", dep.DepServiceKey, component.ServiceCname)
+			}
+		}
+	}
+}
+
+// baseImageSizes returns the sizes of the images of the base package by their names, an image
+// is sized by its own layers, the size is unknown for the tarballs of older packages holding several images
+func baseImageSizes(base *PackageManifest) map[string]savedImage {
+	saved := make(map[string]savedImage)
+	for _, image := range base.Images {
+		sizes := make(map[string]int64, len(image.Layers))
+		var total int64
+		for _, layer := range image.Layers {
+			sizes[layer.Path] = layer.Size
+			total += layer.Size
+		}
+		for _, tag := range image.RepoTags {
+			var size int64
+			if layers, ok := image.TagLayers[tag]; ok {
+				for _, layer := range layers {
+					size += sizes[layer]
+				}
+			} else if len(image.RepoTags) == 1 {
+				size = total
+			}
+			addSavedImage(saved, tag, savedImage{size: size})
+		}
+	}
+	return saved
+}
@@ -0,0 +1,196 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/sirupsen/logrus"
+)
+
+func hasWarning(report *ExportReport, prefix string) bool {
+	for _, warning := range report.Warnings {
+		if strings.HasPrefix(warning, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPlanComposeWritesNothing(t *testing.T) {
+	home := t.TempDir()
+	ram := newComposeTestTemplate()
+	ram.Components[0].AppImage = v1alpha1.ImageInfo{HubUser: "admin"}
+	ram.Components[1].DepServiceMapList = []v1alpha1.ComponentDep{{DepServiceKey: "cache-key"}}
+	ram.Components[1].ServiceVolumeMapList = v1alpha1.ComponentVolumeList{{VolumeName: "conf", VolumeMountPath: "/etc/db.conf", VolumeType: v1alpha1.ConfigFileVolumeType}}
+	exporter, err := newExporter(DC, home, ram, nil, logrus.StandardLogger(), newOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := exporter.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := ioutil.ReadDir(home); len(entries) != 0 {
+		t.Fatalf("expected nothing to be written, got %d entries", len(entries))
+	}
+	if !report.DryRun || report.PackagePath != path.Join(home, "demo-1.0-dockercompose.tar.gz") || report.TemplateFingerprint == "" {
+		t.Fatalf("unexpected plan %+v", report)
+	}
+	files := strings.Join(report.Files, ",")
+	for _, file := range []string{"docker-compose.yaml", "run.sh", ReportFileName, ManifestFileName} {
+		if !strings.Contains(files, file) {
+			t.Fatalf("expected %s to be planned, got %v", file, report.Files)
+		}
+	}
+	if !strings.Contains(files, "/etc/db.conf") {
+		t.Fatalf("expected the config file to be planned, got %v", report.Files)
+	}
+	if len(report.Images) != 2 {
+		t.Fatalf("unexpected images %+v", report.Images)
+	}
+	for _, prefix := range []string{
+		"image registry.example.com/demo/web:v1 of component web has a registry user but no password",
+		"dependency cache-key of component db not found",
+		// warnings of the compose spec are planned as well
+		"host port 8080/tcp of db is already published",
+	} {
+		if !hasWarning(report, prefix) {
+			t.Fatalf("expected warning %q, got %v", prefix, report.Warnings)
+		}
+	}
+}
+
+func TestPlanOnlineWithBasePackage(t *testing.T) {
+	home := t.TempDir()
+	base := PackageManifest{Images: []ManifestImage{{
+		Path:     "component-images.tar",
+		RepoTags: []string{"registry.example.com/demo/db:v1"},
+		Layers:   []ManifestFile{{Path: "l1/layer.tar", Size: 100}, {Path: "l2/layer.tar", Size: 20}},
+	}}}
+	body, _ := json.Marshal(base)
+	baseFile := path.Join(home, "base-manifest.json")
+	if err := ioutil.WriteFile(baseFile, body, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ram := newOnlineTestTemplate()
+	exporter, err := newExporter(RAM, home, ram, nil, logrus.StandardLogger(), newOptions(WithOnlineMode(false), WithBasePackage(baseFile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := exporter.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Mode != OnlineMode || len(report.Phases) != 0 {
+		t.Fatalf("unexpected plan %+v", report)
+	}
+	if !hasWarning(report, "credentials of image registry.example.com/demo/web:v1 of component web are not kept") {
+		t.Fatalf("expected stripped credentials to be reported, got %v", report.Warnings)
+	}
+	if len(report.Images) != 2 || report.Images[1].Size != 120 || report.Images[0].Size != 0 {
+		t.Fatalf("expected the size known from the base package, got %+v", report.Images)
+	}
+}
+
+func TestPlanFilesAreExported(t *testing.T) {
+	for _, tc := range []struct {
+		format AppFormat
+		opts   []Option
+	}{
+		{format: SLG},
+		{format: SLG, opts: []Option{WithSlugSystemd()}},
+		{format: DC},
+	} {
+		ram := newGatewayTestTemplate()
+		ram.Components[0].ServiceVolumeMapList = v1alpha1.ComponentVolumeList{{VolumeName: "conf", VolumeMountPath: "/etc/web/web.conf", FileConent: "a=1", VolumeType: v1alpha1.ConfigFileVolumeType}}
+		if tc.format == SLG {
+			// only the image component is exported, the slugs are not in the saved test images
+			ram.Components = ram.Components[:1]
+		}
+		file, _ := writeTestDisk(t, "web.layer", "web layer")
+		client := &diskImageClient{disks: map[string]string{ram.Components[0].ShareImage: file}}
+		planner, err := newPipeline(tc.format, t.TempDir(), ram, client, logrus.StandardLogger(), newOptions(tc.opts...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := planner.Plan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := newPipeline(tc.format, t.TempDir(), ram, client, logrus.StandardLogger(), newOptions(tc.opts...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Export(); err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range report.Files {
+			info, err := os.Stat(path.Join(p.ctx.ExportPath, file))
+			if err != nil || strings.HasSuffix(file, "/") != info.IsDir() {
+				t.Errorf("%s: planned file %s is not exported: %v", tc.format, file, err)
+			}
+		}
+	}
+}
+
+func TestBaseImageSizesOfImagesInOneTarball(t *testing.T) {
+	file := path.Join(t.TempDir(), "component-images.tar")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	items := []dockerArchiveManifest{
+		{Config: "web.json", RepoTags: []string{"registry.example.com/demo/web:v1"}, Layers: []string{"base/layer.tar", "web/layer.tar"}},
+		{Config: "db.json", RepoTags: []string{"registry.example.com/demo/db:v1"}, Layers: []string{"base/layer.tar", "db/layer.tar"}},
+	}
+	body, _ := json.Marshal(items)
+	for _, entry := range []struct{ name, content string }{
+		{"manifest.json", string(body)},
+		{"base/layer.tar", strings.Repeat("b", 100)},
+		{"web/layer.tar", strings.Repeat("w", 20)},
+		{"db/layer.tar", strings.Repeat("d", 5)},
+	} {
+		tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(entry.content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	_, image, err := scanImageTarball(file)
+	if err != nil || image == nil {
+		t.Fatalf("expected the images of the tarball, got %v", err)
+	}
+	saved := baseImageSizes(&PackageManifest{Images: []ManifestImage{*image}})
+	if size := saved[normalizeImageName("registry.example.com/demo/web:v1")].size; size != 120 {
+		t.Fatalf("expected web to be sized by its own layers, got %d", size)
+	}
+	if size := saved[normalizeImageName("registry.example.com/demo/db:v1")].size; size != 105 {
+		t.Fatalf("expected db to be sized by its own layers, got %d", size)
+	}
+}
@@ -0,0 +1,113 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// archPlatforms returns the platforms of the component images pulled and saved for the arch
+// of their component, the images shared by components of several archs are saved for all
+func archPlatforms(ram v1alpha1.RainbondApplicationConfig) (map[string]image.Platforms, error) {
+	result := make(map[string]image.Platforms)
+	for _, component := range ram.Components {
+		if component.Arch == "" || component.ShareImage == "" {
+			continue
+		}
+		p, err := image.ParsePlatforms(component.Arch)
+		if err != nil {
+			return nil, fmt.Errorf("arch of component %s failure %s", component.ServiceCname, err.Error())
+		}
+		result[component.ShareImage] = result[component.ShareImage].Union(p)
+	}
+	return result, nil
+}
+
+// packagePlatforms returns the platforms of the images saved in the package, the platform
+// of the host if no platform is asked for
+func packagePlatforms(pull image.PullOptions) []string {
+	all := pull.Platforms
+	for _, p := range pull.ImagePlatforms {
+		all = all.Union(p)
+	}
+	if len(all) == 0 {
+		all = image.DefaultPlatforms()
+	}
+	platforms := all.Strings()
+	sort.Strings(platforms)
+	return platforms
+}
+
+// imagePlatforms returns the platforms each component and plugin image is saved for
+func imagePlatforms(ram v1alpha1.RainbondApplicationConfig, pull image.PullOptions) map[string][]string {
+	result := make(map[string][]string)
+	add := func(name string) {
+		if name == "" {
+			return
+		}
+		exported := pull.PlatformsOf(name)
+		if len(exported) == 0 {
+			exported = image.DefaultPlatforms()
+		}
+		result[name] = exported.Strings()
+	}
+	for _, component := range ram.Components {
+		add(component.ShareImage)
+	}
+	for _, plugin := range ram.Plugins {
+		add(plugin.ShareImage)
+	}
+	return result
+}
+
+// primaryPlatform returns the platform the docker load compatible manifest of the image layout
+// lists for multi-platform images, the first platform asked for or the platform of the host
+func primaryPlatform(pull image.PullOptions) ocispec.Platform {
+	if len(pull.Platforms) > 0 {
+		return pull.Platforms[0]
+	}
+	return image.DefaultPlatforms()[0]
+}
+
+// checkComponentArch warn about the components whose arch is not one of the platforms
+// their image is exported for, the package would not run on their nodes
+func (c *Context) checkComponentArch() {
+	for _, component := range c.RAM.Components {
+		if component.Arch == "" || component.ShareImage == "" {
+			continue
+		}
+		arch, err := image.ParsePlatforms(component.Arch)
+		if err != nil {
+			c.Warn("arch %s of component %s is not a platform", component.Arch, component.ServiceCname)
+			continue
+		}
+		exported := c.Pull.PlatformsOf(component.ShareImage)
+		if len(exported) == 0 {
+			exported = image.DefaultPlatforms()
+		}
+		if !exported.Contains(arch[0]) {
+			c.Warn("component %s runs on %s but its image is exported for %s", component.ServiceCname, arch, exported)
+		}
+	}
+}
@@ -22,67 +22,54 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
-	"github.com/goodrain/rainbond-oam/pkg/util/image"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"path"
 )
 
+func init() {
+	Register(RAM, Capabilities{NeedsImages: true, SupportsOnline: true, KeepsCredentials: true, Validate: true, ImageLayout: true, PackageKind: "ram"}, newRAMExporter)
+}
+
 type ramExporter struct {
-	logger      *logrus.Logger
-	ram         v1alpha1.RainbondApplicationConfig
-	imageClient image.Client
-	mode        string
-	homePath    string
-	exportPath  string
+	logger     *logrus.Logger
+	ram        v1alpha1.RainbondApplicationConfig
+	mode       string
+	exportPath string
 }
 
-func (r *ramExporter) Export() (*Result, error) {
-	r.logger.Infof("start export app %s to ram app spec", r.ram.AppName)
-	r.ram.HandleNullValue()
-	if err := r.ram.Validation(); err != nil {
-		return nil, err
-	}
-	// Delete the old application group directory and then regenerate the application package
-	if err := PrepareExportDir(r.exportPath); err != nil {
-		r.logger.Errorf("prepare export dir failure %s", err.Error())
-		return nil, err
-	}
-	r.logger.Infof("success prepare export dir")
-	if r.mode == "offline" {
-		// Save components attachments
-		if len(r.ram.Components) > 0 {
-			if err := SaveComponents(r.ram, r.imageClient, r.exportPath, r.logger, []string{}); err != nil {
-				return nil, err
-			}
-			r.logger.Infof("success save components")
-		}
-		if len(r.ram.Plugins) > 0 {
-			if err := SavePlugins(r.ram, r.imageClient, r.exportPath, r.logger); err != nil {
-				return nil, err
-			}
-			r.logger.Infof("success save plugins")
-		}
-	}
+func newRAMExporter(ctx *Context) (Renderer, error) {
+	return &ramExporter{
+		logger:     ctx.Logger,
+		ram:        *ctx.RAM,
+		mode:       ctx.Mode,
+		exportPath: ctx.ExportPath,
+	}, nil
+}
+
+func (r *ramExporter) Render() error {
 	if err := r.writeMetaFile(); err != nil {
-		return nil, err
+		return err
 	}
 	r.logger.Infof("success write ram spec file")
-	// packaging
-	packageName := fmt.Sprintf("%s-%s-ram.tar.gz", r.ram.AppName, r.ram.AppVersion)
-	name, err := Packaging(packageName, r.homePath, r.exportPath)
-	if err != nil {
-		err = fmt.Errorf("Failed to package app %s: %s ", packageName, err.Error())
-		r.logger.Error(err)
-		return nil, err
+	return nil
+}
+
+func (r *ramExporter) Plan(report *ExportReport) error {
+	report.Files = append(report.Files, "metadata.json")
+	return nil
+}
+
+func (r *ramExporter) InstallSteps() []InstallStep {
+	if r.mode == OnlineMode {
+		return []InstallStep{{Description: "Open the app market of the Rainbond team, choose offline import and upload this package. The images are pulled from their registries, the cluster must reach them."}}
 	}
-	r.logger.Infof("success export app " + r.ram.AppName)
-	return &Result{PackagePath: path.Join(r.homePath, name), PackageName: name}, nil
+	return []InstallStep{{Description: "Open the app market of the Rainbond team, choose offline import and upload this package. The images of the package are pushed to the registry of the cluster."}}
 }
 
 func (r *ramExporter) writeMetaFile() error {
 	// remove component and plugin image hub info
-	if r.mode == "offline" {
+	if r.mode == OfflineMode {
 		for i := range r.ram.Components {
 			r.ram.Components[i].AppImage = v1alpha1.ImageInfo{}
 		}
@@ -0,0 +1,147 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+const testDigest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+
+// fakeResolveImageDigest pins every image to testDigest, the timeout of the last resolve is returned
+func fakeResolveImageDigest(t *testing.T) *int {
+	t.Helper()
+	resolve := resolveImageDigest
+	var last int
+	resolveImageDigest = func(image, username, password string, timeout int) (string, error) {
+		last = timeout
+		return image + "@" + testDigest, nil
+	}
+	t.Cleanup(func() { resolveImageDigest = resolve })
+	return &last
+}
+
+func newOnlineTestTemplate() v1alpha1.RainbondApplicationConfig {
+	ram := newComposeTestTemplate()
+	ram.Components[0].AppImage = v1alpha1.ImageInfo{HubURL: "registry.example.com", HubUser: "admin", HubPassword: "secret"}
+	ram.Components[1].VM = &v1alpha1.VMTemplate{DiskLayout: []v1alpha1.VMDiskLayoutItem{
+		{DiskRole: v1alpha1.VMDiskRoleRoot, SourceType: v1alpha1.VMDiskSourceRegistry, Image: ram.Components[1].ShareImage},
+	}}
+	return ram
+}
+
+func TestOnlineRAMExportPinsImages(t *testing.T) {
+	timeout := fakeResolveImageDigest(t)
+	home := t.TempDir()
+	ram := newOnlineTestTemplate()
+	exporter, err := newExporter(RAM, home, ram, nil, logrus.StandardLogger(), newOptions(WithOnlineMode(false), WithPullTimeout(5)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportPath := path.Join(home, "demo-1.0-ram")
+	if _, err := exporter.Export(); err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadFile(path.Join(exportPath, "metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta v1alpha1.RainbondApplicationConfig
+	if err := json.Unmarshal(body, &meta); err != nil {
+		t.Fatal(err)
+	}
+	web, db := meta.Components[0], meta.Components[1]
+	if web.ShareImage != "registry.example.com/demo/web:v1@"+testDigest {
+		t.Fatalf("expected pinned image, got %s", web.ShareImage)
+	}
+	if web.AppImage.HubURL != "registry.example.com" || web.AppImage.HubUser != "" || web.AppImage.HubPassword != "" {
+		t.Fatalf("expected registry to be kept and credentials stripped, got %+v", web.AppImage)
+	}
+	if db.VM.DiskLayout[0].Image != db.ShareImage {
+		t.Fatalf("expected vm disk image to be pinned, got %s", db.VM.DiskLayout[0].Image)
+	}
+	manifest, err := ReadManifest(exportPath)
+	if err != nil || manifest.Mode != OnlineMode || len(manifest.Images) != 0 {
+		t.Fatalf("expected online manifest without images, got %+v %v", manifest, err)
+	}
+	if *timeout != 5*60 {
+		t.Fatalf("expected the images to be resolved with the pull timeout, got %ds", *timeout)
+	}
+	// the template of the caller can be exported again
+	if ram.Components[0].ShareImage != "registry.example.com/demo/web:v1" || ram.Components[0].AppImage.HubPassword != "secret" || ram.Components[1].VM.DiskLayout[0].Image != ram.Components[1].ShareImage {
+		t.Fatalf("expected the template of the caller to be kept, got %+v", ram.Components[0])
+	}
+}
+
+func TestPinImagesKeepsCredentials(t *testing.T) {
+	fakeResolveImageDigest(t)
+	ram := newOnlineTestTemplate()
+	if err := pinImages(ram, true, 60, logrus.StandardLogger()); err != nil {
+		t.Fatal(err)
+	}
+	if ram.Components[0].AppImage.HubUser != "admin" || ram.Components[0].AppImage.HubPassword != "secret" {
+		t.Fatalf("expected credentials to be kept, got %+v", ram.Components[0].AppImage)
+	}
+}
+
+// failingPullClient an image client whose pulls of the listed images fail
+type failingPullClient struct {
+	image.Client
+	failures map[string]error
+	saved    []string
+}
+
+func (c *failingPullClient) ImagePull(name string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	return nil, c.failures[name]
+}
+
+func (c *failingPullClient) ImageSave(destination string, images []string) error {
+	c.saved = images
+	return nil
+}
+
+func TestSaveComponentsReportsEveryFailedComponent(t *testing.T) {
+	ram := newComposeTestTemplate()
+	ram.Components = append(ram.Components, &v1alpha1.Component{ServiceCname: "web2", ShareImage: ram.Components[0].ShareImage})
+	client := &failingPullClient{failures: map[string]error{
+		ram.Components[0].ShareImage: errors.New("manifest unknown"),
+		ram.Components[1].ShareImage: errors.New("unauthorized"),
+	}}
+	err := SaveComponents(ram, client, t.TempDir(), logrus.StandardLogger(), nil, image.DefaultPullOptions())
+	if err == nil || !strings.Contains(err.Error(), "component web, component web2") || !strings.Contains(err.Error(), "component db") {
+		t.Fatalf("expected all failed components to be reported, got %v", err)
+	}
+	client.failures = nil
+	if err := SaveComponents(ram, client, t.TempDir(), logrus.StandardLogger(), nil, image.DefaultPullOptions()); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.saved) != 2 {
+		t.Fatalf("expected shared image to be saved once, got %v", client.saved)
+	}
+}
@@ -0,0 +1,307 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+const (
+	// ReadmeFileName the description of the package for the people installing it
+	ReadmeFileName = "README.md"
+	// ReadmeHTMLFileName the description rendered as HTML, see WithReadmeHTML
+	ReadmeHTMLFileName = "README.html"
+)
+
+// InstallStep one step of the install instructions in the README
+type InstallStep struct {
+	Description string
+	// Command the shell command of the step, optional
+	Command string
+}
+
+// InstallGuide is implemented by the formats that tell how their package is installed, the
+// steps are listed in the README of the package
+type InstallGuide interface {
+	InstallSteps() []InstallStep
+}
+
+// readme what the README tells about the app, it is built from the app template only
+type readme struct {
+	AppName     string
+	AppVersion  string
+	VersionInfo string
+	Format      AppFormat
+	Components  []readmeComponent
+	Routes      []readmeRoute
+	// Topology the Mermaid flowchart of the app, optional
+	Topology string
+	Steps    []InstallStep
+}
+
+type readmeComponent struct {
+	Name     string
+	Image    string
+	Ports    []string
+	CPU      string
+	Memory   string
+	Replicas int
+	Envs     []readmeEnv
+	Volumes  []readmeVolume
+}
+
+// readmeEnv an env the user may change, it is required if it has no default value
+type readmeEnv struct {
+	Name        string
+	Description string
+	Default     string
+	Required    bool
+}
+
+type readmeVolume struct {
+	Name     string
+	Path     string
+	Type     string
+	Capacity string
+}
+
+type readmeRoute struct {
+	Kind      string
+	Entry     string
+	Component string
+	Port      uint32
+}
+
+func newReadme(ram v1alpha1.RainbondApplicationConfig, format AppFormat, steps []InstallStep, flowchart string) *readme {
+	r := &readme{
+		AppName:     ram.AppName,
+		AppVersion:  ram.AppVersion,
+		VersionInfo: ram.Annotations["version_info"],
+		Format:      format,
+		Topology:    flowchart,
+		Steps:       steps,
+	}
+	names := make(map[string]string)
+	for _, component := range ram.Components {
+		names[component.ComponentKey] = component.ServiceCname
+		names[component.ServiceShareID] = component.ServiceCname
+		item := readmeComponent{
+			Name:     component.ServiceCname,
+			Image:    component.ShareImage,
+			CPU:      "unlimited",
+			Memory:   "unlimited",
+			Replicas: component.ExtendMethodRule.MinNode,
+		}
+		if item.Image == "" {
+			item.Image = component.Image
+		}
+		if component.CPU > 0 {
+			// cpu unit is millicore
+			item.CPU = fmt.Sprintf("%dm", component.CPU)
+		}
+		if component.Memory > 0 {
+			item.Memory = fmt.Sprintf("%d MB", component.Memory)
+		}
+		if item.Replicas < 1 {
+			item.Replicas = 1
+		}
+		for _, port := range component.Ports {
+			p := fmt.Sprintf("%d/%s", port.ContainerPort, port.Protocol)
+			if port.IsOuter {
+				p += " (external)"
+			}
+			item.Ports = append(item.Ports, p)
+		}
+		for _, env := range append(append([]v1alpha1.ComponentEnv{}, component.Envs...), component.ServiceConnectInfoMapList...) {
+			if !env.IsChange {
+				continue
+			}
+			item.Envs = append(item.Envs, readmeEnv{Name: env.AttrName, Description: env.Name, Default: env.AttrValue, Required: env.AttrValue == ""})
+		}
+		for _, volume := range component.ServiceVolumeMapList {
+			v := readmeVolume{Name: volume.VolumeName, Path: volume.VolumeMountPath, Type: volume.VolumeType.String()}
+			if volume.VolumeCapacity > 0 {
+				v.Capacity = fmt.Sprintf("%d GB", volume.VolumeCapacity)
+			}
+			item.Volumes = append(item.Volumes, v)
+		}
+		r.Components = append(r.Components, item)
+	}
+	for _, route := range ram.IngressHTTPRoutes {
+		location := route.Location
+		if location == "" {
+			location = "/"
+		}
+		r.Routes = append(r.Routes, readmeRoute{Kind: "http", Entry: location, Component: names[route.ComponentKey], Port: route.Port})
+	}
+	for _, route := range ram.IngressSreamRoutes {
+		r.Routes = append(r.Routes, readmeRoute{Kind: streamProtocol(route.Protocol), Entry: fmt.Sprintf("%d", route.Port), Component: names[route.ComponentKey], Port: route.Port})
+	}
+	return r
+}
+
+// cell escape the text for a markdown table cell
+func cell(text string) string {
+	text = strings.Replace(text, "|", `\|`, -1)
+	return strings.Replace(text, "\n", " ", -1)
+}
+
+var readmeFuncs = map[string]interface{}{
+	"cell": cell,
+	"join": strings.Join,
+}
+
+var readmeMarkdown = template.Must(template.New("readme").Funcs(readmeFuncs).Parse(`# {{.AppName}} {{.AppVersion}}
+{{if .VersionInfo}}
+{{.VersionInfo}}
+{{end}}
+{{.Format}} package of {{.AppName}} version {{.AppVersion}}, it has {{len .Components}} components.
+
+## Components
+
+| Component | Image | Ports | CPU | Memory | Replicas |
+| --- | --- | --- | --- | --- | --- |
+{{range .Components}}| {{cell .Name}} | {{cell .Image}} | {{cell (join .Ports ", ")}} | {{.CPU}} | {{.Memory}} | {{.Replicas}} |
+{{end}}
+{{- range .Components}}{{if or .Envs .Volumes}}
+### {{.Name}}
+{{if .Envs}}
+Environment variables that can be changed:
+
+| Name | Description | Default | Required |
+| --- | --- | --- | --- |
+{{range .Envs}}| {{cell .Name}} | {{cell .Description}} | {{cell .Default}} | {{if .Required}}yes{{else}}no{{end}} |
+{{end}}{{end}}{{if .Volumes}}
+Volumes:
+
+| Name | Path | Type | Capacity |
+| --- | --- | --- | --- |
+{{range .Volumes}}| {{cell .Name}} | {{cell .Path}} | {{.Type}} | {{.Capacity}} |
+{{end}}{{end}}{{end}}{{end}}
+{{- if .Routes}}
+## Ingress routes
+
+| Protocol | Entry | Component | Port |
+| --- | --- | --- | --- |
+{{range .Routes}}| {{.Kind}} | {{cell .Entry}} | {{cell .Component}} | {{.Port}} |
+{{end}}{{end}}
+{{- if .Topology}}
+## Topology
+
+` + "```" + `mermaid
+{{.Topology}}` + "```" + `
+{{end}}
+{{- if .Steps}}
+## Install
+{{range $i, $step := .Steps}}
+{{$step.Description}}
+{{if $step.Command}}
+` + "```" + `bash
+{{$step.Command}}
+` + "```" + `
+{{end}}{{end}}{{end}}`))
+
+var readmeHTML = htmltemplate.Must(htmltemplate.New("readme").Funcs(readmeFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.AppName}} {{.AppVersion}}</title>
+<style>
+body { font-family: sans-serif; max-width: 960px; margin: 2em auto; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+pre { background: #f4f4f4; padding: 8px; }
+</style>
+</head>
+<body>
+<h1>{{.AppName}} {{.AppVersion}}</h1>
+{{if .VersionInfo}}<p>{{.VersionInfo}}</p>
+{{end}}<p>{{.Format}} package of {{.AppName}} version {{.AppVersion}}, it has {{len .Components}} components.</p>
+<h2>Components</h2>
+<table>
+<tr><th>Component</th><th>Image</th><th>Ports</th><th>CPU</th><th>Memory</th><th>Replicas</th></tr>
+{{range .Components}}<tr><td>{{.Name}}</td><td>{{.Image}}</td><td>{{join .Ports ", "}}</td><td>{{.CPU}}</td><td>{{.Memory}}</td><td>{{.Replicas}}</td></tr>
+{{end}}</table>
+{{range .Components}}{{if or .Envs .Volumes}}<h3>{{.Name}}</h3>
+{{if .Envs}}<p>Environment variables that can be changed:</p>
+<table>
+<tr><th>Name</th><th>Description</th><th>Default</th><th>Required</th></tr>
+{{range .Envs}}<tr><td>{{.Name}}</td><td>{{.Description}}</td><td>{{.Default}}</td><td>{{if .Required}}yes{{else}}no{{end}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Volumes}}<p>Volumes:</p>
+<table>
+<tr><th>Name</th><th>Path</th><th>Type</th><th>Capacity</th></tr>
+{{range .Volumes}}<tr><td>{{.Name}}</td><td>{{.Path}}</td><td>{{.Type}}</td><td>{{.Capacity}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}{{end}}{{if .Routes}}<h2>Ingress routes</h2>
+<table>
+<tr><th>Protocol</th><th>Entry</th><th>Component</th><th>Port</th></tr>
+{{range .Routes}}<tr><td>{{.Kind}}</td><td>{{.Entry}}</td><td>{{.Component}}</td><td>{{.Port}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Topology}}<h2>Topology</h2>
+<pre class="mermaid">{{.Topology}}</pre>
+{{end}}{{if .Steps}}<h2>Install</h2>
+<ol>
+{{range .Steps}}<li><p>{{.Description}}</p>{{if .Command}}<pre><code>{{.Command}}</code></pre>{{end}}</li>
+{{end}}</ol>
+{{end}}</body>
+</html>
+`))
+
+// loadImagesStep the step loading the images saved in the package into docker, from the OCI
+// image layout if the images are stored in it
+func loadImagesStep(imageLayout bool) InstallStep {
+	if imageLayout {
+		return InstallStep{Description: "Load the images of the package, then tag and push them to the registry the cluster pulls from.", Command: "tar -C " + ImageLayoutDir + " -cf - . | docker load"}
+	}
+	return InstallStep{Description: "Load the images of the package, then tag and push them to the registry the cluster pulls from.", Command: "for f in *-images.tar; do docker load -i $f; done"}
+}
+
+// writeReadme write the README of the package, with the install steps of the format if it
+// tells them, the Mermaid flowchart of the app if it is drawn and its HTML rendering if asked for
+func writeReadme(exportPath string, ram v1alpha1.RainbondApplicationConfig, format AppFormat, renderer Renderer, flowchart string, html bool) error {
+	var steps []InstallStep
+	if guide, ok := renderer.(InstallGuide); ok {
+		steps = guide.InstallSteps()
+	}
+	doc := newReadme(ram, format, steps, flowchart)
+	var b bytes.Buffer
+	if err := readmeMarkdown.Execute(&b, doc); err != nil {
+		return fmt.Errorf("render readme failure %s", err.Error())
+	}
+	if err := ioutil.WriteFile(path.Join(exportPath, ReadmeFileName), b.Bytes(), 0644); err != nil {
+		return err
+	}
+	if !html {
+		return nil
+	}
+	b.Reset()
+	if err := readmeHTML.Execute(&b, doc); err != nil {
+		return fmt.Errorf("render html readme failure %s", err.Error())
+	}
+	return ioutil.WriteFile(path.Join(exportPath, ReadmeHTMLFileName), b.Bytes(), 0644)
+}
@@ -0,0 +1,75 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+func TestWriteReadme(t *testing.T) {
+	ram := newComposeTestTemplate()
+	ram.Annotations = map[string]string{"version_info": "first <release>"}
+	ram.Components[0].Envs = []v1alpha1.ComponentEnv{
+		{AttrName: "TITLE", Name: "title of the site", AttrValue: "demo", IsChange: true},
+		{AttrName: "API_KEY", Name: "key | token", IsChange: true},
+		{AttrName: "INTERNAL", AttrValue: "1"},
+	}
+	ram.Components[1].ServiceVolumeMapList = []v1alpha1.ComponentVolume{{VolumeName: "data", VolumeMountPath: "/data", VolumeType: v1alpha1.LocalVolumeType, VolumeCapacity: 10}}
+	ram.IngressHTTPRoutes = []*v1alpha1.IngressHTTPRoute{{Location: "/api", TargetComponent: v1alpha1.TargetComponent{ComponentKey: "web-key", Port: 8080}}}
+	exportPath := t.TempDir()
+	renderer := &dockerComposeExporter{mode: OfflineMode}
+	if err := writeReadme(exportPath, ram, DC, renderer, "flowchart LR\n    c0[\"web\"]\n", true); err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadFile(path.Join(exportPath, ReadmeFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readme := string(body)
+	for _, want := range []string{
+		"# demo 1.0\n\nfirst <release>\n",
+		"| web | registry.example.com/demo/web:v1 | 8080/http (external) | 500m | 512 MB | 1 |",
+		"| db | registry.example.com/demo/db:v1 | 8080/tcp (external) | unlimited | unlimited | 1 |",
+		"| TITLE | title of the site | demo | no |",
+		`| API_KEY | key \| token |  | yes |`,
+		"| data | /data | local | 10 GB |",
+		"| http | /api | web | 8080 |",
+		"## Topology\n\n```mermaid\nflowchart LR\n    c0[\"web\"]\n```\n",
+		"```bash\n./run.sh start\n```",
+	} {
+		if !strings.Contains(readme, want) {
+			t.Fatalf("expected %q in the readme, got\n%s", want, readme)
+		}
+	}
+	if strings.Contains(readme, "INTERNAL") {
+		t.Fatalf("expected the envs that can not be changed to be left out, got\n%s", readme)
+	}
+	html, err := ioutil.ReadFile(path.Join(exportPath, ReadmeHTMLFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), "<p>first &lt;release&gt;</p>") || !strings.Contains(string(html), "<pre><code>./run.sh start</code></pre>") {
+		t.Fatalf("unexpected html readme\n%s", html)
+	}
+}
@@ -0,0 +1,341 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	refdocker "github.com/containerd/containerd/reference/docker"
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
+	"github.com/goodrain/rainbond-oam/pkg/util/ocilayout"
+	"github.com/sirupsen/logrus"
+)
+
+// ReportFileName the export report at the root of every package
+const ReportFileName = "report.json"
+
+// ExportReport describes what was exported. The copy in the package is written before
+// the package is sealed, so it has no package size and no seal or archive phase, the
+// complete report is returned in the Result. Plan returns the same report describing
+// the export before it runs.
+type ExportReport struct {
+	Format     AppFormat `json:"format"`
+	AppName    string    `json:"app_name"`
+	AppVersion string    `json:"app_version"`
+	Mode       string    `json:"mode"`
+	// TemplateFingerprint the same as the one of the package manifest
+	TemplateFingerprint string        `json:"template_fingerprint"`
+	Images              []ReportImage `json:"images"`
+	// Platforms the platforms of the images saved in the package
+	Platforms []string `json:"platforms,omitempty"`
+	// DryRun the report is the plan of the export, nothing is pulled or written
+	DryRun bool `json:"dry_run,omitempty"`
+	// PackagePath path of the package, the first part if it is split
+	PackagePath string `json:"package_path,omitempty"`
+	// Files the files rendered into the package, only listed by the plan
+	Files []string `json:"files,omitempty"`
+	// PackageSize total size of the package, the sum of the parts if it is split
+	PackageSize int64 `json:"package_size,omitempty"`
+	// Parts file names of the parts if the package is split
+	Parts     []string      `json:"parts,omitempty"`
+	Phases    []ReportPhase `json:"phases"`
+	Warnings  []string      `json:"warnings,omitempty"`
+	StartedAt time.Time     `json:"started_at"`
+	// sboms the SBOM documents written for kind/name
+	sboms map[string]string
+	// extraImages images used by the package other than the component and plugin images
+	extraImages []ReportImage
+	// cached the images taken from the image cache
+	cached map[string]bool
+}
+
+// ReportImage an image used by a component, plugin or the gateway of the app
+type ReportImage struct {
+	// Kind component, plugin or gateway
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	// Digest manifest digest, known if the image is pinned or stored in an image layout
+	Digest string `json:"digest,omitempty"`
+	// ID config digest of the image saved in the package
+	ID string `json:"id,omitempty"`
+	// Size size of the config and layers of the image saved in the package
+	Size int64 `json:"size,omitempty"`
+	// SBOM path of the SBOM document of the image in the package
+	SBOM string `json:"sbom,omitempty"`
+	// Cached the image is taken from the image cache instead of pulled
+	Cached bool `json:"cached,omitempty"`
+}
+
+// ReportPhase the duration of a phase of the export
+type ReportPhase struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+func newReport(format AppFormat, ram v1alpha1.RainbondApplicationConfig, mode string) *ExportReport {
+	return &ExportReport{
+		Format:     format,
+		AppName:    ram.AppName,
+		AppVersion: ram.AppVersion,
+		Mode:       mode,
+		StartedAt:  time.Now().UTC(),
+	}
+}
+
+// phase start timing the phase, the returned func is called when the phase is done
+func (r *ExportReport) phase(name string) func() {
+	start := time.Now()
+	return func() {
+		r.Phases = append(r.Phases, ReportPhase{Name: name, Seconds: time.Since(start).Seconds()})
+	}
+}
+
+// warn log the warning and keep it in the report, it is only logged if the report is nil
+func (r *ExportReport) warn(logger *logrus.Logger, format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	logger.Warning(warning)
+	if r != nil {
+		r.Warnings = append(r.Warnings, warning)
+	}
+}
+
+// addImage add the image used by kind/name, images saved in the package get their digests and sizes
+func (r *ExportReport) addImage(kind, name, image string, saved map[string]savedImage) {
+	if image == "" {
+		return
+	}
+	item := ReportImage{Kind: kind, Name: name, Image: image, SBOM: r.sboms[kind+"/"+name], Cached: r.cached[image]}
+	if named, err := refdocker.ParseDockerRef(image); err == nil {
+		if digested, ok := named.(refdocker.Digested); ok {
+			item.Digest = digested.Digest().String()
+		}
+		if s, ok := saved[named.String()]; ok {
+			item.ID, item.Size = s.id, s.size
+			if item.Digest == "" {
+				item.Digest = s.digest
+			}
+		}
+	}
+	r.Images = append(r.Images, item)
+}
+
+// cacheHits mark the images taken from the image cache
+func (r *ExportReport) cacheHits(images []string) {
+	r.cached = make(map[string]bool)
+	for _, image := range images {
+		r.cached[image] = true
+	}
+}
+
+// addExtraImage list an image used by the package other than the component and plugin images,
+// e.g. the gateway of docker compose packages
+func (r *ExportReport) addExtraImage(kind, name, image string) {
+	if r == nil {
+		return
+	}
+	r.extraImages = append(r.extraImages, ReportImage{Kind: kind, Name: name, Image: image})
+}
+
+// write fingerprint the app, collect the images of the components, plugins and the extra images
+// and write the report into the package, it is called right before the package is sealed
+func (r *ExportReport) write(exportPath string, ram v1alpha1.RainbondApplicationConfig) error {
+	fingerprint, err := TemplateFingerprint(ram)
+	if err != nil {
+		return fmt.Errorf("fingerprint app template failure %s", err.Error())
+	}
+	r.TemplateFingerprint = fingerprint
+	saved, err := inspectSavedImages(exportPath)
+	if err != nil {
+		return fmt.Errorf("inspect saved images failure %s", err.Error())
+	}
+	r.addImages(ram, saved)
+	return r.WriteFile(path.Join(exportPath, ReportFileName))
+}
+
+// addImages add the images of the components, plugins and the extra images
+func (r *ExportReport) addImages(ram v1alpha1.RainbondApplicationConfig, saved map[string]savedImage) {
+	for _, component := range ram.Components {
+		r.addImage("component", component.ServiceCname, component.ShareImage, saved)
+	}
+	for _, plugin := range ram.Plugins {
+		r.addImage("plugin", plugin.PluginName, plugin.ShareImage, saved)
+	}
+	for _, image := range r.extraImages {
+		r.addImage(image.Kind, image.Name, image.Image, saved)
+	}
+}
+
+// result complete the report with the package in homePath and returns the export result
+func (r *ExportReport) result(homePath, name string, format archive.Format) (*Result, error) {
+	packagePath := path.Join(homePath, name)
+	indexFile, err := archive.FindPartIndex(packagePath)
+	if err != nil {
+		return nil, err
+	}
+	if indexFile != "" {
+		index, err := archive.ReadPartIndex(indexFile)
+		if err != nil {
+			return nil, err
+		}
+		r.PackageSize = index.Size
+		for _, part := range index.Parts {
+			r.Parts = append(r.Parts, part.Name)
+		}
+	} else {
+		info, err := os.Stat(packagePath)
+		if err != nil {
+			return nil, err
+		}
+		r.PackageSize = info.Size()
+	}
+	r.PackagePath = packagePath
+	return &Result{PackagePath: packagePath, PackageName: name, PackageFormat: string(format), Report: r}, nil
+}
+
+// WriteFile write the report as json, e.g. next to the package in a release pipeline
+func (r *ExportReport) WriteFile(file string) error {
+	body, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, body, 0644)
+}
+
+// savedImage an image saved in the package, keyed by its normalized name
+type savedImage struct {
+	id     string
+	digest string
+	size   int64
+}
+
+// inspectSavedImages read the image tarballs and the image layout in exportPath
+func inspectSavedImages(exportPath string) (map[string]savedImage, error) {
+	saved := make(map[string]savedImage)
+	err := filepath.Walk(exportPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if !ocilayout.IsLayout(file) {
+				return nil
+			}
+			if err := inspectImageLayout(file, saved); err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() && strings.HasSuffix(file, ".tar") {
+			return inspectImageTarball(file, saved)
+		}
+		return nil
+	})
+	return saved, err
+}
+
+func inspectImageLayout(dir string, saved map[string]savedImage) error {
+	layout, err := ocilayout.Open(dir)
+	if err != nil {
+		return err
+	}
+	images, err := layout.Images()
+	if err != nil {
+		return err
+	}
+	for _, img := range images {
+		manifest, err := layout.Manifest(img.Name)
+		if err != nil {
+			return fmt.Errorf("read manifest of image %s failure %s", img.Name, err.Error())
+		}
+		size := manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			size += layer.Size
+		}
+		addSavedImage(saved, img.Name, savedImage{id: manifest.Config.Digest.String(), digest: img.Descriptor.Digest.String(), size: size})
+	}
+	return nil
+}
+
+// inspectImageTarball read the manifest.json of a docker save or containerd export tarball,
+// other tarballs are ignored
+func inspectImageTarball(file string, saved map[string]savedImage) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sizes := make(map[string]int64)
+	var items []dockerArchiveManifest
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// not a tarball
+			return nil
+		}
+		sizes[hdr.Name] = hdr.Size
+		if hdr.Name == "manifest.json" {
+			body, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(body, &items); err != nil {
+				return nil
+			}
+		}
+	}
+	for _, item := range items {
+		size := sizes[item.Config]
+		for _, layer := range item.Layers {
+			size += sizes[layer]
+		}
+		// docker names the config <hex>.json, containerd blobs/sha256/<hex>
+		id := "sha256:" + strings.TrimSuffix(path.Base(item.Config), ".json")
+		for _, tag := range item.RepoTags {
+			addSavedImage(saved, tag, savedImage{id: id, size: size})
+		}
+	}
+	return nil
+}
+
+func addSavedImage(saved map[string]savedImage, name string, image savedImage) {
+	saved[normalizeImageName(name)] = image
+}
+
+// normalizeImageName returns the fully qualified name of the image, e.g. docker.io/library/nginx:latest
+// for nginx, the names saved by docker and containerd are compared by it
+func normalizeImageName(name string) string {
+	if named, err := refdocker.ParseDockerRef(name); err == nil {
+		return named.String()
+	}
+	return name
+}
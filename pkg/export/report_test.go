@@ -0,0 +1,117 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/sirupsen/logrus"
+)
+
+func TestExportReport(t *testing.T) {
+	fakeResolveImageDigest(t)
+	home := t.TempDir()
+	exporter, err := newExporter(RAM, home, newOnlineTestTemplate(), nil, logrus.StandardLogger(), newOptions(WithOnlineMode(false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportPath := path.Join(home, "demo-1.0-ram")
+	result, err := exporter.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Report
+	if result.PackageFormat != "tar.gz" || report == nil {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	info, err := os.Stat(result.PackagePath)
+	if err != nil || report.PackageSize != info.Size() {
+		t.Fatalf("expected package size %v, got %d", info, report.PackageSize)
+	}
+	manifest, err := ReadManifest(exportPath)
+	if err != nil || report.TemplateFingerprint != manifest.TemplateFingerprint {
+		t.Fatalf("expected the fingerprint of the manifest, got %s %v", report.TemplateFingerprint, err)
+	}
+	if len(report.Images) != 2 || report.Images[0].Kind != "component" || report.Images[0].Digest != testDigest {
+		t.Fatalf("unexpected images %+v", report.Images)
+	}
+	var phases []string
+	for _, phase := range report.Phases {
+		phases = append(phases, phase.Name)
+	}
+	if len(phases) != 4 || phases[0] != "pin images" || phases[1] != "render" || phases[3] != "archive package" {
+		t.Fatalf("unexpected phases %v", phases)
+	}
+	// the copy in the package is sealed with the other files
+	body, err := ioutil.ReadFile(path.Join(exportPath, ReportFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packaged ExportReport
+	if err := json.Unmarshal(body, &packaged); err != nil || packaged.TemplateFingerprint != report.TemplateFingerprint {
+		t.Fatalf("unexpected report in package %s %v", body, err)
+	}
+	if report := manifest.Verify(exportPath); !report.OK() {
+		t.Fatal(report.Error())
+	}
+}
+
+func TestInspectSavedImages(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Create(path.Join(dir, "component-images.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	manifest := `[{"Config":"abc.json","RepoTags":["nginx:1.21"],"Layers":["l1/layer.tar"]}]`
+	for name, body := range map[string]string{"manifest.json": manifest, "abc.json": "{}", "l1/layer.tar": "layer"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(body))
+	}
+	tw.Close()
+	f.Close()
+	saved, err := inspectSavedImages(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := &ExportReport{}
+	report.addImage("component", "web", "docker.io/library/nginx:1.21", saved)
+	if image := report.Images[0]; image.ID != "sha256:abc" || image.Size != 7 {
+		t.Fatalf("unexpected image %+v", image)
+	}
+}
+
+func TestComposeReportsDroppedVolumes(t *testing.T) {
+	ram := newComposeTestTemplate()
+	ram.Components[0].MntReleationList = []v1alpha1.ComponentShareVolume{{VolumeName: "data", VolumeMountDir: "/data", ShareServiceUUID: "db-share"}}
+	report := &ExportReport{}
+	d := &dockerComposeExporter{logger: logrus.StandardLogger(), ram: ram, exportPath: t.TempDir(), report: report}
+	if err := d.buildDockerComposeYaml(); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Warnings) == 0 || report.Warnings[0] != "dependent volume db-share/data of component web not found, drop it" {
+		t.Fatalf("expected the dropped volume to be reported, got %v", report.Warnings)
+	}
+}
@@ -0,0 +1,108 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/sbom"
+	"github.com/sirupsen/logrus"
+)
+
+// SBOMDir the SBOM documents of the component and plugin images in the package
+const SBOMDir = "sbom"
+
+// writeSBOMs write the SBOM document of every component and plugin image saved in the
+// image tarballs into the sbom dir, e.g. sbom/component-web.spdx.json. It must be called
+// before the images are stored in the image layout.
+func writeSBOMs(exportPath string, ram v1alpha1.RainbondApplicationConfig, format sbom.Format, report *ExportReport, logger *logrus.Logger) error {
+	inventories := make(map[string]*sbom.Inventory)
+	for _, name := range []string{"component-images.tar", "plugin-images.tar"} {
+		tarball := path.Join(exportPath, name)
+		if _, err := os.Stat(tarball); os.IsNotExist(err) {
+			continue
+		}
+		found, err := sbom.ScanImageArchive(tarball)
+		if err != nil {
+			return fmt.Errorf("scan images of %s failure %s", name, err.Error())
+		}
+		for _, inv := range found {
+			for _, tag := range inv.Tags {
+				inventories[normalizeImageName(tag)] = inv
+			}
+		}
+	}
+	if err := os.MkdirAll(path.Join(exportPath, SBOMDir), 0755); err != nil {
+		return err
+	}
+	report.sboms = make(map[string]string)
+	created := time.Now()
+	used := make(map[string]struct{})
+	write := func(kind, name, image string) error {
+		if image == "" {
+			return nil
+		}
+		inv, ok := inventories[normalizeImageName(image)]
+		if !ok {
+			// plugins not used by the components are not saved by every exporter
+			if kind == "component" {
+				report.warn(logger, "image %s of %s %s is not saved, no sbom is written", image, kind, name)
+			}
+			return nil
+		}
+		for _, warning := range inv.Warnings {
+			report.warn(logger, "sbom of %s %s: %s", kind, name, warning)
+		}
+		fileName := kind + "-" + composeName(name)
+		for i := 2; ; i++ {
+			if _, ok := used[fileName]; !ok {
+				break
+			}
+			fileName = fmt.Sprintf("%s-%s-%d", kind, composeName(name), i)
+		}
+		used[fileName] = struct{}{}
+		file := path.Join(SBOMDir, fileName+format.Ext())
+		f, err := os.Create(path.Join(exportPath, file))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := inv.Encode(f, format, created); err != nil {
+			return fmt.Errorf("write sbom of %s %s failure %s", kind, name, err.Error())
+		}
+		report.sboms[kind+"/"+name] = file
+		logger.Infof("write sbom of %s %s with %d packages", kind, name, len(inv.Packages))
+		return nil
+	}
+	for _, component := range ram.Components {
+		if err := write("component", component.ServiceCname, component.ShareImage); err != nil {
+			return err
+		}
+	}
+	for _, plugin := range ram.Plugins {
+		if err := write("plugin", plugin.PluginName, plugin.ShareImage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/sbom"
+	"github.com/sirupsen/logrus"
+)
+
+func TestWriteSBOMs(t *testing.T) {
+	exportPath := t.TempDir()
+	var layer bytes.Buffer
+	lw := tar.NewWriter(&layer)
+	installed := "P:musl\nV:1.2.3-r0\nA:x86_64\n"
+	lw.WriteHeader(&tar.Header{Name: "lib/apk/db/installed", Mode: 0644, Size: int64(len(installed)), Typeflag: tar.TypeReg})
+	lw.Write([]byte(installed))
+	lw.Close()
+	f, err := os.Create(path.Join(exportPath, "component-images.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	for name, body := range map[string][]byte{
+		"manifest.json": []byte(`[{"Config":"abc.json","RepoTags":["registry.example.com/demo/web:v1"],"Layers":["l1/layer.tar"]}]`),
+		"abc.json":      []byte("{}"),
+		"l1/layer.tar":  layer.Bytes(),
+	} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg})
+		tw.Write(body)
+	}
+	tw.Close()
+	f.Close()
+	ram := newComposeTestTemplate()
+	report := &ExportReport{}
+	if err := writeSBOMs(exportPath, ram, sbom.CycloneDX, report, logrus.StandardLogger()); err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadFile(path.Join(exportPath, SBOMDir, "component-web.cdx.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Components []struct {
+			PURL string `json:"purl"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil || len(doc.Components) != 1 || doc.Components[0].PURL != "pkg:apk/musl@1.2.3-r0?arch=x86_64" {
+		t.Fatalf("unexpected sbom %s %v", body, err)
+	}
+	// the db image is not saved
+	if len(report.Warnings) != 1 {
+		t.Fatalf("expected the missing image to be reported, got %v", report.Warnings)
+	}
+	report.addImage("component", "web", ram.Components[0].ShareImage, nil)
+	if report.Images[0].SBOM != "sbom/component-web.cdx.json" {
+		t.Fatalf("expected the sbom in the report, got %+v", report.Images[0])
+	}
+}
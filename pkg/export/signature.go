@@ -0,0 +1,133 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SignatureFileName detached signature of the manifest. The manifest lists the checksum
+// of every file, so the signature covers the whole package. The content is the base64
+// signature, ecdsa and rsa signatures can be checked with
+// `cosign verify-blob --key cosign.pub --signature manifest.json.sig manifest.json`.
+const SignatureFileName = ManifestFileName + ".sig"
+
+var (
+	// ErrPackageUnsigned the package carries no signature while a trust policy is configured
+	ErrPackageUnsigned = errors.New("package is not signed")
+	// ErrPackageUntrusted the signature is not made by any trusted key
+	ErrPackageUntrusted = errors.New("package signature is not made by a trusted key")
+)
+
+// SignManifest sign the manifest under exportPath with the key supplied by the caller,
+// ed25519 keys sign the manifest itself, ecdsa and rsa keys sign its sha256 digest as cosign does
+func SignManifest(exportPath string, signer crypto.Signer) error {
+	manifest, err := ioutil.ReadFile(filepath.Join(exportPath, ManifestFileName))
+	if err != nil {
+		return err
+	}
+	var sig []byte
+	switch signer.Public().(type) {
+	case ed25519.PublicKey:
+		sig, err = signer.Sign(rand.Reader, manifest, crypto.Hash(0))
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		digest := sha256.Sum256(manifest)
+		sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	default:
+		return fmt.Errorf("not support signing key %T", signer.Public())
+	}
+	if err != nil {
+		return fmt.Errorf("sign package manifest failure %s", err.Error())
+	}
+	return ioutil.WriteFile(filepath.Join(exportPath, SignatureFileName), []byte(base64.StdEncoding.EncodeToString(sig)), 0644)
+}
+
+// TrustPolicy public keys trusted to sign packages, a package must be signed by one of them
+type TrustPolicy struct {
+	PublicKeys []crypto.PublicKey
+}
+
+// ParsePublicKey parse a PEM encoded PKIX public key, e.g. cosign.pub or the output of
+// `openssl pkey -pubout`, ed25519, ecdsa and rsa keys are supported
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM public key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key failure %s", err.Error())
+	}
+	return key, nil
+}
+
+// VerifyManifestSignature verify the signature of the manifest under packagePath against the policy
+func (t *TrustPolicy) VerifyManifestSignature(packagePath string) error {
+	manifest, err := ioutil.ReadFile(filepath.Join(packagePath, ManifestFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrPackageUnsigned
+		}
+		return err
+	}
+	encoded, err := ioutil.ReadFile(filepath.Join(packagePath, SignatureFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrPackageUnsigned
+		}
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return fmt.Errorf("decode package signature failure %s", err.Error())
+	}
+	for _, key := range t.PublicKeys {
+		if verifySignature(key, manifest, sig) {
+			return nil
+		}
+	}
+	return ErrPackageUntrusted
+}
+
+func verifySignature(key crypto.PublicKey, message, sig []byte) bool {
+	digest := sha256.Sum256(message)
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, message, sig)
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digest[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func newSignedTestPackage(t *testing.T, signer crypto.Signer) string {
+	t.Helper()
+	exportPath := t.TempDir()
+	if err := ioutil.WriteFile(path.Join(exportPath, "metadata.json"), []byte(`{"app_name":"demo"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sealPackage(exportPath, RAM, OfflineMode, nil, nil, newComposeTestTemplate(), signer, ""); err != nil {
+		t.Fatal(err)
+	}
+	return exportPath
+}
+
+func TestSignManifestWithTrustedKeys(t *testing.T) {
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, signer := range map[string]crypto.Signer{"ed25519": edKey, "ecdsa": ecKey} {
+		t.Run(name, func(t *testing.T) {
+			exportPath := newSignedTestPackage(t, signer)
+			policy := &TrustPolicy{PublicKeys: []crypto.PublicKey{edPub, &ecKey.PublicKey}}
+			if err := policy.VerifyManifestSignature(exportPath); err != nil {
+				t.Fatalf("expected trusted signature, got %v", err)
+			}
+			// any change of the manifest breaks the signature
+			if err := ioutil.WriteFile(path.Join(exportPath, ManifestFileName), []byte("{}"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := policy.VerifyManifestSignature(exportPath); err != ErrPackageUntrusted {
+				t.Fatalf("expected tampered manifest to be untrusted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyManifestSignatureRejectsUnsignedAndUntrusted(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	policy := &TrustPolicy{PublicKeys: []crypto.PublicKey{otherPub}}
+	if err := policy.VerifyManifestSignature(newSignedTestPackage(t, nil)); err != ErrPackageUnsigned {
+		t.Fatalf("expected unsigned package to be rejected, got %v", err)
+	}
+	if err := policy.VerifyManifestSignature(newSignedTestPackage(t, key)); err != ErrPackageUntrusted {
+		t.Fatalf("expected package signed by unknown key to be rejected, got %v", err)
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecKey.PublicKey.Equal(key) {
+		t.Fatal("expected the parsed key to equal the original key")
+	}
+	if _, err := ParsePublicKey([]byte("not a key")); err == nil {
+		t.Fatal("expected invalid key to fail")
+	}
+}
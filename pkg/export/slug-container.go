@@ -0,0 +1,237 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util"
+	"github.com/goodrain/rainbond-oam/pkg/util/ocilayout"
+)
+
+// exportImageComponent write the image of the component which is not built from source code
+// out of the layout of the saved component images, the component runs as a container in host
+// network next to the slug processes.
+func (s *slugExporter) exportImageComponent(component *v1alpha1.Component, layout *ocilayout.Layout) error {
+	componentPath := path.Join(s.exportPath, component.ServiceCname)
+	if err := os.MkdirAll(componentPath, 0755); err != nil {
+		return err
+	}
+	imageTar := path.Join(s.exportPath, slugImageFileName(component.ServiceCname))
+	if err := writeImageArchive(layout, component.ShareImage, imageTar); err != nil {
+		s.logger.Errorf("write image of component %s failure %s", component.ServiceCname, err.Error())
+		return err
+	}
+	var volumes []string
+	for _, volume := range component.ServiceVolumeMapList {
+		if volume.VolumeType == v1alpha1.ConfigFileVolumeType {
+			if err := exportComponentConfigFile(path.Join(s.exportPath, slugConfigDir(component.ServiceCname)), volume); err != nil {
+				return err
+			}
+			volumes = append(volumes, fmt.Sprintf("${HOME}/config%s:%s", volume.VolumeMountPath, volume.VolumeMountPath))
+			continue
+		}
+		volumes = append(volumes, fmt.Sprintf("%s:%s", containerVolumeName(s.ram.AppName, component.ServiceCname, volume.VolumeName), volume.VolumeMountPath))
+	}
+	for _, share := range component.MntReleationList {
+		for _, owner := range s.ram.Components {
+			if owner.ServiceShareID != share.ShareServiceUUID {
+				continue
+			}
+			volumes = append(volumes, fmt.Sprintf("%s:%s", containerVolumeName(s.ram.AppName, owner.ServiceCname, share.VolumeName), share.VolumeMountDir))
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(s.exportPath, slugEnvFileName(component.ServiceCname)), []byte(containerEnvFile(s.ram, component)), 0644); err != nil {
+		return err
+	}
+	script := containerRunScript
+	script = strings.Replace(script, "@CONTAINER_NAME@", strings.ToLower(composeName(fmt.Sprintf("%s-%s", s.ram.AppName, component.ServiceCname))), 1)
+	script = strings.Replace(script, "@IMAGE@", component.ShareImage, 1)
+	script = strings.Replace(script, "@VOLUMES@", strings.Join(volumes, " "), 1)
+	script = strings.Replace(script, "@CMD@", strings.Replace(component.Cmd, `"`, `\"`, -1), 1)
+	return ioutil.WriteFile(path.Join(s.exportPath, slugScriptName(component.ServiceCname)), []byte(script), 0755)
+}
+
+// writeImageArchive write the image of the layout into a tarball docker load and containerd import
+// accept, the blobs are linked into a staging layout so the image is not saved again
+func writeImageArchive(layout *ocilayout.Layout, name, tarball string) error {
+	staging, err := ioutil.TempDir(path.Dir(layout.Root()), ".image")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	single, err := ocilayout.Create(staging)
+	if err != nil {
+		return err
+	}
+	if _, err := single.CopyImage(layout, name, name); err != nil {
+		return err
+	}
+	return single.WriteArchive(tarball)
+}
+
+// buildSystemdContainerUnit build the unit runs the container of the image component in foreground
+func buildSystemdContainerUnit(ram v1alpha1.RainbondApplicationConfig, com *v1alpha1.Component, units map[string]string) string {
+	unit := buildSystemdUnit(ram, com, "", units)
+	var lines []string
+	for _, line := range strings.Split(unit, "\n") {
+		switch {
+		case strings.HasPrefix(line, "EnvironmentFile="), strings.HasPrefix(line, "ExecStartPre="):
+			continue
+		case strings.HasPrefix(line, "ExecStart="):
+			line = fmt.Sprintf("ExecStart=/bin/bash %s.sh run", com.ServiceCname)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func containerVolumeName(appName, componentName, volumeName string) string {
+	return strings.ToLower(composeName(fmt.Sprintf("%s_%s_%s", appName, componentName, volumeName)))
+}
+
+// containerEnvFile build env file in docker --env-file format, values are not quoted
+func containerEnvFile(ram v1alpha1.RainbondApplicationConfig, component *v1alpha1.Component) string {
+	var b strings.Builder
+	if len(component.Ports) > 0 {
+		fmt.Fprintf(&b, "PORT=%d\n", component.Ports[0].ContainerPort)
+	}
+	// a new slice, appending to component.Envs may write into the template of the caller
+	envs := append(append([]v1alpha1.ComponentEnv(nil), component.Envs...), component.ServiceConnectInfoMapList...)
+	for _, env := range envs {
+		fmt.Fprintf(&b, "%s=%s\n", env.AttrName, env.AttrValue)
+	}
+	for _, group := range ram.AppConfigGroups {
+		for _, key := range group.ComponentKeys {
+			if key != component.ComponentKey {
+				continue
+			}
+			for _, k := range util.SortedKeys(group.ConfigItems) {
+				fmt.Fprintf(&b, "%s=%s\n", k, group.ConfigItems[k])
+			}
+		}
+	}
+	for _, dep := range component.DepServiceMapList {
+		depEnvs := getPublicEnvByKey(dep.DepServiceKey, ram.Components)
+		for _, k := range util.SortedKeys(depEnvs) {
+			fmt.Fprintf(&b, "%s=%s\n", k, depEnvs[k])
+		}
+	}
+	return b.String()
+}
+
+var containerRunScript = `#!/bin/bash
+###
+### app.sh — Controls the container of the image component.
+###
+### Usage:
+###   app.sh <Options>
+###
+### Options:
+###   start   Start the container in background.
+###   run     Run the container in foreground.
+###   stop    Stop the container.
+###   status  Show container status.
+###   -h      Show this message.
+
+[ $DEBUG ] && set -x
+
+cd $(dirname $0)
+HOME=$(pwd)
+APPNAME=$(basename ${HOME})
+CONTAINER=@CONTAINER_NAME@
+IMAGE=@IMAGE@
+VOLUMES="@VOLUMES@"
+CMD="@CMD@"
+
+RUNTIME=docker
+which docker >/dev/null 2>&1 || RUNTIME=nerdctl
+
+function loadImage() {
+    ${RUNTIME} image inspect ${IMAGE} >/dev/null 2>&1 && return 0
+    ${RUNTIME} load -i ${APPNAME}-image.tar
+}
+
+function runArgs() {
+    ARGS="--name ${CONTAINER} --network host --env-file ${APPNAME}.env"
+    for volume in ${VOLUMES}; do
+        ARGS="${ARGS} -v $(eval echo ${volume})"
+    done
+    echo ${ARGS}
+}
+
+function appStart() {
+    appStatus >/dev/null 2>&1 && echo "App ${APPNAME} is already running" && exit 1
+    loadImage || exit 1
+    ${RUNTIME} rm -f ${CONTAINER} >/dev/null 2>&1
+    ${RUNTIME} run -d --restart unless-stopped $(runArgs) ${IMAGE} ${CMD} >/dev/null && appStatus
+}
+
+function appRun() {
+    loadImage || exit 1
+    ${RUNTIME} rm -f ${CONTAINER} >/dev/null 2>&1
+    exec ${RUNTIME} run --rm $(runArgs) ${IMAGE} ${CMD}
+}
+
+function appStop() {
+    ${RUNTIME} stop ${CONTAINER} >/dev/null 2>&1 && ${RUNTIME} rm ${CONTAINER} >/dev/null 2>&1
+    echo "Stopping app ${APPNAME} ... Done"
+}
+
+function appStatus() {
+    STATUS=$(${RUNTIME} inspect -f '{{.State.Status}}' ${CONTAINER} 2>/dev/null)
+    if [ "$STATUS" == "running" ]; then
+        printf "%-30s %-30s %-10s\n" AppName Status PID
+        printf "%-30s \e[1;32m%-30s\e[m %-30s\n" ${APPNAME} "Active(Running)" ${CONTAINER}
+        return 0
+    else
+        printf "%-30s %-30s %-30s\n" AppName Status PID
+        printf "%-30s \e[1;31m%-30s\e[m %-30s\n" "${APPNAME}" "Inactive(Exited)" "N/A"
+        return 1
+    fi
+}
+
+function showHelp() {
+    sed -rn -e "s/^### ?//p" $0 | sed "s#app.sh#${0}#g"
+}
+
+case $1 in
+start)
+    appStart
+    ;;
+run)
+    appRun
+    ;;
+stop)
+    appStop
+    ;;
+status)
+    appStatus
+    ;;
+*)
+    showHelp
+    exit 1
+    ;;
+esac
+`
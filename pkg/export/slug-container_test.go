@@ -0,0 +1,123 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/ocilayout"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSortComponentsByDependency(t *testing.T) {
+	ram := newComposeTestTemplate()
+	sorted := SortComponentsByDependency(ram.Components)
+	if len(sorted) != 2 || sorted[0].ServiceCname != "db" || sorted[1].ServiceCname != "web" {
+		t.Fatalf("expected db to be started before web, got %s %s", sorted[0].ServiceCname, sorted[1].ServiceCname)
+	}
+}
+
+func TestWriteAppScriptStartsInDependencyOrder(t *testing.T) {
+	ram := newComposeTestTemplate()
+	s := &slugExporter{logger: logrus.StandardLogger(), ram: ram}
+	appPath := t.TempDir()
+	if err := s.writeAppScript(appPath, "demo", ram.Components); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path.Join(appPath, "demo.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := string(content)
+	if !strings.Contains(script, "APPS=\"db web\"") || !strings.Contains(script, "REVERSE_APPS=\"web db\"") {
+		t.Fatalf("expected apps in dependency order, got\n%s", script)
+	}
+	if !strings.Contains(script, "for app in ${REVERSE_APPS}; do\n        pushd $app >/dev/null 2>&1\n        ./$app.sh stop") {
+		t.Fatalf("expected apps to be stopped in reverse order")
+	}
+}
+
+func TestContainerEnvFileIncludesDependencyEnvs(t *testing.T) {
+	ram := newComposeTestTemplate()
+	env := containerEnvFile(ram, ram.Components[0])
+	if !strings.HasPrefix(env, "PORT=8080\n") {
+		t.Fatalf("expected PORT env first, got %q", env)
+	}
+	if strings.Contains(env, "\"") {
+		t.Fatalf("env file values must not be quoted, got %q", env)
+	}
+}
+
+func TestContainerEnvFileKeepsTheEnvsOfTheTemplate(t *testing.T) {
+	ram := newComposeTestTemplate()
+	envs := make([]v1alpha1.ComponentEnv, 1, 4)
+	envs[0] = v1alpha1.ComponentEnv{AttrName: "MODE", AttrValue: "prod"}
+	ram.Components[1].Envs = envs
+	ram.Components[1].ServiceConnectInfoMapList = []v1alpha1.ComponentEnv{{AttrName: "DB_HOST", AttrValue: "127.0.0.1"}}
+	if env := containerEnvFile(ram, ram.Components[1]); !strings.Contains(env, "MODE=prod\nDB_HOST=127.0.0.1\n") {
+		t.Fatalf("expected the envs and the connect info, got %q", env)
+	}
+	if spare := envs[:2][1]; spare.AttrName != "" {
+		t.Fatalf("expected the envs of the template not to be written, got %+v", spare)
+	}
+}
+
+func TestBuildSystemdContainerUnitRunsScript(t *testing.T) {
+	ram := newComposeTestTemplate()
+	units := map[string]string{"db-key": "demo-db.service", "web-key": "demo-web.service"}
+	unit := buildSystemdContainerUnit(ram, ram.Components[0], units)
+	if !strings.Contains(unit, "ExecStart=/bin/bash web.sh run\n") {
+		t.Fatalf("expected unit to run the container script, got\n%s", unit)
+	}
+	if strings.Contains(unit, "EnvironmentFile=") || strings.Contains(unit, "ExecStartPre=") {
+		t.Fatalf("expected slug specific lines to be dropped, got\n%s", unit)
+	}
+	if !strings.Contains(unit, "Requires=demo-db.service") {
+		t.Fatalf("expected dependency on db unit, got\n%s", unit)
+	}
+}
+
+func TestSlugExportSavesImageComponentsOnce(t *testing.T) {
+	ram := newComposeTestTemplate()
+	ram.Components = ram.Components[:1]
+	file, _ := writeTestDisk(t, "web.layer", "web layer")
+	client := &diskImageClient{disks: map[string]string{ram.Components[0].ShareImage: file}}
+	p, err := newPipeline(SLG, t.TempDir(), ram, client, logrus.StandardLogger(), newOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Export(); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.saves) != 1 {
+		t.Fatalf("expected the image to be saved once, got %v", client.saves)
+	}
+	layout, err := ocilayout.Create(path.Join(t.TempDir(), "images"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := layout.AddArchive(path.Join(p.ctx.ExportPath, "web", "web-image.tar"))
+	if err != nil || len(names) != 1 || names[0] != ram.Components[0].ShareImage {
+		t.Fatalf("expected the image of web in its tarball, got %v %v", names, err)
+	}
+}
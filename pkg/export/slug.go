@@ -19,11 +19,12 @@
 package export
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
 	"github.com/goodrain/rainbond-oam/pkg/util"
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
 	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/ocilayout"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
@@ -33,126 +34,243 @@ import (
 
 const sourceCode = "source_code"
 
+func init() {
+	// the slugs are read from the saved images, slug packages are always offline
+	Register(SLG, Capabilities{NeedsImages: true, PackageKind: "slug"}, newSlugExporter)
+}
+
 type slugExporter struct {
 	logger      *logrus.Logger
 	ram         v1alpha1.RainbondApplicationConfig
 	imageClient image.Client
+	pull        image.PullOptions
 	mode        string
-	homePath    string
-	exportPath  string
-}
-
-func (s *slugExporter) Export() (*Result, error) {
-	s.logger.Infof("start export app %s to ram app spec", s.ram.AppName)
-	// Delete the old application group directory and then regenerate the application package
-	if err := PrepareExportDir(s.exportPath); err != nil {
-		s.logger.Errorf("prepare export dir failure %s", err.Error())
-		return nil, err
-	}
-	s.logger.Infof("success prepare export dir")
-	if s.mode == "offline" {
-		// Save components attachments
-		if err := SaveComponents(s.ram, s.imageClient, s.exportPath, s.logger, []string{}); err != nil {
-			return nil, err
-		}
-		s.logger.Infof("success save components")
+	// systemd generate systemd units instead of nohup scripts to run the app
+	systemd    bool
+	exportPath string
+	report     *ExportReport
+}
+
+func newSlugExporter(ctx *Context) (Renderer, error) {
+	return &slugExporter{
+		logger:      ctx.Logger,
+		ram:         *ctx.RAM,
+		imageClient: ctx.ImageClient,
+		pull:        ctx.Pull,
+		mode:        ctx.Mode,
+		systemd:     ctx.Options.SlugSystemd,
+		exportPath:  ctx.ExportPath,
+		report:      ctx.Report,
+	}, nil
+}
+
+// slugFileName the slug of the source code component, the file names of the slug package
+// are relative to the package root
+func slugFileName(name string) string {
+	return path.Join(name, name+"-slug.tgz")
+}
+
+// slugImageFileName the image tarball of the image component
+func slugImageFileName(name string) string {
+	return path.Join(name, name+"-image.tar")
+}
+
+// slugEnvFileName the env file of the component
+func slugEnvFileName(name string) string {
+	return path.Join(name, name+".env")
+}
+
+// slugScriptName the control script of the component or the gateway, both get a directory of their name
+func slugScriptName(name string) string {
+	return path.Join(name, name+".sh")
+}
+
+// slugConfigDir the dir the config files of the image component are written under
+func slugConfigDir(name string) string {
+	return path.Join(name, "config")
+}
+
+// slugAppScriptName the script controls all components of the app
+func slugAppScriptName(appName string) string {
+	return appName + ".sh"
+}
+
+func (s *slugExporter) SaveImages(ram v1alpha1.RainbondApplicationConfig) error {
+	// Save components attachments
+	if err := SaveComponents(ram, s.imageClient, s.exportPath, s.logger, []string{}, s.pull); err != nil {
+		return err
 	}
-	// UnTar component-images
+	s.logger.Infof("success save components")
+	return nil
+}
+
+func (s *slugExporter) Render() error {
+	// Read the slugs through an image layout, the layer holding the slug is the last one of the manifest
 	ciTarPath := fmt.Sprintf("%s/component-images.tar", s.exportPath)
 	ciFilePath := fmt.Sprintf("%s/component-images", s.exportPath)
-	err := os.Mkdir(ciFilePath, 0755)
+	layout, err := ocilayout.Create(ciFilePath)
 	if err != nil {
-		s.logger.Error("mkdir component-image error", err)
+		s.logger.Error("create component images layout error", err)
+		return err
 	}
-	err = util.UnImagetar(ciTarPath, ciFilePath)
-	if err != nil {
-		s.logger.Error("component-images UnTar error", err)
-		return nil, err
+	if _, err = layout.AddArchive(ciTarPath); err != nil {
+		s.logger.Error("read component images error", err)
+		return err
 	}
 	// get slug and env file and run script
+	var slugComponents []*v1alpha1.Component
 	for _, component := range s.ram.Components {
 		if component.ServiceSource == sourceCode {
-			// Unmarshal manifest.json
-			mfJsonPath := fmt.Sprintf("%s/manifest.json", ciFilePath)
-			mfByte := util.ReadJson(mfJsonPath)
-			var mfs []*v1alpha1.Manifest
-			err = json.Unmarshal([]byte(mfByte), &mfs)
+			mf, err := layout.Manifest(component.ShareImage)
 			if err != nil {
-				s.logger.Error("mfs json Unmarshal error", err)
-				return nil, err
+				s.logger.Error("read component image manifest error", err)
+				return err
 			}
-			for _, mf := range mfs {
-				for _, tag := range mf.RepoTags {
-					if tag == component.ShareImage {
-						// Gets the Layer directory where slug is stored
-						layer := mf.Layers[len(mf.Layers)-1]
-						layerID := strings.Split(layer, "/")[0]
-						layerPath := fmt.Sprintf("%s/%s", ciFilePath, layerID)
-						layerTar := fmt.Sprintf("%s/%s", ciFilePath, layer)
-						// UnTar layer
-						err = util.UnImagetar(layerTar, layerPath)
-						if err != nil {
-							s.logger.Error("layer UnTar error", err)
-							return nil, err
-						}
-						// Create a package path to store slug
-						slugPath := fmt.Sprintf("%s/%s", s.exportPath, component.ServiceCname)
-						err = os.Mkdir(slugPath, 0755)
-						if err != nil {
-							s.logger.Error("mkdir slug error", err)
-							return nil, err
-						}
-						// Copy slug to store path
-						slugOldPath := fmt.Sprintf("%s/tmp/slug/slug.tgz", layerPath)
-						err = util.CopyDir(slugOldPath, slugPath)
-						if err != nil {
-							s.logger.Error("copy slug error", err)
-							return nil, err
-						}
-						slugName := fmt.Sprintf("%s-slug.tgz", component.ServiceCname)
-						err = os.Rename(slugPath+"/slug.tgz", fmt.Sprintf("%s/%s", slugPath, slugName))
-						if err != nil {
-							logrus.Error("slug.tgz rename error")
-						}
-						// Add an environment variable file
-						if err := s.writeEnvFile(component, slugPath, s.ram.AppConfigGroups); err != nil {
-							return nil, err
-						}
-						// Add a script to run slug
-						if err := s.writeRunScript(slugPath, component.ServiceCname); err != nil {
-							return nil, err
-						}
-					}
-				}
+			if len(mf.Layers) == 0 {
+				return fmt.Errorf("image %s of component %s has no layer", component.ShareImage, component.ServiceCname)
+			}
+			// Gets the Layer directory where slug is stored
+			layer := mf.Layers[len(mf.Layers)-1]
+			layerPath := fmt.Sprintf("%s/%s", ciFilePath, layer.Digest.Encoded())
+			// UnTar layer
+			err = archive.Unpack(layout.BlobPath(layer.Digest), layerPath)
+			if err != nil {
+				s.logger.Error("layer UnTar error", err)
+				return err
 			}
+			// Create a package path to store slug
+			slugPath := fmt.Sprintf("%s/%s", s.exportPath, component.ServiceCname)
+			err = os.Mkdir(slugPath, 0755)
+			if err != nil {
+				s.logger.Error("mkdir slug error", err)
+				return err
+			}
+			// Copy slug to store path
+			slugOldPath := fmt.Sprintf("%s/tmp/slug/slug.tgz", layerPath)
+			err = util.CopyDir(slugOldPath, slugPath)
+			if err != nil {
+				s.logger.Error("copy slug error", err)
+				return err
+			}
+			err = os.Rename(slugPath+"/slug.tgz", path.Join(s.exportPath, slugFileName(component.ServiceCname)))
+			if err != nil {
+				logrus.Error("slug.tgz rename error")
+			}
+			// Add an environment variable file
+			if err := s.writeEnvFile(component, s.ram.AppConfigGroups); err != nil {
+				return err
+			}
+			// Add a script to run slug
+			if err := s.writeRunScript(component.ServiceCname); err != nil {
+				return err
+			}
+			slugComponents = append(slugComponents, component)
 		}
 	}
+	// image components are shipped as loadable images with a container run script
+	for _, component := range s.ram.Components {
+		if component.ServiceSource == sourceCode {
+			continue
+		}
+		if component.ShareImage == "" || component.VM != nil {
+			s.report.warn(s.logger, "component %s can not run in slug package, skip it", component.ServiceCname)
+			continue
+		}
+		if err := s.exportImageComponent(component, layout); err != nil {
+			return err
+		}
+		slugComponents = append(slugComponents, component)
+	}
 	// remove component images file
 	if err = os.RemoveAll(ciTarPath); err != nil {
-		return nil, err
+		return err
 	}
 	if err = os.RemoveAll(ciFilePath); err != nil {
-		return nil, err
+		return err
+	}
+	// Add a reverse proxy implements the ingress routes
+	if hasIngressRoutes(s.ram) {
+		if err := s.writeGateway(); err != nil {
+			return err
+		}
 	}
 	// Add a script to app
-	if err := s.writeAppScript(s.exportPath, s.ram.AppName); err != nil {
-		return nil, err
+	if s.systemd {
+		if err := s.writeSystemdUnits(slugComponents); err != nil {
+			s.logger.Errorf("write systemd units failure %s", err.Error())
+			return err
+		}
+		if err := s.writeSystemdAppScript(s.exportPath, s.ram.AppName); err != nil {
+			return err
+		}
+	} else if err := s.writeAppScript(s.exportPath, s.ram.AppName, slugComponents); err != nil {
+		return err
 	}
-	// packaging
-	packageName := fmt.Sprintf("%s-%s-slug.tar.gz", s.ram.AppName, s.ram.AppVersion)
-	name, err := Packaging(packageName, s.homePath, s.exportPath)
-	if err != nil {
-		err = fmt.Errorf("Failed to package app %s: %s ", packageName, err.Error())
-		s.logger.Error(err)
-		return nil, err
+	return nil
+}
+
+func (s *slugExporter) InstallSteps() []InstallStep {
+	script := fmt.Sprintf("./%s.sh", s.ram.AppName)
+	var steps []InstallStep
+	if s.systemd {
+		steps = append(steps, InstallStep{Description: "Install the systemd units of the app as root.", Command: script + " install"})
 	}
-	s.logger.Infof("success export app " + s.ram.AppName)
-	return &Result{PackagePath: path.Join(s.homePath, name), PackageName: name}, nil
+	return append(steps,
+		InstallStep{Description: "Start the components in dependency order.", Command: script + " start"},
+		InstallStep{Description: "Show the status of the components.", Command: script + " status"},
+		InstallStep{Description: "Stop the app.", Command: script + " stop"})
 }
 
-func (s *slugExporter) writeEnvFile(component *v1alpha1.Component, slugPath string, AppConfigGroups []*v1alpha1.AppConfigGroup) error {
+func (s *slugExporter) Plan(report *ExportReport) error {
+	var slugComponents []*v1alpha1.Component
+	for _, component := range s.ram.Components {
+		if component.ServiceSource != sourceCode {
+			continue
+		}
+		report.Files = append(report.Files,
+			slugFileName(component.ServiceCname),
+			slugEnvFileName(component.ServiceCname),
+			slugScriptName(component.ServiceCname))
+		slugComponents = append(slugComponents, component)
+	}
+	for _, component := range s.ram.Components {
+		if component.ServiceSource == sourceCode {
+			continue
+		}
+		if component.ShareImage == "" || component.VM != nil {
+			report.warn(s.logger, "component %s can not run in slug package, skip it", component.ServiceCname)
+			continue
+		}
+		report.Files = append(report.Files, slugImageFileName(component.ServiceCname))
+		for _, volume := range component.ServiceVolumeMapList {
+			if volume.VolumeType == v1alpha1.ConfigFileVolumeType {
+				report.Files = append(report.Files, configFileName(slugConfigDir(component.ServiceCname), volume))
+			}
+		}
+		report.Files = append(report.Files,
+			slugEnvFileName(component.ServiceCname),
+			slugScriptName(component.ServiceCname))
+		slugComponents = append(slugComponents, component)
+	}
+	if hasIngressRoutes(s.ram) {
+		report.Files = append(report.Files, gatewayConfFile, slugScriptName(gatewayDir))
+	}
+	if s.systemd {
+		for _, com := range slugComponents {
+			report.Files = append(report.Files, path.Join(systemdDir, systemdUnitName(s.ram.AppName, com)))
+		}
+		if hasIngressRoutes(s.ram) {
+			report.Files = append(report.Files, path.Join(systemdDir, systemdGatewayUnitName(s.ram.AppName)))
+		}
+		report.Files = append(report.Files, path.Join(systemdDir, systemdTargetName(s.ram.AppName)))
+	}
+	report.Files = append(report.Files, slugAppScriptName(s.ram.AppName))
+	return nil
+}
+
+func (s *slugExporter) writeEnvFile(component *v1alpha1.Component, AppConfigGroups []*v1alpha1.AppConfigGroup) error {
 	// remove component  image hub info
-	if s.mode == "offline" {
+	if s.mode == OfflineMode {
 		for i := range s.ram.Components {
 			s.ram.Components[i].AppImage = v1alpha1.ImageInfo{}
 		}
@@ -165,7 +283,7 @@ func (s *slugExporter) writeEnvFile(component *v1alpha1.Component, slugPath stri
 	)
 	// get env
 	for _, env := range component.Envs {
-		e := fmt.Sprintf("export %s=%s\n", env.AttrName, env.AttrValue)
+		e := s.envLine(env.AttrName, env.AttrValue)
 		envs += e
 	}
 	// get config groups
@@ -173,7 +291,7 @@ func (s *slugExporter) writeEnvFile(component *v1alpha1.Component, slugPath stri
 		for _, key := range AppConfigGroup.ComponentKeys {
 			if component.ComponentKey == key {
 				for k, v := range AppConfigGroup.ConfigItems {
-					config := fmt.Sprintf("export %s=%s\n", k, v)
+					config := s.envLine(k, v)
 					configs += config
 				}
 			}
@@ -181,17 +299,16 @@ func (s *slugExporter) writeEnvFile(component *v1alpha1.Component, slugPath stri
 	}
 	// get connection information
 	for _, connectInfoMap := range component.ServiceConnectInfoMapList {
-		connInfo := fmt.Sprintf("export %s=%s\n", connectInfoMap.AttrName, connectInfoMap.AttrValue)
+		connInfo := s.envLine(connectInfoMap.AttrName, connectInfoMap.AttrValue)
 		connInfos += connInfo
 	}
 	// get component port
-	port := fmt.Sprintf("export %s=%v\n", "PORT", component.Ports[0].ContainerPort)
+	port := s.envLine("PORT", fmt.Sprintf("%v", component.Ports[0].ContainerPort))
 
 	// parameters are written to the file in KV format
 	fileKV = envs + configs + connInfos + port
 
-	txtName := fmt.Sprintf("%s.env", component.ServiceCname)
-	envFile := path.Join(slugPath, txtName)
+	envFile := path.Join(s.exportPath, slugEnvFileName(component.ServiceCname))
 	f, err := os.OpenFile(envFile, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		s.logger.Error("open envFile error", err)
@@ -206,9 +323,17 @@ func (s *slugExporter) writeEnvFile(component *v1alpha1.Component, slugPath stri
 	return nil
 }
 
-func (s *slugExporter) writeRunScript(slugPath string, name string) error {
-	shName := name + ".sh"
-	shPath := path.Join(slugPath, shName)
+// envLine format env as the line of env file, systemd EnvironmentFile does not support export
+func (s *slugExporter) envLine(name, value string) string {
+	if s.systemd {
+		value = strings.Replace(strings.Replace(value, `\`, `\\`, -1), `"`, `\"`, -1)
+		return fmt.Sprintf("%s=\"%s\"\n", name, value)
+	}
+	return fmt.Sprintf("export %s=%s\n", name, value)
+}
+
+func (s *slugExporter) writeRunScript(name string) error {
+	shPath := path.Join(s.exportPath, slugScriptName(name))
 	shfile, err := os.OpenFile(shPath, os.O_CREATE|os.O_WRONLY, 0777)
 	if err != nil {
 
@@ -216,7 +341,7 @@ func (s *slugExporter) writeRunScript(slugPath string, name string) error {
 		return err
 	}
 	defer shfile.Close()
-	runScript := "#!/bin/bash\n\n###\n### app.sh — Controls app startup and stop.\n###\n### Usage:\n###   app.sh <Options>\n###\n### Options:\n###   start   Start your app.\n###   stop    Stop your app.\n###   status  Show app status.\n###   -h      Show this message.\n\n[ $DEBUG ] && set -x\n\n# make stdout colorful\nGREEN='\\033[1;32m'\nYELLOW='\\033[1;33m'\nRED='\\033[1;31m'\nNC='\\033[0m' # No Color\n\n# 定义当前服务组件的名字\nAPPNAME=$(basename $(pwd))\n\n# 定义当前工作目录\nHOME=$(pwd)\n\n\n# 解压 slug 包\nfunction processSlug() {\n    if [ -f ${APPNAME}-slug.tgz ]; then\n        tar xzf ${APPNAME}-slug.tgz -C $HOME\n    else\n        echo -e \"There is no slug file, ${0#*/} need it to start your app ...$RED Failure $NC\"\n        exit 1\n    fi\n}\n\n# 运行 .profile.d 中的所有文件\n# 这个过程会修改 PATH 环境变量\nfunction processRuntimeEnv() {\n    sleep 1\n    if [ -d .profile.d ]; then\n        echo -e \"Handling runtime environment ... $GREEN Done $NC\"\n        for file in .profile.d/*; do\n            source $file\n        done\n        hash -r\n    fi\n}\n\n# 导入用户自定义的其他环境变量\nfunction processCustomEnv() {\n    if [ -f ${APPNAME}.env ]; then\n        sleep 1\n        echo -e \"Handling custom environment ... $GREEN Done $NC\"\n        source ${APPNAME}.env\n    fi\n}\n\n# 处理启动命令\nfunction processCmd() {\n    # 从 Procfile 文件中截取\n    if [ -f Procfile ]; then\n        # 渲染启动命令中的环境变量\n        eval \"cat <<EOF\n$(<Procfile)\nEOF\n\" >${APPNAME}.cmd\n        sed -i 's/web: //' ${APPNAME}.cmd\n    elif [ ! -f Procfile ] && [ -s .release ]; then\n        eval \"cat <<EOF\n$(cat .release | grep web | sed 's/web: //')\nEOF\n\" >${APPNAME}.cmd\n    else\n        echo -e \"Can not detect start cmd, please check whether file Procfile or .release exists ... $RED Failure $NC\"\n        exit 1\n    fi\n}\n\n# 启动函数\nfunction appStart() {\n    appStatus >/dev/null 2>&1 &&\n        echo -e \"App ${APPNAME} is already running with pid $(cat ${APPNAME}.pid). Try exec $0 status\" &&\n        exit 1\n    processSlug\n    processRuntimeEnv\n    processCustomEnv\n    processCmd\n    echo \"Running app ${APPNAME}, you can check the logs in file ${APPNAME}.log\"\n    echo \"We will start your app with ==> $(cat ${APPNAME}.cmd)\"\n    nohup $(cat ${APPNAME}.cmd) >${APPNAME}.log 2>&1 &\n    # 对于进程运行过程中报错退出的，需要时间窗口来延迟检测\n    sleep 3\n    # 查询进程，来确定是否启动成功\n    RES=$(ps -p $! -o pid= -o comm=)\n    if [ ! -z \"$RES\" ]; then\n        echo -e \"Running app ${APPNAME} with process: $RES ... $GREEN Done $NC\"\n        echo $! >${APPNAME}.pid\n    else\n        echo -e \"Running app ${APPNAME} failed,check ${APPNAME}.log ... $RED Failure $NC\"\n    fi\n}\n\nfunction appStop() {\n    if [ -f ${APPNAME}.pid ]; then\n        PID=$(cat ${APPNAME}.pid)\n        if [ ! -z $PID ]; then\n            # For stopping Nginx process,SIGTERM is better than SIGKILL\n            kill -15 $PID >/dev/null 2>&1\n            if [ $? == 0 ]; then\n                echo -e \"Stopping app ${APPNAME} which running with pid ${PID} ... $GREEN Done $NC\"\n                rm -rf ${APPNAME}.pid\n            else\n                rm -rf ${APPNAME}.pid\n            fi\n        fi\n    else\n        echo \"The app ${APPNAME} is not running.Ignore the operation.\"\n    fi\n}\n\n# # TODO\n# function appRestart() {\n\n# }\n\n# 获取当前目录下的 app 是否启动\nfunction appStatus() {\n    PID=$(cat ${APPNAME}.pid 2>/dev/null)\n    RES=$(ps -p $PID -o pid= -o comm= 2>/dev/null)\n    if [ ! -z \"$RES\" ]; then\n        printf \"%-30s %-30s %-10s\\n\" AppName Status PID\n        printf \"%-30s \\e[1;32m%-30s\\e[m %-30s\\n\" ${APPNAME} \"Active(Running)\" $PID\n        return 0\n    else\n        printf \"%-30s %-30s %-30s\\n\" AppName Status PID\n        printf \"%-30s \\e[1;31m%-30s\\e[m %-30s\\n\" \"${APPNAME}\" \"Inactive(Exited)\" \"N/A\"\n        return 1\n    fi\n}\n\nfunction showHelp() {\n    sed -rn -e \"s/^### ?//p\" $0 | sed \"s#app.sh#${0}#g\"\n}\n\ncase $1 in\nstart)\n    appStart\n    ;;\nstop)\n    appStop\n    ;;\nstatus)\n    appStatus\n    ;;\n*)\n    showHelp\n    exit 1\n    ;;\nesac"
+	runScript := "#!/bin/bash\n\n###\n### app.sh — Controls app startup and stop.\n###\n### Usage:\n###   app.sh <Options>\n###\n### Options:\n###   start   Start your app.\n###   stop    Stop your app.\n###   status  Show app status.\n###   -h      Show this message.\n\n[ $DEBUG ] && set -x\n\n# make stdout colorful\nGREEN='\\033[1;32m'\nYELLOW='\\033[1;33m'\nRED='\\033[1;31m'\nNC='\\033[0m' # No Color\n\n# 定义当前服务组件的名字\nAPPNAME=$(basename $(pwd))\n\n# 定义当前工作目录\nHOME=$(pwd)\n\n\n# 解压 slug 包\nfunction processSlug() {\n    if [ -f ${APPNAME}-slug.tgz ]; then\n        tar xzf ${APPNAME}-slug.tgz -C $HOME\n    else\n        echo -e \"There is no slug file, ${0#*/} need it to start your app ...$RED Failure $NC\"\n        exit 1\n    fi\n}\n\n# 运行 .profile.d 中的所有文件\n# 这个过程会修改 PATH 环境变量\nfunction processRuntimeEnv() {\n    sleep 1\n    if [ -d .profile.d ]; then\n        echo -e \"Handling runtime environment ... $GREEN Done $NC\"\n        for file in .profile.d/*; do\n            source $file\n        done\n        hash -r\n    fi\n}\n\n# 导入用户自定义的其他环境变量\nfunction processCustomEnv() {\n    if [ -f ${APPNAME}.env ]; then\n        sleep 1\n        echo -e \"Handling custom environment ... $GREEN Done $NC\"\n        set -a\n        source ${APPNAME}.env\n        set +a\n    fi\n}\n\n# 处理启动命令\nfunction processCmd() {\n    # 从 Procfile 文件中截取\n    if [ -f Procfile ]; then\n        # 渲染启动命令中的环境变量\n        eval \"cat <<EOF\n$(<Procfile)\nEOF\n\" >${APPNAME}.cmd\n        sed -i 's/web: //' ${APPNAME}.cmd\n    elif [ ! -f Procfile ] && [ -s .release ]; then\n        eval \"cat <<EOF\n$(cat .release | grep web | sed 's/web: //')\nEOF\n\" >${APPNAME}.cmd\n    else\n        echo -e \"Can not detect start cmd, please check whether file Procfile or .release exists ... $RED Failure $NC\"\n        exit 1\n    fi\n}\n\n# 启动函数\nfunction appStart() {\n    appStatus >/dev/null 2>&1 &&\n        echo -e \"App ${APPNAME} is already running with pid $(cat ${APPNAME}.pid). Try exec $0 status\" &&\n        exit 1\n    processSlug\n    processRuntimeEnv\n    processCustomEnv\n    processCmd\n    echo \"Running app ${APPNAME}, you can check the logs in file ${APPNAME}.log\"\n    echo \"We will start your app with ==> $(cat ${APPNAME}.cmd)\"\n    nohup $(cat ${APPNAME}.cmd) >${APPNAME}.log 2>&1 &\n    # 对于进程运行过程中报错退出的，需要时间窗口来延迟检测\n    sleep 3\n    # 查询进程，来确定是否启动成功\n    RES=$(ps -p $! -o pid= -o comm=)\n    if [ ! -z \"$RES\" ]; then\n        echo -e \"Running app ${APPNAME} with process: $RES ... $GREEN Done $NC\"\n        echo $! >${APPNAME}.pid\n    else\n        echo -e \"Running app ${APPNAME} failed,check ${APPNAME}.log ... $RED Failure $NC\"\n    fi\n}\n\nfunction appStop() {\n    if [ -f ${APPNAME}.pid ]; then\n        PID=$(cat ${APPNAME}.pid)\n        if [ ! -z $PID ]; then\n            # For stopping Nginx process,SIGTERM is better than SIGKILL\n            kill -15 $PID >/dev/null 2>&1\n            if [ $? == 0 ]; then\n                echo -e \"Stopping app ${APPNAME} which running with pid ${PID} ... $GREEN Done $NC\"\n                rm -rf ${APPNAME}.pid\n            else\n                rm -rf ${APPNAME}.pid\n            fi\n        fi\n    else\n        echo \"The app ${APPNAME} is not running.Ignore the operation.\"\n    fi\n}\n\n# # TODO\n# function appRestart() {\n\n# }\n\n# 获取当前目录下的 app 是否启动\nfunction appStatus() {\n    PID=$(cat ${APPNAME}.pid 2>/dev/null)\n    RES=$(ps -p $PID -o pid= -o comm= 2>/dev/null)\n    if [ ! -z \"$RES\" ]; then\n        printf \"%-30s %-30s %-10s\\n\" AppName Status PID\n        printf \"%-30s \\e[1;32m%-30s\\e[m %-30s\\n\" ${APPNAME} \"Active(Running)\" $PID\n        return 0\n    else\n        printf \"%-30s %-30s %-30s\\n\" AppName Status PID\n        printf \"%-30s \\e[1;31m%-30s\\e[m %-30s\\n\" \"${APPNAME}\" \"Inactive(Exited)\" \"N/A\"\n        return 1\n    fi\n}\n\nfunction showHelp() {\n    sed -rn -e \"s/^### ?//p\" $0 | sed \"s#app.sh#${0}#g\"\n}\n\ncase $1 in\nstart)\n    appStart\n    ;;\nstop)\n    appStop\n    ;;\nstatus)\n    appStatus\n    ;;\n*)\n    showHelp\n    exit 1\n    ;;\nesac"
 	err = ioutil.WriteFile(shPath, []byte(runScript), 0777)
 	if err != nil {
 		logrus.Error("write run script to sh error")
@@ -224,9 +349,8 @@ func (s *slugExporter) writeRunScript(slugPath string, name string) error {
 	return nil
 }
 
-func (s *slugExporter) writeAppScript(appPath string, name string) error {
-	shName := name + ".sh"
-	shPath := path.Join(appPath, shName)
+func (s *slugExporter) writeAppScript(appPath string, name string, components []*v1alpha1.Component) error {
+	shPath := path.Join(appPath, slugAppScriptName(name))
 	shfile, err := os.OpenFile(shPath, os.O_CREATE|os.O_WRONLY, 0777)
 	if err != nil {
 
@@ -235,9 +359,121 @@ func (s *slugExporter) writeAppScript(appPath string, name string) error {
 	}
 	defer shfile.Close()
 	appScript := "#!/bin/bash\n###\n### app.sh — Controls app startup and stop.\n###\n### Usage:\n###   app.sh <Options>\n###\n### Options:\n###   start   Start your app.\n###   stop    Stop your app.\n###   status  Show app status.\n###   -h      Show this message.\n\n[ $DEBUG ] && set -x\n\n# make stdout colorful\nGREEN='\\033[1;32m'\nYELLOW='\\033[1;33m'\nRED='\\033[1;31m'\nNC='\\033[0m' # No Color\n\n# 定义当前应用的名字\nAPPNAME=$(basename $(pwd))\n\n# 扫描当前应用中所有的服务组件名称\nAPPS=$(ls -d */ | sed \"s#\\/##g\")\n\n# 启动所有的服务组件\nfunction allAppStart() {\n    for app in ${APPS}; do\n        pushd $app >/dev/null 2>&1\n        ./$app.sh start | sed -n '$p'\n        popd >/dev/null 2>&1\n    done\n}\n\nfunction allAppStop() {\n    for app in ${APPS}; do\n        pushd $app >/dev/null 2>&1\n        ./$app.sh stop\n        popd >/dev/null 2>&1\n    done\n}\n\nfunction allAppStatus() {\n    printf \"%-30s %-30s %-10s\\n\" AppName Status PID\n    for app in ${APPS}; do\n        pushd $app >/dev/null 2>&1\n        ./$app.sh status | sed '1d'\n        popd >/dev/null 2>&1\n    done\n}\n\nfunction showHelp() {\n    sed -rn -e \"s/^### ?//p\" $0 | sed \"s#app.sh#${0}#g\"\n}\n\ncase $1 in\nstart)\n    allAppStart\n    ;;\nstop)\n    allAppStop\n    ;;\nstatus)\n    allAppStatus\n    ;;\n*)\n    showHelp\n    exit 1\n    ;;\nesac"
+	// start components in dependency order and stop them in reverse order
+	var apps []string
+	for _, com := range SortComponentsByDependency(components) {
+		apps = append(apps, com.ServiceCname)
+	}
+	if hasIngressRoutes(s.ram) {
+		apps = append(apps, gatewayDir)
+	}
+	reverseApps := make([]string, 0, len(apps))
+	for i := len(apps) - 1; i >= 0; i-- {
+		reverseApps = append(reverseApps, apps[i])
+	}
+	appScript = strings.Replace(appScript, "APPS=$(ls -d */ | sed \"s#\\/##g\")", fmt.Sprintf("APPS=\"%s\"\nREVERSE_APPS=\"%s\"", strings.Join(apps, " "), strings.Join(reverseApps, " ")), 1)
+	appScript = strings.Replace(appScript, "function allAppStop() {\n    for app in ${APPS}; do", "function allAppStop() {\n    for app in ${REVERSE_APPS}; do", 1)
 	err = ioutil.WriteFile(shPath, []byte(appScript), 0777)
 	if err != nil {
 		logrus.Error("write app script to sh error")
 	}
 	return nil
 }
+
+// writeGateway write the nginx config implements the ingress routes, the gateway
+// directory is started by the app script as well as the components.
+func (s *slugExporter) writeGateway() error {
+	gateway := newNginxGateway(s.ram, func(com *v1alpha1.Component, port int) string {
+		return fmt.Sprintf("127.0.0.1:%d", port)
+	}, true)
+	conf := gateway.Render()
+	for _, warning := range gateway.Warnings {
+		s.report.warn(s.logger, "[gateway] %s", warning)
+	}
+	gatewayPath := path.Join(s.exportPath, gatewayDir)
+	if err := os.MkdirAll(path.Join(gatewayPath, "logs"), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(s.exportPath, gatewayConfFile), []byte(conf), 0644); err != nil {
+		s.logger.Errorf("write gateway config failure %s", err.Error())
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(s.exportPath, slugScriptName(gatewayDir)), []byte(slugGatewayScript), 0755); err != nil {
+		s.logger.Errorf("write gateway script failure %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+var slugGatewayScript = `#!/bin/bash
+###
+### gateway.sh — Controls the nginx reverse proxy of the app.
+###
+### Usage:
+###   gateway.sh <Options>
+###
+### Options:
+###   start   Start the gateway.
+###   stop    Stop the gateway.
+###   status  Show gateway status.
+###   -h      Show this message.
+
+[ $DEBUG ] && set -x
+
+HOME=$(pwd)
+PIDFILE=${HOME}/logs/nginx.pid
+
+function gatewayStart() {
+    which nginx >/dev/null 2>&1 || {
+        echo -e "Not found nginx command, install it to serve the ingress routes ... \033[1;31m Failure \033[0m"
+        exit 1
+    }
+    gatewayStatus >/dev/null 2>&1 && echo "Gateway is already running with pid $(cat ${PIDFILE})" && exit 1
+    nginx -p ${HOME} -c ${HOME}/nginx.conf -g "pid ${PIDFILE};"
+    sleep 1
+    gatewayStatus
+}
+
+function gatewayStop() {
+    if [ -f ${PIDFILE} ]; then
+        nginx -p ${HOME} -c ${HOME}/nginx.conf -g "pid ${PIDFILE};" -s quit
+        rm -f ${PIDFILE}
+    else
+        echo "The gateway is not running.Ignore the operation."
+    fi
+}
+
+function gatewayStatus() {
+    PID=$(cat ${PIDFILE} 2>/dev/null)
+    RES=$(ps -p $PID -o pid= -o comm= 2>/dev/null)
+    if [ ! -z "$RES" ]; then
+        printf "%-30s %-30s %-10s\n" AppName Status PID
+        printf "%-30s \e[1;32m%-30s\e[m %-30s\n" gateway "Active(Running)" $PID
+        return 0
+    else
+        printf "%-30s %-30s %-30s\n" AppName Status PID
+        printf "%-30s \e[1;31m%-30s\e[m %-30s\n" gateway "Inactive(Exited)" "N/A"
+        return 1
+    fi
+}
+
+function showHelp() {
+    sed -rn -e "s/^### ?//p" $0 | sed "s#gateway.sh#${0}#g"
+}
+
+case $1 in
+start)
+    gatewayStart
+    ;;
+stop)
+    gatewayStop
+    ;;
+status)
+    gatewayStatus
+    ;;
+*)
+    showHelp
+    exit 1
+    ;;
+esac
+`
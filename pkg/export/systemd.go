@@ -0,0 +1,295 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+const (
+	systemdDir = "systemd"
+	// systemdInstallDir placeholder replaced with the package directory by the install script
+	systemdInstallDir = "@INSTALL_DIR@"
+)
+
+// systemdUnitName returns the unit name of the component
+func systemdUnitName(appName string, com *v1alpha1.Component) string {
+	return strings.ToLower(composeName(fmt.Sprintf("%s-%s", appName, com.ServiceCname))) + ".service"
+}
+
+// systemdGatewayUnitName returns the unit name of the gateway
+func systemdGatewayUnitName(appName string) string {
+	return strings.ToLower(composeName(fmt.Sprintf("%s-%s", appName, gatewayDir))) + ".service"
+}
+
+// systemdTargetName returns the target that groups all units of the app
+func systemdTargetName(appName string) string {
+	return strings.ToLower(composeName(appName)) + ".target"
+}
+
+// readSlugStartCmd read the web process command from the Procfile or .release in the slug
+func readSlugStartCmd(slugFile string) (string, error) {
+	f, err := os.Open(slugFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+	var release string
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		name := strings.TrimPrefix(hdr.Name, "./")
+		if name != "Procfile" && name != ".release" {
+			continue
+		}
+		cmd := findWebProcess(tr)
+		if name == "Procfile" && cmd != "" {
+			return cmd, nil
+		}
+		if name == ".release" {
+			release = cmd
+		}
+	}
+	if release != "" {
+		return release, nil
+	}
+	return "", fmt.Errorf("can not detect start cmd, neither Procfile nor .release found in %s", path.Base(slugFile))
+}
+
+func findWebProcess(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "web:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "web:"))
+		}
+	}
+	return ""
+}
+
+// systemdExecEscaper quote the command for bash -c and keep systemd from expanding the variables
+// and specifiers in it, the variables are set by .profile.d when bash runs
+var systemdExecEscaper = strings.NewReplacer("'", `'\''`, "$", "$$", "%", "%%")
+
+// buildSystemdUnit build the service unit of the slug component
+func buildSystemdUnit(ram v1alpha1.RainbondApplicationConfig, com *v1alpha1.Component, startCmd string, units map[string]string) string {
+	var deps []string
+	for _, dep := range com.DepServiceMapList {
+		if unit, ok := units[dep.DepServiceKey]; ok {
+			deps = append(deps, unit)
+		}
+	}
+	workDir := path.Join(systemdInstallDir, com.ServiceCname)
+	target := systemdTargetName(ram.AppName)
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s of %s %s\n", com.ServiceCname, ram.AppName, ram.AppVersion)
+	fmt.Fprintf(&b, "After=network.target %s\n", strings.Join(deps, " "))
+	if len(deps) > 0 {
+		fmt.Fprintf(&b, "Requires=%s\n", strings.Join(deps, " "))
+	}
+	fmt.Fprintf(&b, "PartOf=%s\n\n", target)
+	b.WriteString("[Service]\nType=simple\n")
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", workDir)
+	fmt.Fprintf(&b, "EnvironmentFile=%s\n", path.Join(workDir, com.ServiceCname+".env"))
+	fmt.Fprintf(&b, "Environment=HOME=%s\n", workDir)
+	fmt.Fprintf(&b, "ExecStartPre=/bin/bash -c 'test -d .profile.d -o -f Procfile || tar xzf %s-slug.tgz'\n", com.ServiceCname)
+	fmt.Fprintf(&b, "ExecStart=/bin/bash -c 'for f in .profile.d/*; do [ -f \"$$f\" ] && source \"$$f\"; done; exec %s'\n", systemdExecEscaper.Replace(startCmd))
+	b.WriteString("Restart=on-failure\nRestartSec=5\nKillSignal=SIGTERM\n\n")
+	fmt.Fprintf(&b, "[Install]\nWantedBy=%s\n", target)
+	return b.String()
+}
+
+// buildSystemdGatewayUnit build the unit of the nginx reverse proxy, it starts after all components
+func buildSystemdGatewayUnit(ram v1alpha1.RainbondApplicationConfig, units []string) string {
+	workDir := path.Join(systemdInstallDir, gatewayDir)
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=gateway of %s %s\n", ram.AppName, ram.AppVersion)
+	fmt.Fprintf(&b, "After=network.target %s\n", strings.Join(units, " "))
+	fmt.Fprintf(&b, "PartOf=%s\n\n", systemdTargetName(ram.AppName))
+	b.WriteString("[Service]\nType=simple\n")
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", workDir)
+	fmt.Fprintf(&b, "ExecStart=/bin/bash -c 'exec nginx -p %s -c %s/nginx.conf -g \"daemon off; pid logs/nginx.pid;\"'\n", workDir, workDir)
+	b.WriteString("ExecReload=/bin/kill -HUP $MAINPID\nRestart=on-failure\nRestartSec=5\n\n")
+	fmt.Fprintf(&b, "[Install]\nWantedBy=%s\n", systemdTargetName(ram.AppName))
+	return b.String()
+}
+
+func buildSystemdTarget(ram v1alpha1.RainbondApplicationConfig, units []string) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s %s\n", ram.AppName, ram.AppVersion)
+	fmt.Fprintf(&b, "Wants=%s\n", strings.Join(units, " "))
+	fmt.Fprintf(&b, "After=%s\n\n", strings.Join(units, " "))
+	b.WriteString("[Install]\nWantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// writeSystemdUnits write the service units of all slug components and the app target
+func (s *slugExporter) writeSystemdUnits(components []*v1alpha1.Component) error {
+	units := make(map[string]string)
+	for _, com := range components {
+		units[com.ComponentKey] = systemdUnitName(s.ram.AppName, com)
+		if com.ServiceShareID != "" {
+			units[com.ServiceShareID] = units[com.ComponentKey]
+		}
+	}
+	unitDir := path.Join(s.exportPath, systemdDir)
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		return err
+	}
+	var unitNames []string
+	for _, com := range components {
+		unitName := units[com.ComponentKey]
+		var unit string
+		if com.ServiceSource != sourceCode {
+			unit = buildSystemdContainerUnit(s.ram, com, units)
+		} else {
+			slugFile := path.Join(s.exportPath, slugFileName(com.ServiceCname))
+			startCmd, err := readSlugStartCmd(slugFile)
+			if err != nil {
+				s.logger.Warningf("read start cmd of %s failure %s, read it from Procfile when starting", com.ServiceCname, err.Error())
+				startCmd = "$(sed -n \"s/^web: //p\" Procfile)"
+			}
+			unit = buildSystemdUnit(s.ram, com, startCmd, units)
+		}
+		if err := ioutil.WriteFile(path.Join(unitDir, unitName), []byte(unit), 0644); err != nil {
+			return err
+		}
+		unitNames = append(unitNames, unitName)
+	}
+	if hasIngressRoutes(s.ram) {
+		unitName := systemdGatewayUnitName(s.ram.AppName)
+		if err := ioutil.WriteFile(path.Join(unitDir, unitName), []byte(buildSystemdGatewayUnit(s.ram, unitNames)), 0644); err != nil {
+			return err
+		}
+		unitNames = append(unitNames, unitName)
+	}
+	target := buildSystemdTarget(s.ram, unitNames)
+	return ioutil.WriteFile(path.Join(unitDir, systemdTargetName(s.ram.AppName)), []byte(target), 0644)
+}
+
+// writeSystemdAppScript the app script install units and controls the app through systemctl
+func (s *slugExporter) writeSystemdAppScript(appPath string, name string) error {
+	script := strings.Replace(slugSystemdAppScript, "@TARGET@", systemdTargetName(s.ram.AppName), -1)
+	return ioutil.WriteFile(path.Join(appPath, slugAppScriptName(name)), []byte(script), 0755)
+}
+
+var slugSystemdAppScript = `#!/bin/bash
+###
+### app.sh — Controls app startup and stop through systemd.
+###
+### Usage:
+###   app.sh <Options>
+###
+### Options:
+###   install    Install the systemd units of the app.
+###   uninstall  Stop the app and remove the systemd units.
+###   start      Start your app.
+###   stop       Stop your app.
+###   restart    Restart your app.
+###   status     Show app status.
+###   -h         Show this message.
+
+[ $DEBUG ] && set -x
+
+cd $(dirname $0)
+INSTALL_DIR=$(pwd)
+TARGET=@TARGET@
+UNIT_DIR=/etc/systemd/system
+
+function appInstall() {
+    for unit in systemd/*; do
+        sed "s#@INSTALL_DIR@#${INSTALL_DIR}#g" $unit >${UNIT_DIR}/$(basename $unit)
+    done
+    systemctl daemon-reload
+    systemctl enable ${TARGET}
+    for unit in systemd/*.service; do
+        systemctl enable $(basename $unit)
+    done
+}
+
+function appUninstall() {
+    systemctl stop ${TARGET}
+    systemctl disable ${TARGET}
+    for unit in systemd/*; do
+        systemctl disable $(basename $unit) >/dev/null 2>&1
+        rm -f ${UNIT_DIR}/$(basename $unit)
+    done
+    systemctl daemon-reload
+}
+
+function appStatus() {
+    for unit in systemd/*.service; do
+        systemctl --no-pager status $(basename $unit) | head -3
+    done
+}
+
+function showHelp() {
+    sed -rn -e "s/^### ?//p" $0 | sed "s#app.sh#${0}#g"
+}
+
+case $1 in
+install)
+    appInstall
+    ;;
+uninstall)
+    appUninstall
+    ;;
+start)
+    [ -f ${UNIT_DIR}/${TARGET} ] || appInstall
+    systemctl start ${TARGET}
+    ;;
+stop)
+    systemctl stop ${TARGET}
+    ;;
+restart)
+    systemctl restart ${TARGET}
+    ;;
+status)
+    appStatus
+    ;;
+*)
+    showHelp
+    exit 1
+    ;;
+esac
+`
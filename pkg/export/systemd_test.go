@@ -0,0 +1,114 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+func writeTestSlug(t *testing.T, files map[string]string) string {
+	t.Helper()
+	slugFile := path.Join(t.TempDir(), "web-slug.tgz")
+	f, err := os.Create(slugFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return slugFile
+}
+
+func TestReadSlugStartCmdFromProcfile(t *testing.T) {
+	slugFile := writeTestSlug(t, map[string]string{
+		"./Procfile": "web: java $JAVA_OPTS -jar target/app.jar --server.port=$PORT\n",
+	})
+	cmd, err := readSlugStartCmd(slugFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != "java $JAVA_OPTS -jar target/app.jar --server.port=$PORT" {
+		t.Fatalf("unexpected start cmd %q", cmd)
+	}
+}
+
+func TestReadSlugStartCmdWithoutProcfile(t *testing.T) {
+	slugFile := writeTestSlug(t, map[string]string{"./index.js": "console.log(1)"})
+	if _, err := readSlugStartCmd(slugFile); err == nil {
+		t.Fatalf("expected error without Procfile")
+	}
+}
+
+func TestBuildSystemdUnitOrdersDependencies(t *testing.T) {
+	ram := newComposeTestTemplate()
+	units := map[string]string{
+		"web-key": systemdUnitName(ram.AppName, ram.Components[0]),
+		"db-key":  systemdUnitName(ram.AppName, ram.Components[1]),
+	}
+	unit := buildSystemdUnit(ram, ram.Components[0], "node index.js", units)
+	for _, expected := range []string{
+		"After=network.target demo-db.service",
+		"Requires=demo-db.service",
+		"EnvironmentFile=@INSTALL_DIR@/web/web.env",
+		"exec node index.js",
+		"Restart=on-failure",
+		"WantedBy=demo.target",
+	} {
+		if !strings.Contains(unit, expected) {
+			t.Fatalf("expected unit to contain %q, got\n%s", expected, unit)
+		}
+	}
+}
+
+func TestBuildSystemdUnitKeepsProcfileVariables(t *testing.T) {
+	ram := newComposeTestTemplate()
+	unit := buildSystemdUnit(ram, ram.Components[0], "java $JAVA_OPTS -Dserver.port=${PORT} -jar 'app 100%.jar'", nil)
+	expected := `exec java $$JAVA_OPTS -Dserver.port=$${PORT} -jar '\''app 100%%.jar'\'''`
+	if !strings.Contains(unit, expected) {
+		t.Fatalf("expected the variables to be left to bash, got\n%s", unit)
+	}
+}
+
+func TestSlugEnvLineFormat(t *testing.T) {
+	s := &slugExporter{ram: v1alpha1.RainbondApplicationConfig{}}
+	if line := s.envLine("A", "b"); line != "export A=b\n" {
+		t.Fatalf("unexpected script env line %q", line)
+	}
+	s.systemd = true
+	if line := s.envLine("A", `say "hi"`); line != "A=\"say \\\"hi\\\"\"\n" {
+		t.Fatalf("unexpected systemd env line %q", line)
+	}
+}
@@ -0,0 +1,258 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+// TopologyFormat the text format the topology of the app is drawn in
+type TopologyFormat string
+
+const (
+	// TopologyDOT Graphviz DOT, written as topology.dot
+	TopologyDOT TopologyFormat = "dot"
+	// TopologyMermaid Mermaid flowchart, written as topology.mmd and embedded in the README
+	TopologyMermaid TopologyFormat = "mermaid"
+)
+
+// FileName the file the topology is written into in the package
+func (f TopologyFormat) FileName() string {
+	if f == TopologyMermaid {
+		return "topology.mmd"
+	}
+	return "topology." + string(f)
+}
+
+func (f TopologyFormat) valid() bool {
+	return f == TopologyDOT || f == TopologyMermaid
+}
+
+// sourceThirdParty the service source of the components of third-party endpoints
+const sourceThirdParty = "third_party"
+
+const (
+	topologyComponent = "component"
+	topologyEndpoints = "endpoints"
+	topologyPlugin    = "plugin"
+	topologyIngress   = "ingress"
+)
+
+const (
+	topologyDependency = "dependency"
+	topologyVolume     = "volume"
+	topologyUsePlugin  = "plugin"
+	topologyRoute      = "route"
+)
+
+// topology the nodes and edges of the app, in the order of the template
+type topology struct {
+	name  string
+	nodes []topologyNode
+	edges []topologyEdge
+}
+
+type topologyNode struct {
+	id    string
+	kind  string
+	label string
+}
+
+type topologyEdge struct {
+	from  string
+	to    string
+	kind  string
+	label string
+}
+
+// newTopology draw the app: components, third-party endpoints and plugins are the nodes, the
+// dependencies, shared volumes and plugins of the components and the ingress routes the edges
+func newTopology(ram v1alpha1.RainbondApplicationConfig) *topology {
+	t := &topology{name: ram.AppName}
+	components := make(map[string]string)
+	for i, component := range ram.Components {
+		node := topologyNode{id: fmt.Sprintf("c%d", i), kind: topologyComponent, label: component.ServiceCname}
+		if component.ServiceSource == sourceThirdParty || component.Endpoints.Endpoints != "" {
+			node.kind = topologyEndpoints
+		}
+		t.nodes = append(t.nodes, node)
+		for _, key := range []string{component.ComponentKey, component.ServiceShareID} {
+			if _, ok := components[key]; key != "" && !ok {
+				components[key] = node.id
+			}
+		}
+	}
+	plugins := make(map[string]string)
+	for i, plugin := range ram.Plugins {
+		node := topologyNode{id: fmt.Sprintf("p%d", i), kind: topologyPlugin, label: plugin.PluginName}
+		t.nodes = append(t.nodes, node)
+		for _, key := range []string{plugin.PluginID, plugin.PluginKey} {
+			if _, ok := plugins[key]; key != "" && !ok {
+				plugins[key] = node.id
+			}
+		}
+	}
+	for i, route := range ram.IngressHTTPRoutes {
+		location := route.Location
+		if location == "" {
+			location = "/"
+		}
+		t.addRoute(fmt.Sprintf("ih%d", i), "http "+location, components[route.ComponentKey], route.Port)
+	}
+	for i, route := range ram.IngressSreamRoutes {
+		t.addRoute(fmt.Sprintf("is%d", i), fmt.Sprintf("%s %d", streamProtocol(route.Protocol), route.Port), components[route.ComponentKey], route.Port)
+	}
+	for i, component := range ram.Components {
+		from := t.nodes[i].id
+		for _, dep := range component.DepServiceMapList {
+			if to, ok := components[dep.DepServiceKey]; ok {
+				t.edges = append(t.edges, topologyEdge{from: from, to: to, kind: topologyDependency})
+			}
+		}
+		for _, mnt := range component.MntReleationList {
+			if to, ok := components[mnt.ShareServiceUUID]; ok {
+				t.edges = append(t.edges, topologyEdge{from: from, to: to, kind: topologyVolume, label: mnt.VolumeName})
+			}
+		}
+		for _, config := range component.ServicePluginConfigs {
+			to, ok := plugins[config.PluginID]
+			if !ok {
+				to, ok = plugins[config.PluginKey]
+			}
+			if ok {
+				t.edges = append(t.edges, topologyEdge{from: from, to: to, kind: topologyUsePlugin})
+			}
+		}
+	}
+	return t
+}
+
+// addRoute add the entrypoint of the ingress route, routes to unknown components are left out
+func (t *topology) addRoute(id, label, to string, port uint32) {
+	if to == "" {
+		return
+	}
+	t.nodes = append(t.nodes, topologyNode{id: id, kind: topologyIngress, label: label})
+	t.edges = append(t.edges, topologyEdge{from: id, to: to, kind: topologyRoute, label: fmt.Sprintf("%d", port)})
+}
+
+func (t *topology) dot() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n    rankdir=LR;\n", dotQuote(t.name))
+	shapes := map[string]string{
+		topologyComponent: "shape=box",
+		topologyEndpoints: "shape=box, style=dashed",
+		topologyPlugin:    "shape=component",
+		topologyIngress:   "shape=cds",
+	}
+	for _, node := range t.nodes {
+		fmt.Fprintf(&b, "    %s [label=%s, %s];\n", node.id, dotQuote(node.label), shapes[node.kind])
+	}
+	for _, edge := range t.edges {
+		var attrs []string
+		if edge.label != "" {
+			attrs = append(attrs, "label="+dotQuote(edge.label))
+		}
+		switch edge.kind {
+		case topologyVolume:
+			attrs = append(attrs, "style=dashed", "arrowhead=odot")
+		case topologyUsePlugin:
+			attrs = append(attrs, "style=dotted", "arrowhead=none")
+		}
+		fmt.Fprintf(&b, "    %s -> %s", edge.from, edge.to)
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (t *topology) mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	shapes := map[string][2]string{
+		topologyComponent: {"[", "]"},
+		topologyEndpoints: {"([", "])"},
+		topologyPlugin:    {"{{", "}}"},
+		topologyIngress:   {">", "]"},
+	}
+	for _, node := range t.nodes {
+		shape := shapes[node.kind]
+		fmt.Fprintf(&b, "    %s%s%s%s\n", node.id, shape[0], mermaidQuote(node.label), shape[1])
+	}
+	for _, edge := range t.edges {
+		arrow := "-->"
+		switch edge.kind {
+		case topologyVolume:
+			arrow = "-.-o"
+		case topologyUsePlugin:
+			arrow = "-.-"
+		}
+		if edge.label != "" {
+			arrow += "|" + mermaidQuote(edge.label) + "|"
+		}
+		fmt.Fprintf(&b, "    %s %s %s\n", edge.from, arrow, edge.to)
+	}
+	return b.String()
+}
+
+func dotQuote(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(text) + `"`
+}
+
+func mermaidQuote(text string) string {
+	return `"` + strings.NewReplacer(`"`, "#quot;", "\n", " ").Replace(text) + `"`
+}
+
+// Topology draw the topology of the app in the format
+func Topology(ram v1alpha1.RainbondApplicationConfig, format TopologyFormat) (string, error) {
+	if !format.valid() {
+		return "", fmt.Errorf("not support topology format %s", format)
+	}
+	if format == TopologyMermaid {
+		return newTopology(ram).mermaid(), nil
+	}
+	return newTopology(ram).dot(), nil
+}
+
+// writeTopologies write the topology of the app in the formats into the package and returns
+// the Mermaid flowchart if it is one of them, it is embedded in the README
+func writeTopologies(exportPath string, ram v1alpha1.RainbondApplicationConfig, formats []TopologyFormat) (string, error) {
+	var flowchart string
+	for _, format := range formats {
+		content, err := Topology(ram, format)
+		if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(path.Join(exportPath, format.FileName()), []byte(content), 0644); err != nil {
+			return "", err
+		}
+		if format == TopologyMermaid {
+			flowchart = content
+		}
+	}
+	return flowchart, nil
+}
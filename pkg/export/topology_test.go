@@ -0,0 +1,93 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+func newTopologyTestTemplate() v1alpha1.RainbondApplicationConfig {
+	ram := newComposeTestTemplate()
+	ram.Components[0].MntReleationList = []v1alpha1.ComponentShareVolume{{VolumeName: "data", VolumeMountDir: "/data", ShareServiceUUID: "db-share"}}
+	ram.Components[0].ServicePluginConfigs = []v1alpha1.ComponentPluginConfig{{PluginID: "plugin-1"}}
+	ram.Components = append(ram.Components, &v1alpha1.Component{ServiceCname: `legacy "api"`, ComponentKey: "api-key", ServiceSource: sourceThirdParty})
+	ram.Components[1].DepServiceMapList = []v1alpha1.ComponentDep{{DepServiceKey: "api-key"}}
+	ram.Plugins = []*v1alpha1.Plugin{{PluginName: "mesh", PluginID: "plugin-1"}}
+	ram.IngressHTTPRoutes = []*v1alpha1.IngressHTTPRoute{{TargetComponent: v1alpha1.TargetComponent{ComponentKey: "web-key", Port: 8080}}}
+	ram.IngressSreamRoutes = []*v1alpha1.IngressSreamRoute{
+		{Protocol: "tcp", TargetComponent: v1alpha1.TargetComponent{ComponentKey: "db-key", Port: 8080}},
+		{Protocol: "tcp", TargetComponent: v1alpha1.TargetComponent{ComponentKey: "missing", Port: 9090}},
+	}
+	return ram
+}
+
+func TestTopologyDOT(t *testing.T) {
+	dot, err := Topology(newTopologyTestTemplate(), TopologyDOT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`digraph "demo" {`,
+		`c0 [label="web", shape=box];`,
+		`c2 [label="legacy \"api\"", shape=box, style=dashed];`,
+		`p0 [label="mesh", shape=component];`,
+		`ih0 [label="http /", shape=cds];`,
+		`ih0 -> c0 [label="8080"];`,
+		`is0 -> c1 [label="8080"];`,
+		`c0 -> c1;`,
+		`c1 -> c2;`,
+		`c0 -> c1 [label="data", style=dashed, arrowhead=odot];`,
+		`c0 -> p0 [style=dotted, arrowhead=none];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Fatalf("expected %s in the dot graph, got\n%s", want, dot)
+		}
+	}
+	if strings.Contains(dot, "9090") {
+		t.Fatalf("expected the route to the missing component to be left out, got\n%s", dot)
+	}
+}
+
+func TestTopologyMermaid(t *testing.T) {
+	flowchart, err := Topology(newTopologyTestTemplate(), TopologyMermaid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"flowchart LR\n",
+		`c0["web"]`,
+		`c2(["legacy #quot;api#quot;"])`,
+		`p0{{"mesh"}}`,
+		`is0>"tcp 8080"]`,
+		`ih0 -->|"8080"| c0`,
+		`c0 --> c1`,
+		`c0 -.-o|"data"| c1`,
+		`c0 -.- p0`,
+	} {
+		if !strings.Contains(flowchart, want) {
+			t.Fatalf("expected %s in the flowchart, got\n%s", want, flowchart)
+		}
+	}
+	if _, err := Topology(newTopologyTestTemplate(), "svg"); err == nil {
+		t.Fatal("expected unknown formats to be rejected")
+	}
+}
@@ -19,15 +19,16 @@
 package export
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
 	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/ocilayout"
 	"github.com/mozillazg/go-pinyin"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"regexp"
 	"strconv"
@@ -120,28 +121,39 @@ func PrepareExportDir(exportPath string) error {
 	return os.MkdirAll(exportPath, 0755)
 }
 
+// configFileName the file the config file volume is written to under serviceDir
+func configFileName(serviceDir string, v v1alpha1.ComponentVolume) string {
+	return fmt.Sprintf("%s%s", strings.TrimRight(serviceDir, "/"), v.VolumeMountPath)
+}
+
 func exportComponentConfigFile(serviceDir string, v v1alpha1.ComponentVolume) error {
-	serviceDir = strings.TrimRight(serviceDir, "/")
-	filename := fmt.Sprintf("%s%s", serviceDir, v.VolumeMountPath)
+	filename := configFileName(serviceDir, v)
 	dir := path.Dir(filename)
 	os.MkdirAll(dir, 0755)
 	return ioutil.WriteFile(filename, []byte(v.FileConent), 0644)
 }
 
-func SaveComponents(ram v1alpha1.RainbondApplicationConfig, imageClient image.Client, exportPath string, logger *logrus.Logger, dependentImages []string) error {
+// SaveComponents pull the component images and save them into component-images.tar,
+// dependentImages are saved as well
+func SaveComponents(ram v1alpha1.RainbondApplicationConfig, imageClient image.Client, exportPath string, logger *logrus.Logger, dependentImages []string, pull image.PullOptions) error {
+	var requests []image.PullRequest
 	var componentImageNames []string
 	for _, component := range ram.Components {
 		componentName := unicode2zh(component.ServiceCname)
 		if component.ShareImage != "" {
 			// app is image type
-			_, err := imageClient.ImagePull(component.ShareImage, component.AppImage.HubUser, component.AppImage.HubPassword, 30)
-			if err != nil {
-				return err
-			}
-			logger.Infof("pull component %s image success", componentName)
+			requests = append(requests, image.PullRequest{
+				Image:    component.ShareImage,
+				Username: component.AppImage.HubUser,
+				Password: component.AppImage.HubPassword,
+				Owners:   []string{"component " + componentName},
+			})
 			componentImageNames = append(componentImageNames, component.ShareImage)
 		}
 	}
+	if err := image.PullImages(imageClient, requests, pull, logger); err != nil {
+		return err
+	}
 	start := time.Now()
 	for _, dependentImage := range dependentImages {
 		if dependentImage == "" {
@@ -149,7 +161,7 @@ func SaveComponents(ram v1alpha1.RainbondApplicationConfig, imageClient image.Cl
 		}
 		componentImageNames = append(componentImageNames, dependentImage)
 	}
-	err := imageClient.ImageSave(fmt.Sprintf("%s/component-images.tar", exportPath), componentImageNames)
+	err := image.SaveImages(imageClient, fmt.Sprintf("%s/component-images.tar", exportPath), uniqueImages(componentImageNames), pull)
 	if err != nil {
 		logrus.Errorf("Failed to save image(%v) : %s", componentImageNames, err)
 		return err
@@ -158,21 +170,27 @@ func SaveComponents(ram v1alpha1.RainbondApplicationConfig, imageClient image.Cl
 	return nil
 }
 
-func SavePlugins(ram v1alpha1.RainbondApplicationConfig, imageClient image.Client, exportPath string, logger *logrus.Logger) error {
+// SavePlugins pull the plugin images and save them into plugin-images.tar
+func SavePlugins(ram v1alpha1.RainbondApplicationConfig, imageClient image.Client, exportPath string, logger *logrus.Logger, pull image.PullOptions) error {
+	var requests []image.PullRequest
 	var pluginImageNames []string
 	for _, plugin := range ram.Plugins {
 		if plugin.ShareImage != "" {
 			// app is image type
-			_, err := imageClient.ImagePull(plugin.ShareImage, plugin.PluginImage.HubUser, plugin.PluginImage.HubPassword, 30)
-			if err != nil {
-				return err
-			}
-			logger.Infof("pull plugin %s image success", plugin.PluginName)
+			requests = append(requests, image.PullRequest{
+				Image:    plugin.ShareImage,
+				Username: plugin.PluginImage.HubUser,
+				Password: plugin.PluginImage.HubPassword,
+				Owners:   []string{"plugin " + plugin.PluginName},
+			})
 			pluginImageNames = append(pluginImageNames, plugin.ShareImage)
 		}
 	}
+	if err := image.PullImages(imageClient, requests, pull, logger); err != nil {
+		return err
+	}
 	start := time.Now()
-	err := imageClient.ImageSave(fmt.Sprintf("%s/plugin-images.tar", exportPath), pluginImageNames)
+	err := image.SaveImages(imageClient, fmt.Sprintf("%s/plugin-images.tar", exportPath), uniqueImages(pluginImageNames), pull)
 	if err != nil {
 		logrus.Errorf("Failed to save image(%v) : %s", pluginImageNames, err)
 		return err
@@ -181,18 +199,148 @@ func SavePlugins(ram v1alpha1.RainbondApplicationConfig, imageClient image.Clien
 	return nil
 }
 
-func Packaging(packageName, homePath, exportPath string) (string, error) {
-	cmd := exec.Command("tar", "-czf", path.Join(homePath, packageName), path.Base(exportPath))
-	logrus.Infof("package cmd: [%s]", cmd.String())
-	cmd.Dir = homePath
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		if strings.Contains(stderr.String(), "file changed as we read it") {
-			logrus.Warnf("Ignored changed files warning: %s", stderr.String())
-			return packageName, nil // 返回成功但记录警告
+// uniqueImages drop the repeated image names, the order is kept
+func uniqueImages(images []string) []string {
+	var unique []string
+	seen := make(map[string]struct{})
+	for _, name := range images {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		unique = append(unique, name)
+	}
+	return unique
+}
+
+// resolveImageDigest resolve image tags to digests in the registries
+var resolveImageDigest = image.ResolveDigest
+
+// pinImages pin the images of the components and plugins to their digests for online packages,
+// the registry credentials are stripped unless keepCredentials is set. timeout is the timeout
+// of resolving one image in seconds.
+func pinImages(ram v1alpha1.RainbondApplicationConfig, keepCredentials bool, timeout int, logger *logrus.Logger) error {
+	for _, component := range ram.Components {
+		if component.ShareImage != "" {
+			pinned, err := resolveImageDigest(component.ShareImage, component.AppImage.HubUser, component.AppImage.HubPassword, timeout)
+			if err != nil {
+				return err
+			}
+			if component.VM != nil {
+				for i := range component.VM.DiskLayout {
+					disk := &component.VM.DiskLayout[i]
+					if disk.SourceType == v1alpha1.VMDiskSourceRegistry && (disk.Image == component.ShareImage || disk.Image == "" && disk.DiskRole == v1alpha1.VMDiskRoleRoot) {
+						disk.Image = pinned
+					}
+				}
+			}
+			logger.Infof("pin component %s image to %s", component.ServiceCname, pinned)
+			component.ShareImage = pinned
+		}
+		if !keepCredentials {
+			component.AppImage.HubUser, component.AppImage.HubPassword = "", ""
+		}
+	}
+	for _, plugin := range ram.Plugins {
+		if plugin.ShareImage != "" {
+			pinned, err := resolveImageDigest(plugin.ShareImage, plugin.PluginImage.HubUser, plugin.PluginImage.HubPassword, timeout)
+			if err != nil {
+				return err
+			}
+			logger.Infof("pin plugin %s image to %s", plugin.PluginName, pinned)
+			plugin.ShareImage = pinned
+		}
+		if !keepCredentials {
+			plugin.PluginImage.HubUser, plugin.PluginImage.HubPassword = "", ""
 		}
-		return "", fmt.Errorf("error is [%s] , stderr is [%s]", err.Error(), stderr.String())
 	}
-	return packageName, nil
+	return nil
+}
+
+// ImageLayoutDir the OCI image layout of the package, see WithOCIImageLayout
+const ImageLayoutDir = "images"
+
+// storeImagesInLayout move the images of the saved tarballs into the OCI image layout of the package,
+// manifest.json of the layout lists the platform of multi-platform images
+func storeImagesInLayout(exportPath string, platform ocispec.Platform, logger *logrus.Logger) error {
+	layout, err := ocilayout.Create(path.Join(exportPath, ImageLayoutDir))
+	if err != nil {
+		return fmt.Errorf("create image layout failure %s", err.Error())
+	}
+	if err := layout.SetPlatform(platform); err != nil {
+		return err
+	}
+	for _, name := range []string{"component-images.tar", "plugin-images.tar"} {
+		tarball := path.Join(exportPath, name)
+		if _, err := os.Stat(tarball); os.IsNotExist(err) {
+			continue
+		}
+		images, err := layout.AddArchive(tarball)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(tarball); err != nil {
+			return err
+		}
+		logger.Infof("store %d images of %s in image layout", len(images), name)
+	}
+	return nil
+}
+
+// Packaging archive the export dir into homePath, the format follows the extension of packageName.
+// If splitSize is set the package is split into parts of at most splitSize bytes and the name of
+// the first part is returned, the import accepts the first part and reassembles the others.
+func Packaging(packageName, homePath, exportPath string, splitSize int64) (string, error) {
+	format := archive.DetectFormat(packageName)
+	logrus.Infof("package %s to %s in %s format", exportPath, packageName, format)
+	if err := archive.Pack(exportPath, path.Join(homePath, packageName), format); err != nil {
+		return "", err
+	}
+	if splitSize <= 0 {
+		return packageName, nil
+	}
+	index, err := archive.Split(path.Join(homePath, packageName), splitSize)
+	if err != nil {
+		return "", fmt.Errorf("split package %s failure %s", packageName, err.Error())
+	}
+	logrus.Infof("split package %s into %d parts", packageName, len(index.Parts))
+	return index.Parts[0].Name, nil
+}
+
+// packageFileName returns the package name of the app in the given format, e.g. app-1.0-ram.tar.gz
+func packageFileName(ram v1alpha1.RainbondApplicationConfig, kind string, format archive.Format) string {
+	return fmt.Sprintf("%s-%s-%s%s", ram.AppName, ram.AppVersion, kind, format.Ext())
+}
+
+// SortComponentsByDependency sort components so that every component comes after
+// the components it depends on, components in a dependency cycle keep their original order
+func SortComponentsByDependency(components []*v1alpha1.Component) []*v1alpha1.Component {
+	index := make(map[string]*v1alpha1.Component)
+	for _, com := range components {
+		index[com.ComponentKey] = com
+		if com.ServiceShareID != "" {
+			index[com.ServiceShareID] = com
+		}
+	}
+	var sorted []*v1alpha1.Component
+	visited := make(map[*v1alpha1.Component]int)
+	var visit func(com *v1alpha1.Component)
+	visit = func(com *v1alpha1.Component) {
+		// 1 visiting, 2 visited
+		if visited[com] != 0 {
+			return
+		}
+		visited[com] = 1
+		for _, dep := range com.DepServiceMapList {
+			if depCom, ok := index[dep.DepServiceKey]; ok {
+				visit(depCom)
+			}
+		}
+		visited[com] = 2
+		sorted = append(sorted, com)
+	}
+	for _, com := range components {
+		visit(com)
+	}
+	return sorted
 }
@@ -0,0 +1,155 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/vmdisk"
+)
+
+// VMDiskDir the disk files of the VM components in the package, see WithVMDisks
+const VMDiskDir = "vm-disks"
+
+// VMDiskImage returns the image the disk is pulled from, the root disk without image boots
+// from the component image. Disks not sourced from a registry have no image.
+func VMDiskImage(component *v1alpha1.Component, disk v1alpha1.VMDiskLayoutItem) string {
+	if disk.SourceType != v1alpha1.VMDiskSourceRegistry {
+		return ""
+	}
+	if disk.Image == "" && disk.DiskRole == v1alpha1.VMDiskRoleRoot {
+		return component.ShareImage
+	}
+	return disk.Image
+}
+
+// hasVMDisks returns true if a VM component has a disk pulled from a registry
+func hasVMDisks(ram v1alpha1.RainbondApplicationConfig) bool {
+	for _, component := range ram.Components {
+		if component.VM == nil {
+			continue
+		}
+		for _, disk := range component.VM.DiskLayout {
+			if VMDiskImage(component, disk) != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// extractVMDisks pull the disk images of the VM components and write their disks into
+// vm-disks/<component>/<disk_key>, the disks are checked against their checksums and the
+// files are recorded in the disk layouts. The images of the extracted disks are returned,
+// they are not saved into the image tarballs.
+func extractVMDisks(ctx *Context) (map[string]struct{}, error) {
+	var requests []image.PullRequest
+	for _, component := range ctx.RAM.Components {
+		if component.VM == nil {
+			continue
+		}
+		for _, disk := range component.VM.DiskLayout {
+			if name := VMDiskImage(component, disk); name != "" {
+				requests = append(requests, image.PullRequest{
+					Image:    name,
+					Username: component.AppImage.HubUser,
+					Password: component.AppImage.HubPassword,
+					Owners:   []string{"vm disk " + component.ServiceCname + "/" + disk.DiskKey},
+				})
+			}
+		}
+	}
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	if err := image.PullImages(ctx.ImageClient, requests, ctx.Pull, ctx.Logger); err != nil {
+		return nil, err
+	}
+	staging, err := ioutil.TempDir(ctx.HomePath, ".vm-disks")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	extracted := make(map[string]struct{})
+	used := make(map[string]struct{})
+	for _, component := range ctx.RAM.Components {
+		if component.VM == nil {
+			continue
+		}
+		componentDir := composeName(component.ServiceCname)
+		for i := 2; ; i++ {
+			if _, ok := used[componentDir]; !ok {
+				break
+			}
+			componentDir = fmt.Sprintf("%s-%d", composeName(component.ServiceCname), i)
+		}
+		used[componentDir] = struct{}{}
+		for i := range component.VM.DiskLayout {
+			disk := &component.VM.DiskLayout[i]
+			name := VMDiskImage(component, *disk)
+			if name == "" {
+				continue
+			}
+			diskDir := composeName(disk.DiskKey)
+			if diskDir == "" {
+				diskDir = fmt.Sprintf("disk-%d", i)
+			}
+			tarball := path.Join(staging, fmt.Sprintf("%s-%d.tar", componentDir, i))
+			if err := image.SaveImages(ctx.ImageClient, tarball, []string{name}, ctx.Pull); err != nil {
+				return nil, err
+			}
+			file, err := vmdisk.Extract(tarball, path.Join(ctx.ExportPath, VMDiskDir, componentDir, diskDir))
+			os.Remove(tarball)
+			if err != nil {
+				return nil, fmt.Errorf("extract disk %s of vm %s failure %s", disk.DiskKey, component.ServiceCname, err.Error())
+			}
+			if err := vmdisk.Verify(file, disk.Checksum); err != nil {
+				return nil, fmt.Errorf("verify disk %s of vm %s failure %s", disk.DiskKey, component.ServiceCname, err.Error())
+			}
+			disk.File = file[len(ctx.ExportPath)+1:]
+			extracted[name] = struct{}{}
+			ctx.Logger.Infof("extract disk %s of vm %s to %s", disk.DiskKey, component.ServiceCname, disk.File)
+		}
+	}
+	return extracted, nil
+}
+
+// withoutImages returns the app with the components of the images removed from the images
+// to save, the components are copied
+func withoutImages(ram v1alpha1.RainbondApplicationConfig, images map[string]struct{}) v1alpha1.RainbondApplicationConfig {
+	if len(images) == 0 {
+		return ram
+	}
+	var components []*v1alpha1.Component
+	for _, component := range ram.Components {
+		if _, ok := images[component.ShareImage]; ok {
+			copied := *component
+			copied.ShareImage = ""
+			component = &copied
+		}
+		components = append(components, component)
+	}
+	ram.Components = components
+	return ram
+}
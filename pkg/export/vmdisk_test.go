@@ -0,0 +1,141 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/vmdisk"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// diskImageClient saves the disk images as container disk images, other images are not written
+type diskImageClient struct {
+	image.Client
+	disks map[string]string
+	saves [][]string
+}
+
+func (c *diskImageClient) ImagePull(name string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	return &ocispec.ImageConfig{}, nil
+}
+
+func (c *diskImageClient) ImageSave(destination string, images []string) error {
+	c.saves = append(c.saves, images)
+	if len(images) == 1 && c.disks[images[0]] != "" {
+		return vmdisk.WriteImageArchive(c.disks[images[0]], images[0], destination)
+	}
+	return nil
+}
+
+func writeTestDisk(t *testing.T, name, body string) (string, string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(body))
+	return file, "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func newVMTestTemplate(rootChecksum string) v1alpha1.RainbondApplicationConfig {
+	return v1alpha1.RainbondApplicationConfig{
+		AppName:    "demo",
+		AppVersion: "1.0",
+		Components: []*v1alpha1.Component{
+			{ServiceCname: "web", ShareImage: "goodrain.me/web:v1"},
+			{ServiceCname: "vm", ShareImage: "goodrain.me/vm:v1", VM: &v1alpha1.VMTemplate{DiskLayout: []v1alpha1.VMDiskLayoutItem{
+				{DiskKey: "root", DiskRole: v1alpha1.VMDiskRoleRoot, SourceType: v1alpha1.VMDiskSourceRegistry, Format: "qcow2", Checksum: rootChecksum},
+				{DiskKey: "data-1", DiskRole: v1alpha1.VMDiskRoleData, SourceType: v1alpha1.VMDiskSourceRegistry, Format: "raw", Image: "goodrain.me/vm-data:v1"},
+			}}},
+		},
+	}
+}
+
+func TestExportVMDisksAsFiles(t *testing.T) {
+	root, checksum := writeTestDisk(t, "root.qcow2", "root disk")
+	data, _ := writeTestDisk(t, "data.raw", "data disk")
+	client := &diskImageClient{disks: map[string]string{"goodrain.me/vm:v1": root, "goodrain.me/vm-data:v1": data}}
+	home := t.TempDir()
+	p, err := newPipeline(RAM, home, newVMTestTemplate(checksum), client, logrus.StandardLogger(), newOptions(WithVMDisks()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Export(); err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadFile(path.Join(p.ctx.ExportPath, "metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ram v1alpha1.RainbondApplicationConfig
+	if err := json.Unmarshal(body, &ram); err != nil {
+		t.Fatal(err)
+	}
+	disks := ram.Components[1].VM.DiskLayout
+	if disks[0].File != "vm-disks/vm/root/root.qcow2" || disks[1].File != "vm-disks/vm/data-1/data.raw" {
+		t.Fatalf("expected the disk files to be recorded, got %+v", disks)
+	}
+	if got, _ := ioutil.ReadFile(path.Join(p.ctx.ExportPath, disks[1].File)); string(got) != "data disk" {
+		t.Fatalf("unexpected data disk %q", got)
+	}
+	last := client.saves[len(client.saves)-1]
+	if strings.Join(last, ",") != "goodrain.me/web:v1" {
+		t.Fatalf("expected the vm image not to be saved into the image tarball, got %v", last)
+	}
+}
+
+func TestExportVMDisksChecksChecksum(t *testing.T) {
+	root, _ := writeTestDisk(t, "root.qcow2", "root disk")
+	client := &diskImageClient{disks: map[string]string{"goodrain.me/vm:v1": root, "goodrain.me/vm-data:v1": root}}
+	p, err := newPipeline(RAM, t.TempDir(), newVMTestTemplate("sha256:"+strings.Repeat("0", 64)), client, logrus.StandardLogger(), newOptions(WithVMDisks()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Export(); err == nil || !strings.Contains(err.Error(), "verify disk root of vm vm failure") {
+		t.Fatalf("expected the corrupted disk to be rejected, got %v", err)
+	}
+}
+
+func TestExportVMDisksThroughImageSaver(t *testing.T) {
+	root, checksum := writeTestDisk(t, "root.qcow2", "root disk")
+	data, _ := writeTestDisk(t, "data.raw", "data disk")
+	client := &diskImageClient{disks: map[string]string{"goodrain.me/vm:v1": root, "goodrain.me/vm-data:v1": data}}
+	p, err := newPipeline(DC, t.TempDir(), newVMTestTemplate(checksum), client, logrus.StandardLogger(), newOptions(WithVMDisks()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Export(); err != nil {
+		t.Fatal(err)
+	}
+	last := client.saves[len(client.saves)-1]
+	if strings.Join(last, ",") != "goodrain.me/web:v1" {
+		t.Fatalf("expected the vm image not to be saved by the docker compose format, got %v", last)
+	}
+}
@@ -0,0 +1,149 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package installer installs the docker compose package of an app on a host without network
+// access. The exporter writes the bundle spec next to docker-compose.yaml and ships the installer
+// binary built from cmd/installer in the package, the installer loads the images of the package
+// through the local docker, renders the parameters and starts the services in dependency order.
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// BundleFileName the bundle spec at the root of the package
+	BundleFileName = "installer.json"
+	// BinaryName the installer binary at the root of the package
+	BinaryName = "installer"
+	// EnvFileName the env file of the compose file the parameters are rendered into
+	EnvFileName = ".env"
+	// ValuesFileName the values of the parameters kept in the install dir for upgrades
+	ValuesFileName = "values.env"
+)
+
+// Bundle describes the app of the package for the installer
+type Bundle struct {
+	AppName    string `json:"app_name"`
+	AppVersion string `json:"app_version"`
+	// Project compose project name, it stays the same across versions of the app
+	Project string `json:"project"`
+	// ComposeFile the compose file of the app, relative to the package root
+	ComposeFile string `json:"compose_file"`
+	// Images image archives or image layout dirs to load, relative to the package root
+	Images []string `json:"images,omitempty"`
+	// Arch architectures of the images, e.g. amd64, the host must be one of them
+	Arch []string `json:"arch,omitempty"`
+	// Services the services of the compose file in start order
+	Services []Service `json:"services"`
+	// Ports host ports published by the services
+	Ports []Port `json:"ports,omitempty"`
+	// Parameters the envs set at install time
+	Parameters []Parameter `json:"parameters,omitempty"`
+}
+
+// Service a service of the compose file
+type Service struct {
+	Name      string   `json:"name"`
+	DependsOn []string `json:"depends_on,omitempty"`
+	// Healthcheck the service is started once it is healthy, otherwise once it is running
+	Healthcheck bool `json:"healthcheck,omitempty"`
+}
+
+// Port a published host port
+type Port struct {
+	Service  string `json:"service"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+// Parameter an env of a service set at install time
+type Parameter struct {
+	// Name the key of the parameter in the values file, e.g. WEB_DB_PASSWORD
+	Name    string `json:"name"`
+	Service string `json:"service"`
+	Env     string `json:"env"`
+	Default string `json:"default,omitempty"`
+	// Description shown when the value is asked for
+	Description string `json:"description,omitempty"`
+	// Secret the value is generated at install time instead of asked for, it is kept on upgrades
+	Secret bool `json:"secret,omitempty"`
+}
+
+// ReadBundle read the bundle spec in the package dir
+func ReadBundle(dir string) (*Bundle, error) {
+	body, err := ioutil.ReadFile(filepath.Join(dir, BundleFileName))
+	if err != nil {
+		return nil, err
+	}
+	var bundle Bundle
+	if err := json.Unmarshal(body, &bundle); err != nil {
+		return nil, fmt.Errorf("parse bundle spec failure %s", err.Error())
+	}
+	if bundle.Project == "" || bundle.ComposeFile == "" {
+		return nil, fmt.Errorf("bundle spec has no project or compose file")
+	}
+	return &bundle, nil
+}
+
+// Write write the bundle spec into the package dir
+func (b *Bundle) Write(dir string) error {
+	body, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, BundleFileName), body, 0644)
+}
+
+// SortServices sort the services so that every service comes after the services it depends on,
+// services without dependencies between them are sorted by name
+func SortServices(services []Service) []Service {
+	index := make(map[string]Service)
+	var names []string
+	for _, service := range services {
+		index[service.Name] = service
+		names = append(names, service.Name)
+	}
+	sort.Strings(names)
+	var sorted []Service
+	visited := make(map[string]int)
+	var visit func(name string)
+	visit = func(name string) {
+		// 1 visiting, 2 visited, dependency cycles are broken at the visiting service
+		service, ok := index[name]
+		if !ok || visited[name] != 0 {
+			return
+		}
+		visited[name] = 1
+		deps := append([]string{}, service.DependsOn...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			visit(dep)
+		}
+		visited[name] = 2
+		sorted = append(sorted, service)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return sorted
+}
@@ -0,0 +1,415 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultInstallRoot the install dir of the app is <root>/<project> by default
+const DefaultInstallRoot = "/opt/rainbond"
+
+// Runner runs the docker commands of the installer
+type Runner interface {
+	Run(stdin io.Reader, name string, args ...string) ([]byte, error)
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(stdin io.Reader, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, fmt.Errorf("%s %s failure %s %s", name, strings.Join(args, " "), err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Installer installs, upgrades and uninstalls the app of a package
+type Installer struct {
+	// PackageDir the extracted package the installer runs from
+	PackageDir string
+	// Dir the compose files and the values of the installed app are kept in
+	Dir string
+	// ValuesFile values of the parameters, the values not in it are asked for
+	ValuesFile string
+	// In the values not known are read from it, the defaults are used if it is nil
+	In  io.Reader
+	Out io.Writer
+	// Timeout time a service has to become running or healthy
+	Timeout time.Duration
+	// SkipPreflight install without checking the host
+	SkipPreflight bool
+	Runner        Runner
+	Logger        *logrus.Logger
+
+	bundle       *Bundle
+	compose      []string
+	pollInterval time.Duration
+}
+
+// New returns the installer of the package in packageDir, the app is installed into
+// dir or <DefaultInstallRoot>/<project> if dir is empty
+func New(packageDir, dir string) (*Installer, error) {
+	bundle, err := ReadBundle(packageDir)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		dir = filepath.Join(DefaultInstallRoot, bundle.Project)
+	}
+	return &Installer{
+		PackageDir:   packageDir,
+		Dir:          dir,
+		Out:          os.Stdout,
+		Timeout:      5 * time.Minute,
+		Runner:       execRunner{},
+		Logger:       logrus.StandardLogger(),
+		bundle:       bundle,
+		pollInterval: 2 * time.Second,
+	}, nil
+}
+
+// Bundle returns the bundle spec of the package
+func (i *Installer) Bundle() *Bundle {
+	return i.bundle
+}
+
+// Installed returns true if the app is installed in the install dir
+func (i *Installer) Installed() bool {
+	_, err := os.Stat(filepath.Join(i.Dir, ValuesFileName))
+	return err == nil
+}
+
+// Install install the app, it fails if the app is already installed
+func (i *Installer) Install() error {
+	if i.Installed() {
+		return fmt.Errorf("app %s is already installed in %s, upgrade it instead", i.bundle.AppName, i.Dir)
+	}
+	if err := i.preflight(false); err != nil {
+		return err
+	}
+	known, err := i.knownValues(nil)
+	if err != nil {
+		return err
+	}
+	if err := i.deploy(known); err != nil {
+		return err
+	}
+	i.Logger.Infof("app %s %s is installed in %s", i.bundle.AppName, i.bundle.AppVersion, i.Dir)
+	return nil
+}
+
+// Upgrade upgrade the installed app to the version of the package, the values of the
+// installed app are kept and only the new parameters are asked for
+func (i *Installer) Upgrade() error {
+	if !i.Installed() {
+		return fmt.Errorf("app %s is not installed in %s, install it first", i.bundle.AppName, i.Dir)
+	}
+	installed, err := ReadBundle(i.Dir)
+	if err != nil {
+		return fmt.Errorf("read installed app failure %s", err.Error())
+	}
+	if installed.Project != i.bundle.Project {
+		return fmt.Errorf("%s is installed in %s, not %s", installed.AppName, i.Dir, i.bundle.AppName)
+	}
+	if err := i.preflight(true); err != nil {
+		return err
+	}
+	previous, err := ReadValues(filepath.Join(i.Dir, ValuesFileName))
+	if err != nil {
+		return err
+	}
+	known, err := i.knownValues(previous)
+	if err != nil {
+		return err
+	}
+	i.Logger.Infof("upgrade app %s from %s to %s", i.bundle.AppName, installed.AppVersion, i.bundle.AppVersion)
+	if err := i.deploy(known); err != nil {
+		return err
+	}
+	i.Logger.Infof("app %s is upgraded to %s", i.bundle.AppName, i.bundle.AppVersion)
+	return nil
+}
+
+// Start start the services of the installed app in dependency order
+func (i *Installer) Start() error {
+	if !i.Installed() {
+		return fmt.Errorf("app %s is not installed in %s", i.bundle.AppName, i.Dir)
+	}
+	if err := i.detectCompose(); err != nil {
+		return err
+	}
+	return i.startServices()
+}
+
+// Stop stop the services of the installed app
+func (i *Installer) Stop() error {
+	if err := i.detectCompose(); err != nil {
+		return err
+	}
+	_, err := i.runCompose("stop")
+	return err
+}
+
+// Uninstall remove the services and the install dir of the app, the volumes are removed if purge is set
+func (i *Installer) Uninstall(purge bool) error {
+	if !i.Installed() {
+		return fmt.Errorf("app %s is not installed in %s", i.bundle.AppName, i.Dir)
+	}
+	if err := i.detectCompose(); err != nil {
+		return err
+	}
+	args := []string{"down", "--remove-orphans"}
+	if purge {
+		args = append(args, "--volumes")
+	}
+	if _, err := i.runCompose(args...); err != nil {
+		return err
+	}
+	i.Logger.Infof("app %s is uninstalled", i.bundle.AppName)
+	return os.RemoveAll(i.Dir)
+}
+
+// knownValues returns the previous values overridden by the values file
+func (i *Installer) knownValues(previous map[string]string) (map[string]string, error) {
+	known := make(map[string]string)
+	for k, v := range previous {
+		known[k] = v
+	}
+	if i.ValuesFile != "" {
+		values, err := ReadValues(i.ValuesFile)
+		if err != nil {
+			return nil, fmt.Errorf("read values file failure %s", err.Error())
+		}
+		for k, v := range values {
+			known[k] = v
+		}
+	}
+	return known, nil
+}
+
+// deploy copy the package into the install dir, render the values, load the images and start the services
+func (i *Installer) deploy(known map[string]string) error {
+	values, err := resolveValues(i.bundle.Parameters, known, i.In, i.Out)
+	if err != nil {
+		return err
+	}
+	if err := i.copyPackage(); err != nil {
+		return fmt.Errorf("copy package into %s failure %s", i.Dir, err.Error())
+	}
+	if err := WriteValues(filepath.Join(i.Dir, ValuesFileName), values); err != nil {
+		return err
+	}
+	if len(i.bundle.Parameters) > 0 {
+		if err := writeEnvFile(filepath.Join(i.Dir, EnvFileName), i.bundle.Parameters, values); err != nil {
+			return err
+		}
+	}
+	if err := i.loadImages(); err != nil {
+		return err
+	}
+	return i.startServices()
+}
+
+// copyPackage copy the files of the package except the images into the install dir
+func (i *Installer) copyPackage() error {
+	if samePath(i.PackageDir, i.Dir) {
+		return nil
+	}
+	images := make(map[string]struct{})
+	for _, image := range i.bundle.Images {
+		images[filepath.Clean(image)] = struct{}{}
+	}
+	return filepath.Walk(i.PackageDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(i.PackageDir, file)
+		if err != nil {
+			return err
+		}
+		if _, ok := images[rel]; ok {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(i.Dir, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(file, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(source, target string, perm os.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func samePath(a, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && a == b
+}
+
+// loadImages load the image archives and image layouts of the package into the local docker
+func (i *Installer) loadImages() error {
+	for _, image := range i.bundle.Images {
+		source := filepath.Join(i.PackageDir, image)
+		info, err := os.Stat(source)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		if info.IsDir() {
+			// docker loads the OCI image layout as a tar stream
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(tarDir(source, pw))
+			}()
+			_, err = i.Runner.Run(pr, "docker", "load")
+			pr.Close()
+		} else {
+			_, err = i.Runner.Run(nil, "docker", "load", "-i", source)
+		}
+		if err != nil {
+			return fmt.Errorf("load images of %s failure %s", image, err.Error())
+		}
+		i.Logger.Infof("load images of %s success, take %s", image, time.Since(start))
+	}
+	return nil
+}
+
+func tarDir(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// startServices start the services one by one in dependency order, every service is
+// started once the services it depends on are running or healthy
+func (i *Installer) startServices() error {
+	for n, service := range i.bundle.Services {
+		args := []string{"up", "-d", "--no-deps"}
+		if n == 0 {
+			args = append(args, "--remove-orphans")
+		}
+		if _, err := i.runCompose(append(args, service.Name)...); err != nil {
+			return err
+		}
+		if err := i.waitService(service); err != nil {
+			return err
+		}
+		i.Logger.Infof("service %s is started", service.Name)
+	}
+	return nil
+}
+
+// waitService wait for the container of the service to be healthy, or running if it has no healthcheck
+func (i *Installer) waitService(service Service) error {
+	deadline := time.Now().Add(i.Timeout)
+	for {
+		out, err := i.Runner.Run(nil, "docker", "inspect", "-f", "{{if .State.Health}}{{.State.Health.Status}}{{else}}{{.State.Status}}{{end}}", service.Name)
+		if err != nil {
+			return err
+		}
+		switch status := strings.TrimSpace(string(out)); status {
+		case "healthy":
+			return nil
+		case "running":
+			if !service.Healthcheck {
+				return nil
+			}
+		case "unhealthy", "exited", "dead":
+			return fmt.Errorf("service %s is %s, check it with docker logs %s", service.Name, status, service.Name)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s is not ready in %s", service.Name, i.Timeout)
+		}
+		time.Sleep(i.pollInterval)
+	}
+}
+
+func (i *Installer) runCompose(args ...string) ([]byte, error) {
+	command := append([]string{}, i.compose[1:]...)
+	command = append(command, "-p", i.bundle.Project, "--project-directory", i.Dir, "-f", filepath.Join(i.Dir, i.bundle.ComposeFile))
+	return i.Runner.Run(nil, i.compose[0], append(command, args...)...)
+}
@@ -0,0 +1,207 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeRunner records the commands, containers are running or healthy as soon as they are inspected
+type fakeRunner struct {
+	commands []string
+	// noPlugin docker compose v2 is not installed
+	noPlugin bool
+}
+
+func (f *fakeRunner) Run(stdin io.Reader, name string, args ...string) ([]byte, error) {
+	command := strings.Join(append([]string{name}, args...), " ")
+	f.commands = append(f.commands, command)
+	switch {
+	case name == "docker" && len(args) > 0 && args[0] == "compose" && args[1] == "version" && f.noPlugin:
+		return nil, os.ErrNotExist
+	case name == "docker" && len(args) > 0 && args[0] == "inspect" && args[len(args)-1] == "db":
+		return []byte("healthy\n"), nil
+	case name == "docker" && len(args) > 0 && args[0] == "inspect":
+		return []byte("running\n"), nil
+	case name == "docker" && len(args) > 0 && args[0] == "info":
+		return []byte(os.TempDir()), nil
+	}
+	return nil, nil
+}
+
+func (f *fakeRunner) find(prefix string) []string {
+	var found []string
+	for _, command := range f.commands {
+		if strings.HasPrefix(command, prefix) {
+			found = append(found, command)
+		}
+	}
+	return found
+}
+
+func writeTestPackage(t *testing.T, bundle *Bundle) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := bundle.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{"docker-compose.yaml": "services: {}\n", "component-images.tar": "images", "gateway/nginx.conf": "events {}\n", EnvFileName: "# title of the site\nWEB_TITLE=demo\n# db ROOT_PASSWORD, generated secret\nDB_ROOT_PASSWORD=\n"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func newTestBundle() *Bundle {
+	return &Bundle{
+		AppName:     "demo",
+		AppVersion:  "1.0",
+		Project:     "demo",
+		ComposeFile: "docker-compose.yaml",
+		Images:      []string{"component-images.tar", "images"},
+		Services: SortServices([]Service{
+			{Name: "web", DependsOn: []string{"db"}},
+			{Name: "db", Healthcheck: true},
+			{Name: "gateway", DependsOn: []string{"web"}},
+		}),
+		Parameters: []Parameter{
+			{Name: "WEB_TITLE", Service: "web", Env: "TITLE", Default: "demo"},
+			{Name: "DB_ROOT_PASSWORD", Service: "db", Env: "ROOT_PASSWORD", Secret: true},
+		},
+	}
+}
+
+func newTestInstaller(t *testing.T, packageDir, dir string) (*Installer, *fakeRunner) {
+	t.Helper()
+	i, err := New(packageDir, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := &fakeRunner{noPlugin: true}
+	i.Runner = runner
+	i.Out = ioutil.Discard
+	i.Logger = logrus.StandardLogger()
+	i.pollInterval = 0
+	return i, runner
+}
+
+func TestSortServices(t *testing.T) {
+	var names []string
+	for _, service := range newTestBundle().Services {
+		names = append(names, service.Name)
+	}
+	if strings.Join(names, ",") != "db,web,gateway" {
+		t.Fatalf("unexpected start order %v", names)
+	}
+}
+
+func TestInstallUpgradeUninstall(t *testing.T) {
+	packageDir := writeTestPackage(t, newTestBundle())
+	dir := filepath.Join(t.TempDir(), "demo")
+	values := filepath.Join(t.TempDir(), "values.env")
+	ioutil.WriteFile(values, []byte("# site values\nWEB_TITLE=\"Demo Site\"\n"), 0644)
+
+	i, runner := newTestInstaller(t, packageDir, dir)
+	i.ValuesFile = values
+	i.SkipPreflight = true
+	if err := i.Install(); err != nil {
+		t.Fatal(err)
+	}
+	if loads := runner.find("docker load"); len(loads) != 1 || !strings.HasSuffix(loads[0], "component-images.tar") {
+		t.Fatalf("expected the image archive to be loaded, got %v", loads)
+	}
+	ups := runner.find("docker-compose -p")
+	var started []string
+	for _, up := range ups {
+		if strings.Contains(up, " up -d ") {
+			started = append(started, up[strings.LastIndex(up, " ")+1:])
+		}
+	}
+	if strings.Join(started, ",") != "db,web,gateway" {
+		t.Fatalf("expected the services to be started in dependency order, got %v", ups)
+	}
+	env, _ := ioutil.ReadFile(filepath.Join(dir, EnvFileName))
+	if !strings.Contains(string(env), "# title of the site\nWEB_TITLE='Demo Site'\n") {
+		t.Fatalf("unexpected env file %s", env)
+	}
+	secret := regexp.MustCompile(`DB_ROOT_PASSWORD=([0-9a-f]+)\n`).FindStringSubmatch(string(env))
+	if len(secret) != 2 || len(secret[1]) != 32 {
+		t.Fatalf("expected the secret to be generated, got %s", env)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "component-images.tar")); !os.IsNotExist(err) {
+		t.Fatal("expected the images not to be copied into the install dir")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gateway", "nginx.conf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Install(); err == nil {
+		t.Fatal("expected installing twice to fail")
+	}
+
+	// the new version asks for the new parameter only
+	bundle := newTestBundle()
+	bundle.AppVersion = "1.1"
+	bundle.Parameters = append(bundle.Parameters, Parameter{Name: "DB_PASSWORD", Service: "db", Env: "PASSWORD", Default: "changeme"})
+	upgrade, _ := newTestInstaller(t, writeTestPackage(t, bundle), dir)
+	upgrade.SkipPreflight = true
+	upgrade.In = strings.NewReader("s3cret\n")
+	if err := upgrade.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadValues(filepath.Join(dir, ValuesFileName))
+	if err != nil || got["WEB_TITLE"] != "Demo Site" || got["DB_PASSWORD"] != "s3cret" || got["DB_ROOT_PASSWORD"] != secret[1] {
+		t.Fatalf("unexpected values %v %v", got, err)
+	}
+	installed, err := ReadBundle(dir)
+	if err != nil || installed.AppVersion != "1.1" {
+		t.Fatalf("expected the installed bundle to be upgraded, got %+v %v", installed, err)
+	}
+
+	remove, runner := newTestInstaller(t, dir, "")
+	remove.Dir = dir
+	if err := remove.Uninstall(true); err != nil {
+		t.Fatal(err)
+	}
+	if downs := runner.find("docker-compose -p"); len(downs) != 1 || !strings.HasSuffix(downs[0], "down --remove-orphans --volumes") {
+		t.Fatalf("unexpected uninstall commands %v", runner.commands)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatal("expected the install dir to be removed")
+	}
+}
+
+func TestPreflightChecksArch(t *testing.T) {
+	bundle := newTestBundle()
+	bundle.Arch = []string{"s390x-unknown"}
+	i, _ := newTestInstaller(t, writeTestPackage(t, bundle), t.TempDir())
+	problems := strings.Join(i.Preflight(), ";")
+	if !strings.Contains(problems, "the images are built for s390x-unknown") {
+		t.Fatalf("expected the architecture to be checked, got %s", problems)
+	}
+}
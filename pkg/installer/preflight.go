@@ -0,0 +1,153 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Preflight check the host can run the app: docker and compose are installed, the host
+// architecture matches the images, the docker root dir has space for the images and the
+// published ports are free. It returns the problems found.
+func (i *Installer) Preflight() []string {
+	return i.checkHost(true)
+}
+
+func (i *Installer) preflight(upgrade bool) error {
+	if i.SkipPreflight {
+		return i.detectCompose()
+	}
+	// the ports of an installed app are published by the app itself
+	if problems := i.checkHost(!upgrade); len(problems) > 0 {
+		return fmt.Errorf("preflight failure: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (i *Installer) checkHost(ports bool) []string {
+	var problems []string
+	if _, err := i.Runner.Run(nil, "docker", "version", "--format", "{{.Server.Version}}"); err != nil {
+		problems = append(problems, "docker is not installed or the docker daemon is not running")
+	} else if err := i.detectCompose(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(i.bundle.Arch) > 0 {
+		supported := false
+		for _, arch := range i.bundle.Arch {
+			if normalizeArch(arch) == runtime.GOARCH {
+				supported = true
+			}
+		}
+		if !supported {
+			problems = append(problems, fmt.Sprintf("the images are built for %s, the host is %s", strings.Join(i.bundle.Arch, ","), runtime.GOARCH))
+		}
+	}
+	if problem := i.checkDisk(); problem != "" {
+		problems = append(problems, problem)
+	}
+	if ports {
+		for _, port := range i.bundle.Ports {
+			if !portFree(port) {
+				problems = append(problems, fmt.Sprintf("port %d/%s of service %s is in use", port.Port, port.Protocol, port.Service))
+			}
+		}
+	}
+	return problems
+}
+
+// detectCompose use docker compose v2, or docker-compose if the plugin is not installed
+func (i *Installer) detectCompose() error {
+	if i.compose != nil {
+		return nil
+	}
+	if _, err := i.Runner.Run(nil, "docker", "compose", "version"); err == nil {
+		i.compose = []string{"docker", "compose"}
+		return nil
+	}
+	if _, err := i.Runner.Run(nil, "docker-compose", "version"); err == nil {
+		i.compose = []string{"docker-compose"}
+		return nil
+	}
+	return fmt.Errorf("neither docker compose nor docker-compose is installed")
+}
+
+// checkDisk check the docker root dir has space for the images of the package
+func (i *Installer) checkDisk() string {
+	var size int64
+	for _, image := range i.bundle.Images {
+		size += pathSize(filepath.Join(i.PackageDir, image))
+	}
+	if size == 0 {
+		return ""
+	}
+	out, err := i.Runner.Run(nil, "docker", "info", "--format", "{{.DockerRootDir}}")
+	if err != nil {
+		return ""
+	}
+	free, ok := freeSpace(strings.TrimSpace(string(out)))
+	if !ok || free >= uint64(size) {
+		return ""
+	}
+	return fmt.Sprintf("the docker root dir has %d MB free, the images need %d MB", free>>20, size>>20)
+}
+
+func pathSize(file string) int64 {
+	var size int64
+	filepath.Walk(file, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func portFree(port Port) bool {
+	address := fmt.Sprintf(":%d", port.Port)
+	if port.Protocol == "udp" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// normalizeArch returns the go architecture of the arch reported by uname or the template
+func normalizeArch(arch string) string {
+	switch strings.ToLower(arch) {
+	case "x86_64", "x86-64":
+		return "amd64"
+	case "aarch64", "armv8":
+		return "arm64"
+	}
+	return strings.ToLower(arch)
+}
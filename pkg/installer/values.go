@@ -0,0 +1,162 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ReadValues read a values file of KEY=VALUE lines, empty lines and lines starting
+// with # are skipped, the value may be quoted
+func ReadValues(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		kv := strings.SplitN(text, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid value at line %d of %s", line, filepath.Base(file))
+		}
+		value := strings.TrimSpace(kv[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(kv[0])] = value
+	}
+	return values, scanner.Err()
+}
+
+// WriteValues write the values as a values file
+func WriteValues(file string, values map[string]string) error {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s=%q\n", key, values[key])
+	}
+	return ioutil.WriteFile(file, []byte(b.String()), 0600)
+}
+
+// resolveValues returns the values of all parameters. Values already known, from the values file
+// or the previous install, are kept; the others are asked for if in is set, else the defaults are used.
+func resolveValues(parameters []Parameter, known map[string]string, in io.Reader, out io.Writer) (map[string]string, error) {
+	values := make(map[string]string)
+	var reader *bufio.Reader
+	if in != nil {
+		reader = bufio.NewReader(in)
+	}
+	for _, p := range parameters {
+		if value, ok := known[p.Name]; ok {
+			values[p.Name] = value
+			continue
+		}
+		if p.Secret && p.Default == "" {
+			secret, err := generateSecret()
+			if err != nil {
+				return nil, fmt.Errorf("generate secret %s failure %s", p.Name, err.Error())
+			}
+			values[p.Name] = secret
+			continue
+		}
+		if reader == nil {
+			values[p.Name] = p.Default
+			continue
+		}
+		description := p.Description
+		if description == "" {
+			description = p.Env
+		}
+		fmt.Fprintf(out, "%s of %s (%s) [%s]: ", description, p.Service, p.Name, p.Default)
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if value := strings.TrimSpace(line); value != "" {
+			values[p.Name] = value
+		} else {
+			values[p.Name] = p.Default
+		}
+	}
+	return values, nil
+}
+
+// writeEnvFile render the values of the parameters into the env file of the compose file,
+// the comments of the env file shipped in the package are kept
+func writeEnvFile(file string, parameters []Parameter, values map[string]string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	rendered := make(map[string]bool)
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if value, ok := values[strings.TrimSpace(kv[0])]; ok && len(kv) == 2 && !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			line = kv[0] + "=" + envValue(value)
+			rendered[strings.TrimSpace(kv[0])] = true
+		}
+		lines = append(lines, line)
+	}
+	for _, p := range parameters {
+		if !rendered[p.Name] {
+			lines = append(lines, p.Name+"="+envValue(values[p.Name]))
+		}
+	}
+	return ioutil.WriteFile(file, []byte(strings.TrimLeft(strings.Join(lines, "\n"), "\n")+"\n"), 0600)
+}
+
+// envValue quote the value for the env file, single quoted values are not interpolated by compose
+func envValue(value string) string {
+	if !strings.ContainsAny(value, " \t#'\"$\\") {
+		return value
+	}
+	if !strings.Contains(value, "'") {
+		return "'" + value + "'"
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$").Replace(value) + `"`
+}
+
+// generateSecret returns a random hex value of the secret parameters
+func generateSecret() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
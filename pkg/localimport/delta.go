@@ -0,0 +1,104 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package localimport
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/export"
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/docker"
+	"github.com/goodrain/rainbond-oam/pkg/util/ocilayout"
+)
+
+// restoreBaseLayers put the layers not shipped by the incremental package back into its image
+// tarballs. The layers are read from the base images, which must be present locally or in the
+// target registry, otherwise the import is refused.
+func (r *ramImport) restoreBaseLayers(packagePath string, hubInfo v1alpha1.ImageInfo) error {
+	manifest, err := export.ReadManifest(packagePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if manifest.Base == nil {
+		return nil
+	}
+	r.logger.Infof("package is exported against %s %s, restore the base layers", manifest.Base.AppName, manifest.Base.AppVersion)
+	sourceDir, err := ioutil.TempDir(r.homeDir, ".base-images")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(sourceDir)
+	// base images shared by several tarballs are saved once
+	sources := make(map[string]string)
+	for _, image := range manifest.Images {
+		var files []string
+		for _, tag := range image.BaseRepoTags {
+			source, ok := sources[tag]
+			if !ok {
+				source = r.saveBaseImage(tag, hubInfo, path.Join(sourceDir, fmt.Sprintf("%d.tar", len(sources))))
+				sources[tag] = source
+			}
+			if source != "" {
+				files = append(files, source)
+			}
+		}
+		restore := export.RestoreImageTarball
+		if ocilayout.IsLayout(path.Join(packagePath, image.Path)) {
+			restore = export.RestoreImageLayout
+		}
+		missing, err := restore(path.Join(packagePath, image.Path), image, files)
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("base layers %s of %s are neither present locally nor in the target registry, import the base package %s %s first",
+				strings.Join(missing, ", "), image.Path, manifest.Base.AppName, manifest.Base.AppVersion)
+		}
+	}
+	return nil
+}
+
+// saveBaseImage save the base image from the local store, or pull it from the target registry
+// where the base package was pushed to. An empty path is returned if the image is not found.
+func (r *ramImport) saveBaseImage(image string, hubInfo v1alpha1.ImageInfo, destination string) string {
+	if err := r.imageClient.ImageSave(destination, []string{image}); err == nil {
+		return destination
+	}
+	pushed, err := docker.NewImageName(image, hubInfo)
+	if err != nil {
+		r.logger.Warningf("parse base image %s failure %s", image, err.Error())
+		return ""
+	}
+	if _, err := r.imageClient.ImagePull(pushed, hubInfo.HubUser, hubInfo.HubPassword, 20); err != nil {
+		r.logger.Warningf("base image %s is not found locally or in the target registry: %s", image, err.Error())
+		return ""
+	}
+	if err := r.imageClient.ImageSave(destination, []string{pushed}); err != nil {
+		r.logger.Warningf("save base image %s failure %s", pushed, err.Error())
+		return ""
+	}
+	return destination
+}
@@ -22,16 +22,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/platforms"
 	dockercli "github.com/docker/docker/client"
 	"github.com/goodrain/rainbond-oam/pkg/export"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
 	"github.com/goodrain/rainbond-oam/pkg/util"
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
 	"github.com/goodrain/rainbond-oam/pkg/util/docker"
 	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/ocilayout"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -40,17 +46,48 @@ type AppLocalImport interface {
 	Import(filePath string, hubInfo v1alpha1.ImageInfo) (*v1alpha1.RainbondApplicationConfig, error)
 }
 
+// Options import options
+type Options struct {
+	// TrustPolicy only packages signed by the trusted keys are imported if it is set
+	TrustPolicy *export.TrustPolicy
+	// Platform the platform of the target registry, e.g. linux/arm64, the platform of the host by default
+	Platform string
+}
+
+// Option set import option
+type Option func(*Options)
+
+// WithTrustPolicy reject unsigned packages and packages not signed by the trusted keys
+func WithTrustPolicy(policy *export.TrustPolicy) Option {
+	return func(o *Options) {
+		o.TrustPolicy = policy
+	}
+}
+
+// WithPlatform reject packages with images not of the platform of the target registry
+func WithPlatform(platform string) Option {
+	return func(o *Options) {
+		o.Platform = platform
+	}
+}
+
 // New new
-func New(logger *logrus.Logger, containerdCli *containerd.Client, dockerCli *dockercli.Client, homeDir string) (AppLocalImport, error) {
+func New(logger *logrus.Logger, containerdCli *containerd.Client, dockerCli *dockercli.Client, homeDir string, opts ...Option) (AppLocalImport, error) {
 	imageClient, err := image.NewClient(containerdCli, dockerCli)
 	if err != nil {
 		logger.Errorf("create image client error: %v", err)
 		return nil, err
 	}
+	var options Options
+	for _, opt := range opts {
+		opt(&options)
+	}
 	return &ramImport{
 		logger:      logger,
 		imageClient: imageClient,
 		homeDir:     homeDir,
+		trustPolicy: options.TrustPolicy,
+		platform:    options.Platform,
 	}, nil
 }
 
@@ -58,6 +95,8 @@ type ramImport struct {
 	logger      *logrus.Logger
 	imageClient image.Client
 	homeDir     string
+	trustPolicy *export.TrustPolicy
+	platform    string
 }
 
 func rewriteComponentVMImageReferences(component *v1alpha1.Component, previousImage, newImage string) {
@@ -84,8 +123,19 @@ func (r *ramImport) Import(filePath string, hubInfo v1alpha1.ImageInfo) (*v1alph
 		r.logger.Errorf("prepare import dir failure %s", err.Error())
 		return nil, err
 	}
+	partIndex, err := archive.FindPartIndex(filePath)
+	if err != nil {
+		r.logger.Errorf("read package %s failure %s", filePath, err.Error())
+		return nil, err
+	}
 	ext := path.Ext(filePath)
-	if ext == ".zip" {
+	if partIndex != "" {
+		// the parts are extracted as one stream, no reassembled copy is written
+		if err := archive.UnpackParts(partIndex, r.homeDir); err != nil {
+			r.logger.Errorf("unpack split package %s failure %s", filePath, err.Error())
+			return nil, err
+		}
+	} else if ext == ".zip" {
 		if err := util.Unzip(filePath, r.homeDir); err != nil {
 			r.logger.Errorf("unzip file %s faile %s", filePath, err.Error())
 			return nil, err
@@ -102,6 +152,13 @@ func (r *ramImport) Import(filePath string, hubInfo v1alpha1.ImageInfo) (*v1alph
 	if len(files) < 1 {
 		return nil, fmt.Errorf("Failed to read files in tmp dir %s", r.homeDir)
 	}
+	if err := r.verifyPackage(path.Join(r.homeDir, files[0].Name())); err != nil {
+		return nil, err
+	}
+	if err := r.restoreBaseLayers(path.Join(r.homeDir, files[0].Name()), hubInfo); err != nil {
+		r.logger.Errorf("restore base layers failure %s", err.Error())
+		return nil, err
+	}
 	metaFile, err := os.Open(path.Join(r.homeDir, files[0].Name(), "metadata.json"))
 	if err != nil {
 		return nil, fmt.Errorf("Failed to read files in tmp dir %s: %v", r.homeDir, err)
@@ -115,6 +172,15 @@ func (r *ramImport) Import(filePath string, hubInfo v1alpha1.ImageInfo) (*v1alph
 	if err := ram.Validation(); err != nil {
 		return nil, fmt.Errorf("invalid ram meta file: %v", err)
 	}
+	manifest, err := export.ReadManifest(path.Join(r.homeDir, files[0].Name()))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// online packages carry no images, the pinned references are kept
+	if manifest != nil && manifest.Mode == export.OnlineMode {
+		r.logger.Infof("package is exported in online mode, images are pulled from their registries")
+		return &ram, nil
+	}
 	// load all component images and plugin images
 	//after v5.3 package
 	l1, err := util.GetFileList(path.Join(r.homeDir, files[0].Name()), 1)
@@ -132,6 +198,11 @@ func (r *ramImport) Import(filePath string, hubInfo v1alpha1.ImageInfo) (*v1alph
 			continue
 		}
 		if strings.HasSuffix(f, ".tar") {
+			// only the tarballs the manifest vouches for are loaded
+			if rel, _ := filepath.Rel(path.Join(r.homeDir, files[0].Name()), f); manifest != nil && !manifest.Lists(filepath.ToSlash(rel)) {
+				r.logger.Warningf("skip image file %s, it is not in the package manifest", f)
+				continue
+			}
 			err = r.imageClient.ImageLoad(f)
 			if err != nil {
 				if err.Error() != "unrecognized image format" {
@@ -142,6 +213,15 @@ func (r *ramImport) Import(filePath string, hubInfo v1alpha1.ImageInfo) (*v1alph
 			r.logger.Infof("load image from file %s success", f)
 		}
 	}
+	if layoutDir := path.Join(r.homeDir, files[0].Name(), export.ImageLayoutDir); ocilayout.IsLayout(layoutDir) && (manifest == nil || manifest.Lists(export.ImageLayoutDir)) {
+		if err := r.loadImageLayout(layoutDir); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.loadVMDisks(path.Join(r.homeDir, files[0].Name()), &ram, hubInfo); err != nil {
+		r.logger.Errorf("load vm disks failure %s", err.Error())
+		return nil, err
+	}
 	for _, com := range ram.Components {
 		// new hub info
 		previousImage := com.ShareImage
@@ -210,3 +290,116 @@ func (r *ramImport) Import(filePath string, hubInfo v1alpha1.ImageInfo) (*v1alph
 	}
 	return &ram, nil
 }
+
+// loadImageLayout load the images stored in the OCI image layout of the package, the layout is
+// written into an archive that both docker load and containerd import accept
+func (r *ramImport) loadImageLayout(layoutDir string) error {
+	layout, err := ocilayout.Open(layoutDir)
+	if err != nil {
+		return err
+	}
+	// docker load reads the manifest of multi-platform images listed in manifest.json
+	target, err := r.targetPlatform()
+	if err != nil {
+		return err
+	}
+	if err := layout.SetPlatform(target); err != nil {
+		return fmt.Errorf("select images of platform %s failure %s", platforms.Format(target), err.Error())
+	}
+	tarball := layoutDir + ".tar"
+	if err := layout.WriteArchive(tarball); err != nil {
+		return fmt.Errorf("archive image layout failure %s", err.Error())
+	}
+	defer os.Remove(tarball)
+	if err := r.imageClient.ImageLoad(tarball); err != nil {
+		return fmt.Errorf("load images of image layout failure %s", err.Error())
+	}
+	r.logger.Infof("load images from image layout %s success", layoutDir)
+	return nil
+}
+
+// verifyPackage check the signature and the files against the manifest before loading images,
+// packages exported before the manifest was introduced are accepted if no trust policy is set
+func (r *ramImport) verifyPackage(packagePath string) error {
+	if r.trustPolicy != nil {
+		if err := r.trustPolicy.VerifyManifestSignature(packagePath); err != nil {
+			r.logger.Errorf("verify package signature failure %s", err.Error())
+			return err
+		}
+		r.logger.Infof("verify package signature success")
+	}
+	manifest, err := export.ReadManifest(packagePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			r.logger.Warningf("package has no %s, skip integrity verification", export.ManifestFileName)
+			return nil
+		}
+		return err
+	}
+	report := manifest.Verify(packagePath)
+	// files a signed package does not list could have been added by anyone after signing
+	if !report.OK() || (r.trustPolicy != nil && !report.Complete()) {
+		r.logger.Errorf("verify package %s %s failure: %s", manifest.AppName, manifest.AppVersion, report.Error())
+		return report
+	}
+	if len(report.Unlisted) > 0 {
+		r.logger.Warningf("package %s %s contains files not in the manifest, they are not imported: %s", manifest.AppName, manifest.AppVersion, strings.Join(report.Unlisted, ", "))
+	}
+	r.logger.Infof("verify %d files of package %s %s success", len(manifest.Files), manifest.AppName, manifest.AppVersion)
+	return r.checkPlatform(manifest)
+}
+
+// checkPlatform reject packages with images not of the target platform, online packages and
+// packages exported before the platforms were recorded are accepted
+func (r *ramImport) checkPlatform(manifest *export.PackageManifest) error {
+	if manifest.Mode == export.OnlineMode || len(manifest.Platforms) == 0 {
+		return nil
+	}
+	target, err := r.targetPlatform()
+	if err != nil {
+		return err
+	}
+	// packages exported before the platforms of every image were recorded are checked as a whole
+	if len(manifest.ImagePlatforms) == 0 {
+		contained, err := image.ParsePlatforms(manifest.Platforms...)
+		if err != nil {
+			return fmt.Errorf("read platforms of package failure %s", err.Error())
+		}
+		if !contained.Contains(target) {
+			return fmt.Errorf("package %s %s contains images of %s, not of the target platform %s", manifest.AppName, manifest.AppVersion, contained, platforms.Format(target))
+		}
+		r.logger.Infof("package contains images of the target platform %s", platforms.Format(target))
+		return nil
+	}
+	var mismatched []string
+	for name, specs := range manifest.ImagePlatforms {
+		contained, err := image.ParsePlatforms(specs...)
+		if err != nil {
+			return fmt.Errorf("read platforms of image %s failure %s", name, err.Error())
+		}
+		if !contained.Contains(target) {
+			mismatched = append(mismatched, fmt.Sprintf("%s (%s)", name, contained))
+		}
+	}
+	if len(mismatched) > 0 {
+		sort.Strings(mismatched)
+		return fmt.Errorf("package %s %s contains images not of the target platform %s: %s", manifest.AppName, manifest.AppVersion, platforms.Format(target), strings.Join(mismatched, ", "))
+	}
+	r.logger.Infof("all images of the package are of the target platform %s", platforms.Format(target))
+	return nil
+}
+
+// targetPlatform returns the platform of the target registry, the platform of the host by default
+func (r *ramImport) targetPlatform() (ocispec.Platform, error) {
+	target := image.DefaultPlatforms()
+	if r.platform != "" {
+		var err error
+		if target, err = image.ParsePlatforms(r.platform); err != nil {
+			return ocispec.Platform{}, err
+		}
+	}
+	if len(target) == 0 {
+		return ocispec.Platform{}, fmt.Errorf("no target platform")
+	}
+	return target[0], nil
+}
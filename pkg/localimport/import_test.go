@@ -19,12 +19,22 @@
 package localimport
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path"
+	"strings"
 	"testing"
 
 	"github.com/containerd/containerd"
 	"github.com/docker/docker/client"
+	"github.com/goodrain/rainbond-oam/pkg/export"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
 )
 
@@ -96,3 +106,260 @@ func TestImport(t *testing.T) {
 	}
 	t.Logf("%+v", info)
 }
+
+func TestVerifyPackageRejectsCorruptedImages(t *testing.T) {
+	packagePath := t.TempDir()
+	imageTar := path.Join(packagePath, "component-images.tar")
+	if err := ioutil.WriteFile(imageTar, []byte("images"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := export.WriteManifest(packagePath, export.RAM, v1alpha1.RainbondApplicationConfig{AppName: "demo"}); err != nil {
+		t.Fatal(err)
+	}
+	r := &ramImport{logger: logrus.StandardLogger()}
+	if err := r.verifyPackage(packagePath); err != nil {
+		t.Fatalf("expected intact package, got %v", err)
+	}
+	if err := ioutil.WriteFile(imageTar, []byte("imag"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := r.verifyPackage(packagePath)
+	report, ok := err.(*export.ManifestReport)
+	if !ok || len(report.Corrupted) != 1 {
+		t.Fatalf("expected corrupted image tarball to be reported, got %v", err)
+	}
+}
+
+func TestVerifyPackageChecksTargetPlatform(t *testing.T) {
+	packagePath := t.TempDir()
+	manifest, err := export.WriteManifest(packagePath, export.RAM, v1alpha1.RainbondApplicationConfig{AppName: "demo", AppVersion: "1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Platforms = []string{"linux/s390x"}
+	if err := manifest.Write(packagePath); err != nil {
+		t.Fatal(err)
+	}
+	r := &ramImport{logger: logrus.StandardLogger()}
+	if err := r.verifyPackage(packagePath); err == nil || !strings.Contains(err.Error(), "not of the target platform") {
+		t.Fatalf("expected the package of another platform to be rejected, got %v", err)
+	}
+	r.platform = "linux/s390x"
+	if err := r.verifyPackage(packagePath); err != nil {
+		t.Fatalf("expected the package of the target registry platform to be accepted, got %v", err)
+	}
+	// every image must run on the target, not just one of them
+	manifest.Platforms = []string{"linux/amd64", "linux/arm64"}
+	manifest.ImagePlatforms = map[string][]string{"goodrain.me/web:v1": {"linux/arm64"}, "goodrain.me/db:v1": {"linux/amd64"}}
+	if err := manifest.Write(packagePath); err != nil {
+		t.Fatal(err)
+	}
+	r.platform = "linux/amd64"
+	if err := r.verifyPackage(packagePath); err == nil || !strings.Contains(err.Error(), "not of the target platform linux/amd64: goodrain.me/web:v1 (linux/arm64)") {
+		t.Fatalf("expected the package with an arm64 only image to be rejected, got %v", err)
+	}
+}
+
+func TestVerifyPackageWithTrustPolicyRejectsUnsigned(t *testing.T) {
+	packagePath := t.TempDir()
+	if _, err := export.WriteManifest(packagePath, export.RAM, v1alpha1.RainbondApplicationConfig{AppName: "demo"}); err != nil {
+		t.Fatal(err)
+	}
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	r := &ramImport{logger: logrus.StandardLogger(), trustPolicy: &export.TrustPolicy{PublicKeys: []crypto.PublicKey{pub}}}
+	if err := r.verifyPackage(packagePath); err != export.ErrPackageUnsigned {
+		t.Fatalf("expected unsigned package to be rejected, got %v", err)
+	}
+	if err := export.SignManifest(packagePath, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.verifyPackage(packagePath); err != nil {
+		t.Fatalf("expected signed package to be accepted, got %v", err)
+	}
+}
+
+func TestImportLoadsOnlyImagesInTheManifest(t *testing.T) {
+	exportPath := path.Join(t.TempDir(), "demo-1.0-ram")
+	os.MkdirAll(exportPath, 0755)
+	ram := v1alpha1.RainbondApplicationConfig{AppName: "demo", AppVersion: "1.0", Components: []*v1alpha1.Component{{ServiceCname: "web", ShareImage: "goodrain.me/demo/web:v1"}}}
+	ioutil.WriteFile(path.Join(exportPath, "metadata.json"), []byte(ram.JSON()), 0644)
+	ioutil.WriteFile(path.Join(exportPath, "component-images.tar"), []byte("images"), 0644)
+	if _, err := export.WriteManifest(exportPath, export.RAM, ram); err != nil {
+		t.Fatal(err)
+	}
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	if err := export.SignManifest(exportPath, key); err != nil {
+		t.Fatal(err)
+	}
+	// an image tarball dropped into the signed package
+	ioutil.WriteFile(path.Join(exportPath, "extra-images.tar"), []byte("extra"), 0644)
+	pkg := exportPath + ".tar.gz"
+	if err := archive.Pack(exportPath, pkg, archive.TarGz); err != nil {
+		t.Fatal(err)
+	}
+	client := &recordingImageClient{}
+	r := &ramImport{logger: logrus.StandardLogger(), imageClient: client, homeDir: t.TempDir(), trustPolicy: &export.TrustPolicy{PublicKeys: []crypto.PublicKey{pub}}}
+	hub := v1alpha1.ImageInfo{HubURL: "goodrain.me", Namespace: "test"}
+	_, err := r.Import(pkg, hub)
+	if report, ok := err.(*export.ManifestReport); !ok || len(report.Unlisted) != 1 {
+		t.Fatalf("expected the signed package with an unlisted tarball to be rejected, got %v", err)
+	}
+	// without a trust policy the package is imported, the unlisted tarball is not loaded
+	r = &ramImport{logger: logrus.StandardLogger(), imageClient: client, homeDir: t.TempDir()}
+	if _, err := r.Import(pkg, hub); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(client.loaded, ",") != "component-images.tar" {
+		t.Fatalf("expected only the listed tarball to be loaded, got %v", client.loaded)
+	}
+}
+
+// missingImageClient an image client without any image, pulls fail as well
+type missingImageClient struct{}
+
+func (missingImageClient) ImageSave(destination string, images []string) error {
+	return fmt.Errorf("no such image")
+}
+func (missingImageClient) ImageLoad(tarFile string) error { return nil }
+func (missingImageClient) ImagePull(image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	return nil, fmt.Errorf("not found")
+}
+func (missingImageClient) ImagePush(image, user, pass string, timeout int) error { return nil }
+func (missingImageClient) ImageTag(source, target string, timeout int) error     { return nil }
+
+func TestRestoreBaseLayersRefusesWithoutBaseImages(t *testing.T) {
+	packagePath := t.TempDir()
+	manifest := &export.PackageManifest{
+		AppName: "demo",
+		Base:    &export.BaseReference{AppName: "demo", AppVersion: "1.0"},
+		Images: []export.ManifestImage{{
+			Path:         "component-images.tar",
+			Layers:       []export.ManifestFile{{Path: "base/layer.tar", Size: 10, SHA256: "abc", Base: true}},
+			BaseRepoTags: []string{"demo/web:1.0"},
+		}},
+	}
+	if err := manifest.Write(packagePath); err != nil {
+		t.Fatal(err)
+	}
+	r := &ramImport{logger: logrus.StandardLogger(), imageClient: missingImageClient{}, homeDir: t.TempDir()}
+	err := r.restoreBaseLayers(packagePath, v1alpha1.ImageInfo{HubURL: "registry.example.com"})
+	if err == nil || !strings.Contains(err.Error(), "import the base package demo 1.0 first") {
+		t.Fatalf("expected delta import to be refused, got %v", err)
+	}
+}
+
+const onlineTestImage = "registry.example.com/demo/web:v1@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+
+// writeOnlinePackage write an online mode package of one component
+func writeOnlinePackage(t *testing.T) string {
+	t.Helper()
+	exportPath := path.Join(t.TempDir(), "demo-1.0-ram")
+	os.MkdirAll(exportPath, 0755)
+	ram := v1alpha1.RainbondApplicationConfig{AppName: "demo", AppVersion: "1.0", Components: []*v1alpha1.Component{{ServiceCname: "web", ShareImage: onlineTestImage}}}
+	if err := ioutil.WriteFile(path.Join(exportPath, "metadata.json"), []byte(ram.JSON()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := export.BuildManifest(exportPath, export.RAM, ram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Mode = export.OnlineMode
+	if err := manifest.Write(exportPath); err != nil {
+		t.Fatal(err)
+	}
+	pkg := exportPath + ".tar.gz"
+	if err := archive.Pack(exportPath, pkg, archive.TarGz); err != nil {
+		t.Fatal(err)
+	}
+	return pkg
+}
+
+func TestImportOnlinePackageKeepsPinnedImages(t *testing.T) {
+	pkg := writeOnlinePackage(t)
+	r := &ramImport{logger: logrus.StandardLogger(), imageClient: missingImageClient{}, homeDir: t.TempDir()}
+	imported, err := r.Import(pkg, v1alpha1.ImageInfo{HubURL: "goodrain.me", Namespace: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Components[0].ShareImage != onlineTestImage {
+		t.Fatalf("expected pinned image to be kept, got %s", imported.Components[0].ShareImage)
+	}
+}
+
+func TestImportSplitPackageFromFirstPart(t *testing.T) {
+	pkg := writeOnlinePackage(t)
+	index, err := archive.Split(pkg, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Parts) < 2 {
+		t.Fatalf("expected several parts, got %d", len(index.Parts))
+	}
+	r := &ramImport{logger: logrus.StandardLogger(), imageClient: missingImageClient{}, homeDir: t.TempDir()}
+	imported, err := r.Import(pkg+".001", v1alpha1.ImageInfo{HubURL: "goodrain.me", Namespace: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.AppName != "demo" {
+		t.Fatalf("unexpected app %s", imported.AppName)
+	}
+}
+
+// recordingImageClient records the loaded tarballs and the pushed images
+type recordingImageClient struct {
+	missingImageClient
+	loaded []string
+	pushed []string
+}
+
+func (c *recordingImageClient) ImageLoad(tarFile string) error {
+	if _, err := os.Stat(tarFile); err != nil {
+		return err
+	}
+	c.loaded = append(c.loaded, path.Base(tarFile))
+	return nil
+}
+
+func (c *recordingImageClient) ImagePush(image, user, pass string, timeout int) error {
+	c.pushed = append(c.pushed, image)
+	return nil
+}
+
+func TestLoadVMDisksWrapsDiskFiles(t *testing.T) {
+	packagePath := t.TempDir()
+	os.MkdirAll(path.Join(packagePath, "vm-disks", "vm", "root"), 0755)
+	os.MkdirAll(path.Join(packagePath, "vm-disks", "vm", "data-1"), 0755)
+	ioutil.WriteFile(path.Join(packagePath, "vm-disks", "vm", "root", "root.qcow2"), []byte("root disk"), 0644)
+	ioutil.WriteFile(path.Join(packagePath, "vm-disks", "vm", "data-1", "data.raw"), []byte("data disk"), 0644)
+	ram := &v1alpha1.RainbondApplicationConfig{Components: []*v1alpha1.Component{{
+		ServiceCname: "vm",
+		ShareImage:   "goodrain.me/vm:v1",
+		VM: &v1alpha1.VMTemplate{DiskLayout: []v1alpha1.VMDiskLayoutItem{
+			{DiskKey: "root", DiskRole: v1alpha1.VMDiskRoleRoot, SourceType: v1alpha1.VMDiskSourceRegistry, File: "vm-disks/vm/root/root.qcow2"},
+			{DiskKey: "data-1", DiskRole: v1alpha1.VMDiskRoleData, SourceType: v1alpha1.VMDiskSourceRegistry, Image: "goodrain.me/vm-data:v1", File: "vm-disks/vm/data-1/data.raw", Checksum: "md5:" + strings.Repeat("0", 32)},
+		}},
+	}}}
+	client := &recordingImageClient{}
+	r := &ramImport{logger: logrus.StandardLogger(), imageClient: client}
+	hub := v1alpha1.ImageInfo{HubURL: "registry.example.com", Namespace: "team"}
+	if err := r.loadVMDisks(packagePath, ram, hub); err == nil || !strings.Contains(err.Error(), "verify disk data-1 of vm vm failure") {
+		t.Fatalf("expected the corrupted disk to be rejected, got %v", err)
+	}
+	ram.Components[0].VM.DiskLayout[1].Checksum = ""
+	if err := r.loadVMDisks(packagePath, ram, hub); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(client.loaded, ",") != "root.qcow2.tar,data.raw.tar" {
+		t.Fatalf("expected the disks to be loaded as images, got %v", client.loaded)
+	}
+	disks := ram.Components[0].VM.DiskLayout
+	if len(client.pushed) != 1 || disks[1].Image != client.pushed[0] || !strings.HasPrefix(disks[1].Image, "registry.example.com/team/") {
+		t.Fatalf("expected the data disk image to be pushed, got %v %s", client.pushed, disks[1].Image)
+	}
+	if disks[0].File != "" || disks[1].File != "" {
+		t.Fatalf("expected the disk files to be cleared, got %+v", disks)
+	}
+	if _, err := os.Stat(path.Join(packagePath, "vm-disks", "vm", "root", "root.qcow2.tar")); !os.IsNotExist(err) {
+		t.Fatal("expected the wrapped image tarball to be removed")
+	}
+}
@@ -0,0 +1,86 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package localimport
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/export"
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/docker"
+	"github.com/goodrain/rainbond-oam/pkg/util/vmdisk"
+)
+
+// loadVMDisks wrap the disk files of the VM components into container disk images and load
+// them. The disks of the component images are pushed with the components, the disks of other
+// images are pushed to the hub here and their disk layouts point to the pushed images.
+func (r *ramImport) loadVMDisks(packagePath string, ram *v1alpha1.RainbondApplicationConfig, hubInfo v1alpha1.ImageInfo) error {
+	for _, component := range ram.Components {
+		if component.VM == nil {
+			continue
+		}
+		for i := range component.VM.DiskLayout {
+			disk := &component.VM.DiskLayout[i]
+			if disk.File == "" {
+				continue
+			}
+			if file := path.Clean(disk.File); !strings.HasPrefix(file, export.VMDiskDir+"/") {
+				return fmt.Errorf("disk %s of vm %s is not in %s: %s", disk.DiskKey, component.ServiceCname, export.VMDiskDir, disk.File)
+			}
+			file := path.Join(packagePath, disk.File)
+			if err := vmdisk.Verify(file, disk.Checksum); err != nil {
+				return fmt.Errorf("verify disk %s of vm %s failure %s", disk.DiskKey, component.ServiceCname, err.Error())
+			}
+			name := export.VMDiskImage(component, *disk)
+			if name == "" {
+				return fmt.Errorf("disk %s of vm %s has no image to wrap the disk file into", disk.DiskKey, component.ServiceCname)
+			}
+			tarball := file + ".tar"
+			if err := vmdisk.WriteImageArchive(file, name, tarball); err != nil {
+				return fmt.Errorf("wrap disk %s of vm %s failure %s", disk.DiskKey, component.ServiceCname, err.Error())
+			}
+			err := r.imageClient.ImageLoad(tarball)
+			os.Remove(tarball)
+			if err != nil {
+				return fmt.Errorf("load disk %s of vm %s failure %s", disk.DiskKey, component.ServiceCname, err.Error())
+			}
+			r.logger.Infof("load disk %s of vm %s as image %s", disk.DiskKey, component.ServiceCname, name)
+			disk.File = ""
+			if name == component.ShareImage {
+				continue
+			}
+			newImageName, err := docker.NewImageName(name, hubInfo)
+			if err != nil {
+				return err
+			}
+			if err := r.imageClient.ImageTag(name, newImageName, 2); err != nil {
+				return fmt.Errorf("change image %s tag to %s failure %s", name, newImageName, err.Error())
+			}
+			if err := r.imageClient.ImagePush(newImageName, hubInfo.HubUser, hubInfo.HubPassword, 20); err != nil {
+				return fmt.Errorf("push image %s failure %s", newImageName, err.Error())
+			}
+			r.logger.Infof("push disk image %s success", newImageName)
+			disk.Image = newImageName
+		}
+	}
+	return nil
+}
@@ -0,0 +1,530 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package oam
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	//VelaAPIVersion kubevela application api version
+	VelaAPIVersion = "core.oam.dev/v1beta1"
+	//VelaApplicationKind kubevela application kind
+	VelaApplicationKind = "Application"
+
+	//VelaWebServiceType component that exposes ports
+	VelaWebServiceType = "webservice"
+	//VelaWorkerType component without any port
+	VelaWorkerType = "worker"
+	//VelaK8sObjectsType raw kubernetes resources
+	VelaK8sObjectsType = "k8s-objects"
+
+	velaK8sObjectsName = "k8s-resources"
+)
+
+// VelaApplication kubevela core.oam.dev/v1beta1 Application
+type VelaApplication struct {
+	APIVersion string              `json:"apiVersion"`
+	Kind       string              `json:"kind"`
+	Metadata   VelaMetadata        `json:"metadata"`
+	Spec       VelaApplicationSpec `json:"spec"`
+}
+
+// VelaMetadata application metadata
+type VelaMetadata struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// VelaApplicationSpec application spec
+type VelaApplicationSpec struct {
+	Components []VelaComponent `json:"components"`
+	Workflow   *VelaWorkflow   `json:"workflow,omitempty"`
+}
+
+// VelaComponent application component
+type VelaComponent struct {
+	Name       string                 `json:"name"`
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	Traits     []VelaTrait            `json:"traits,omitempty"`
+}
+
+// VelaTrait component trait
+type VelaTrait struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// VelaWorkflow application workflow
+type VelaWorkflow struct {
+	Steps []VelaWorkflowStep `json:"steps"`
+}
+
+// VelaWorkflowStep workflow step
+type VelaWorkflowStep struct {
+	Name       string                 `json:"name"`
+	Type       string                 `json:"type"`
+	DependsOn  []string               `json:"dependsOn,omitempty"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// VelaBuilder kubevela application builder
+type VelaBuilder interface {
+	Build() (*VelaApplication, error)
+}
+
+type velaBuilder struct {
+	ram v1alpha1.RainbondApplicationConfig
+	// component key or share id -> vela component name
+	names map[string]string
+}
+
+// NewVelaBuilder new kubevela application builder
+func NewVelaBuilder(ram v1alpha1.RainbondApplicationConfig) VelaBuilder {
+	return &velaBuilder{
+		ram:   ram,
+		names: make(map[string]string),
+	}
+}
+
+func (v *velaBuilder) Build() (*VelaApplication, error) {
+	for _, com := range v.ram.Components {
+		name := VelaComponentName(com)
+		v.names[com.ComponentKey] = name
+		if com.ServiceShareID != "" {
+			v.names[com.ServiceShareID] = name
+		}
+	}
+	app := &VelaApplication{
+		APIVersion: VelaAPIVersion,
+		Kind:       VelaApplicationKind,
+		Metadata: VelaMetadata{
+			Name: v.ram.AppName,
+			Labels: map[string]string{
+				"app.rainbond.io/version": v.ram.AppVersion,
+			},
+		},
+	}
+	if info, ok := v.ram.Annotations["version_info"]; ok && info != "" {
+		app.Metadata.Annotations = map[string]string{"app.rainbond.io/version-info": info}
+	}
+	var steps []VelaWorkflowStep
+	if len(v.ram.K8sResources) > 0 {
+		objects, err := v.buildK8sObjects()
+		if err != nil {
+			return nil, err
+		}
+		app.Spec.Components = append(app.Spec.Components, VelaComponent{
+			Name:       velaK8sObjectsName,
+			Type:       VelaK8sObjectsType,
+			Properties: map[string]interface{}{"objects": objects},
+		})
+		steps = append(steps, newApplyComponentStep(velaK8sObjectsName, nil))
+	}
+	for _, com := range v.ram.Components {
+		name := v.names[com.ComponentKey]
+		app.Spec.Components = append(app.Spec.Components, v.buildComponent(com, name))
+		steps = append(steps, newApplyComponentStep(name, v.buildDependsOn(com)))
+	}
+	app.Spec.Workflow = &VelaWorkflow{Steps: steps}
+	return app, nil
+}
+
+// VelaComponentName returns the kubernetes friendly name of the component
+func VelaComponentName(com *v1alpha1.Component) string {
+	for _, name := range []string{com.K8SComponentName, com.ServiceAlias, com.ServiceName} {
+		if name != "" {
+			return strings.ToLower(name)
+		}
+	}
+	return strings.ToLower(com.ComponentKey)
+}
+
+func newApplyComponentStep(name string, dependsOn []string) VelaWorkflowStep {
+	return VelaWorkflowStep{
+		Name:       name,
+		Type:       "apply-component",
+		DependsOn:  dependsOn,
+		Properties: map[string]interface{}{"component": name},
+	}
+}
+
+func (v *velaBuilder) buildDependsOn(com *v1alpha1.Component) []string {
+	var dependsOn []string
+	set := make(map[string]struct{})
+	for _, dep := range com.DepServiceMapList {
+		name, ok := v.names[dep.DepServiceKey]
+		if !ok {
+			logrus.Warningf("[vela] dependent component %s of %s not found", dep.DepServiceKey, com.ServiceCname)
+			continue
+		}
+		if _, exists := set[name]; exists {
+			continue
+		}
+		set[name] = struct{}{}
+		dependsOn = append(dependsOn, name)
+	}
+	return dependsOn
+}
+
+func (v *velaBuilder) buildComponent(com *v1alpha1.Component, name string) VelaComponent {
+	properties := map[string]interface{}{
+		"image": com.ShareImage,
+	}
+	if com.Cmd != "" {
+		properties["cmd"] = strings.Fields(com.Cmd)
+	}
+	if envs := v.buildEnv(com); len(envs) > 0 {
+		properties["env"] = envs
+	}
+	if com.CPU > 0 {
+		properties["cpu"] = fmt.Sprintf("%dm", com.CPU)
+	}
+	if com.Memory > 0 {
+		properties["memory"] = fmt.Sprintf("%dMi", com.Memory)
+	}
+	for _, probe := range com.Probes {
+		if !probe.IsUsed {
+			continue
+		}
+		switch probe.Mode {
+		case "readiness":
+			properties["readinessProbe"] = buildVelaProbe(probe)
+		case "liveness":
+			properties["livenessProbe"] = buildVelaProbe(probe)
+		}
+	}
+	componentType := VelaWorkerType
+	if len(com.Ports) > 0 {
+		componentType = VelaWebServiceType
+		var ports []map[string]interface{}
+		for _, p := range com.Ports {
+			protocol := "TCP"
+			if strings.ToLower(p.Protocol) == "udp" {
+				protocol = "UDP"
+			}
+			ports = append(ports, map[string]interface{}{
+				"name":     fmt.Sprintf("%s-%d", strings.ToLower(protocol), p.ContainerPort),
+				"port":     p.ContainerPort,
+				"protocol": protocol,
+				"expose":   p.IsInner || p.IsOuter,
+			})
+		}
+		properties["ports"] = ports
+	}
+	return VelaComponent{
+		Name:       name,
+		Type:       componentType,
+		Properties: properties,
+		Traits:     v.buildTraits(com, name),
+	}
+}
+
+func (v *velaBuilder) buildEnv(com *v1alpha1.Component) []map[string]interface{} {
+	var envs []map[string]interface{}
+	set := make(map[string]struct{})
+	add := func(name, value string) {
+		if _, exists := set[name]; exists || name == "" {
+			return
+		}
+		set[name] = struct{}{}
+		envs = append(envs, map[string]interface{}{"name": name, "value": value})
+	}
+	for _, env := range com.Envs {
+		add(env.AttrName, env.AttrValue)
+	}
+	for _, env := range com.ServiceConnectInfoMapList {
+		add(env.AttrName, env.AttrValue)
+	}
+	for _, group := range v.ram.AppConfigGroups {
+		for _, key := range group.ComponentKeys {
+			if key != com.ComponentKey {
+				continue
+			}
+			for _, k := range util.SortedKeys(group.ConfigItems) {
+				add(k, group.ConfigItems[k])
+			}
+		}
+	}
+	for _, dep := range com.DepServiceMapList {
+		for _, depCom := range v.ram.Components {
+			if depCom.ComponentKey != dep.DepServiceKey && depCom.ServiceShareID != dep.DepServiceKey {
+				continue
+			}
+			for _, env := range depCom.ServiceConnectInfoMapList {
+				add(env.AttrName, env.AttrValue)
+			}
+		}
+	}
+	return envs
+}
+
+func buildVelaProbe(probe v1alpha1.ComponentProbe) map[string]interface{} {
+	re := map[string]interface{}{}
+	switch {
+	case probe.Cmd != "":
+		re["exec"] = map[string]interface{}{"command": strings.Fields(probe.Cmd)}
+	case probe.Scheme == "http":
+		re["httpGet"] = map[string]interface{}{"path": probe.Path, "port": probe.Port}
+	default:
+		re["tcpSocket"] = map[string]interface{}{"port": probe.Port}
+	}
+	if probe.InitialDelaySecond > 0 {
+		re["initialDelaySeconds"] = probe.InitialDelaySecond
+	}
+	if probe.PeriodSecond > 0 {
+		re["periodSeconds"] = probe.PeriodSecond
+	}
+	if probe.TimeoutSecond > 0 {
+		re["timeoutSeconds"] = probe.TimeoutSecond
+	}
+	if probe.FailureThreshold > 0 {
+		re["failureThreshold"] = probe.FailureThreshold
+	}
+	if probe.SuccessThreshold > 0 {
+		re["successThreshold"] = probe.SuccessThreshold
+	}
+	return re
+}
+
+func (v *velaBuilder) buildTraits(com *v1alpha1.Component, name string) []VelaTrait {
+	replicas := com.ExtendMethodRule.MinNode
+	if replicas < 1 {
+		replicas = 1
+	}
+	traits := []VelaTrait{{
+		Type:       "scaler",
+		Properties: map[string]interface{}{"replicas": replicas},
+	}}
+	if gateway := v.buildGatewayTrait(com); gateway != nil {
+		traits = append(traits, *gateway)
+	}
+	if storage := v.buildStorageTrait(com, name); storage != nil {
+		traits = append(traits, *storage)
+	}
+	traits = append(traits, v.buildSidecarTraits(com)...)
+	return traits
+}
+
+func (v *velaBuilder) buildGatewayTrait(com *v1alpha1.Component) *VelaTrait {
+	http := make(map[string]interface{})
+	for _, route := range v.ram.IngressHTTPRoutes {
+		if route.ComponentKey != com.ComponentKey {
+			continue
+		}
+		location := route.Location
+		if location == "" {
+			location = "/"
+		}
+		http[location] = route.Port
+	}
+	if len(http) == 0 {
+		return nil
+	}
+	return &VelaTrait{
+		Type:       "gateway",
+		Properties: map[string]interface{}{"http": http},
+	}
+}
+
+func (v *velaBuilder) buildStorageTrait(com *v1alpha1.Component, name string) *VelaTrait {
+	var pvcs, configMaps, emptyDirs []map[string]interface{}
+	for _, volume := range com.ServiceVolumeMapList {
+		volumeName := velaVolumeName(name, volume.VolumeName)
+		switch volume.VolumeType {
+		case v1alpha1.ConfigFileVolumeType:
+			fileName := path.Base(volume.VolumeMountPath)
+			configMaps = append(configMaps, map[string]interface{}{
+				"name":      volumeName,
+				"mountPath": volume.VolumeMountPath,
+				"subPath":   fileName,
+				"data":      map[string]string{fileName: volume.FileConent},
+			})
+		case v1alpha1.MemoryFSVolumeType:
+			emptyDirs = append(emptyDirs, map[string]interface{}{
+				"name":      volumeName,
+				"mountPath": volume.VolumeMountPath,
+				"medium":    "memory",
+			})
+		default:
+			pvcs = append(pvcs, newVelaPVC(volumeName, volume.VolumeMountPath, volume))
+		}
+	}
+	for _, share := range com.MntReleationList {
+		owner := v.findComponent(share.ShareServiceUUID)
+		if owner == nil {
+			logrus.Warningf("[vela] shared volume %s/%s not found", share.ShareServiceUUID, share.VolumeName)
+			continue
+		}
+		for _, volume := range owner.ServiceVolumeMapList {
+			if volume.VolumeName != share.VolumeName || volume.VolumeType == v1alpha1.ConfigFileVolumeType {
+				continue
+			}
+			volumeName := velaVolumeName(v.names[owner.ComponentKey], volume.VolumeName)
+			pvc := newVelaPVC(volumeName, share.VolumeMountDir, volume)
+			// reuse the claim created by the owner component
+			pvc["mountOnly"] = true
+			pvcs = append(pvcs, pvc)
+		}
+	}
+	if len(pvcs) == 0 && len(configMaps) == 0 && len(emptyDirs) == 0 {
+		return nil
+	}
+	properties := make(map[string]interface{})
+	if len(pvcs) > 0 {
+		properties["pvc"] = pvcs
+	}
+	if len(configMaps) > 0 {
+		properties["configMap"] = configMaps
+	}
+	if len(emptyDirs) > 0 {
+		properties["emptyDir"] = emptyDirs
+	}
+	return &VelaTrait{Type: "storage", Properties: properties}
+}
+
+func newVelaPVC(name, mountPath string, volume v1alpha1.ComponentVolume) map[string]interface{} {
+	pvc := map[string]interface{}{
+		"name":        name,
+		"mountPath":   mountPath,
+		"accessModes": []string{velaAccessMode(volume.AccessMode)},
+	}
+	if volume.VolumeCapacity > 0 {
+		pvc["resources"] = map[string]interface{}{
+			"requests": map[string]string{"storage": fmt.Sprintf("%dGi", volume.VolumeCapacity)},
+		}
+	}
+	return pvc
+}
+
+func velaVolumeName(componentName, volumeName string) string {
+	return strings.ToLower(strings.Replace(fmt.Sprintf("%s-%s", componentName, volumeName), "_", "-", -1))
+}
+
+func velaAccessMode(mode v1alpha1.AccessMode) string {
+	switch mode {
+	case v1alpha1.RWXAccessMode:
+		return "ReadWriteMany"
+	case v1alpha1.ROXAccessMode:
+		return "ReadOnlyMany"
+	default:
+		return "ReadWriteOnce"
+	}
+}
+
+func (v *velaBuilder) buildSidecarTraits(com *v1alpha1.Component) []VelaTrait {
+	var traits []VelaTrait
+	for _, config := range com.ServicePluginConfigs {
+		plugin := v.findPlugin(config.PluginKey, config.PluginID)
+		if plugin == nil {
+			logrus.Warningf("[vela] plugin %s of %s not found", config.PluginKey, com.ServiceCname)
+			continue
+		}
+		name := plugin.PluginAlias
+		if name == "" {
+			name = plugin.PluginKey
+		}
+		properties := map[string]interface{}{
+			"name":  strings.ToLower(name),
+			"image": plugin.ShareImage,
+		}
+		pluginEnvs := PluginConfigEnvs(plugin, config)
+		var envs []map[string]interface{}
+		for _, key := range util.SortedKeys(pluginEnvs) {
+			envs = append(envs, map[string]interface{}{"name": key, "value": pluginEnvs[key]})
+		}
+		if len(envs) > 0 {
+			properties["env"] = envs
+		}
+		traits = append(traits, VelaTrait{Type: "sidecar", Properties: properties})
+	}
+	return traits
+}
+
+// PluginConfigEnvs returns the env config of plugin used by the component.
+// The default value of the config group option is overwritten by the component attr.
+func PluginConfigEnvs(plugin *v1alpha1.Plugin, config v1alpha1.ComponentPluginConfig) map[string]string {
+	envs := make(map[string]string)
+	for _, group := range plugin.ConfigGroups {
+		if group.ServiceMetaType != "" && group.ServiceMetaType != "unbind" {
+			continue
+		}
+		for _, option := range group.Options {
+			if option.AttrName == "" {
+				continue
+			}
+			envs[option.AttrName] = option.AttrDefaultValue
+		}
+	}
+	for _, attr := range config.Attr {
+		name, _ := attr["attr_name"].(string)
+		if name == "" {
+			continue
+		}
+		if value, ok := attr["attr_value"]; ok && value != nil {
+			envs[name] = fmt.Sprintf("%v", value)
+		}
+	}
+	return envs
+}
+
+func (v *velaBuilder) buildK8sObjects() ([]map[string]interface{}, error) {
+	var objects []map[string]interface{}
+	for _, resource := range v.ram.K8sResources {
+		var object map[string]interface{}
+		if err := yaml.Unmarshal([]byte(resource.Content), &object); err != nil {
+			return nil, fmt.Errorf("parse k8s resource %s/%s failure %v", resource.Kind, resource.Name, err)
+		}
+		if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+			for _, key := range []string{"namespace", "resourceVersion", "uid", "creationTimestamp", "managedFields"} {
+				delete(metadata, key)
+			}
+		}
+		delete(object, "status")
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+func (v *velaBuilder) findComponent(key string) *v1alpha1.Component {
+	for _, com := range v.ram.Components {
+		if com.ServiceShareID == key || com.ComponentKey == key {
+			return com
+		}
+	}
+	return nil
+}
+
+func (v *velaBuilder) findPlugin(key, id string) *v1alpha1.Plugin {
+	for _, p := range v.ram.Plugins {
+		if p.PluginKey == key || (id != "" && p.PluginID == id) {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package oam
+
+import (
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+func newVelaTestTemplate() v1alpha1.RainbondApplicationConfig {
+	return v1alpha1.RainbondApplicationConfig{
+		AppName:    "demo",
+		AppVersion: "1.0",
+		Components: []*v1alpha1.Component{
+			{
+				ComponentKey:     "web-key",
+				K8SComponentName: "web",
+				ShareImage:       "registry.example.com/demo/web:v1",
+				Ports:            []v1alpha1.ComponentPort{{ContainerPort: 8080, Protocol: "http", IsOuter: true}},
+				DepServiceMapList: []v1alpha1.ComponentDep{
+					{DepServiceKey: "db-key"},
+				},
+				ServiceVolumeMapList: v1alpha1.ComponentVolumeList{
+					{VolumeName: "data", VolumeMountPath: "/data", VolumeType: v1alpha1.ShareFileVolumeType, VolumeCapacity: 2},
+				},
+				ServicePluginConfigs: []v1alpha1.ComponentPluginConfig{
+					{PluginKey: "mesh", Attr: []map[string]interface{}{{"attr_name": "LOG_LEVEL", "attr_value": "debug"}}},
+				},
+				ExtendMethodRule: v1alpha1.ComponentExtendMethodRule{MinNode: 2},
+			},
+			{
+				ComponentKey:     "db-key",
+				K8SComponentName: "db",
+				ShareImage:       "registry.example.com/demo/db:v1",
+				ServiceConnectInfoMapList: []v1alpha1.ComponentEnv{
+					{AttrName: "DB_HOST", AttrValue: "127.0.0.1"},
+				},
+			},
+		},
+		Plugins: []*v1alpha1.Plugin{
+			{
+				PluginKey:   "mesh",
+				PluginAlias: "Mesh",
+				ShareImage:  "registry.example.com/demo/mesh:v1",
+				ConfigGroups: []v1alpha1.PluginConfigGroup{
+					{Options: []v1alpha1.PluginConfigGroupOption{{AttrName: "LOG_LEVEL", AttrDefaultValue: "info"}}},
+				},
+			},
+		},
+		IngressHTTPRoutes: []*v1alpha1.IngressHTTPRoute{
+			{Location: "/api", TargetComponent: v1alpha1.TargetComponent{ComponentKey: "web-key", Port: 8080}},
+		},
+	}
+}
+
+func findVelaTrait(com VelaComponent, traitType string) *VelaTrait {
+	for i := range com.Traits {
+		if com.Traits[i].Type == traitType {
+			return &com.Traits[i]
+		}
+	}
+	return nil
+}
+
+func TestVelaBuilderMapsComponentTypes(t *testing.T) {
+	app, err := NewVelaBuilder(newVelaTestTemplate()).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.APIVersion != VelaAPIVersion || app.Kind != VelaApplicationKind {
+		t.Fatalf("unexpected application type %s/%s", app.APIVersion, app.Kind)
+	}
+	if len(app.Spec.Components) != 2 {
+		t.Fatalf("expected 2 components, got %d", len(app.Spec.Components))
+	}
+	if got := app.Spec.Components[0].Type; got != VelaWebServiceType {
+		t.Fatalf("expected component with ports to be webservice, got %s", got)
+	}
+	if got := app.Spec.Components[1].Type; got != VelaWorkerType {
+		t.Fatalf("expected component without ports to be worker, got %s", got)
+	}
+}
+
+func TestVelaBuilderBuildsTraits(t *testing.T) {
+	app, err := NewVelaBuilder(newVelaTestTemplate()).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := app.Spec.Components[0]
+	scaler := findVelaTrait(web, "scaler")
+	if scaler == nil || scaler.Properties["replicas"] != 2 {
+		t.Fatalf("expected scaler trait with 2 replicas, got %+v", scaler)
+	}
+	gateway := findVelaTrait(web, "gateway")
+	if gateway == nil {
+		t.Fatalf("expected gateway trait")
+	}
+	if port := gateway.Properties["http"].(map[string]interface{})["/api"]; port != uint32(8080) {
+		t.Fatalf("expected gateway route /api -> 8080, got %v", port)
+	}
+	if findVelaTrait(web, "storage") == nil {
+		t.Fatalf("expected storage trait")
+	}
+	sidecar := findVelaTrait(web, "sidecar")
+	if sidecar == nil {
+		t.Fatalf("expected sidecar trait")
+	}
+	envs := sidecar.Properties["env"].([]map[string]interface{})
+	if len(envs) != 1 || envs[0]["value"] != "debug" {
+		t.Fatalf("expected component attr to overwrite plugin default, got %v", envs)
+	}
+}
+
+func TestVelaBuilderDependsOnWorkflowSteps(t *testing.T) {
+	app, err := NewVelaBuilder(newVelaTestTemplate()).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Spec.Workflow == nil || len(app.Spec.Workflow.Steps) != 2 {
+		t.Fatalf("expected 2 workflow steps, got %+v", app.Spec.Workflow)
+	}
+	step := app.Spec.Workflow.Steps[0]
+	if step.Name != "web" || len(step.DependsOn) != 1 || step.DependsOn[0] != "db" {
+		t.Fatalf("expected web step to depend on db, got %+v", step)
+	}
+	var hasDepEnv bool
+	for _, env := range app.Spec.Components[0].Properties["env"].([]map[string]interface{}) {
+		if env["name"] == "DB_HOST" {
+			hasDepEnv = true
+		}
+	}
+	if !hasDepEnv {
+		t.Fatalf("expected dependency connection env to be injected")
+	}
+}
@@ -262,6 +262,8 @@ type VMDiskLayoutItem struct {
 	SourceType  string `json:"source_type,omitempty"`
 	Image       string `json:"image,omitempty"`
 	Checksum    string `json:"checksum,omitempty"`
+	// File the disk file in the package if the disk is exported as file, e.g. vm-disks/web/root/disk.qcow2
+	File string `json:"file,omitempty"`
 }
 
 // HandleNullValue 处理null值
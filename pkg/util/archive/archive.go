@@ -0,0 +1,113 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package archive packs and extracts app packages without depending on the
+// tar and cp commands of the host.
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Format archive format of the package
+type Format string
+
+const (
+	// TarGz gzip compressed tar, the default package format
+	TarGz Format = "tar.gz"
+	// TarZst zstd compressed tar
+	TarZst Format = "tar.zst"
+	// Zip zip archive, the owner of entries is kept in the entry comment as uid/gid
+	Zip Format = "zip"
+	// Tar uncompressed tar, used by image tarballs
+	Tar Format = "tar"
+)
+
+// Formats package formats supported by Pack
+var Formats = []Format{TarGz, TarZst, Zip}
+
+// ParseFormat parse the package format
+func ParseFormat(format string) (Format, error) {
+	switch f := Format(strings.TrimPrefix(strings.ToLower(format), ".")); f {
+	case "", "tgz":
+		return TarGz, nil
+	case "tzst":
+		return TarZst, nil
+	case TarGz, TarZst, Zip, Tar:
+		return f, nil
+	default:
+		return "", fmt.Errorf("not support archive format %s", format)
+	}
+}
+
+// Ext returns the file extension of the format, with the leading dot
+func (f Format) Ext() string {
+	if f == "" {
+		return "." + string(TarGz)
+	}
+	return "." + string(f)
+}
+
+// DetectFormat detect the archive format by the file name, tar.gz is the default
+func DetectFormat(name string) Format {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return TarZst
+	case strings.HasSuffix(name, ".zip"):
+		return Zip
+	case strings.HasSuffix(name, ".tar"):
+		return Tar
+	default:
+		return TarGz
+	}
+}
+
+var magics = []struct {
+	format Format
+	magic  []byte
+}{
+	{TarGz, []byte{0x1f, 0x8b}},
+	{TarZst, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{Zip, []byte("PK\x03\x04")},
+}
+
+// SniffFormat detect the archive format by the leading bytes of the file,
+// files without a known compression magic are treated as plain tar
+func SniffFormat(file string) (Format, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 4)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	for _, m := range magics {
+		if bytes.HasPrefix(head[:n], m.magic) {
+			return m.format, nil
+		}
+	}
+	return Tar, nil
+}
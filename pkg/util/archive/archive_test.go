@@ -0,0 +1,140 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestTree(t *testing.T) string {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "app-1.0-ram")
+	if err := os.MkdirAll(filepath.Join(src, "web", "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "metadata.json"), []byte(`{"app_name":"app"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "web", "bin", "run.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("bin/run.sh", filepath.Join(src, "web", "start")); err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+func TestPackAndUnpackRoundTrip(t *testing.T) {
+	for _, format := range Formats {
+		t.Run(string(format), func(t *testing.T) {
+			src := writeTestTree(t)
+			pkg := filepath.Join(t.TempDir(), "app"+format.Ext())
+			if err := Pack(src, pkg, format); err != nil {
+				t.Fatal(err)
+			}
+			if sniffed, err := SniffFormat(pkg); err != nil || sniffed != format {
+				t.Fatalf("expected sniffed format %s, got %s %v", format, sniffed, err)
+			}
+			target := t.TempDir()
+			if err := Unpack(pkg, target); err != nil {
+				t.Fatal(err)
+			}
+			content, err := ioutil.ReadFile(filepath.Join(target, "app-1.0-ram", "metadata.json"))
+			if err != nil || string(content) != `{"app_name":"app"}` {
+				t.Fatalf("unexpected metadata %q %v", content, err)
+			}
+			info, err := os.Stat(filepath.Join(target, "app-1.0-ram", "web", "bin", "run.sh"))
+			if err != nil || info.Mode().Perm() != 0755 {
+				t.Fatalf("expected executable script, got %v %v", info, err)
+			}
+			link, err := os.Readlink(filepath.Join(target, "app-1.0-ram", "web", "start"))
+			if err != nil || link != "bin/run.sh" {
+				t.Fatalf("expected symlink to be kept, got %q %v", link, err)
+			}
+		})
+	}
+}
+
+func TestUnpackRejectsPathTraversal(t *testing.T) {
+	pkg := filepath.Join(t.TempDir(), "evil.tar")
+	f, err := os.Create(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	if err := tw.WriteHeader(&tar.Header{Name: "../evil", Mode: 0644, Size: 1, Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte("x"))
+	tw.Close()
+	f.Close()
+	if err := Unpack(pkg, t.TempDir()); err == nil {
+		t.Fatal("expected entry escaping the target to be rejected")
+	}
+}
+
+func TestUnpackRejectsWritingThroughSymlink(t *testing.T) {
+	outside := t.TempDir()
+	pkg := filepath.Join(t.TempDir(), "evil.tar")
+	f, err := os.Create(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	tw.WriteHeader(&tar.Header{Name: "link", Linkname: outside, Typeflag: tar.TypeSymlink})
+	tw.WriteHeader(&tar.Header{Name: "link/evil", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	tw.Write([]byte("x"))
+	tw.Close()
+	f.Close()
+	if err := Unpack(pkg, t.TempDir()); err == nil {
+		t.Fatal("expected entry written through symlink to be rejected")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "evil")); !os.IsNotExist(err) {
+		t.Fatal("file was written out of the target directory")
+	}
+}
+
+func TestCopyKeepsModeAndSymlink(t *testing.T) {
+	src := writeTestTree(t)
+	dest := t.TempDir()
+	if err := Copy(filepath.Join(src, "web"), dest); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "web", "bin", "run.sh")); err != nil || info.Mode().Perm() != 0755 {
+		t.Fatalf("expected executable script, got %v %v", info, err)
+	}
+	if link, err := os.Readlink(filepath.Join(dest, "web", "start")); err != nil || link != "bin/run.sh" {
+		t.Fatalf("expected symlink to be kept, got %q %v", link, err)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for in, want := range map[string]Format{"": TarGz, "tgz": TarGz, ".tar.zst": TarZst, "ZIP": Zip} {
+		if got, err := ParseFormat(in); err != nil || got != want {
+			t.Fatalf("parse %q: expected %s, got %s %v", in, want, got, err)
+		}
+	}
+	if _, err := ParseFormat("rar"); err == nil {
+		t.Fatal("expected unsupported format to fail")
+	}
+}
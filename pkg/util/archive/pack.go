@@ -0,0 +1,211 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/zip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// entryWriter writes the walked files into an archive
+type entryWriter interface {
+	WriteEntry(name string, info os.FileInfo, file string) error
+	Close() error
+}
+
+// Pack archive the src directory into target, entries are prefixed with the base name of src
+// like `tar -C $(dirname src) -cf target $(basename src)` does.
+// A file that is modified while being read fails the packaging.
+func Pack(src, target string, format Format) (err error) {
+	src, target = filepath.Clean(src), filepath.Clean(target)
+	f, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("create archive %s failure %s", target, err.Error())
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(target)
+		}
+	}()
+	buf := bufio.NewWriterSize(f, 1<<20)
+	w, err := newEntryWriter(buf, format)
+	if err != nil {
+		return err
+	}
+	base := filepath.Dir(src)
+	err = filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// skip the archive itself when it is written into src
+		if file == target {
+			return nil
+		}
+		name, err := filepath.Rel(base, file)
+		if err != nil {
+			return err
+		}
+		return w.WriteEntry(filepath.ToSlash(name), info, file)
+	})
+	if err != nil {
+		w.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if err = buf.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func newEntryWriter(w io.Writer, format Format) (entryWriter, error) {
+	switch format {
+	case TarGz, "":
+		gw, err := gzip.NewWriterLevel(w, gzip.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		return &tarEntryWriter{tw: tar.NewWriter(gw), compressor: gw}, nil
+	case TarZst:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return &tarEntryWriter{tw: tar.NewWriter(zw), compressor: zw}, nil
+	case Tar:
+		return &tarEntryWriter{tw: tar.NewWriter(w)}, nil
+	case Zip:
+		return &zipEntryWriter{zw: zip.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("not support archive format %s", format)
+	}
+}
+
+type tarEntryWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser
+}
+
+func (t *tarEntryWriter) WriteEntry(name string, info os.FileInfo, file string) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(file)
+		if err != nil {
+			return err
+		}
+		link = target
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("create tar header of %s failure %s", file, err.Error())
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	return copyFile(t.tw, file, info)
+}
+
+func (t *tarEntryWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	if t.compressor != nil {
+		return t.compressor.Close()
+	}
+	return nil
+}
+
+type zipEntryWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipEntryWriter) WriteEntry(name string, info os.FileInfo, file string) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return fmt.Errorf("create zip header of %s failure %s", file, err.Error())
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	} else {
+		hdr.Method = zip.Deflate
+	}
+	// Unzip restores the owner from the comment
+	if uid, gid, ok := fileOwner(info); ok {
+		hdr.Comment = fmt.Sprintf("%d/%d", uid, gid)
+	}
+	w, err := z.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(file)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, target)
+		return err
+	case info.Mode().IsRegular():
+		return copyFile(w, file, info)
+	}
+	return nil
+}
+
+func (z *zipEntryWriter) Close() error {
+	return z.zw.Close()
+}
+
+// copyFile copy exactly the size recorded in the header, and fails if the file changed meanwhile
+func copyFile(w io.Writer, file string, info os.FileInfo) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.CopyN(w, f, info.Size()); err != nil {
+		return fmt.Errorf("read file %s failure %s", file, err.Error())
+	}
+	after, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) {
+		return fmt.Errorf("file %s changed as we read it", file)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/zip"
+)
+
+// PartIndexSuffix suffix of the part index written next to the parts,
+// e.g. app-1.0-ram.tar.gz.parts.json for app-1.0-ram.tar.gz.001, .002 ...
+const PartIndexSuffix = ".parts.json"
+
+var partSuffix = regexp.MustCompile(`\.[0-9]{3,}$`)
+
+// PartIndex lists the parts of a split package
+type PartIndex struct {
+	// Name file name of the package before it was split
+	Name     string     `json:"name"`
+	Format   Format     `json:"format"`
+	Size     int64      `json:"size"`
+	SHA256   string     `json:"sha256"`
+	PartSize int64      `json:"part_size"`
+	Parts    []PartFile `json:"parts"`
+}
+
+// PartFile a part of the split package
+type PartFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Split split the package into numbered parts of partSize bytes next to it, the part
+// index is written as <file>.parts.json and the package itself is removed
+func Split(file string, partSize int64) (*PartIndex, error) {
+	if partSize <= 0 {
+		return nil, fmt.Errorf("invalid part size %d", partSize)
+	}
+	format, err := SniffFormat(file)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	index := &PartIndex{Name: filepath.Base(file), Format: format, PartSize: partSize}
+	total := sha256.New()
+	var written []string
+	fail := func(err error) (*PartIndex, error) {
+		for _, part := range written {
+			os.Remove(part)
+		}
+		return nil, err
+	}
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s.%03d", index.Name, i)
+		part := filepath.Join(filepath.Dir(file), name)
+		out, err := os.Create(part)
+		if err != nil {
+			return fail(err)
+		}
+		written = append(written, part)
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(out, h, total), io.LimitReader(f, partSize))
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if n == 0 && i > 1 {
+			os.Remove(part)
+			break
+		}
+		index.Size += n
+		index.Parts = append(index.Parts, PartFile{Name: name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))})
+		if n < partSize {
+			break
+		}
+	}
+	index.SHA256 = hex.EncodeToString(total.Sum(nil))
+	body, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fail(err)
+	}
+	if err := ioutil.WriteFile(file+PartIndexSuffix, body, 0644); err != nil {
+		return fail(err)
+	}
+	f.Close()
+	return index, os.Remove(file)
+}
+
+// FindPartIndex returns the part index of a split package, path is the index itself, one of
+// the parts or the directory holding them. An empty string is returned if path is not split.
+func FindPartIndex(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		indexes, err := filepath.Glob(filepath.Join(path, "*"+PartIndexSuffix))
+		if err != nil {
+			return "", err
+		}
+		if len(indexes) != 1 {
+			return "", fmt.Errorf("expected one part index in %s, found %d", path, len(indexes))
+		}
+		return indexes[0], nil
+	}
+	if strings.HasSuffix(path, PartIndexSuffix) {
+		return path, nil
+	}
+	if partSuffix.MatchString(path) {
+		index := partSuffix.ReplaceAllString(path, "") + PartIndexSuffix
+		if _, err := os.Stat(index); err == nil {
+			return index, nil
+		}
+	}
+	return "", nil
+}
+
+// ReadPartIndex read the part index file
+func ReadPartIndex(indexFile string) (*PartIndex, error) {
+	body, err := ioutil.ReadFile(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	var index PartIndex
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, fmt.Errorf("parse part index %s failure %s", filepath.Base(indexFile), err.Error())
+	}
+	return &index, nil
+}
+
+// PartReader reads the parts of a split package as one stream, the checksum of every part
+// is verified when it is read through
+type PartReader struct {
+	index   *PartIndex
+	dir     string
+	files   []*os.File
+	offsets []int64
+	// sequential read state
+	current int
+	read    int64
+	hash    hash.Hash
+}
+
+// OpenParts open the split package, indexFile is returned by FindPartIndex
+func OpenParts(indexFile string) (*PartReader, error) {
+	index, err := ReadPartIndex(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	r := &PartReader{index: index, dir: filepath.Dir(indexFile), hash: sha256.New()}
+	var offset int64
+	for _, part := range index.Parts {
+		f, err := os.Open(filepath.Join(r.dir, filepath.Base(part.Name)))
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("open part %s failure %s", part.Name, err.Error())
+		}
+		r.files = append(r.files, f)
+		r.offsets = append(r.offsets, offset)
+		offset += part.Size
+	}
+	return r, nil
+}
+
+// Index the part index
+func (r *PartReader) Index() *PartIndex {
+	return r.index
+}
+
+// Size the size of the reassembled package
+func (r *PartReader) Size() int64 {
+	return r.index.Size
+}
+
+func (r *PartReader) Read(p []byte) (int, error) {
+	for r.current < len(r.files) {
+		part := r.index.Parts[r.current]
+		n, err := r.files[r.current].Read(p)
+		r.hash.Write(p[:n])
+		r.read += int64(n)
+		if err == io.EOF {
+			if err := r.finishPart(part); err != nil {
+				return n, err
+			}
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+	return 0, io.EOF
+}
+
+func (r *PartReader) finishPart(part PartFile) error {
+	if r.read != part.Size || hex.EncodeToString(r.hash.Sum(nil)) != part.SHA256 {
+		return fmt.Errorf("part %s is corrupted", part.Name)
+	}
+	r.current++
+	r.read = 0
+	r.hash.Reset()
+	return nil
+}
+
+// ReadAt read the reassembled package at off, the checksums are not verified, see Verify
+func (r *PartReader) ReadAt(p []byte, off int64) (int, error) {
+	var total int
+	for len(p) > 0 {
+		if off >= r.index.Size {
+			return total, io.EOF
+		}
+		i := len(r.offsets) - 1
+		for i > 0 && r.offsets[i] > off {
+			i--
+		}
+		partOff := off - r.offsets[i]
+		want := r.index.Parts[i].Size - partOff
+		if want > int64(len(p)) {
+			want = int64(len(p))
+		}
+		n, err := r.files[i].ReadAt(p[:want], partOff)
+		total += n
+		off += int64(n)
+		p = p[n:]
+		if err != nil && err != io.EOF {
+			return total, err
+		}
+		if int64(n) < want {
+			return total, io.ErrUnexpectedEOF
+		}
+	}
+	return total, nil
+}
+
+// Verify check the checksums of all parts
+func (r *PartReader) Verify() error {
+	for i, part := range r.index.Parts {
+		h := sha256.New()
+		n, err := io.Copy(h, io.NewSectionReader(r.files[i], 0, part.Size+1))
+		if err != nil {
+			return err
+		}
+		if n != part.Size || hex.EncodeToString(h.Sum(nil)) != part.SHA256 {
+			return fmt.Errorf("part %s is corrupted", part.Name)
+		}
+	}
+	return nil
+}
+
+// Close close the parts
+func (r *PartReader) Close() error {
+	for _, f := range r.files {
+		f.Close()
+	}
+	return nil
+}
+
+// UnpackParts extract the split package into target without reassembling it on disk,
+// path is the part index, one of the parts or the directory holding them
+func UnpackParts(path, target string) error {
+	indexFile, err := FindPartIndex(path)
+	if err != nil {
+		return err
+	}
+	if indexFile == "" {
+		return fmt.Errorf("%s is not a split package", path)
+	}
+	r, err := OpenParts(indexFile)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	if r.index.Format != Zip {
+		if err := unpackStream(r, target, r.index.Format, r.index.Name); err != nil {
+			return err
+		}
+		// the tar reader stops at the end marker, read the padding to verify the last part
+		_, err := io.Copy(ioutil.Discard, r)
+		return err
+	}
+	// the zip directory is at the end of the archive, the parts are read at random
+	if err := r.Verify(); err != nil {
+		return err
+	}
+	reader, err := zip.NewReader(r, r.Size())
+	if err != nil {
+		return fmt.Errorf("open zip archive %s failure %s", r.index.Name, err.Error())
+	}
+	return unzipReader(reader, target)
+}
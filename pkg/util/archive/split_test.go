@@ -0,0 +1,94 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitAndUnpackParts(t *testing.T) {
+	for _, format := range Formats {
+		t.Run(string(format), func(t *testing.T) {
+			src := writeTestTree(t)
+			pkg := filepath.Join(t.TempDir(), "app"+format.Ext())
+			if err := Pack(src, pkg, format); err != nil {
+				t.Fatal(err)
+			}
+			index, err := Split(pkg, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(index.Parts) < 2 {
+				t.Fatalf("expected several parts, got %d", len(index.Parts))
+			}
+			if _, err := os.Stat(pkg); !os.IsNotExist(err) {
+				t.Fatal("expected the package to be replaced by its parts")
+			}
+			// the first part and the directory are both accepted
+			for _, path := range []string{pkg + ".001", filepath.Dir(pkg)} {
+				target := t.TempDir()
+				if err := UnpackParts(path, target); err != nil {
+					t.Fatal(err)
+				}
+				content, err := ioutil.ReadFile(filepath.Join(target, "app-1.0-ram", "metadata.json"))
+				if err != nil || string(content) != `{"app_name":"app"}` {
+					t.Fatalf("unexpected metadata %q %v", content, err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnpackPartsDetectsCorruptedPart(t *testing.T) {
+	for _, format := range []Format{Tar, Zip} {
+		t.Run(string(format), func(t *testing.T) {
+			pkg := filepath.Join(t.TempDir(), "app"+format.Ext())
+			if err := Pack(writeTestTree(t), pkg, format); err != nil {
+				t.Fatal(err)
+			}
+			index, err := Split(pkg, 512)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := filepath.Join(filepath.Dir(pkg), index.Parts[len(index.Parts)-1].Name)
+			f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write([]byte("x"))
+			f.Close()
+			if err := UnpackParts(pkg+".001", t.TempDir()); err == nil {
+				t.Fatal("expected corrupted part to be detected")
+			}
+		})
+	}
+}
+
+func TestFindPartIndexIgnoresPlainPackage(t *testing.T) {
+	pkg := filepath.Join(t.TempDir(), "app.tar")
+	if err := Pack(writeTestTree(t), pkg, Tar); err != nil {
+		t.Fatal(err)
+	}
+	if index, err := FindPartIndex(pkg); err != nil || index != "" {
+		t.Fatalf("expected plain package, got %q %v", index, err)
+	}
+}
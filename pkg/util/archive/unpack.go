@@ -0,0 +1,366 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/zip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Unpack extract the archive into target, the format is detected by the content
+func Unpack(archive, target string) error {
+	format, err := SniffFormat(archive)
+	if err != nil {
+		return err
+	}
+	return UnpackFormat(archive, target, format)
+}
+
+// UnpackFormat extract the archive of the given format into target
+func UnpackFormat(archive, target string, format Format) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	if format == Zip {
+		return unzip(archive, target)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return unpackStream(f, target, format, archive)
+}
+
+// unpackStream extract the tar stream of the given format into target, name is used in errors
+func unpackStream(src io.Reader, target string, format Format, name string) error {
+	var r io.Reader = bufio.NewReaderSize(src, 1<<20)
+	switch format {
+	case TarGz:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("open gzip archive %s failure %s", name, err.Error())
+		}
+		defer gr.Close()
+		r = gr
+	case TarZst:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("open zstd archive %s failure %s", name, err.Error())
+		}
+		defer zr.Close()
+		r = zr
+	case Tar:
+	default:
+		return fmt.Errorf("not support archive format %s", format)
+	}
+	if err := untar(r, target); err != nil {
+		return fmt.Errorf("extract archive %s failure %s", name, err.Error())
+	}
+	return nil
+}
+
+func untar(r io.Reader, target string) error {
+	tr := tar.NewReader(r)
+	var dirs dirModes
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return dirs.apply()
+		}
+		if err != nil {
+			return err
+		}
+		file, err := entryPath(target, hdr.Name)
+		if err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(file, 0755); err != nil {
+				return err
+			}
+			dirs.add(file, mode)
+		case tar.TypeReg, tar.TypeRegA:
+			if err := writeFile(file, tr, mode); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := writeSymlink(file, hdr.Linkname); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source, err := entryPath(target, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			os.Remove(file)
+			if err := os.Link(source, file); err != nil {
+				return err
+			}
+		default:
+			// devices and fifos can not be created without privileges, image layers do not need them
+			continue
+		}
+		if canChown() {
+			if err := os.Lchown(file, hdr.Uid, hdr.Gid); err != nil {
+				return fmt.Errorf("change owner of %s failure %s", file, err.Error())
+			}
+		}
+		if hdr.Typeflag != tar.TypeSymlink {
+			os.Chtimes(file, hdr.ModTime, hdr.ModTime)
+		}
+	}
+}
+
+func unzip(archive, target string) error {
+	reader, err := zip.OpenDirectReader(archive)
+	if err != nil {
+		return fmt.Errorf("error opening archive: %v", err)
+	}
+	defer reader.Close()
+	return unzipReader(&reader.Reader, target)
+}
+
+func unzipReader(reader *zip.Reader, target string) error {
+	var dirs dirModes
+	for _, f := range reader.File {
+		file, err := entryPath(target, f.Name)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(file, 0755); err != nil {
+				return err
+			}
+			dirs.add(file, mode)
+		case mode&os.ModeSymlink != 0:
+			link, err := readZipEntry(f)
+			if err != nil {
+				return err
+			}
+			if err := writeSymlink(file, link); err != nil {
+				return err
+			}
+		default:
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("fileReader; error opening file: %v", err)
+			}
+			err = writeFile(file, rc, mode)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		if uid, gid, ok := zipOwner(f.Comment); ok && canChown() {
+			if err := os.Lchown(file, uid, gid); err != nil {
+				return fmt.Errorf("error changing owner: %v", err)
+			}
+		}
+	}
+	return dirs.apply()
+}
+
+type dirMode struct {
+	path string
+	mode os.FileMode
+}
+
+// dirModes directory modes applied after extraction, so read-only directories can be filled
+type dirModes []dirMode
+
+func (d *dirModes) add(path string, mode os.FileMode) {
+	*d = append(*d, dirMode{path: path, mode: mode})
+}
+
+func (d dirModes) apply() error {
+	for i := len(d) - 1; i >= 0; i-- {
+		if err := os.Chmod(d[i].path, d[i].mode.Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zipOwner parse the uid/gid kept in the entry comment
+func zipOwner(comment string) (uid, gid int, ok bool) {
+	guid := strings.Split(comment, "/")
+	if len(guid) != 2 {
+		return 0, 0, false
+	}
+	uid, err := strconv.Atoi(guid[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	gid, err = strconv.Atoi(guid[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return uid, gid, true
+}
+
+func readZipEntry(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	var b strings.Builder
+	if _, err := io.Copy(&b, rc); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// entryPath returns the path the entry is extracted to, entries escaping target
+// directly or through an extracted symlink are rejected
+func entryPath(target, name string) (string, error) {
+	file := filepath.Join(target, filepath.FromSlash(name))
+	if !within(target, file) {
+		return "", fmt.Errorf("illegal entry %s in archive, it escapes the target directory", name)
+	}
+	// check the nearest existing ancestor, the missing ones are created by the extraction
+	dir := filepath.Dir(file)
+	parent, err := filepath.EvalSymlinks(dir)
+	for err != nil && os.IsNotExist(err) && within(target, dir) && dir != target {
+		dir = filepath.Dir(dir)
+		parent, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", err
+	}
+	if !within(root, parent) {
+		return "", fmt.Errorf("illegal entry %s in archive, it is extracted through a symlink out of the target directory", name)
+	}
+	return file, nil
+}
+
+func within(dir, file string) bool {
+	dir, file = filepath.Clean(dir), filepath.Clean(file)
+	return file == dir || strings.HasPrefix(file, dir+string(os.PathSeparator))
+}
+
+func writeFile(file string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// an existing symlink must not be followed
+	if info, err := os.Lstat(file); err == nil && !info.Mode().IsRegular() {
+		if err := os.RemoveAll(file); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("write file %s failure %s", file, err.Error())
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// the umask is not applied to the archived mode
+	return os.Chmod(file, mode.Perm()|mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+}
+
+func writeSymlink(file, link string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	os.Remove(file)
+	return os.Symlink(link, file)
+}
+
+// ReadEntry returns the content of the first entry matched in the archive without extracting it
+func ReadEntry(file string, match func(name string) bool) ([]byte, error) {
+	format, err := SniffFormat(file)
+	if err != nil {
+		return nil, err
+	}
+	if format == Zip {
+		reader, err := zip.OpenDirectReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		for _, f := range reader.File {
+			if match(f.Name) {
+				content, err := readZipEntry(f)
+				return []byte(content), err
+			}
+		}
+		return nil, os.ErrNotExist
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+	switch format {
+	case TarGz:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	case TarZst:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, os.ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && match(hdr.Name) {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
@@ -20,20 +20,18 @@ package util
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
-	"github.com/goodrain/rainbond-oam/pkg/util/zip"
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
-	"strconv"
+	"sort"
 	"strings"
 )
 
@@ -75,86 +73,23 @@ func getVariableKey(source string) (key, value string) {
 	return k, ""
 }
 
-// Unzip archive file to target dir
-func Unzip(archive, target string) error {
-	reader, err := zip.OpenDirectReader(archive)
-	if err != nil {
-		return fmt.Errorf("error opening archive: %v", err)
-	}
-	if err := os.MkdirAll(target, 0755); err != nil {
-		return err
-	}
-	for _, file := range reader.File {
-		run := func() error {
-			path := filepath.Join(target, file.Name)
-			if file.FileInfo().IsDir() {
-				os.MkdirAll(path, file.Mode())
-				if file.Comment != "" && strings.Contains(file.Comment, "/") {
-					guid := strings.Split(file.Comment, "/")
-					if len(guid) == 2 {
-						uid, _ := strconv.Atoi(guid[0])
-						gid, _ := strconv.Atoi(guid[1])
-						if err := os.Chown(path, uid, gid); err != nil {
-							return fmt.Errorf("error changing owner: %v", err)
-						}
-					}
-				}
-				return nil
-			}
-
-			fileReader, err := file.Open()
-			if err != nil {
-				return fmt.Errorf("fileReader; error opening file: %v", err)
-			}
-			defer fileReader.Close()
-			targetFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-			if err != nil {
-				return fmt.Errorf("targetFile; error opening file: %v", err)
-			}
-			defer targetFile.Close()
-
-			if _, err := io.Copy(targetFile, fileReader); err != nil {
-				return fmt.Errorf("error copy file: %v", err)
-			}
-			if file.Comment != "" && strings.Contains(file.Comment, "/") {
-				guid := strings.Split(file.Comment, "/")
-				if len(guid) == 2 {
-					uid, _ := strconv.Atoi(guid[0])
-					gid, _ := strconv.Atoi(guid[1])
-					if err := os.Chown(path, uid, gid); err != nil {
-						return err
-					}
-				}
-			}
-			return nil
-		}
-		if err := run(); err != nil {
-			return err
-		}
-	}
-
-	return nil
+// Unzip archive file to target dir, the owner kept in the entry comment as uid/gid is restored
+func Unzip(file, target string) error {
+	return archive.UnpackFormat(file, target, archive.Zip)
 }
 
-// Untar tar -zxvf
-func Untar(archive, target string) error {
-	cmd := exec.Command("tar", "-xzf", archive, "-C", target)
-	logrus.Infof("untar cmd: [%s]", cmd.String())
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to untar app %s, error is [%s] , stderr is [%s]", archive, err.Error(), stderr.String())
+// Untar extract the tar package, gzip and zstd compression is detected by the content
+func Untar(file, target string) error {
+	logrus.Infof("untar %s to %s", file, target)
+	if err := archive.Unpack(file, target); err != nil {
+		return fmt.Errorf("failed to untar app %s, error is [%s]", file, err.Error())
 	}
 	return nil
 }
 
 // UnImagetar image-tar
-func UnImagetar(archive, target string) error {
-	cmd := exec.Command("tar", "-xf", archive, "-C", target)
-	if err := cmd.Run(); err != nil {
-		return err
-	}
-	return nil
+func UnImagetar(file, target string) error {
+	return archive.UnpackFormat(file, target, archive.Tar)
 }
 
 // GetFileList -
@@ -202,6 +137,16 @@ func ReadJson(filePath string) (result string) {
 	return result
 }
 
+// SortedKeys returns the keys of the map in order, so the rendered files do not change between exports
+func SortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // FormatPath format path
 func FormatPath(s string) string {
 	log.Println("runtime.GOOS:", runtime.GOOS)
@@ -216,34 +161,11 @@ func FormatPath(s string) string {
 	}
 }
 
-// CopyDir copy dir
+// CopyDir copy src into dest dir like cp -R
 func CopyDir(src string, dest string) error {
-	_, err := os.Stat(dest)
-	if err != nil {
-		if !os.IsExist(err) {
-			err := os.MkdirAll(dest, 0755)
-			if err != nil {
-				fmt.Println("make and copy dir error", err)
-				return err
-			}
-		}
-	}
-	src = FormatPath(src)
-	dest = FormatPath(dest)
-
-	var cmd *exec.Cmd
-
-	switch runtime.GOOS {
-	case "windows":
-		cmd = exec.Command("xcopy", src, dest, "/I", "/E")
-	case "darwin", "linux":
-		cmd = exec.Command("cp", "-R", src, dest)
-	}
-	outPut, err := cmd.Output()
-	if err != nil {
-		fmt.Printf("Output error: %s\n", err.Error())
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		fmt.Println("make and copy dir error", err)
 		return err
 	}
-	fmt.Println(outPut)
-	return nil
+	return archive.Copy(src, dest)
 }
@@ -18,8 +18,18 @@
 
 package util
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestNewUUID(t *testing.T) {
 	t.Log(NewUUID())
 }
+
+func TestSortedKeys(t *testing.T) {
+	keys := SortedKeys(map[string]string{"b": "2", "a": "1", "c": "3"})
+	if strings.Join(keys, ",") != "a,b,c" {
+		t.Fatalf("expected the keys in order, got %v", keys)
+	}
+}
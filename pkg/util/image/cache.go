@@ -0,0 +1,282 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	refdocker "github.com/containerd/containerd/reference/docker"
+	"github.com/goodrain/rainbond-oam/pkg/util/ocilayout"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultCacheSize size limit of the blobs of the image cache, 20GiB
+	DefaultCacheSize int64 = 20 << 30
+
+	cacheLayoutDir = "layout"
+	cacheStateFile = "cache.json"
+)
+
+// Cache content addressed cache of the saved images shared by the exports. The configs and
+// layers are kept in an OCI image layout under the cache dir, an image is taken from the
+// cache instead of pulled and saved again if the digest of its reference in the registry
+// is the cached one. The least recently used images are evicted when the blobs exceed
+// the size limit. One process uses the cache dir at a time.
+type Cache struct {
+	dir     string
+	maxSize int64
+	layout  *ocilayout.Layout
+	mu      sync.Mutex
+	state   cacheState
+	// resolved the registry digests of the images of the export
+	resolved map[string]string
+	// hits the images of the export taken from the cache
+	hits map[string]struct{}
+	// used the keys of the images of the export, they are not evicted
+	used map[string]struct{}
+	now  func() time.Time
+}
+
+type cacheState struct {
+	Entries map[string]*cacheEntry `json:"entries"`
+}
+
+// cacheEntry an image of the cache, the key is its name in the cache layout
+type cacheEntry struct {
+	// Name the name the image is saved as by the image runtime
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// OpenCache open the cache under dir, it is created if it does not exist. The blobs are
+// not limited if maxSize is not positive.
+func OpenCache(dir string, maxSize int64) (*Cache, error) {
+	layout, err := ocilayout.Create(filepath.Join(dir, cacheLayoutDir))
+	if err != nil {
+		return nil, fmt.Errorf("create image cache failure %s", err.Error())
+	}
+	c := &Cache{
+		dir:      dir,
+		maxSize:  maxSize,
+		layout:   layout,
+		state:    cacheState{Entries: make(map[string]*cacheEntry)},
+		resolved: make(map[string]string),
+		hits:     make(map[string]struct{}),
+		used:     make(map[string]struct{}),
+		now:      time.Now,
+	}
+	body, err := ioutil.ReadFile(filepath.Join(dir, cacheStateFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(body, &c.state); err != nil {
+			return nil, fmt.Errorf("read image cache state failure %s", err.Error())
+		}
+		if c.state.Entries == nil {
+			c.state.Entries = make(map[string]*cacheEntry)
+		}
+	}
+	return c, nil
+}
+
+// Hits returns the images of the export taken from the cache
+func (c *Cache) Hits() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var hits []string
+	for name := range c.hits {
+		hits = append(hits, name)
+	}
+	sort.Strings(hits)
+	return hits
+}
+
+// lookup resolve the digest of the image in its registry and returns true if the image
+// is cached, images that can not be resolved are pulled as usual
+func (c *Cache) lookup(req PullRequest, options PullOptions, logger *logrus.Logger) bool {
+	pinned, err := ResolveDigest(req.Image, req.Username, req.Password, options.Timeout*60)
+	if err != nil {
+		logger.Warningf("resolve image %s for the image cache failure %s", req.Image, err.Error())
+		return false
+	}
+	named, err := refdocker.ParseDockerRef(pinned)
+	if err != nil {
+		return false
+	}
+	digested, ok := named.(refdocker.Digested)
+	if !ok {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resolved[req.Image] = digested.Digest().String()
+	key, ok := c.key(req.Image, options)
+	if !ok {
+		return false
+	}
+	if _, ok := c.state.Entries[key]; !ok {
+		return false
+	}
+	if _, err := c.layout.Find(key); err != nil {
+		delete(c.state.Entries, key)
+		return false
+	}
+	c.hits[req.Image] = struct{}{}
+	c.used[key] = struct{}{}
+	return true
+}
+
+// key the name of the image in the cache layout, the repository and the digest of the
+// image and the platforms it is saved for
+func (c *Cache) key(image string, options PullOptions) (string, bool) {
+	dgst, ok := c.resolved[image]
+	if !ok {
+		return "", false
+	}
+	named, err := refdocker.ParseDockerRef(image)
+	if err != nil {
+		return "", false
+	}
+	key := named.Name() + "@" + dgst
+	if platforms := options.PlatformsOf(image); len(platforms) > 0 {
+		key += "#" + platforms.String()
+	}
+	return key, true
+}
+
+// save write the images into the destination tarball, the cached images are copied from the
+// cache and the others are saved by the image runtime and added to the cache
+func (c *Cache) save(client Client, destination string, names []string, options PullOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	staging := destination + ".layout"
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	layout, err := ocilayout.Create(staging)
+	if err != nil {
+		return err
+	}
+	var missed []string
+	for _, name := range names {
+		key, ok := c.key(name, options)
+		if _, hit := c.hits[name]; !ok || !hit {
+			missed = append(missed, name)
+			continue
+		}
+		entry, ok := c.state.Entries[key]
+		if !ok {
+			return fmt.Errorf("image %s is not in the image cache any more", name)
+		}
+		if _, err := layout.CopyImage(c.layout, key, entry.Name); err != nil {
+			return fmt.Errorf("copy image %s from the image cache failure %s", name, err.Error())
+		}
+		entry.LastUsed = c.now()
+	}
+	if len(missed) > 0 {
+		tarball := destination + ".saved"
+		defer os.Remove(tarball)
+		if err := saveImages(client, tarball, missed, options); err != nil {
+			return err
+		}
+		saved, err := layout.AddArchive(tarball)
+		if err != nil {
+			return err
+		}
+		for _, name := range missed {
+			key, ok := c.key(name, options)
+			savedName := findSavedName(saved, name)
+			if !ok || savedName == "" {
+				continue
+			}
+			size, err := c.layout.CopyImage(layout, savedName, key)
+			if err != nil {
+				return fmt.Errorf("add image %s to the image cache failure %s", name, err.Error())
+			}
+			c.state.Entries[key] = &cacheEntry{Name: savedName, Size: size, LastUsed: c.now()}
+			c.used[key] = struct{}{}
+		}
+	}
+	if err := layout.WriteArchive(destination); err != nil {
+		return err
+	}
+	if err := c.evict(); err != nil {
+		return fmt.Errorf("evict image cache failure %s", err.Error())
+	}
+	return c.writeState()
+}
+
+// evict remove the least recently used images until the blobs fit in the size limit, the
+// images of the export are kept
+func (c *Cache) evict() error {
+	if c.maxSize <= 0 {
+		return nil
+	}
+	size, err := c.layout.Size()
+	if err != nil || size <= c.maxSize {
+		return err
+	}
+	var keys []string
+	for key := range c.state.Entries {
+		if _, ok := c.used[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.state.Entries[keys[i]].LastUsed.Before(c.state.Entries[keys[j]].LastUsed)
+	})
+	for _, key := range keys {
+		if size <= c.maxSize {
+			break
+		}
+		if err := c.layout.RemoveImage(key); err != nil {
+			return err
+		}
+		delete(c.state.Entries, key)
+		removed, err := c.layout.Prune()
+		if err != nil {
+			return err
+		}
+		size -= removed
+		logrus.Infof("evict image %s from the image cache, %d bytes freed", key, removed)
+	}
+	return nil
+}
+
+func (c *Cache) writeState() error {
+	body, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(c.dir, "."+cacheStateFile+".tmp")
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(c.dir, cacheStateFile))
+}
+
+// findSavedName returns the name the image is saved as, runtimes may save the normalized name
+func findSavedName(saved []string, name string) string {
+	named, err := refdocker.ParseDockerRef(name)
+	if err != nil {
+		return ""
+	}
+	for _, s := range saved {
+		if s == name {
+			return s
+		}
+		if n, err := refdocker.ParseDockerRef(s); err == nil && n.String() == named.String() {
+			return s
+		}
+	}
+	return ""
+}
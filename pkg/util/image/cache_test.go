@@ -0,0 +1,146 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/ocilayout"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// savingClient pulls nothing and saves every image as a docker save tarball with its own layer
+type savingClient struct {
+	Client
+	pulls []string
+	saves [][]string
+}
+
+func (c *savingClient) ImagePull(image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	c.pulls = append(c.pulls, image)
+	return &ocispec.ImageConfig{}, nil
+}
+
+func (c *savingClient) ImageSave(destination string, names []string) error {
+	c.saves = append(c.saves, names)
+	f, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	type manifest struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	var manifests []manifest
+	entries := map[string]string{}
+	for i, name := range names {
+		id := string(rune('a' + i))
+		manifests = append(manifests, manifest{Config: id + ".json", RepoTags: []string{name}, Layers: []string{id + "/layer.tar"}})
+		entries[id+".json"] = `{"architecture":"amd64","os":"linux","config":{"Cmd":["` + name + `"]}}`
+		entries[id+"/layer.tar"] = "layer of " + name
+	}
+	body, _ := json.Marshal(manifests)
+	entries["manifest.json"] = string(body)
+	for entry, body := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: entry, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		tw.Write([]byte(body))
+	}
+	return tw.Close()
+}
+
+// exportWithCache pull and save the images with the cache and returns the images in the tarball
+func exportWithCache(t *testing.T, client *savingClient, cache *Cache, names ...string) []string {
+	t.Helper()
+	var requests []PullRequest
+	for _, name := range names {
+		requests = append(requests, PullRequest{Image: name})
+	}
+	options := PullOptions{Concurrency: 1, Timeout: 1, Cache: cache}
+	if err := PullImages(client, requests, options, logrus.StandardLogger()); err != nil {
+		t.Fatal(err)
+	}
+	tarball := filepath.Join(t.TempDir(), "images.tar")
+	if err := SaveImages(client, tarball, names, options); err != nil {
+		t.Fatal(err)
+	}
+	layout, err := ocilayout.Create(filepath.Join(t.TempDir(), "layout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := layout.AddArchive(tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return saved
+}
+
+func TestCacheTakesUnchangedImagesFromTheCache(t *testing.T) {
+	dir := t.TempDir()
+	web := "goodrain.me/demo/web@" + testManifestDigest
+	db := "goodrain.me/demo/db@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	client := &savingClient{}
+	cache, err := OpenCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportWithCache(t, client, cache, web)
+	if len(client.pulls) != 1 || len(cache.Hits()) != 0 {
+		t.Fatalf("expected the first export to pull the image, got %v", client.pulls)
+	}
+
+	// a later export reads the state written by the first one
+	client = &savingClient{}
+	cache, err = OpenCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved := exportWithCache(t, client, cache, web, db); len(saved) != 2 {
+		t.Fatalf("expected both images in the tarball, got %v", saved)
+	}
+	if len(client.pulls) != 1 || client.pulls[0] != db {
+		t.Fatalf("expected only the new image to be pulled, got %v", client.pulls)
+	}
+	if len(client.saves) != 1 || len(client.saves[0]) != 1 || client.saves[0][0] != db {
+		t.Fatalf("expected only the new image to be saved, got %v", client.saves)
+	}
+	if hits := cache.Hits(); len(hits) != 1 || hits[0] != web {
+		t.Fatalf("expected the cached image to be reported, got %v", hits)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsedImages(t *testing.T) {
+	dir := t.TempDir()
+	web := "goodrain.me/demo/web@" + testManifestDigest
+	db := "goodrain.me/demo/db@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	cache, err := OpenCache(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportWithCache(t, &savingClient{}, cache, web)
+	if len(cache.state.Entries) != 1 {
+		t.Fatalf("expected the images of the export to be kept, got %v", cache.state.Entries)
+	}
+
+	cache, err = OpenCache(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportWithCache(t, &savingClient{}, cache, db)
+	if len(cache.state.Entries) != 1 {
+		t.Fatalf("expected the old image to be evicted, got %v", cache.state.Entries)
+	}
+	for key := range cache.state.Entries {
+		if _, err := cache.layout.Find(key); err != nil || !strings.HasPrefix(key, "goodrain.me/demo/db@") {
+			t.Fatalf("expected db to be kept, got %s %v", key, err)
+		}
+	}
+}
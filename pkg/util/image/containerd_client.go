@@ -146,6 +146,86 @@ func (c *containerdImageCliImpl) ImagePull(image string, username, password stri
 	return getImageConfig(ctx, img)
 }
 
+// ImagePullPlatforms fetch the image for the platforms, the image is not unpacked as the platforms
+// may not run on the host. An error is returned if the image is not available for one of them.
+func (c *containerdImageCliImpl) ImagePullPlatforms(image string, username, password string, timeout int, platforms Platforms) (*ocispec.ImageConfig, error) {
+	named, err := refdocker.ParseDockerRef(image)
+	if err != nil {
+		return nil, err
+	}
+	reference := named.String()
+	ctx := namespaces.WithNamespace(context.Background(), Namespace)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Minute*time.Duration(timeout))
+		defer cancel()
+	}
+	fetch := func(defaultScheme string) (images.Image, error) {
+		options := containerdResolverOptions(ctx, docker.NewInMemoryTracker(), newContainerdHostOptions(username, password, defaultScheme))
+		return c.client.Fetch(ctx, reference,
+			//nolint:staticcheck
+			containerd.WithSchema1Conversion, //lint:ignore SA1019 nerdctl should support schema1 as well.
+			containerd.WithPlatformMatcher(platforms.matcher()),
+			containerd.WithResolver(docker.NewResolver(options)),
+		)
+	}
+	img, err := fetch("")
+	if isPlainHTTPRegistryError(err) {
+		logrus.Infof("pull image %s with HTTPS failed against plain HTTP registry, retry with HTTP", reference)
+		img, err = fetch("http")
+	}
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("pull image %s of platforms %s success", reference, platforms)
+	if err := checkPlatforms(ctx, c.client.ContentStore(), reference, img.Target, platforms); err != nil {
+		return nil, err
+	}
+	return getImageConfig(ctx, containerd.NewImageWithPlatform(c.client, img, platforms.matcher()))
+}
+
+// ImageSavePlatforms save the images with the manifests of their platforms, the images of
+// several platforms are saved as indexes of those platforms
+func (c *containerdImageCliImpl) ImageSavePlatforms(destination string, images []PlatformImage) error {
+	ctx := namespaces.WithNamespace(context.Background(), Namespace)
+	// the filtered indexes are only referenced by the lease until the images are saved
+	ctx, done, err := c.client.WithLease(ctx)
+	if err != nil {
+		return err
+	}
+	defer done(ctx)
+	var all Platforms
+	var exportOpts []archive.ExportOpt
+	for _, image := range images {
+		ref, err := refdocker.ParseDockerRef(image.Name)
+		if err != nil {
+			return err
+		}
+		wanted := image.Platforms
+		if len(wanted) == 0 {
+			wanted = DefaultPlatforms()
+		}
+		img, err := c.client.ImageService().Get(ctx, ref.String())
+		if err != nil {
+			return fmt.Errorf("get image %s failure %s", ref.String(), err.Error())
+		}
+		desc, err := filterIndex(ctx, c.client.ContentStore(), ref.String(), img.Target, wanted)
+		if err != nil {
+			return err
+		}
+		exportOpts = append(exportOpts, archive.WithManifest(desc, ref.String()))
+		all = all.Union(wanted)
+	}
+	// the manifest.json of docker load refers the manifest of the host platform if it is saved
+	exportOpts = append(exportOpts, archive.WithPlatform(all.matcher()))
+	w, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return c.client.Export(ctx, w, exportOpts...)
+}
+
 func getImageConfig(ctx context.Context, image containerd.Image) (*ocispec.ImageConfig, error) {
 	desc, err := image.Config(ctx)
 	if err != nil {
@@ -196,7 +276,9 @@ func (c *containerdImageCliImpl) ImagePush(image, user, pass string, timeout int
 	}
 	desc := img.Target
 	cs := c.client.ContentStore()
-	if manifests, err := images.Children(ctx, cs, desc); err == nil && len(manifests) > 0 {
+	// indexes of images saved for several platforms are pushed as they are, the manifest of
+	// the host platform is pushed if the content of the other platforms is not in the store
+	if manifests, err := images.Children(ctx, cs, desc); err == nil && len(manifests) > 0 && !contentComplete(ctx, cs, desc) {
 		matcher := platforms.NewMatcher(platforms.DefaultSpec())
 		for _, manifest := range manifests {
 			if manifest.Platform != nil && matcher.Match(*manifest.Platform) {
@@ -255,6 +337,19 @@ func (c *containerdImageCliImpl) ImagePush(image, user, pass string, timeout int
 	})
 }
 
+// contentComplete returns true if the image and all its children are in the store
+func contentComplete(ctx context.Context, cs content.Store, desc ocispec.Descriptor) bool {
+	complete := true
+	err := images.Walk(ctx, images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if _, err := cs.Info(ctx, desc.Digest); err != nil {
+			complete = false
+			return nil, images.ErrSkipDesc
+		}
+		return images.Children(ctx, cs, desc)
+	}), desc)
+	return err == nil && complete
+}
+
 func pushWithPlainHTTPFallback(push func(defaultScheme string) error) error {
 	defaultScheme := ""
 	for attempt := 1; attempt <= containerdPushMaxAttempts; attempt++ {
@@ -267,7 +362,7 @@ func pushWithPlainHTTPFallback(push func(defaultScheme string) error) error {
 				logrus.Infof("push image with HTTPS failed against plain HTTP registry, retry with HTTP")
 				defaultScheme = "http"
 			}
-		} else if !isTransientRegistryPushError(err) || attempt == containerdPushMaxAttempts {
+		} else if !isTransientRegistryError(err) || attempt == containerdPushMaxAttempts {
 			return err
 		}
 		logrus.Warnf("push image failed, retrying attempt %d/%d: %v", attempt+1, containerdPushMaxAttempts, err)
@@ -276,7 +371,7 @@ func pushWithPlainHTTPFallback(push func(defaultScheme string) error) error {
 	return nil
 }
 
-func isTransientRegistryPushError(err error) bool {
+func isTransientRegistryError(err error) bool {
 	if err == nil {
 		return false
 	}
@@ -0,0 +1,210 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Platforms the platforms images are pulled and saved for, e.g. linux/amd64 and linux/arm64
+type Platforms []ocispec.Platform
+
+// ParsePlatforms parse the platforms, e.g. linux/arm64 or linux/arm/v7, the OS is linux
+// if only the architecture is given. Empty and repeated platforms are dropped.
+func ParsePlatforms(specs ...string) (Platforms, error) {
+	var result Platforms
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if !strings.Contains(spec, "/") {
+			spec = "linux/" + spec
+		}
+		p, err := platforms.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("parse platform %s failure %s", spec, err.Error())
+		}
+		if !result.Contains(p) {
+			result = append(result, platforms.Normalize(p))
+		}
+	}
+	return result, nil
+}
+
+// DefaultPlatforms returns the platform of the host
+func DefaultPlatforms() Platforms {
+	return Platforms{platforms.DefaultSpec()}
+}
+
+// Contains returns true if one of the platforms matches the platform
+func (p Platforms) Contains(platform ocispec.Platform) bool {
+	matcher := platforms.NewMatcher(platform)
+	for _, item := range p {
+		if matcher.Match(item) {
+			return true
+		}
+	}
+	return false
+}
+
+// Union returns the platforms in p or o
+func (p Platforms) Union(o Platforms) Platforms {
+	result := append(Platforms{}, p...)
+	for _, item := range o {
+		if !result.Contains(item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Strings returns the platforms formatted, e.g. linux/arm/v7
+func (p Platforms) Strings() []string {
+	var result []string
+	for _, item := range p {
+		result = append(result, platforms.Format(item))
+	}
+	return result
+}
+
+func (p Platforms) String() string {
+	return strings.Join(p.Strings(), ",")
+}
+
+// isDefault returns true if the platforms are the platform of the host only
+func (p Platforms) isDefault() bool {
+	return len(p) == 0 || len(p) == 1 && DefaultPlatforms().Contains(p[0])
+}
+
+// matcher matches the platforms, the platform of the host is preferred if it is one of them
+func (p Platforms) matcher() platforms.MatchComparer {
+	if len(p) == 0 {
+		return platforms.Default()
+	}
+	ordered := append(Platforms{}, p...)
+	host := platforms.DefaultSpec()
+	for i, item := range ordered {
+		if platforms.NewMatcher(host).Match(item) {
+			ordered[0], ordered[i] = ordered[i], ordered[0]
+			break
+		}
+	}
+	return platforms.Ordered(ordered...)
+}
+
+// PlatformImage an image and the platforms it is saved for
+type PlatformImage struct {
+	Name      string
+	Platforms Platforms
+}
+
+// PlatformClient is implemented by the clients that pull and save images of other platforms
+// than the host, images of all platforms are kept side by side
+type PlatformClient interface {
+	ImagePullPlatforms(image string, username, password string, timeout int, platforms Platforms) (*ocispec.ImageConfig, error)
+	ImageSavePlatforms(destination string, images []PlatformImage) error
+}
+
+// SaveImages save the images into the destination tarball for the platforms of the options,
+// the client must implement PlatformClient if they are not the platform of the host. The
+// images are taken from and added to the cache of the options if it is set.
+func SaveImages(client Client, destination string, names []string, options PullOptions) error {
+	if options.Cache != nil {
+		return options.Cache.save(client, destination, names, options)
+	}
+	return saveImages(client, destination, names, options)
+}
+
+func saveImages(client Client, destination string, names []string, options PullOptions) error {
+	var saves []PlatformImage
+	hostOnly := true
+	for _, name := range names {
+		p := options.PlatformsOf(name)
+		hostOnly = hostOnly && p.isDefault()
+		saves = append(saves, PlatformImage{Name: name, Platforms: p})
+	}
+	if hostOnly {
+		return client.ImageSave(destination, names)
+	}
+	platformed, ok := client.(PlatformClient)
+	if !ok {
+		return fmt.Errorf("saving images of other platforms than the host requires containerd")
+	}
+	return platformed.ImageSavePlatforms(destination, saves)
+}
+
+// pullImage pull the image for the platforms of the options
+func pullImage(client Client, req PullRequest, options PullOptions) error {
+	p := options.PlatformsOf(req.Image)
+	if p.isDefault() {
+		_, err := client.ImagePull(req.Image, req.Username, req.Password, options.Timeout)
+		return err
+	}
+	platformed, ok := client.(PlatformClient)
+	if !ok {
+		return fmt.Errorf("pulling images of platforms %s requires containerd", p)
+	}
+	_, err := platformed.ImagePullPlatforms(req.Image, req.Username, req.Password, options.Timeout, p)
+	return err
+}
+
+// checkPlatforms returns an error if the image has no manifest of one of the platforms
+func checkPlatforms(ctx context.Context, provider content.Provider, name string, target ocispec.Descriptor, wanted Platforms) error {
+	available, err := images.Platforms(ctx, provider, target)
+	if err != nil {
+		return fmt.Errorf("read platforms of image %s failure %s", name, err.Error())
+	}
+	for _, p := range wanted {
+		if !Platforms(available).Contains(p) {
+			return fmt.Errorf("image %s is not available for platform %s, it has %s", name, platforms.Format(p), Platforms(available))
+		}
+	}
+	return nil
+}
+
+// filterIndex returns the index of the image with the manifests of the platforms only, the
+// index is written into the store if manifests are dropped. Manifests are returned as is.
+func filterIndex(ctx context.Context, store content.Store, name string, desc ocispec.Descriptor, wanted Platforms) (ocispec.Descriptor, error) {
+	if desc.MediaType != ocispec.MediaTypeImageIndex && desc.MediaType != images.MediaTypeDockerSchema2ManifestList {
+		return desc, nil
+	}
+	body, err := content.ReadBlob(ctx, store, desc)
+	if err != nil {
+		return desc, err
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(body, &index); err != nil {
+		return desc, err
+	}
+	var manifests []ocispec.Descriptor
+	for _, m := range index.Manifests {
+		if m.Platform != nil && wanted.Contains(*m.Platform) {
+			manifests = append(manifests, m)
+		}
+	}
+	if len(manifests) == 0 {
+		return desc, fmt.Errorf("image %s has no manifest of platforms %s", name, wanted)
+	}
+	if len(manifests) == len(index.Manifests) {
+		return desc, nil
+	}
+	index.Manifests = manifests
+	body, err = json.Marshal(index)
+	if err != nil {
+		return desc, err
+	}
+	filtered := ocispec.Descriptor{MediaType: desc.MediaType, Digest: digest.FromBytes(body), Size: int64(len(body))}
+	if err := content.WriteBlob(ctx, store, filtered.Digest.String(), bytes.NewReader(body), filtered); err != nil {
+		return desc, fmt.Errorf("write index of image %s failure %s", name, err.Error())
+	}
+	return filtered, nil
+}
@@ -0,0 +1,127 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// platformPullClient records the platforms the images are pulled and saved for
+type platformPullClient struct {
+	flakyPullClient
+	mu        sync.Mutex
+	platforms map[string]string
+	saved     []PlatformImage
+}
+
+func (c *platformPullClient) ImagePullPlatforms(image string, username, password string, timeout int, platforms Platforms) (*ocispec.ImageConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.platforms[image] = platforms.String()
+	return &ocispec.ImageConfig{}, nil
+}
+
+func (c *platformPullClient) ImageSavePlatforms(destination string, images []PlatformImage) error {
+	c.saved = images
+	return nil
+}
+
+func TestParsePlatforms(t *testing.T) {
+	got, err := ParsePlatforms("linux/amd64", " arm64 ", "", "linux/arm64/v8", "linux/arm/v7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "linux/amd64,linux/arm64,linux/arm/v7" {
+		t.Fatalf("unexpected platforms %s", got)
+	}
+	if !got.Contains(ocispec.Platform{OS: "linux", Architecture: "aarch64"}) || got.Contains(ocispec.Platform{OS: "linux", Architecture: "s390x"}) {
+		t.Fatalf("unexpected matching of platforms %s", got)
+	}
+	if _, err := ParsePlatforms("linux/amd64/v1/x"); err == nil {
+		t.Fatal("expected an invalid platform to fail")
+	}
+}
+
+func TestPullAndSaveImagesOfPlatforms(t *testing.T) {
+	client := &platformPullClient{flakyPullClient: *newFlakyPullClient(), platforms: map[string]string{}}
+	arm, _ := ParsePlatforms("linux/arm64")
+	options := DefaultPullOptions()
+	options.Platforms, _ = ParsePlatforms("linux/amd64", "linux/arm64")
+	options.ImagePlatforms = map[string]Platforms{"demo/db": arm}
+	requests := []PullRequest{{Image: "demo/web", Owners: []string{"web"}}, {Image: "demo/db", Owners: []string{"db"}}}
+	if err := PullImages(client, requests, options, logrus.StandardLogger()); err != nil {
+		t.Fatal(err)
+	}
+	if client.platforms["demo/web"] != "linux/amd64,linux/arm64" || client.platforms["demo/db"] != "linux/arm64" || len(client.pulls) != 0 {
+		t.Fatalf("unexpected pulls %v %v", client.platforms, client.pulls)
+	}
+	if err := SaveImages(client, "images.tar", []string{"demo/web", "demo/db"}, options); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.saved) != 2 || client.saved[1].Platforms.String() != "linux/arm64" {
+		t.Fatalf("unexpected saved images %+v", client.saved)
+	}
+	// clients without platform support pull and save the images of the host only
+	if err := PullImages(newFlakyPullClient(), requests, options, logrus.StandardLogger()); err == nil || !strings.Contains(err.Error(), "requires containerd") {
+		t.Fatalf("expected pulling other platforms to fail, got %v", err)
+	}
+	if err := PullImages(newFlakyPullClient(), requests, DefaultPullOptions(), logrus.StandardLogger()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestBlob(t *testing.T, store content.Store, mediaType string, v interface{}) ocispec.Descriptor {
+	t.Helper()
+	body, _ := json.Marshal(v)
+	desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(body), Size: int64(len(body))}
+	if err := content.WriteBlob(context.Background(), store, desc.Digest.String(), bytes.NewReader(body), desc); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func TestFilterIndexKeepsWantedPlatforms(t *testing.T) {
+	ctx := context.Background()
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifests []ocispec.Descriptor
+	for _, arch := range []string{"amd64", "arm64", "s390x"} {
+		config := writeTestBlob(t, store, ocispec.MediaTypeImageConfig, ocispec.Image{OS: "linux", Architecture: arch})
+		manifest := writeTestBlob(t, store, ocispec.MediaTypeImageManifest, ocispec.Manifest{Config: config})
+		manifest.Platform = &ocispec.Platform{OS: "linux", Architecture: arch}
+		manifests = append(manifests, manifest)
+	}
+	index := writeTestBlob(t, store, ocispec.MediaTypeImageIndex, ocispec.Index{Manifests: manifests})
+
+	wanted, _ := ParsePlatforms("linux/amd64", "linux/arm64")
+	if err := checkPlatforms(ctx, store, "demo/web", index, wanted); err != nil {
+		t.Fatal(err)
+	}
+	filtered, err := filterIndex(ctx, store, "demo/web", index, wanted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	available, err := images.Platforms(ctx, store, filtered)
+	if err != nil || Platforms(available).String() != "linux/amd64,linux/arm64" {
+		t.Fatalf("unexpected platforms of the filtered index %v %v", available, err)
+	}
+	riscv, _ := ParsePlatforms("linux/riscv64")
+	if err := checkPlatforms(ctx, store, "demo/web", index, riscv); err == nil {
+		t.Fatal("expected a missing platform to fail")
+	}
+	if _, err := filterIndex(ctx, store, "demo/web", index, riscv); err == nil {
+		t.Fatal("expected an index without the platforms to fail")
+	}
+}
@@ -0,0 +1,173 @@
+package image
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultPullConcurrency images pulled at the same time
+	DefaultPullConcurrency = 4
+	// DefaultPullTimeout timeout of one image pull in minutes
+	DefaultPullTimeout = 30
+	// DefaultPullMaxAttempts attempts of one image pull on transient registry errors
+	DefaultPullMaxAttempts = 3
+)
+
+var sleepBeforeImagePullRetry = time.Sleep
+
+// PullOptions options of pulling a batch of images
+type PullOptions struct {
+	// Concurrency images pulled at the same time
+	Concurrency int
+	// Timeout timeout of one image pull in minutes
+	Timeout int
+	// MaxAttempts attempts of one image pull, only transient registry errors are retried
+	MaxAttempts int
+	// Platforms the platforms the images are pulled and saved for, the platform of the host if it is empty
+	Platforms Platforms
+	// ImagePlatforms the platforms of the images not pulled for Platforms, e.g. for the arch of their component
+	ImagePlatforms map[string]Platforms
+	// Cache the images saved by earlier exports are taken from the cache instead of pulled
+	Cache *Cache
+}
+
+// PlatformsOf returns the platforms the image is pulled and saved for
+func (o PullOptions) PlatformsOf(image string) Platforms {
+	if p, ok := o.ImagePlatforms[image]; ok && len(p) > 0 {
+		return p
+	}
+	return o.Platforms
+}
+
+// DefaultPullOptions returns the default pull options
+func DefaultPullOptions() PullOptions {
+	return PullOptions{Concurrency: DefaultPullConcurrency, Timeout: DefaultPullTimeout, MaxAttempts: DefaultPullMaxAttempts}
+}
+
+// PullRequest an image to pull, owners are the components or plugins that use it
+type PullRequest struct {
+	Image    string
+	Username string
+	Password string
+	Owners   []string
+}
+
+// PullFailure an image failed to pull
+type PullFailure struct {
+	Image  string
+	Owners []string
+	Err    error
+}
+
+// PullError the images failed to pull in a batch
+type PullError struct {
+	Failures []PullFailure
+}
+
+func (e *PullError) Error() string {
+	var parts []string
+	for _, f := range e.Failures {
+		parts = append(parts, fmt.Sprintf("%s (%s): %s", strings.Join(f.Owners, ", "), f.Image, f.Err.Error()))
+	}
+	return fmt.Sprintf("pull %d images failure: %s", len(e.Failures), strings.Join(parts, "; "))
+}
+
+// PullImages pull the images with a bounded worker pool. Identical image references are pulled
+// once, transient registry errors are retried with backoff, and all images are tried before the
+// failures are returned as a *PullError.
+func PullImages(client Client, requests []PullRequest, options PullOptions, logger *logrus.Logger) error {
+	if options.Concurrency < 1 {
+		options.Concurrency = 1
+	}
+	if options.MaxAttempts < 1 {
+		options.MaxAttempts = 1
+	}
+	requests = mergePullRequests(requests)
+	var (
+		mu       sync.Mutex
+		failures []PullFailure
+		wg       sync.WaitGroup
+	)
+	queue := make(chan PullRequest)
+	for i := 0; i < options.Concurrency && i < len(requests); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range queue {
+				if options.Cache != nil && options.Cache.lookup(req, options, logger) {
+					logger.Infof("use cached %s image %s", strings.Join(req.Owners, ", "), req.Image)
+					continue
+				}
+				if err := pullWithRetry(client, req, options, logger); err != nil {
+					mu.Lock()
+					failures = append(failures, PullFailure{Image: req.Image, Owners: req.Owners, Err: err})
+					mu.Unlock()
+					continue
+				}
+				logger.Infof("pull %s image %s success", strings.Join(req.Owners, ", "), req.Image)
+			}
+		}()
+	}
+	for _, req := range requests {
+		queue <- req
+	}
+	close(queue)
+	wg.Wait()
+	if len(failures) == 0 {
+		return nil
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Image < failures[j].Image })
+	return &PullError{Failures: failures}
+}
+
+func pullWithRetry(client Client, req PullRequest, options PullOptions, logger *logrus.Logger) error {
+	for attempt := 1; ; attempt++ {
+		err := pullImage(client, req, options)
+		if err == nil || !isTransientRegistryError(err) || attempt == options.MaxAttempts {
+			return err
+		}
+		logger.Warnf("pull image %s failed, retrying attempt %d/%d: %v", req.Image, attempt+1, options.MaxAttempts, err)
+		sleepBeforeImagePullRetry(time.Duration(attempt) * 2 * time.Second)
+	}
+}
+
+// mergePullRequests merge the requests of the same image, the first credentials supplied are used
+func mergePullRequests(requests []PullRequest) []PullRequest {
+	var merged []PullRequest
+	index := make(map[string]int)
+	for _, req := range requests {
+		if req.Image == "" {
+			continue
+		}
+		i, ok := index[req.Image]
+		if !ok {
+			index[req.Image] = len(merged)
+			merged = append(merged, PullRequest{Image: req.Image, Username: req.Username, Password: req.Password, Owners: append([]string{}, req.Owners...)})
+			continue
+		}
+		if merged[i].Username == "" && req.Username != "" {
+			merged[i].Username, merged[i].Password = req.Username, req.Password
+		}
+		for _, owner := range req.Owners {
+			if !containsString(merged[i].Owners, owner) {
+				merged[i].Owners = append(merged[i].Owners, owner)
+			}
+		}
+	}
+	return merged
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
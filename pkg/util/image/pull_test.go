@@ -0,0 +1,106 @@
+package image
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// flakyPullClient fails the pulls of an image for the configured times
+type flakyPullClient struct {
+	Client
+	mu       sync.Mutex
+	failures map[string]error
+	times    map[string]int
+	pulls    map[string]int
+	running  int32
+	peak     int32
+}
+
+func (c *flakyPullClient) ImagePull(image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	running := atomic.AddInt32(&c.running, 1)
+	defer atomic.AddInt32(&c.running, -1)
+	for {
+		peak := atomic.LoadInt32(&c.peak)
+		if running <= peak || atomic.CompareAndSwapInt32(&c.peak, peak, running) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pulls[image]++
+	if err, ok := c.failures[image]; ok && c.pulls[image] <= c.times[image] {
+		return nil, err
+	}
+	return &ocispec.ImageConfig{}, nil
+}
+
+func newFlakyPullClient() *flakyPullClient {
+	return &flakyPullClient{failures: map[string]error{}, times: map[string]int{}, pulls: map[string]int{}}
+}
+
+func noPullRetrySleep(t *testing.T) {
+	sleep := sleepBeforeImagePullRetry
+	sleepBeforeImagePullRetry = func(time.Duration) {}
+	t.Cleanup(func() { sleepBeforeImagePullRetry = sleep })
+}
+
+func TestPullImagesDeduplicatesAndBoundsConcurrency(t *testing.T) {
+	client := newFlakyPullClient()
+	var requests []PullRequest
+	for _, image := range []string{"a", "b", "c", "d", "e", "f", "a", "b"} {
+		requests = append(requests, PullRequest{Image: "demo/" + image, Owners: []string{image}})
+	}
+	if err := PullImages(client, requests, PullOptions{Concurrency: 2, MaxAttempts: 1}, logrus.StandardLogger()); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.pulls) != 6 || client.pulls["demo/a"] != 1 {
+		t.Fatalf("expected each image to be pulled once, got %v", client.pulls)
+	}
+	if client.peak > 2 {
+		t.Fatalf("expected at most 2 concurrent pulls, got %d", client.peak)
+	}
+}
+
+func TestPullImagesRetriesTransientErrors(t *testing.T) {
+	noPullRetrySleep(t)
+	client := newFlakyPullClient()
+	client.failures["demo/web"], client.times["demo/web"] = errors.New("connection reset by peer"), 2
+	if err := PullImages(client, []PullRequest{{Image: "demo/web", Owners: []string{"web"}}}, DefaultPullOptions(), logrus.StandardLogger()); err != nil {
+		t.Fatal(err)
+	}
+	if client.pulls["demo/web"] != 3 {
+		t.Fatalf("expected 3 attempts, got %d", client.pulls["demo/web"])
+	}
+}
+
+func TestPullImagesAggregatesFailures(t *testing.T) {
+	noPullRetrySleep(t)
+	client := newFlakyPullClient()
+	client.failures["demo/web"], client.times["demo/web"] = errors.New("manifest unknown"), 10
+	client.failures["demo/db"], client.times["demo/db"] = errors.New("service unavailable"), 10
+	requests := []PullRequest{
+		{Image: "demo/web", Owners: []string{"web"}},
+		{Image: "demo/db", Owners: []string{"db"}},
+		{Image: "demo/web", Owners: []string{"web-replica"}},
+		{Image: "demo/cache", Owners: []string{"cache"}},
+	}
+	err := PullImages(client, requests, DefaultPullOptions(), logrus.StandardLogger())
+	pullErr, ok := err.(*PullError)
+	if !ok || len(pullErr.Failures) != 2 {
+		t.Fatalf("expected 2 failures, got %v", err)
+	}
+	if client.pulls["demo/web"] != 1 || client.pulls["demo/db"] != DefaultPullMaxAttempts || client.pulls["demo/cache"] != 1 {
+		t.Fatalf("unexpected pulls %v", client.pulls)
+	}
+	if !strings.Contains(err.Error(), "web, web-replica (demo/web): manifest unknown") || !strings.Contains(err.Error(), "db (demo/db)") {
+		t.Fatalf("expected failures to name the components, got %s", err.Error())
+	}
+}
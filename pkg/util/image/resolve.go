@@ -0,0 +1,51 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	refdocker "github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/containerd/remotes/docker/config"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// ResolveDigest resolve the image in its registry and returns the reference pinned to the
+// manifest digest, e.g. nginx:1.21@sha256:..., the tag is kept for readability. No daemon
+// is required, references already pinned are returned as is.
+func ResolveDigest(image, username, password string, timeout int) (string, error) {
+	named, err := refdocker.ParseDockerRef(image)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := named.(refdocker.Digested); ok {
+		return image, nil
+	}
+	reference := named.String()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	dgst, err := resolveManifestDigest(ctx, reference, newContainerdHostOptions(username, password, ""))
+	if isPlainHTTPRegistryError(err) {
+		logrus.Infof("resolve image %s with HTTPS failed against plain HTTP registry, retry with HTTP", reference)
+		dgst, err = resolveManifestDigest(ctx, reference, newContainerdHostOptions(username, password, "http"))
+	}
+	if err != nil {
+		return "", fmt.Errorf("resolve image %s failure %s", image, err.Error())
+	}
+	pinned, err := refdocker.WithDigest(named, dgst)
+	if err != nil {
+		return "", err
+	}
+	return refdocker.FamiliarString(pinned), nil
+}
+
+func resolveManifestDigest(ctx context.Context, reference string, hostOpt config.HostOptions) (digest.Digest, error) {
+	resolver := docker.NewResolver(containerdResolverOptions(ctx, nil, hostOpt))
+	_, desc, err := resolver.Resolve(ctx, reference)
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest, nil
+}
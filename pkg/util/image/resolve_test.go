@@ -0,0 +1,54 @@
+package image
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const testManifestDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+func newTestRegistry(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/demo/web/manifests/v1" {
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", testManifestDigest)
+			w.Header().Set("Content-Length", "2")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestResolveDigestPinsTag(t *testing.T) {
+	host := strings.TrimPrefix(newTestRegistry(t).URL, "http://")
+	pinned, err := ResolveDigest(host+"/demo/web:v1", "", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned != host+"/demo/web:v1@"+testManifestDigest {
+		t.Fatalf("unexpected pinned reference %s", pinned)
+	}
+}
+
+func TestResolveDigestKeepsPinnedReference(t *testing.T) {
+	image := "goodrain.me/demo/web@" + testManifestDigest
+	pinned, err := ResolveDigest(image, "", "", 10)
+	if err != nil || pinned != image {
+		t.Fatalf("expected pinned reference to be kept, got %s %v", pinned, err)
+	}
+}
+
+func TestResolveDigestFailsForUnknownImage(t *testing.T) {
+	host := strings.TrimPrefix(newTestRegistry(t).URL, "http://")
+	if _, err := ResolveDigest(host+"/demo/db:v1", "", "", 10); err == nil {
+		t.Fatal("expected unknown image to fail")
+	}
+}
@@ -0,0 +1,252 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package ocilayout
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// AddArchive add the images of the tarball written by docker save or containerd export
+// into the layout, the names of the added images are returned
+func (l *Layout) AddArchive(tarball string) ([]string, error) {
+	var dockerBody, indexBody []byte
+	if err := walkTar(tarball, func(hdr *tar.Header, r io.Reader) error {
+		var err error
+		switch hdr.Name {
+		case DockerManifestFile:
+			dockerBody, err = ioutil.ReadAll(r)
+		case IndexFile:
+			indexBody, err = ioutil.ReadAll(r)
+		}
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("read image archive %s failure %s", filepath.Base(tarball), err.Error())
+	}
+	var names []string
+	var err error
+	switch {
+	case indexBody != nil:
+		names, err = l.addOCIArchive(tarball, indexBody)
+	case dockerBody != nil:
+		names, err = l.addDockerArchive(tarball, dockerBody)
+	default:
+		return nil, fmt.Errorf("%s is not an image archive", filepath.Base(tarball))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("add image archive %s failure %s", filepath.Base(tarball), err.Error())
+	}
+	return names, l.writeDockerManifest()
+}
+
+// addOCIArchive copy the blobs and merge the index
+func (l *Layout) addOCIArchive(tarball string, indexBody []byte) ([]string, error) {
+	var index ocispec.Index
+	if err := json.Unmarshal(indexBody, &index); err != nil {
+		return nil, err
+	}
+	if err := walkTar(tarball, func(hdr *tar.Header, r io.Reader) error {
+		if !strings.HasPrefix(hdr.Name, "blobs/") {
+			return nil
+		}
+		d, _, err := l.writeBlob(r)
+		if err != nil {
+			return err
+		}
+		if want := strings.TrimPrefix(hdr.Name, "blobs/"); want != d.Algorithm().String()+"/"+d.Encoded() {
+			return fmt.Errorf("blob %s does not match its digest %s", hdr.Name, d)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, desc := range index.Manifests {
+		if _, err := os.Stat(l.BlobPath(desc.Digest)); err != nil {
+			return nil, fmt.Errorf("manifest %s not found in archive", desc.Digest)
+		}
+		name := imageName(desc)
+		if err := l.addImage(name, desc); err != nil {
+			return nil, err
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// addDockerArchive store the configs and layers of the docker save tarball as blobs
+// and write an OCI manifest for every image
+func (l *Layout) addDockerArchive(tarball string, dockerBody []byte) ([]string, error) {
+	var items []dockerManifest
+	if err := json.Unmarshal(dockerBody, &items); err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]struct{})
+	for _, item := range items {
+		wanted[item.Config] = struct{}{}
+		for _, layer := range item.Layers {
+			wanted[layer] = struct{}{}
+		}
+	}
+	blobs := make(map[string]ocispec.Descriptor)
+	if err := walkTar(tarball, func(hdr *tar.Header, r io.Reader) error {
+		if _, ok := wanted[hdr.Name]; !ok {
+			return nil
+		}
+		br := bufio.NewReader(r)
+		mediaType := ocispec.MediaTypeImageLayer
+		if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+			mediaType = ocispec.MediaTypeImageLayerGzip
+		}
+		d, size, err := l.writeBlob(br)
+		if err != nil {
+			return err
+		}
+		blobs[hdr.Name] = ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: size}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, item := range items {
+		config, ok := blobs[item.Config]
+		if !ok {
+			return nil, fmt.Errorf("config %s not found in archive", item.Config)
+		}
+		config.MediaType = ocispec.MediaTypeImageConfig
+		manifest := ocispec.Manifest{Versioned: specs.Versioned{SchemaVersion: 2}, Config: config, Layers: []ocispec.Descriptor{}}
+		for _, layer := range item.Layers {
+			desc, ok := blobs[layer]
+			if !ok {
+				return nil, fmt.Errorf("layer %s not found in archive", layer)
+			}
+			manifest.Layers = append(manifest.Layers, desc)
+		}
+		body, err := json.Marshal(manifest)
+		if err != nil {
+			return nil, err
+		}
+		d, size, err := l.writeBlob(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		desc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: d, Size: size}
+		if len(item.RepoTags) == 0 {
+			if err := l.addImage("", desc); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, tag := range item.RepoTags {
+			if err := l.addImage(tag, desc); err != nil {
+				return nil, err
+			}
+			names = append(names, tag)
+		}
+	}
+	return names, nil
+}
+
+// WriteArchive write the layout into a tarball that can be loaded by docker load and
+// containerd import, the entries are at the root of the tarball
+func (l *Layout) WriteArchive(target string) error {
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(f)
+	err = filepath.Walk(l.root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.root, file)
+		if err != nil || rel == "." || strings.HasPrefix(info.Name(), ".") {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		src, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(target)
+	}
+	return err
+}
+
+func walkTar(tarball string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		hdr.Name = strings.TrimPrefix(hdr.Name, "./")
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
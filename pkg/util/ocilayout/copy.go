@@ -0,0 +1,200 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package ocilayout
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Find returns the image of the name
+func (l *Layout) Find(name string) (*Image, error) {
+	images, err := l.Images()
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		if image.Name == name {
+			return &image, nil
+		}
+	}
+	return nil, fmt.Errorf("image %s not found in oci layout", name)
+}
+
+// CopyImage add the image srcName of the src layout as name, the blobs are hard linked
+// if the layouts are on the same file system and copied if not. The size of the blobs of
+// the image is returned.
+func (l *Layout) CopyImage(src *Layout, srcName, name string) (int64, error) {
+	image, err := src.Find(srcName)
+	if err != nil {
+		return 0, err
+	}
+	blobs, err := src.blobs(image.Descriptor)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, blob := range blobs {
+		if err := linkOrCopy(src.BlobPath(blob.Digest), l.BlobPath(blob.Digest)); err != nil {
+			return 0, fmt.Errorf("copy blob %s of image %s failure %s", blob.Digest, srcName, err.Error())
+		}
+		size += blob.Size
+	}
+	if err := l.addImage(name, ocispec.Descriptor{MediaType: image.Descriptor.MediaType, Digest: image.Descriptor.Digest, Size: image.Descriptor.Size}); err != nil {
+		return 0, err
+	}
+	return size, l.writeDockerManifest()
+}
+
+// RemoveImage drop the name from the index, the blobs are kept until Prune
+func (l *Layout) RemoveImage(name string) error {
+	index, err := l.Index()
+	if err != nil {
+		return err
+	}
+	manifests := index.Manifests[:0]
+	for _, m := range index.Manifests {
+		if imageName(m) != name {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = manifests
+	if err := l.writeJSON(IndexFile, index); err != nil {
+		return err
+	}
+	return l.writeDockerManifest()
+}
+
+// Prune remove the blobs not referenced by the images of the index, the size
+// of the removed blobs is returned
+func (l *Layout) Prune() (int64, error) {
+	index, err := l.Index()
+	if err != nil {
+		return 0, err
+	}
+	used := make(map[digest.Digest]struct{})
+	for _, desc := range index.Manifests {
+		blobs, err := l.blobs(desc)
+		if err != nil {
+			return 0, err
+		}
+		for _, blob := range blobs {
+			used[blob.Digest] = struct{}{}
+		}
+	}
+	dir := filepath.Join(l.root, "blobs", string(digest.SHA256))
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var removed int64
+	for _, f := range files {
+		// the blobs being written are hidden temporary files
+		if _, ok := used[digest.NewDigestFromEncoded(digest.SHA256, f.Name())]; ok || f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+			return removed, err
+		}
+		removed += f.Size()
+	}
+	return removed, nil
+}
+
+// Size returns the size of the blobs of the layout
+func (l *Layout) Size() (int64, error) {
+	files, err := ioutil.ReadDir(filepath.Join(l.root, "blobs", string(digest.SHA256)))
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, f := range files {
+		if !f.IsDir() {
+			size += f.Size()
+		}
+	}
+	return size, nil
+}
+
+// blobs returns the descriptor and the descriptors it references: the manifests of an
+// index, the config and the layers of a manifest. Manifests of an index not in the layout
+// are skipped, images saved for one platform keep the index of all platforms.
+func (l *Layout) blobs(desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	result := []ocispec.Descriptor{desc}
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, mediaTypeDockerManifestList:
+		var index ocispec.Index
+		if err := l.readJSON(l.BlobPath(desc.Digest), &index); err != nil {
+			return nil, err
+		}
+		for _, m := range index.Manifests {
+			if _, err := os.Stat(l.BlobPath(m.Digest)); os.IsNotExist(err) {
+				continue
+			}
+			children, err := l.blobs(m)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, children...)
+		}
+	case ocispec.MediaTypeImageManifest, mediaTypeDockerManifest, "":
+		var manifest ocispec.Manifest
+		if err := l.readJSON(l.BlobPath(desc.Digest), &manifest); err != nil {
+			return nil, err
+		}
+		result = append(result, manifest.Config)
+		result = append(result, manifest.Layers...)
+	}
+	return result, nil
+}
+
+// linkOrCopy hard link the file, it is copied if it can not be linked
+func linkOrCopy(source, target string) error {
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := ioutil.TempFile(filepath.Dir(target), ".blob")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
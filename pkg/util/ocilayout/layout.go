@@ -0,0 +1,328 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package ocilayout stores images in an OCI image layout directory, blobs are
+// content addressed so layers shared by several images are stored once
+package ocilayout
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// IndexFile the index of the layout
+	IndexFile = "index.json"
+	// DockerManifestFile docker save compatible manifest kept next to the index, so
+	// `tar -C <layout> -c . | docker load` works with docker versions not reading OCI archives
+	DockerManifestFile = "manifest.json"
+	// AnnotationImageName the full image name, set by containerd export as well
+	AnnotationImageName = "io.containerd.image.name"
+
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// Image an image of the layout
+type Image struct {
+	Name       string
+	Descriptor ocispec.Descriptor
+}
+
+// Layout an OCI image layout directory
+type Layout struct {
+	root string
+	// platform the manifest of multi-platform images read and listed in manifest.json, the platform of the host if it is nil
+	platform *ocispec.Platform
+}
+
+// dockerManifest an item of the manifest.json written by docker save
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// IsLayout returns true if dir is an OCI image layout
+func IsLayout(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, ocispec.ImageLayoutFile))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// Create create the layout under root, an existing layout is opened
+func Create(root string) (*Layout, error) {
+	if IsLayout(root) {
+		return Open(root)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "blobs", string(digest.SHA256)), 0755); err != nil {
+		return nil, err
+	}
+	l := &Layout{root: root}
+	if err := l.writeJSON(ocispec.ImageLayoutFile, ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion}); err != nil {
+		return nil, err
+	}
+	if err := l.writeJSON(IndexFile, ocispec.Index{Versioned: specs.Versioned{SchemaVersion: 2}}); err != nil {
+		return nil, err
+	}
+	if err := l.writeJSON(DockerManifestFile, []dockerManifest{}); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Open open the layout under root
+func Open(root string) (*Layout, error) {
+	if !IsLayout(root) {
+		return nil, fmt.Errorf("%s is not an oci image layout", root)
+	}
+	return &Layout{root: root}, nil
+}
+
+// Root the layout directory
+func (l *Layout) Root() string {
+	return l.root
+}
+
+// BlobPath the file of the blob
+func (l *Layout) BlobPath(d digest.Digest) string {
+	return filepath.Join(l.root, "blobs", d.Algorithm().String(), d.Encoded())
+}
+
+// Blob open the blob
+func (l *Layout) Blob(d digest.Digest) (io.ReadCloser, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return os.Open(l.BlobPath(d))
+}
+
+// Index read the index of the layout
+func (l *Layout) Index() (*ocispec.Index, error) {
+	var index ocispec.Index
+	if err := l.readJSON(filepath.Join(l.root, IndexFile), &index); err != nil {
+		return nil, fmt.Errorf("read oci layout index failure %s", err.Error())
+	}
+	return &index, nil
+}
+
+// Images list the named images of the layout
+func (l *Layout) Images() ([]Image, error) {
+	index, err := l.Index()
+	if err != nil {
+		return nil, err
+	}
+	var images []Image
+	for _, desc := range index.Manifests {
+		if name := imageName(desc); name != "" {
+			images = append(images, Image{Name: name, Descriptor: desc})
+		}
+	}
+	return images, nil
+}
+
+// Manifest read the manifest of the image, the manifest matching the current
+// platform is returned for multi-platform images
+func (l *Layout) Manifest(name string) (*ocispec.Manifest, error) {
+	images, err := l.Images()
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		if image.Name == name {
+			return l.resolveManifest(image.Descriptor)
+		}
+	}
+	return nil, fmt.Errorf("image %s not found in oci layout", name)
+}
+
+// Config read the config of the image, no daemon is required
+func (l *Layout) Config(name string) (*ocispec.Image, error) {
+	manifest, err := l.Manifest(name)
+	if err != nil {
+		return nil, err
+	}
+	var config ocispec.Image
+	if err := l.readJSON(l.BlobPath(manifest.Config.Digest), &config); err != nil {
+		return nil, fmt.Errorf("read config of image %s failure %s", name, err.Error())
+	}
+	return &config, nil
+}
+
+// SetPlatform select the manifest of multi-platform images for the platform, e.g. the platform
+// of the target the images are loaded on, and list it in manifest.json for docker load
+func (l *Layout) SetPlatform(platform ocispec.Platform) error {
+	l.platform = &platform
+	return l.writeDockerManifest()
+}
+
+// ManifestOf read the manifest the descriptor of the index points to, the manifest matching
+// the platform of the layout is returned for multi-platform images
+func (l *Layout) ManifestOf(desc ocispec.Descriptor) (*ocispec.Manifest, error) {
+	return l.resolveManifest(desc)
+}
+
+func (l *Layout) resolveManifest(desc ocispec.Descriptor) (*ocispec.Manifest, error) {
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, mediaTypeDockerManifestList:
+		var index ocispec.Index
+		if err := l.readJSON(l.BlobPath(desc.Digest), &index); err != nil {
+			return nil, err
+		}
+		if len(index.Manifests) == 0 {
+			return nil, fmt.Errorf("image index %s is empty", desc.Digest)
+		}
+		platform := platforms.DefaultSpec()
+		if l.platform != nil {
+			platform = *l.platform
+		}
+		matcher := platforms.NewMatcher(platform)
+		for _, m := range index.Manifests {
+			if m.Platform != nil && matcher.Match(*m.Platform) {
+				return l.resolveManifest(m)
+			}
+		}
+		return l.resolveManifest(index.Manifests[0])
+	case ocispec.MediaTypeImageManifest, mediaTypeDockerManifest, "":
+		var manifest ocispec.Manifest
+		if err := l.readJSON(l.BlobPath(desc.Digest), &manifest); err != nil {
+			return nil, err
+		}
+		return &manifest, nil
+	}
+	return nil, fmt.Errorf("not support manifest media type %s", desc.MediaType)
+}
+
+// addImage point the name to the manifest, the image of the same name is replaced
+func (l *Layout) addImage(name string, desc ocispec.Descriptor) error {
+	index, err := l.Index()
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		desc.Annotations = map[string]string{AnnotationImageName: name}
+		if tag := imageTag(name); tag != "" {
+			desc.Annotations[ocispec.AnnotationRefName] = tag
+		}
+	}
+	manifests := index.Manifests[:0]
+	for _, m := range index.Manifests {
+		if name != "" && imageName(m) == name || name == "" && m.Digest == desc.Digest {
+			continue
+		}
+		manifests = append(manifests, m)
+	}
+	index.Manifests = append(manifests, desc)
+	return l.writeJSON(IndexFile, index)
+}
+
+// writeDockerManifest regenerate manifest.json from the index
+func (l *Layout) writeDockerManifest() error {
+	images, err := l.Images()
+	if err != nil {
+		return err
+	}
+	items := []dockerManifest{}
+	for _, image := range images {
+		manifest, err := l.resolveManifest(image.Descriptor)
+		if err != nil {
+			return fmt.Errorf("read manifest of image %s failure %s", image.Name, err.Error())
+		}
+		item := dockerManifest{Config: blobEntry(manifest.Config.Digest), RepoTags: []string{image.Name}}
+		for _, layer := range manifest.Layers {
+			item.Layers = append(item.Layers, blobEntry(layer.Digest))
+		}
+		items = append(items, item)
+	}
+	return l.writeJSON(DockerManifestFile, items)
+}
+
+func (l *Layout) readJSON(file string, v interface{}) error {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (l *Layout) writeJSON(name string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(l.root, "."+name+".tmp")
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(l.root, name))
+}
+
+// writeBlob store the content, nothing is written if the blob exists
+func (l *Layout) writeBlob(r io.Reader) (digest.Digest, int64, error) {
+	tmp, err := ioutil.TempFile(filepath.Join(l.root, "blobs", string(digest.SHA256)), ".blob")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	digester := digest.SHA256.Digester()
+	size, err := io.Copy(io.MultiWriter(tmp, digester.Hash()), r)
+	if err != nil {
+		tmp.Close()
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+	d := digester.Digest()
+	if _, err := os.Stat(l.BlobPath(d)); err == nil {
+		return d, size, nil
+	}
+	return d, size, os.Rename(tmp.Name(), l.BlobPath(d))
+}
+
+func blobEntry(d digest.Digest) string {
+	return "blobs/" + d.Algorithm().String() + "/" + d.Encoded()
+}
+
+func imageName(desc ocispec.Descriptor) string {
+	if name := desc.Annotations[AnnotationImageName]; name != "" {
+		return name
+	}
+	return desc.Annotations[ocispec.AnnotationRefName]
+}
+
+// imageTag the tag of the image name, empty for digest references
+func imageTag(name string) string {
+	if strings.Contains(name, "@") {
+		return ""
+	}
+	i := strings.LastIndex(name, ":")
+	if i < 0 || strings.Contains(name[i:], "/") {
+		return "latest"
+	}
+	return name[i+1:]
+}
@@ -0,0 +1,235 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package ocilayout
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// writeDockerArchive write a docker save tarball of one image with a shared base layer
+func writeDockerArchive(t *testing.T, name, tag, cmd string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name+".tar")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	manifest, _ := json.Marshal([]dockerManifest{{Config: name + ".json", RepoTags: []string{tag}, Layers: []string{"base/layer.tar", name + "/layer.tar"}}})
+	config := `{"architecture":"amd64","os":"linux","config":{"Cmd":["` + cmd + `"]}}`
+	for entry, body := range map[string]string{
+		"manifest.json":     string(manifest),
+		name + ".json":      config,
+		"base/layer.tar":    "shared base layer",
+		name + "/layer.tar": "layer of " + name,
+		"repositories":      "{}",
+	} {
+		if err := tw.WriteHeader(&tar.Header{Name: entry, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(body))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func countBlobs(t *testing.T, l *Layout) int {
+	t.Helper()
+	files, err := ioutil.ReadDir(filepath.Join(l.Root(), "blobs", "sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestAddDockerArchiveDeduplicatesLayers(t *testing.T) {
+	l, err := Create(filepath.Join(t.TempDir(), "images"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, archive := range []string{
+		writeDockerArchive(t, "web", "goodrain.me/web:v1", "web"),
+		writeDockerArchive(t, "db", "goodrain.me/db:v1", "db"),
+	} {
+		if _, err := l.AddArchive(archive); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 2 configs, 2 own layers, 1 shared layer and 2 manifests
+	if n := countBlobs(t, l); n != 7 {
+		t.Fatalf("expected 7 blobs, got %d", n)
+	}
+	images, err := l.Images()
+	if err != nil || len(images) != 2 {
+		t.Fatalf("expected 2 images, got %v %v", images, err)
+	}
+	if images[0].Descriptor.Annotations["org.opencontainers.image.ref.name"] != "v1" {
+		t.Fatalf("expected tag annotation, got %v", images[0].Descriptor.Annotations)
+	}
+	config, err := l.Config("goodrain.me/db:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Config.Cmd) != 1 || config.Config.Cmd[0] != "db" {
+		t.Fatalf("unexpected config %+v", config.Config)
+	}
+	manifest, err := l.Manifest("goodrain.me/web:v1")
+	if err != nil || len(manifest.Layers) != 2 {
+		t.Fatalf("expected 2 layers, got %v %v", manifest, err)
+	}
+	var items []dockerManifest
+	if err := l.readJSON(filepath.Join(l.Root(), DockerManifestFile), &items); err != nil || len(items) != 2 {
+		t.Fatalf("expected docker manifest of 2 images, got %v %v", items, err)
+	}
+	if _, err := os.Stat(filepath.Join(l.Root(), filepath.FromSlash(items[0].Layers[0]))); err != nil {
+		t.Fatalf("docker manifest points to a missing layer: %v", err)
+	}
+}
+
+func TestWriteArchiveIsReadableAsOCIArchive(t *testing.T) {
+	l, err := Create(filepath.Join(t.TempDir(), "images"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.AddArchive(writeDockerArchive(t, "web", "goodrain.me/web:v1", "web")); err != nil {
+		t.Fatal(err)
+	}
+	tarball := filepath.Join(t.TempDir(), "images.tar")
+	if err := l.WriteArchive(tarball); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := Create(filepath.Join(t.TempDir(), "images"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := copied.AddArchive(tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "goodrain.me/web:v1" {
+		t.Fatalf("unexpected images %v", names)
+	}
+	if countBlobs(t, copied) != countBlobs(t, l) {
+		t.Fatal("expected all blobs to be copied")
+	}
+}
+
+func TestCopyImageAndPrune(t *testing.T) {
+	src, err := Create(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"web", "db"} {
+		if _, err := src.AddArchive(writeDockerArchive(t, name, "goodrain.me/"+name+":v1", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dst, err := Create(filepath.Join(t.TempDir(), "images"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := dst.CopyImage(src, "goodrain.me/web:v1", "goodrain.me/web:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// manifest, config and two layers
+	if size == 0 || countBlobs(t, dst) != 4 {
+		t.Fatalf("expected the blobs of web to be copied, got %d blobs of %d bytes", countBlobs(t, dst), size)
+	}
+	if _, err := dst.Manifest("goodrain.me/web:latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	before := countBlobs(t, src)
+	if err := src.RemoveImage("goodrain.me/web:v1"); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := src.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the base layer is shared with db
+	if removed == 0 || countBlobs(t, src) != before-3 {
+		t.Fatalf("expected the blobs only used by web to be removed, %d of %d blobs left", countBlobs(t, src), before)
+	}
+	if _, err := src.Manifest("goodrain.me/db:v1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetPlatformSelectsManifestOfMultiPlatformImages(t *testing.T) {
+	l, err := Create(filepath.Join(t.TempDir(), "images"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := ocispec.Index{Versioned: specs.Versioned{SchemaVersion: 2}, MediaType: ocispec.MediaTypeImageIndex}
+	for _, arch := range []string{"amd64", "arm64"} {
+		if _, err := l.AddArchive(writeDockerArchive(t, arch, "goodrain.me/web:"+arch, arch)); err != nil {
+			t.Fatal(err)
+		}
+		image, err := l.Find("goodrain.me/web:" + arch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc := image.Descriptor
+		desc.Annotations = nil
+		desc.Platform = &ocispec.Platform{OS: "linux", Architecture: arch}
+		index.Manifests = append(index.Manifests, desc)
+		if err := l.RemoveImage("goodrain.me/web:" + arch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	body, _ := json.Marshal(index)
+	dgst, size, err := l.writeBlob(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.addImage("goodrain.me/web:v1", ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex, Digest: dgst, Size: size}); err != nil {
+		t.Fatal(err)
+	}
+	for _, arch := range []string{"arm64", "amd64"} {
+		if err := l.SetPlatform(ocispec.Platform{OS: "linux", Architecture: arch}); err != nil {
+			t.Fatal(err)
+		}
+		config, err := l.Config("goodrain.me/web:v1")
+		if err != nil || config.Config.Cmd[0] != arch {
+			t.Fatalf("expected the %s image, got %v %v", arch, config, err)
+		}
+		var items []dockerManifest
+		if err := l.readJSON(filepath.Join(l.Root(), DockerManifestFile), &items); err != nil || len(items) != 1 {
+			t.Fatalf("expected docker manifest of 1 image, got %v %v", items, err)
+		}
+		manifest, _ := l.Manifest("goodrain.me/web:v1")
+		if items[0].Config != blobEntry(manifest.Config.Digest) {
+			t.Fatalf("expected docker manifest to list the %s config, got %s", arch, items[0].Config)
+		}
+	}
+}
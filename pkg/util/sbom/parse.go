@@ -0,0 +1,376 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+var (
+	osReleaseFiles = map[string]bool{"etc/os-release": true, "usr/lib/os-release": true}
+	// rpmDatabases the berkeley db and sqlite rpm databases, the ndb one of suse is
+	// reported but not read
+	rpmDatabases = map[string]func(inv *Inventory, location string, body []byte) error{
+		"var/lib/rpm/Packages":              parseRpmBerkeleyDB,
+		"var/lib/rpm/Packages.db":           nil,
+		"var/lib/rpm/rpmdb.sqlite":          parseRpmSqlite,
+		"usr/lib/sysimage/rpm/rpmdb.sqlite": parseRpmSqlite,
+	}
+	lockfiles = map[string]func(inv *Inventory, location string, body []byte) error{
+		"package-lock.json": parsePackageLock,
+		"yarn.lock":         parseYarnLock,
+		"requirements.txt":  parseRequirements,
+		"Pipfile.lock":      parsePipfileLock,
+		"poetry.lock":       parseTOMLPackages("pypi"),
+		"Cargo.lock":        parseTOMLPackages("cargo"),
+		"composer.lock":     parseComposerLock,
+		"Gemfile.lock":      parseGemfileLock,
+		"go.mod":            parseGoMod,
+	}
+)
+
+// interesting returns true if the file is read for packages
+func interesting(name string) bool {
+	if _, ok := rpmDatabases[name]; ok {
+		return true
+	}
+	if osReleaseFiles[name] || name == "var/lib/dpkg/status" || name == "lib/apk/db/installed" {
+		return true
+	}
+	if strings.HasPrefix(name, "var/lib/dpkg/status.d/") {
+		return true
+	}
+	// the lockfiles of dependencies are not the ones of the app
+	if strings.Contains("/"+name, "/node_modules/") || strings.Contains("/"+name, "/vendor/") {
+		return false
+	}
+	_, ok := lockfiles[path.Base(name)]
+	return ok
+}
+
+// read the packages of the file, files that fail to parse are reported as warnings
+func (inv *Inventory) read(name string, body []byte) {
+	var err error
+	switch {
+	case osReleaseFiles[name]:
+		if inv.Distro == "" || name == "etc/os-release" {
+			inv.Distro, inv.DistroVersion = parseOSRelease(body)
+		}
+	case rpmDatabases[name] != nil:
+		err = rpmDatabases[name](inv, "/"+name, body)
+	case name == "var/lib/rpm/Packages.db":
+		inv.Warnings = append(inv.Warnings, fmt.Sprintf("rpm database /%s is not supported, its packages are not listed", name))
+	case name == "var/lib/dpkg/status" || strings.HasPrefix(name, "var/lib/dpkg/status.d/"):
+		parseDpkgStatus(inv, "/"+name, body)
+	case name == "lib/apk/db/installed":
+		parseApkInstalled(inv, "/"+name, body)
+	default:
+		if parse, ok := lockfiles[path.Base(name)]; ok {
+			err = parse(inv, "/"+name, body)
+		}
+	}
+	if err != nil {
+		inv.Warnings = append(inv.Warnings, fmt.Sprintf("parse /%s failure %s", name, err.Error()))
+	}
+}
+
+func parseOSRelease(body []byte) (string, string) {
+	var id, version string
+	for _, line := range strings.Split(string(body), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.Trim(kv[1], `"'`)
+		switch kv[0] {
+		case "ID":
+			id = value
+		case "VERSION_ID":
+			version = value
+		}
+	}
+	return id, version
+}
+
+// paragraphs split the rfc822 style database into paragraphs of fields, continuation lines are dropped
+func paragraphs(body []byte, sep string) []map[string]string {
+	var result []map[string]string
+	current := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), maxFileSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				result = append(result, current)
+				current = make(map[string]string)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		kv := strings.SplitN(line, sep, 2)
+		if len(kv) == 2 {
+			current[kv[0]] = strings.TrimSpace(kv[1])
+		}
+	}
+	if len(current) > 0 {
+		result = append(result, current)
+	}
+	return result
+}
+
+func parseDpkgStatus(inv *Inventory, location string, body []byte) {
+	for _, p := range paragraphs(body, ":") {
+		// distroless images keep the status of each package without the Status field
+		if status, ok := p["Status"]; ok && !strings.HasSuffix(status, " installed") {
+			continue
+		}
+		if p["Package"] == "" {
+			continue
+		}
+		inv.Packages = append(inv.Packages, Package{Name: p["Package"], Version: p["Version"], Type: "deb", Arch: p["Architecture"], Location: location})
+	}
+}
+
+func parseApkInstalled(inv *Inventory, location string, body []byte) {
+	for _, p := range paragraphs(body, ":") {
+		if p["P"] == "" {
+			continue
+		}
+		inv.Packages = append(inv.Packages, Package{Name: p["P"], Version: p["V"], Type: "apk", Arch: p["A"], Location: location})
+	}
+}
+
+type npmLockDependency struct {
+	Version      string                       `json:"version"`
+	Dependencies map[string]npmLockDependency `json:"dependencies"`
+}
+
+func parsePackageLock(inv *Inventory, location string, body []byte) error {
+	var lock struct {
+		Packages map[string]struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Link    bool   `json:"link"`
+		} `json:"packages"`
+		Dependencies map[string]npmLockDependency `json:"dependencies"`
+	}
+	if err := json.Unmarshal(body, &lock); err != nil {
+		return err
+	}
+	// lockfile v2 and v3 list the installed packages by path
+	if len(lock.Packages) > 0 {
+		for key, p := range lock.Packages {
+			i := strings.LastIndex(key, "node_modules/")
+			if i < 0 || p.Link {
+				continue
+			}
+			name := key[i+len("node_modules/"):]
+			if p.Name != "" {
+				name = p.Name
+			}
+			inv.Packages = append(inv.Packages, Package{Name: name, Version: p.Version, Type: "npm", Location: location})
+		}
+		return nil
+	}
+	var walk func(deps map[string]npmLockDependency)
+	walk = func(deps map[string]npmLockDependency) {
+		for name, dep := range deps {
+			inv.Packages = append(inv.Packages, Package{Name: name, Version: dep.Version, Type: "npm", Location: location})
+			walk(dep.Dependencies)
+		}
+	}
+	walk(lock.Dependencies)
+	return nil
+}
+
+// parseYarnLock read yarn v1 and berry lockfiles, the entries are the unindented lines
+// listing the specs and the version is the indented version field
+func parseYarnLock(inv *Inventory, location string, body []byte) error {
+	var name string
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			spec := strings.Trim(strings.SplitN(strings.TrimSuffix(line, ":"), ",", 2)[0], `" `)
+			name = ""
+			if i := strings.LastIndex(spec, "@"); i > 0 {
+				name = spec[:i]
+			}
+			// berry keeps the protocol in the spec, e.g. lodash@npm:^4.17.21
+			if i := strings.LastIndex(name, "@npm"); i > 0 {
+				name = name[:i]
+			}
+			continue
+		}
+		field := strings.TrimSpace(line)
+		if name == "" || !strings.HasPrefix(field, "version") {
+			continue
+		}
+		version := strings.Trim(strings.TrimLeft(strings.TrimPrefix(field, "version"), ": "), `"`)
+		inv.Packages = append(inv.Packages, Package{Name: name, Version: version, Type: "npm", Location: location})
+		name = ""
+	}
+	return nil
+}
+
+func parseRequirements(inv *Inventory, location string, body []byte) error {
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(strings.SplitN(line, "#", 2)[0])
+		line = strings.TrimSpace(strings.SplitN(line, ";", 2)[0])
+		kv := strings.SplitN(line, "==", 2)
+		if len(kv) != 2 || strings.HasPrefix(line, "-") {
+			continue
+		}
+		name := strings.TrimSpace(strings.SplitN(kv[0], "[", 2)[0])
+		version := strings.TrimSpace(strings.Fields(kv[1] + " ")[0])
+		inv.Packages = append(inv.Packages, Package{Name: name, Version: version, Type: "pypi", Location: location})
+	}
+	return nil
+}
+
+func parsePipfileLock(inv *Inventory, location string, body []byte) error {
+	var lock map[string]json.RawMessage
+	if err := json.Unmarshal(body, &lock); err != nil {
+		return err
+	}
+	for _, section := range []string{"default", "develop"} {
+		var packages map[string]struct {
+			Version string `json:"version"`
+		}
+		if raw, ok := lock[section]; ok {
+			if err := json.Unmarshal(raw, &packages); err != nil {
+				return err
+			}
+		}
+		for name, p := range packages {
+			inv.Packages = append(inv.Packages, Package{Name: name, Version: strings.TrimPrefix(p.Version, "=="), Type: "pypi", Location: location})
+		}
+	}
+	return nil
+}
+
+// parseTOMLPackages read the [[package]] tables of poetry.lock and Cargo.lock
+func parseTOMLPackages(purlType string) func(inv *Inventory, location string, body []byte) error {
+	return func(inv *Inventory, location string, body []byte) error {
+		var current *Package
+		flush := func() {
+			if current != nil && current.Name != "" {
+				inv.Packages = append(inv.Packages, *current)
+			}
+			current = nil
+		}
+		for _, line := range strings.Split(string(body), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "[") {
+				flush()
+				if line == "[[package]]" {
+					current = &Package{Type: purlType, Location: location}
+				}
+				continue
+			}
+			kv := strings.SplitN(line, "=", 2)
+			if current == nil || len(kv) != 2 {
+				continue
+			}
+			value := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			switch strings.TrimSpace(kv[0]) {
+			case "name":
+				current.Name = value
+			case "version":
+				current.Version = value
+			}
+		}
+		flush()
+		return nil
+	}
+}
+
+func parseComposerLock(inv *Inventory, location string, body []byte) error {
+	var lock struct {
+		Packages []struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"packages"`
+		PackagesDev []struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"packages-dev"`
+	}
+	if err := json.Unmarshal(body, &lock); err != nil {
+		return err
+	}
+	for _, p := range append(lock.Packages, lock.PackagesDev...) {
+		inv.Packages = append(inv.Packages, Package{Name: p.Name, Version: p.Version, Type: "composer", Location: location})
+	}
+	return nil
+}
+
+// parseGemfileLock read the specs of the GEM section, the gems are indented by 4 spaces
+func parseGemfileLock(inv *Inventory, location string, body []byte) error {
+	var inGem bool
+	for _, line := range strings.Split(string(body), "\n") {
+		if !strings.HasPrefix(line, " ") {
+			inGem = line == "GEM"
+			continue
+		}
+		if !inGem || !strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "     ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		inv.Packages = append(inv.Packages, Package{Name: fields[0], Version: strings.Trim(fields[1], "()"), Type: "gem", Location: location})
+	}
+	return nil
+}
+
+func parseGoMod(inv *Inventory, location string, body []byte) error {
+	var inRequire bool
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(strings.SplitN(line, "//", 2)[0])
+		switch {
+		case line == "require (":
+			inRequire = true
+			continue
+		case inRequire && line == ")":
+			inRequire = false
+			continue
+		case strings.HasPrefix(line, "require "):
+			line = strings.TrimPrefix(line, "require ")
+		case !inRequire:
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		inv.Packages = append(inv.Packages, Package{Name: fields[0], Version: fields[1], Type: "golang", Location: location})
+	}
+	return nil
+}
@@ -0,0 +1,448 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sbom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// rpm header tags and types read for the packages
+const (
+	rpmTagName    = 1000
+	rpmTagVersion = 1001
+	rpmTagRelease = 1002
+	rpmTagEpoch   = 1003
+	rpmTagArch    = 1022
+
+	rpmTypeInt32  = 4
+	rpmTypeString = 6
+)
+
+var errNotRpmHeader = errors.New("invalid rpm header")
+
+// parseRpmHeader read the package of a header blob as stored in the rpm databases,
+// the index and data lengths followed by the index entries and the data, all big endian
+func parseRpmHeader(blob []byte) (Package, error) {
+	var p Package
+	if len(blob) < 8 {
+		return p, errNotRpmHeader
+	}
+	il, dl := int(binary.BigEndian.Uint32(blob)), int(binary.BigEndian.Uint32(blob[4:]))
+	if il <= 0 || il > len(blob)/16 || dl < 0 || 8+il*16+dl > len(blob) {
+		return p, errNotRpmHeader
+	}
+	data := blob[8+il*16 : 8+il*16+dl]
+	var version, release string
+	var epoch int32
+	for i := 0; i < il; i++ {
+		entry := blob[8+i*16:]
+		tag, typ, offset := binary.BigEndian.Uint32(entry), binary.BigEndian.Uint32(entry[4:]), int(binary.BigEndian.Uint32(entry[8:]))
+		if offset < 0 || offset >= len(data) {
+			continue
+		}
+		switch {
+		case typ == rpmTypeString:
+			value := data[offset:]
+			if end := bytes.IndexByte(value, 0); end >= 0 {
+				value = value[:end]
+			}
+			switch tag {
+			case rpmTagName:
+				p.Name = string(value)
+			case rpmTagVersion:
+				version = string(value)
+			case rpmTagRelease:
+				release = string(value)
+			case rpmTagArch:
+				p.Arch = string(value)
+			}
+		case typ == rpmTypeInt32 && tag == rpmTagEpoch && offset+4 <= len(data):
+			epoch = int32(binary.BigEndian.Uint32(data[offset:]))
+		}
+	}
+	if p.Name == "" {
+		return p, errNotRpmHeader
+	}
+	p.Version = version
+	if release != "" {
+		p.Version += "-" + release
+	}
+	if epoch != 0 {
+		p.Version = fmt.Sprintf("%d:%s", epoch, p.Version)
+	}
+	p.Type = "rpm"
+	return p, nil
+}
+
+// appendRpmHeaders add the packages of the header blobs, the gpg-pubkey entries are
+// the imported signing keys and not packages
+func appendRpmHeaders(inv *Inventory, location string, blobs [][]byte) error {
+	for _, blob := range blobs {
+		p, err := parseRpmHeader(blob)
+		if err != nil {
+			return err
+		}
+		if p.Name == "gpg-pubkey" {
+			continue
+		}
+		p.Location = location
+		inv.Packages = append(inv.Packages, p)
+	}
+	return nil
+}
+
+// berkeley db hash database, see db_page.h of libdb
+const (
+	bdbHashMagic        = 0x061561
+	bdbPageHeaderSize   = 26
+	bdbPageHashUnsorted = 2
+	bdbPageOverflow     = 7
+	bdbPageHash         = 13
+	bdbItemKeyData      = 1
+	bdbItemOffPage      = 3
+)
+
+// parseRpmBerkeleyDB read the headers of the Packages hash database of rpm before 4.16,
+// the keys are the header numbers and the values the headers, mostly on overflow pages
+func parseRpmBerkeleyDB(inv *Inventory, location string, body []byte) error {
+	if len(body) < 72 {
+		return errors.New("invalid berkeley db")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(body[12:]) != bdbHashMagic {
+		order = binary.BigEndian
+		if order.Uint32(body[12:]) != bdbHashMagic {
+			return errors.New("not a berkeley db hash database")
+		}
+	}
+	pageSize := int(order.Uint32(body[20:]))
+	if pageSize < 512 || pageSize > 64<<10 {
+		return fmt.Errorf("invalid berkeley db page size %d", pageSize)
+	}
+	pages := len(body) / pageSize
+	if last := int(order.Uint32(body[32:])) + 1; last < pages {
+		pages = last
+	}
+	page := func(n int) []byte {
+		if n <= 0 || n >= pages {
+			return nil
+		}
+		return body[n*pageSize : (n+1)*pageSize]
+	}
+	var blobs [][]byte
+	for n := 1; n < pages; n++ {
+		p := page(n)
+		if p[25] != bdbPageHash && p[25] != bdbPageHashUnsorted {
+			continue
+		}
+		entries := int(order.Uint16(p[20:]))
+		if bdbPageHeaderSize+entries*2 > pageSize {
+			return fmt.Errorf("invalid berkeley db page %d", n)
+		}
+		// the items are the key and data pairs, the data are the odd ones
+		for i := 1; i < entries; i += 2 {
+			offset := int(order.Uint16(p[bdbPageHeaderSize+i*2:]))
+			end := int(order.Uint16(p[bdbPageHeaderSize+(i-1)*2:]))
+			if offset >= end || end > pageSize {
+				return fmt.Errorf("invalid berkeley db page %d", n)
+			}
+			item := p[offset:end]
+			switch item[0] {
+			case bdbItemKeyData:
+				blobs = append(blobs, item[1:])
+			case bdbItemOffPage:
+				if len(item) < 12 {
+					return fmt.Errorf("invalid berkeley db page %d", n)
+				}
+				value, err := bdbOverflow(page, order, int(order.Uint32(item[4:])), int(order.Uint32(item[8:])))
+				if err != nil {
+					return err
+				}
+				blobs = append(blobs, value)
+			}
+		}
+	}
+	// rpm keeps the next header number in a short record
+	var headers [][]byte
+	for _, blob := range blobs {
+		if len(blob) > 8 {
+			headers = append(headers, blob)
+		}
+	}
+	return appendRpmHeaders(inv, location, headers)
+}
+
+// bdbOverflow read the value stored on the chain of overflow pages, the free area
+// offset of the pages is the length of the data they hold
+func bdbOverflow(page func(n int) []byte, order binary.ByteOrder, n, length int) ([]byte, error) {
+	var value []byte
+	for len(value) < length {
+		p := page(n)
+		if p == nil || p[25] != bdbPageOverflow {
+			return nil, fmt.Errorf("invalid berkeley db overflow page %d", n)
+		}
+		size := int(order.Uint16(p[22:]))
+		if size == 0 || bdbPageHeaderSize+size > len(p) {
+			return nil, fmt.Errorf("invalid berkeley db overflow page %d", n)
+		}
+		value = append(value, p[bdbPageHeaderSize:bdbPageHeaderSize+size]...)
+		n = int(order.Uint32(p[16:]))
+	}
+	return value[:length], nil
+}
+
+// sqlite database file, see https://www.sqlite.org/fileformat.html
+const (
+	sqliteMagic             = "SQLite format 3\x00"
+	sqlitePageInteriorTable = 0x05
+	sqlitePageLeafTable     = 0x0d
+)
+
+type sqliteFile struct {
+	body     []byte
+	pageSize int
+	usable   int
+}
+
+// parseRpmSqlite read the headers of the blob column of the Packages table of rpm 4.16 and later
+func parseRpmSqlite(inv *Inventory, location string, body []byte) error {
+	if len(body) < 100 || string(body[:16]) != sqliteMagic {
+		return errors.New("not a sqlite database")
+	}
+	db := &sqliteFile{body: body, pageSize: int(binary.BigEndian.Uint16(body[16:]))}
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 {
+		return fmt.Errorf("invalid sqlite page size %d", db.pageSize)
+	}
+	db.usable = db.pageSize - int(body[20])
+	var root int64
+	err := db.walk(1, func(record []interface{}) {
+		if len(record) >= 4 && record[0] == "table" && record[1] == "Packages" {
+			root, _ = record[3].(int64)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if root == 0 {
+		return errors.New("no Packages table in the rpm database")
+	}
+	var blobs [][]byte
+	err = db.walk(int(root), func(record []interface{}) {
+		for _, value := range record {
+			if blob, ok := value.([]byte); ok {
+				blobs = append(blobs, blob)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return appendRpmHeaders(inv, location, blobs)
+}
+
+func (db *sqliteFile) page(n int) []byte {
+	if n <= 0 || n*db.pageSize > len(db.body) {
+		return nil
+	}
+	return db.body[(n-1)*db.pageSize : n*db.pageSize]
+}
+
+// walk call fn with the records of the table b-tree in rowid order
+func (db *sqliteFile) walk(root int, fn func(record []interface{})) error {
+	visited := make(map[int]bool)
+	var walk func(n int) error
+	walk = func(n int) error {
+		p := db.page(n)
+		if p == nil || visited[n] {
+			return fmt.Errorf("invalid sqlite page %d", n)
+		}
+		visited[n] = true
+		header := p
+		// the first page starts with the database header
+		if n == 1 {
+			header = p[100:]
+		}
+		cells := int(binary.BigEndian.Uint16(header[3:]))
+		switch header[0] {
+		case sqlitePageLeafTable:
+			if 8+cells*2 > len(header) {
+				return fmt.Errorf("invalid sqlite page %d", n)
+			}
+			for i := 0; i < cells; i++ {
+				payload, err := db.cellPayload(p, int(binary.BigEndian.Uint16(header[8+i*2:])))
+				if err != nil {
+					return err
+				}
+				record, err := sqliteRecord(payload)
+				if err != nil {
+					return err
+				}
+				fn(record)
+			}
+		case sqlitePageInteriorTable:
+			if 12+cells*2 > len(header) {
+				return fmt.Errorf("invalid sqlite page %d", n)
+			}
+			for i := 0; i < cells; i++ {
+				offset := int(binary.BigEndian.Uint16(header[12+i*2:]))
+				if offset+4 > len(p) {
+					return fmt.Errorf("invalid sqlite page %d", n)
+				}
+				if err := walk(int(binary.BigEndian.Uint32(p[offset:]))); err != nil {
+					return err
+				}
+			}
+			return walk(int(binary.BigEndian.Uint32(header[8:])))
+		default:
+			return fmt.Errorf("unexpected sqlite page %d of type %d", n, header[0])
+		}
+		return nil
+	}
+	return walk(root)
+}
+
+// cellPayload read the payload of the leaf cell, the part that does not fit in the page
+// is on a chain of overflow pages
+func (db *sqliteFile) cellPayload(p []byte, offset int) ([]byte, error) {
+	if offset >= len(p) {
+		return nil, errors.New("invalid sqlite cell")
+	}
+	size, n := sqliteVarint(p[offset:])
+	_, m := sqliteVarint(p[offset+n:])
+	if n == 0 || m == 0 {
+		return nil, errors.New("invalid sqlite cell")
+	}
+	offset += n + m
+	u, total := db.usable, int(size)
+	local := total
+	if maxLocal := u - 35; total > maxLocal {
+		minLocal := (u-12)*32/255 - 23
+		local = minLocal + (total-minLocal)%(u-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if total < 0 || offset+local > len(p) {
+		return nil, errors.New("invalid sqlite cell")
+	}
+	payload := append([]byte(nil), p[offset:offset+local]...)
+	if local == total {
+		return payload, nil
+	}
+	if offset+local+4 > len(p) {
+		return nil, errors.New("invalid sqlite cell")
+	}
+	next := int(binary.BigEndian.Uint32(p[offset+local:]))
+	for pages := 0; len(payload) < total; pages++ {
+		overflow := db.page(next)
+		if overflow == nil || pages > len(db.body)/db.pageSize {
+			return nil, fmt.Errorf("invalid sqlite overflow page %d", next)
+		}
+		chunk := overflow[4:u]
+		if rest := total - len(payload); rest < len(chunk) {
+			chunk = chunk[:rest]
+		}
+		payload = append(payload, chunk...)
+		next = int(binary.BigEndian.Uint32(overflow))
+	}
+	return payload, nil
+}
+
+// sqliteRecord decode the columns of the record, integers as int64, texts as string
+// and blobs as []byte, floats are not read
+func sqliteRecord(payload []byte) ([]interface{}, error) {
+	headerSize, n := sqliteVarint(payload)
+	if n == 0 || int(headerSize) > len(payload) || headerSize < uint64(n) {
+		return nil, errors.New("invalid sqlite record")
+	}
+	var types []uint64
+	for i := n; i < int(headerSize); {
+		t, m := sqliteVarint(payload[i:int(headerSize)])
+		if m == 0 {
+			return nil, errors.New("invalid sqlite record")
+		}
+		types = append(types, t)
+		i += m
+	}
+	var record []interface{}
+	data := payload[headerSize:]
+	for _, t := range types {
+		var size int
+		switch {
+		case t >= 12:
+			size = int((t - 12) / 2)
+		case t >= 1 && t <= 4:
+			size = int(t)
+		case t == 5:
+			size = 6
+		case t == 6 || t == 7:
+			size = 8
+		}
+		if size > len(data) {
+			return nil, errors.New("invalid sqlite record")
+		}
+		value := data[:size]
+		data = data[size:]
+		switch {
+		case t >= 12 && t%2 == 0:
+			record = append(record, value)
+		case t >= 13:
+			record = append(record, string(value))
+		case t >= 1 && t <= 6:
+			var v int64
+			for i, b := range value {
+				if i == 0 {
+					v = int64(int8(b))
+					continue
+				}
+				v = v<<8 | int64(b)
+			}
+			record = append(record, v)
+		case t == 8:
+			record = append(record, int64(0))
+		case t == 9:
+			record = append(record, int64(1))
+		default:
+			record = append(record, nil)
+		}
+	}
+	return record, nil
+}
+
+// sqliteVarint returns the big endian varint and its length, 0 if it is truncated
+func sqliteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,330 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package sbom builds software bills of materials of images from their layers, the
+// OS package databases and language lockfiles are read offline from the image content.
+package sbom
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Format document format of the SBOM
+type Format string
+
+const (
+	// SPDX SPDX 2.3 json document
+	SPDX Format = "spdx"
+	// CycloneDX CycloneDX 1.4 json document
+	CycloneDX Format = "cyclonedx"
+)
+
+// toolName the tool recorded as the creator of the documents
+const toolName = "rainbond-oam"
+
+// ParseFormat parse the SBOM format
+func ParseFormat(format string) (Format, error) {
+	switch strings.ToLower(format) {
+	case "spdx", "spdx-json":
+		return SPDX, nil
+	case "cyclonedx", "cyclonedx-json", "cdx":
+		return CycloneDX, nil
+	}
+	return "", fmt.Errorf("not support sbom format %s", format)
+}
+
+// Ext returns the file extension of the format
+func (f Format) Ext() string {
+	if f == CycloneDX {
+		return ".cdx.json"
+	}
+	return ".spdx.json"
+}
+
+// Package a package installed in the image
+type Package struct {
+	Name    string
+	Version string
+	// Type purl type, e.g. deb, apk, npm, pypi
+	Type string
+	Arch string
+	// Location the package database or lockfile the package is found in
+	Location string
+}
+
+// Inventory the packages found in an image
+type Inventory struct {
+	Image string
+	// Tags all names of the image in the archive
+	Tags []string
+	// ID config digest of the image
+	ID string
+	// Distro id and version of /etc/os-release, e.g. debian 11
+	Distro        string
+	DistroVersion string
+	Packages      []Package
+	// Warnings content found but not understood, e.g. ndb rpm databases
+	Warnings []string
+}
+
+// PURL returns the package url of the package, distro qualifies OS packages
+func (p Package) PURL(distro, distroVersion string) string {
+	var namespace string
+	name := p.Name
+	qualifiers := url.Values{}
+	switch p.Type {
+	case "deb", "apk", "rpm":
+		namespace = distro
+		if p.Arch != "" {
+			qualifiers.Set("arch", p.Arch)
+		}
+		if distro != "" {
+			qualifiers.Set("distro", strings.Trim(distro+"-"+distroVersion, "-"))
+		}
+	case "npm":
+		if strings.HasPrefix(name, "@") && strings.Contains(name, "/") {
+			parts := strings.SplitN(name, "/", 2)
+			namespace, name = parts[0], parts[1]
+		}
+	case "pypi":
+		name = strings.ReplaceAll(strings.ToLower(name), "_", "-")
+	case "composer", "golang":
+		if i := strings.LastIndex(name, "/"); i > 0 {
+			namespace, name = name[:i], name[i+1:]
+		}
+	}
+	purl := "pkg:" + p.Type + "/"
+	if namespace != "" {
+		var segments []string
+		for _, segment := range strings.Split(namespace, "/") {
+			// the npm scope is escaped, e.g. pkg:npm/%40babel/core
+			segments = append(segments, strings.ReplaceAll(url.PathEscape(segment), "@", "%40"))
+		}
+		purl += strings.Join(segments, "/") + "/"
+	}
+	purl += url.PathEscape(name)
+	if p.Version != "" {
+		purl += "@" + url.PathEscape(p.Version)
+	}
+	if len(qualifiers) > 0 {
+		purl += "?" + qualifiers.Encode()
+	}
+	return purl
+}
+
+// sortPackages sort the packages and drop the repeated ones
+func (inv *Inventory) sortPackages() {
+	sort.SliceStable(inv.Packages, func(i, j int) bool {
+		a, b := inv.Packages[i], inv.Packages[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	var packages []Package
+	for i, p := range inv.Packages {
+		if i > 0 {
+			last := packages[len(packages)-1]
+			if last.Type == p.Type && last.Name == p.Name && last.Version == p.Version {
+				continue
+			}
+		}
+		packages = append(packages, p)
+	}
+	inv.Packages = packages
+}
+
+// Encode write the inventory as a document of the format
+func (inv *Inventory) Encode(w io.Writer, format Format, created time.Time) error {
+	var doc interface{}
+	switch format {
+	case SPDX:
+		doc = inv.spdx(created)
+	case CycloneDX:
+		doc = inv.cycloneDX(created)
+	default:
+		return fmt.Errorf("not support sbom format %s", format)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
+
+// digest returns a stable id of the inventory for the document namespace and serial number
+func (inv *Inventory) digest() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", inv.Image, inv.ID)
+	for _, p := range inv.Packages {
+		fmt.Fprintf(h, "%s/%s@%s\n", p.Type, p.Name, p.Version)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+func newSPDXPackage(id, name, version string) spdxPackage {
+	return spdxPackage{
+		Name:             name,
+		SPDXID:           id,
+		VersionInfo:      version,
+		DownloadLocation: "NOASSERTION",
+		LicenseConcluded: "NOASSERTION",
+		LicenseDeclared:  "NOASSERTION",
+		CopyrightText:    "NOASSERTION",
+	}
+}
+
+func (inv *Inventory) spdx(created time.Time) *spdxDocument {
+	doc := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              inv.Image,
+		DocumentNamespace: fmt.Sprintf("https://www.rainbond.com/spdxdocs/%s", inv.digest()),
+		CreationInfo: spdxCreationInfo{
+			Created:  created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: " + toolName},
+		},
+	}
+	image := newSPDXPackage("SPDXRef-Image", inv.Image, inv.ID)
+	doc.Packages = append(doc.Packages, image)
+	doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: doc.SPDXID, RelationshipType: "DESCRIBES", RelatedSPDXElement: image.SPDXID})
+	for i, p := range inv.Packages {
+		pkg := newSPDXPackage(fmt.Sprintf("SPDXRef-Package-%d", i+1), p.Name, p.Version)
+		pkg.SourceInfo = "found in " + p.Location
+		pkg.ExternalRefs = []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: p.PURL(inv.Distro, inv.DistroVersion)}}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: image.SPDXID, RelationshipType: "CONTAINS", RelatedSPDXElement: pkg.SPDXID})
+	}
+	return doc
+}
+
+type cdxDocument struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     []cdxTool    `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTool struct {
+	Vendor string `json:"vendor"`
+	Name   string `json:"name"`
+}
+
+type cdxComponent struct {
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Type       string        `json:"type"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (inv *Inventory) cycloneDX(created time.Time) *cdxDocument {
+	id := inv.digest()
+	doc := &cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.4",
+		SerialNumber: fmt.Sprintf("urn:uuid:%s-%s-%s-%s-%s", id[0:8], id[8:12], id[12:16], id[16:20], id[20:32]),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: created.UTC().Format(time.RFC3339),
+			Tools:     []cdxTool{{Vendor: "goodrain", Name: toolName}},
+			Component: cdxComponent{BOMRef: inv.Image, Type: "container", Name: inv.Image, Version: inv.ID},
+		},
+		Components: []cdxComponent{},
+	}
+	if inv.Distro != "" {
+		doc.Components = append(doc.Components, cdxComponent{BOMRef: "os:" + inv.Distro, Type: "operating-system", Name: inv.Distro, Version: inv.DistroVersion})
+	}
+	for _, p := range inv.Packages {
+		purl := p.PURL(inv.Distro, inv.DistroVersion)
+		doc.Components = append(doc.Components, cdxComponent{
+			BOMRef:     purl,
+			Type:       "library",
+			Name:       p.Name,
+			Version:    p.Version,
+			PURL:       purl,
+			Properties: []cdxProperty{{Name: "rainbond:location", Value: p.Location}},
+		})
+	}
+	return doc
+}
@@ -0,0 +1,306 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sbom
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tarLayer(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, body := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(body))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const dpkgStatus = `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Version: 2.31-13
+Description: GNU C Library
+ continuation line
+
+Package: removed
+Status: deinstall ok config-files
+Version: 1.0
+`
+
+const packageLock = `{"lockfileVersion":2,"packages":{"":{"name":"app"},"node_modules/express":{"version":"4.18.2"},"node_modules/@babel/core":{"version":"7.20.0"}}}`
+
+func TestAnalyzeMergesLayers(t *testing.T) {
+	base, err := ScanLayer(bytes.NewReader(tarLayer(t, map[string]string{
+		"etc/os-release":      "ID=debian\nVERSION_ID=\"11\"\n",
+		"var/lib/dpkg/status": dpkgStatus,
+		"app/go.mod":          "module app\n\nrequire (\n\tgithub.com/sirupsen/logrus v1.8.1\n)\n",
+		"usr/bin/bash":        "binary",
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := ScanLayer(bytes.NewReader(tarLayer(t, map[string]string{
+		"app/.wh.go.mod":                    "",
+		"app/package-lock.json":             packageLock,
+		"app/node_modules/x/yarn.lock":      "x@^1.0.0:\n  version \"1.0.0\"\n",
+		"app/requirements.txt":              "flask==2.2.2 # web\nrequests[socks]==2.28.1\n-r other.txt\n",
+		"var/lib/rpm/rpmdb.sqlite":          "sqlite",
+		"lib/apk/db/installed":              "P:musl\nV:1.2.3-r0\nA:x86_64\n",
+		"app/node_modules/express/index.js": "js",
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := Analyze("goodrain.me/app:v1", []*Layer{base, app})
+	if inv.Distro != "debian" || inv.DistroVersion != "11" {
+		t.Fatalf("unexpected distro %s %s", inv.Distro, inv.DistroVersion)
+	}
+	var names []string
+	for _, p := range inv.Packages {
+		names = append(names, p.Type+"/"+p.Name+"@"+p.Version)
+	}
+	expected := []string{"apk/musl@1.2.3-r0", "deb/libc6@2.31-13", "npm/@babel/core@7.20.0", "npm/express@4.18.2", "pypi/flask@2.2.2", "pypi/requests@2.28.1"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, names)
+		}
+	}
+	if len(inv.Warnings) != 1 || !strings.Contains(inv.Warnings[0], "/var/lib/rpm/rpmdb.sqlite") {
+		t.Fatalf("expected the invalid rpm database to be reported, got %v", inv.Warnings)
+	}
+	if purl := inv.Packages[1].PURL(inv.Distro, inv.DistroVersion); purl != "pkg:deb/debian/libc6@2.31-13?arch=amd64&distro=debian-11" {
+		t.Fatalf("unexpected purl %s", purl)
+	}
+	if purl := inv.Packages[2].PURL("", ""); purl != "pkg:npm/%40babel/core@7.20.0" {
+		t.Fatalf("unexpected purl %s", purl)
+	}
+}
+
+// rpmHeader returns the header blob of the package as stored in the rpm databases
+func rpmHeader(name, version, release, arch string, epoch int32) []byte {
+	var index, data bytes.Buffer
+	entry := func(tag, typ uint32, value []byte) {
+		binary.Write(&index, binary.BigEndian, []uint32{tag, typ, uint32(data.Len()), 1})
+		data.Write(value)
+	}
+	entry(rpmTagName, rpmTypeString, []byte(name+"\x00"))
+	entry(rpmTagVersion, rpmTypeString, []byte(version+"\x00"))
+	entry(rpmTagRelease, rpmTypeString, []byte(release+"\x00"))
+	entry(rpmTagArch, rpmTypeString, []byte(arch+"\x00"))
+	if epoch != 0 {
+		data.Write(make([]byte, 3-(data.Len()+3)%4))
+		value := make([]byte, 4)
+		binary.BigEndian.PutUint32(value, uint32(epoch))
+		entry(rpmTagEpoch, rpmTypeInt32, value)
+	}
+	blob := make([]byte, 8)
+	binary.BigEndian.PutUint32(blob, uint32(index.Len()/16))
+	binary.BigEndian.PutUint32(blob[4:], uint32(data.Len()))
+	return append(append(blob, index.Bytes()...), data.Bytes()...)
+}
+
+// berkeleyDB returns a little endian hash database of 512 bytes pages with the header
+// numbers as keys, the first header is stored in the hash page and the others on
+// overflow pages like rpm does for headers larger than a page
+func berkeleyDB(headers [][]byte) []byte {
+	const pageSize = 512
+	le := binary.LittleEndian
+	newPage := func(typ byte) []byte {
+		p := make([]byte, pageSize)
+		p[25] = typ
+		return p
+	}
+	meta := newPage(8)
+	le.PutUint32(meta[12:], bdbHashMagic)
+	le.PutUint32(meta[20:], pageSize)
+	hash := newPage(bdbPageHash)
+	pages := [][]byte{meta, hash}
+	var items [][]byte
+	keyData := func(value []byte) []byte { return append([]byte{bdbItemKeyData}, value...) }
+	number := func(n uint32) []byte {
+		b := make([]byte, 4)
+		le.PutUint32(b, n)
+		return b
+	}
+	// the record 0 keeps the next header number
+	items = append(items, keyData(number(0)), keyData(number(uint32(len(headers)+1))))
+	for i, header := range headers {
+		items = append(items, keyData(number(uint32(i+1))))
+		if i == 0 {
+			items = append(items, keyData(header))
+			continue
+		}
+		offPage := make([]byte, 12)
+		offPage[0] = bdbItemOffPage
+		le.PutUint32(offPage[4:], uint32(len(pages)))
+		le.PutUint32(offPage[8:], uint32(len(header)))
+		items = append(items, offPage)
+		for rest := header; len(rest) > 0; {
+			p := newPage(bdbPageOverflow)
+			size := copy(p[bdbPageHeaderSize:], rest)
+			rest = rest[size:]
+			le.PutUint16(p[22:], uint16(size))
+			if len(rest) > 0 {
+				le.PutUint32(p[16:], uint32(len(pages)+1))
+			}
+			pages = append(pages, p)
+		}
+	}
+	offset := pageSize
+	for i, item := range items {
+		offset -= len(item)
+		copy(hash[offset:], item)
+		le.PutUint16(hash[bdbPageHeaderSize+i*2:], uint16(offset))
+	}
+	le.PutUint16(hash[20:], uint16(len(items)))
+	le.PutUint16(hash[22:], uint16(offset))
+	le.PutUint32(meta[32:], uint32(len(pages)-1))
+	return bytes.Join(pages, nil)
+}
+
+func rpmPackages(inv *Inventory) []string {
+	var packages []string
+	for _, p := range inv.Packages {
+		packages = append(packages, p.Name+"@"+p.Version+"."+p.Arch)
+	}
+	return packages
+}
+
+func TestReadRpmBerkeleyDB(t *testing.T) {
+	layer, err := ScanLayer(bytes.NewReader(tarLayer(t, map[string]string{
+		"etc/os-release": "ID=centos\nVERSION_ID=\"7\"\n",
+		"var/lib/rpm/Packages": string(berkeleyDB([][]byte{
+			rpmHeader("bash", "4.2.46", "35.el7_9", "x86_64", 0),
+			rpmHeader("openssl-libs", "1.0.2k", "26.el7_9", "x86_64", 1),
+			rpmHeader("gpg-pubkey", "f4a80eb5", "53a7ff4b", "", 0),
+			append(rpmHeader("glibc", "2.17", "326.el7_9", "x86_64", 0), make([]byte, 1200)...),
+		})),
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := Analyze("goodrain.me/centos:7", []*Layer{layer})
+	expected := []string{"bash@4.2.46-35.el7_9.x86_64", "glibc@2.17-326.el7_9.x86_64", "openssl-libs@1:1.0.2k-26.el7_9.x86_64"}
+	if packages := rpmPackages(inv); strings.Join(packages, " ") != strings.Join(expected, " ") || len(inv.Warnings) != 0 {
+		t.Fatalf("expected %v, got %v %v", expected, packages, inv.Warnings)
+	}
+	if purl := inv.Packages[2].PURL(inv.Distro, inv.DistroVersion); purl != "pkg:rpm/centos/openssl-libs@1:1.0.2k-26.el7_9?arch=x86_64&distro=centos-7" {
+		t.Fatalf("unexpected purl %s", purl)
+	}
+}
+
+// testdata/rpmdb.sqlite is a database of 512 bytes pages created by sqlite3 with the
+// schema of rpm, its 42 packages span interior and overflow pages
+func TestReadRpmSqlite(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/rpmdb.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := &Inventory{}
+	inv.read("usr/lib/sysimage/rpm/rpmdb.sqlite", body)
+	packages := rpmPackages(inv)
+	if len(packages) != 42 || len(inv.Warnings) != 0 {
+		t.Fatalf("expected 42 packages, got %v %v", packages, inv.Warnings)
+	}
+	if packages[0] != "pkg00@1.0-1.el9.x86_64" || packages[39] != "pkg39@1.39-1.el9.x86_64" || packages[41] != "openssl-libs@1:3.0.7-27.el9.x86_64" {
+		t.Fatalf("unexpected packages %v", packages)
+	}
+	if inv.Packages[41].Location != "/usr/lib/sysimage/rpm/rpmdb.sqlite" {
+		t.Fatalf("unexpected location %s", inv.Packages[41].Location)
+	}
+}
+
+func TestEncodeDocuments(t *testing.T) {
+	inv := &Inventory{Image: "goodrain.me/app:v1", ID: "sha256:abc", Packages: []Package{{Name: "express", Version: "4.18.2", Type: "npm", Location: "/app/package-lock.json"}}}
+	for _, format := range []Format{SPDX, CycloneDX} {
+		var buf bytes.Buffer
+		if err := inv.Encode(&buf, format, time.Unix(0, 0)); err != nil {
+			t.Fatal(err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatal(err)
+		}
+		switch format {
+		case SPDX:
+			if doc["spdxVersion"] != "SPDX-2.3" || len(doc["packages"].([]interface{})) != 2 {
+				t.Fatalf("unexpected spdx document %s", buf.String())
+			}
+		case CycloneDX:
+			if doc["bomFormat"] != "CycloneDX" || len(doc["components"].([]interface{})) != 1 {
+				t.Fatalf("unexpected cyclonedx document %s", buf.String())
+			}
+		}
+	}
+}
+
+func TestScanImageArchive(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "component-images.tar")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	layer := tarLayer(t, map[string]string{"lib/apk/db/installed": "P:musl\nV:1.2.3-r0\n"})
+	for name, body := range map[string][]byte{
+		"manifest.json": []byte(`[{"Config":"abc.json","RepoTags":["goodrain.me/app:v1"],"Layers":["l1/layer.tar","l2/layer.tar"]}]`),
+		"abc.json":      []byte(`{"architecture":"amd64","os":"linux"}`),
+		"l1/layer.tar":  layer,
+		// a zstd compressed layer of a containerd export
+		"l2/layer.tar":    bytes.Repeat([]byte{0x28, 0xb5, 0x2f, 0xfd}, 256),
+		"l1/VERSION":      []byte("1.0"),
+		"l1/json":         []byte("{}"),
+		"repositories":    []byte("{}"),
+		"not-a-layer.txt": []byte("plain"),
+	} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg})
+		tw.Write(body)
+	}
+	tw.Close()
+	f.Close()
+	inventories, err := ScanImageArchive(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inventories) != 1 || inventories[0].ID != "sha256:abc" || len(inventories[0].Packages) != 1 {
+		t.Fatalf("unexpected inventories %+v", inventories)
+	}
+	if warnings := inventories[0].Warnings; len(warnings) != 1 || !strings.HasPrefix(warnings[0], "read layer l2/layer.tar failure") {
+		t.Fatalf("expected the unreadable layer to be reported, got %v", warnings)
+	}
+}
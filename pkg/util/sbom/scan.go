@@ -0,0 +1,229 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// maxFileSize package databases and lockfiles larger than it are skipped
+const maxFileSize = 64 << 20
+
+// maxRpmDatabaseSize the rpm databases keep the file lists of the packages and are larger
+const maxRpmDatabaseSize = 512 << 20
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// Layer the files of interest found in an image layer
+type Layer struct {
+	files map[string][]byte
+	// whiteouts paths removed by the layer, opaque dirs end with a slash
+	whiteouts []string
+}
+
+// ScanLayer read the layer tar stream, gzip compressed layers are supported, and keep
+// the package databases, lockfiles and os-release
+func ScanLayer(r io.Reader) (*Layer, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		return scanTar(gr)
+	}
+	return scanTar(br)
+}
+
+func scanTar(r io.Reader) (*Layer, error) {
+	layer := &Layer{files: make(map[string][]byte)}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return layer, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean("/" + hdr.Name)[1:]
+		dir, base := path.Split(name)
+		if base == whiteoutOpaque {
+			layer.whiteouts = append(layer.whiteouts, dir)
+			continue
+		}
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			layer.whiteouts = append(layer.whiteouts, dir+strings.TrimPrefix(base, whiteoutPrefix))
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if !interesting(name) || hdr.Size > maxFileSize && (rpmDatabases[name] == nil || hdr.Size > maxRpmDatabaseSize) {
+			continue
+		}
+		body, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		layer.files[name] = body
+	}
+}
+
+// Analyze merge the layers in order and read the packages of the resulting filesystem
+func Analyze(image string, layers []*Layer) *Inventory {
+	files := make(map[string][]byte)
+	for _, layer := range layers {
+		for _, whiteout := range layer.whiteouts {
+			for name := range files {
+				if name == whiteout || strings.HasPrefix(name, strings.TrimSuffix(whiteout, "/")+"/") {
+					delete(files, name)
+				}
+			}
+		}
+		for name, body := range layer.files {
+			files[name] = body
+		}
+	}
+	inv := &Inventory{Image: image}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		inv.read(name, files[name])
+	}
+	inv.sortPackages()
+	return inv
+}
+
+type dockerArchiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// ScanImageArchive read the images of a docker save or containerd export tarball, the layers
+// that can not be read, e.g. zstd compressed ones, are reported in the warnings of the images
+func ScanImageArchive(tarball string) ([]*Inventory, error) {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// the manifest is written after the layers, it is read first to know which entries are layers
+	items, err := readArchiveManifest(f, path.Base(tarball))
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, item := range items {
+		for _, name := range item.Layers {
+			wanted[name] = true
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	layers := make(map[string]*Layer)
+	unreadable := make(map[string]string)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !wanted[hdr.Name] || (hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA) {
+			continue
+		}
+		layer, err := ScanLayer(tr)
+		if err != nil {
+			unreadable[hdr.Name] = err.Error()
+			continue
+		}
+		layers[hdr.Name] = layer
+	}
+	var inventories []*Inventory
+	for _, item := range items {
+		var imageLayers []*Layer
+		var warnings []string
+		for _, name := range item.Layers {
+			if reason, ok := unreadable[name]; ok {
+				warnings = append(warnings, fmt.Sprintf("read layer %s failure %s, its packages are not listed", name, reason))
+				continue
+			}
+			layer, ok := layers[name]
+			if !ok {
+				return nil, fmt.Errorf("layer %s of %s not found in %s", name, item.Config, path.Base(tarball))
+			}
+			imageLayers = append(imageLayers, layer)
+		}
+		name := strings.TrimSuffix(path.Base(item.Config), ".json")
+		if len(item.RepoTags) > 0 {
+			name = item.RepoTags[0]
+		}
+		inv := Analyze(name, imageLayers)
+		inv.Tags = item.RepoTags
+		inv.ID = "sha256:" + strings.TrimSuffix(path.Base(item.Config), ".json")
+		inv.Warnings = append(warnings, inv.Warnings...)
+		inventories = append(inventories, inv)
+	}
+	return inventories, nil
+}
+
+// readArchiveManifest returns the images listed in manifest.json of the archive
+func readArchiveManifest(r io.Reader, name string) ([]dockerArchiveManifest, error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name != "manifest.json" {
+			continue
+		}
+		var items []dockerArchiveManifest
+		if err := json.NewDecoder(tr).Decode(&items); err != nil {
+			return nil, fmt.Errorf("parse manifest of %s failure %s", name, err.Error())
+		}
+		return items, nil
+	}
+}
@@ -0,0 +1,280 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package vmdisk extracts the disks of VM components from their container disk images and
+// wraps disk files into container disk images again. Container disk images keep the disk
+// in the disk dir of the image, e.g. /disk/root.qcow2.
+package vmdisk
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/ocilayout"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// DiskDir the dir of the disk in container disk images
+	DiskDir = "disk"
+	// qemuUser the user and group the disk is owned by in container disk images
+	qemuUser = 107
+)
+
+// Extract write the disk of the only image of the image tarball into dir and returns the
+// path of the disk file, the disk of the topmost layer is taken if several layers have one
+func Extract(tarball, dir string) (string, error) {
+	staging, err := ioutil.TempDir(filepath.Dir(tarball), ".vmdisk-layout")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+	layout, err := ocilayout.Create(staging)
+	if err != nil {
+		return "", err
+	}
+	if _, err := layout.AddArchive(tarball); err != nil {
+		return "", fmt.Errorf("read image tarball %s failure %s", path.Base(tarball), err.Error())
+	}
+	index, err := layout.Index()
+	if err != nil {
+		return "", err
+	}
+	if len(index.Manifests) != 1 {
+		return "", fmt.Errorf("expected one image in %s, found %d", path.Base(tarball), len(index.Manifests))
+	}
+	manifest, err := layout.ManifestOf(index.Manifests[0])
+	if err != nil {
+		return "", err
+	}
+	for i := len(manifest.Layers) - 1; i >= 0; i-- {
+		file, err := extractLayer(layout, manifest.Layers[i].Digest, dir)
+		if err != nil {
+			return "", fmt.Errorf("read layer %s failure %s", manifest.Layers[i].Digest, err.Error())
+		}
+		if file != "" {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("image has no disk in its %s dir", DiskDir)
+}
+
+// extractLayer write the disk of the layer into dir, an empty path is returned if the layer
+// has no disk
+func extractLayer(layout *ocilayout.Layout, d digest.Digest, dir string) (string, error) {
+	blob, err := layout.Blob(d)
+	if err != nil {
+		return "", err
+	}
+	defer blob.Close()
+	br := bufio.NewReader(blob)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return "", err
+		}
+		defer gr.Close()
+		r = gr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		name := path.Clean("/" + hdr.Name)[1:]
+		if path.Dir(name) != DiskDir || strings.HasPrefix(path.Base(name), ".wh.") {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+		file := filepath.Join(dir, path.Base(name))
+		if err := writeFile(file, tr); err != nil {
+			return "", err
+		}
+		return file, nil
+	}
+}
+
+func writeFile(file string, r io.Reader) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Verify check the file against the checksum of the disk layout, e.g. sha256:<hex>. Hex
+// digests without algorithm are md5, sha256 or sha512 by their length, empty checksums are
+// not checked.
+func Verify(file, checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+	algorithm, expected := "", strings.ToLower(checksum)
+	if i := strings.Index(expected, ":"); i > 0 {
+		algorithm, expected = expected[:i], expected[i+1:]
+	}
+	var h hash.Hash
+	switch {
+	case algorithm == "md5" || algorithm == "" && len(expected) == md5.Size*2:
+		h = md5.New()
+	case algorithm == "sha256" || algorithm == "" && len(expected) == sha256.Size*2:
+		h = sha256.New()
+	case algorithm == "sha512" || algorithm == "" && len(expected) == sha512.Size*2:
+		h = sha512.New()
+	default:
+		return fmt.Errorf("not support checksum %s", checksum)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return fmt.Errorf("checksum of disk %s is %s, expected %s", filepath.Base(file), actual, expected)
+	}
+	return nil
+}
+
+// WriteImageArchive wrap the disk file into a container disk image named image and write it
+// as docker save tarball into destination, both docker load and containerd import read it
+func WriteImageArchive(file, image, destination string) error {
+	layer := destination + ".layer"
+	defer os.Remove(layer)
+	diffID, size, err := writeDiskLayer(file, layer)
+	if err != nil {
+		return fmt.Errorf("write layer of disk %s failure %s", filepath.Base(file), err.Error())
+	}
+	config, err := json.Marshal(ocispec.Image{
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		RootFS:       ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{diffID}},
+	})
+	if err != nil {
+		return err
+	}
+	configName := digest.FromBytes(config).Encoded() + ".json"
+	layerName := diffID.Encoded() + "/layer.tar"
+	manifest, err := json.Marshal([]map[string]interface{}{{"Config": configName, "RepoTags": []string{image}, "Layers": []string{layerName}}})
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	tw := tar.NewWriter(out)
+	for _, entry := range []struct {
+		name string
+		body []byte
+	}{{"manifest.json", manifest}, {configName, config}} {
+		if err := tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.body)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(entry.body); err != nil {
+			return err
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: layerName, Mode: 0644, Size: size, Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	f, err := os.Open(layer)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(tw, f); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// writeDiskLayer write the layer tar with the disk in the disk dir, owned by qemu as
+// KubeVirt expects, and returns its digest and size
+func writeDiskLayer(file, layer string) (digest.Digest, int64, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", 0, err
+	}
+	disk, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer disk.Close()
+	out, err := os.Create(layer)
+	if err != nil {
+		return "", 0, err
+	}
+	defer out.Close()
+	digester := digest.Canonical.Digester()
+	tw := tar.NewWriter(io.MultiWriter(out, digester.Hash()))
+	if err := tw.WriteHeader(&tar.Header{Name: DiskDir + "/", Mode: 0555, Uid: qemuUser, Gid: qemuUser, Typeflag: tar.TypeDir, ModTime: info.ModTime()}); err != nil {
+		return "", 0, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: DiskDir + "/" + filepath.Base(file), Mode: 0440, Uid: qemuUser, Gid: qemuUser, Size: info.Size(), Typeflag: tar.TypeReg, ModTime: info.ModTime()}); err != nil {
+		return "", 0, err
+	}
+	if _, err := io.Copy(tw, disk); err != nil {
+		return "", 0, err
+	}
+	if err := tw.Close(); err != nil {
+		return "", 0, err
+	}
+	stat, err := out.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	return digester.Digest(), stat.Size(), out.Close()
+}
@@ -0,0 +1,66 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package vmdisk
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWrapAndExtractDisk(t *testing.T) {
+	dir := t.TempDir()
+	disk := filepath.Join(dir, "root.qcow2")
+	if err := ioutil.WriteFile(disk, []byte("qcow2 disk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tarball := filepath.Join(dir, "image.tar")
+	if err := WriteImageArchive(disk, "goodrain.me/vm:v1", tarball); err != nil {
+		t.Fatal(err)
+	}
+	file, err := Extract(tarball, filepath.Join(dir, "vm-disks", "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(file) != "root.qcow2" {
+		t.Fatalf("expected the disk name to be kept, got %s", file)
+	}
+	if body, _ := ioutil.ReadFile(file); string(body) != "qcow2 disk" {
+		t.Fatalf("unexpected disk %q", body)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	disk := filepath.Join(t.TempDir(), "root.raw")
+	if err := ioutil.WriteFile(disk, []byte("raw disk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, checksum := range []string{"", "sha256:87025b68c50228cec8c29f52307ad92116ff5fd09f65ae2b37b49f862be85222", "ED391C3E3E3B421E8CEDFC7362B9F3AA"} {
+		if err := Verify(disk, checksum); err != nil {
+			t.Fatalf("expected checksum %q to match, got %v", checksum, err)
+		}
+	}
+	if err := Verify(disk, "md5:"+strings.Repeat("0", 32)); err == nil || !strings.Contains(err.Error(), "expected") {
+		t.Fatalf("expected the mismatched checksum to be rejected, got %v", err)
+	}
+	if err := Verify(disk, "crc32:1234"); err == nil || !strings.Contains(err.Error(), "not support") {
+		t.Fatalf("expected unknown algorithms to be rejected, got %v", err)
+	}
+}